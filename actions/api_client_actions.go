@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/models"
+	valids "bigbucks/solution/auth/validations"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidClient is returned when client authentication fails
+var ErrInvalidClient = errors.New("invalid client credentials")
+
+// CreateAPIClient registers a confidential client and returns it with its secret.
+// Only a hash of the secret is stored, so it cannot be shown again.
+func CreateAPIClient(name string, scopes []string) (*models.APIClient, string, int, error) {
+	customerr := valids.NewErrorDict()
+	if strings.TrimSpace(name) == "" {
+		customerr.Errors["name"] = "Name is required"
+		return nil, "", http.StatusBadRequest, customerr
+	}
+
+	clientID, err := newClientID()
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+	secret, err := newClientSecret()
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+
+	client := &models.APIClient{
+		ClientID:   clientID,
+		Name:       name,
+		SecretHash: models.HashClientSecret(secret),
+		Scopes:     strings.Join(scopes, " "),
+	}
+	if err := models.Dbcon.Create(client).Error; err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+	return client, secret, 0, nil
+}
+
+// AuthenticateAPIClient checks a client id and secret pair
+func AuthenticateAPIClient(clientID, secret string) (*models.APIClient, error) {
+	if clientID == "" || secret == "" {
+		return nil, ErrInvalidClient
+	}
+	client, err := models.GetAPIClient(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if !client.CheckSecret(secret) {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// newClientID returns a random public client identifier
+func newClientID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
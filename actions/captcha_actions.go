@@ -0,0 +1,80 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/captcha"
+	"bigbucks/solution/auth/loging"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"context"
+	"net/http"
+)
+
+// Error codes of requests refused for want of a CAPTCHA
+const (
+	CaptchaRequiredCode = "captcha_required"
+	CaptchaInvalidCode  = "captcha_invalid"
+)
+
+// CaptchaError is returned when a request needs a solved CAPTCHA. It is encoded as
+// the response body so the UI can show the widget.
+type CaptchaError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	Provider    string `json:"provider,omitempty"`
+	SiteKey     string `json:"siteKey,omitempty"`
+}
+
+func (e *CaptchaError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func newCaptchaError(code, description string) *CaptchaError {
+	return &CaptchaError{
+		Code:        code,
+		Description: description,
+		Provider:    settings.Current.CaptchaProvider,
+		SiteKey:     settings.Current.CaptchaSiteKey,
+	}
+}
+
+// CheckCaptcha verifies the CAPTCHA token of a request to the endpoint when the
+// captchaEndpoints setting asks for one there: always, or once the address made as
+// many failed attempts as the setting lets through.
+func CheckCaptcha(ctx context.Context, endpoint, token, ip string, sessionStore *sessionstore.SessionStore) (int, error) {
+	allowed, enforced := settings.Current.CaptchaEndpoints[endpoint]
+	if !enforced {
+		return 0, nil
+	}
+	if allowed > 0 {
+		failures, err := sessionStore.CaptchaFailures(endpoint, ip)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if failures < int64(allowed) {
+			return 0, nil
+		}
+	}
+	if token == "" {
+		return http.StatusBadRequest, newCaptchaError(CaptchaRequiredCode, "solve the CAPTCHA to continue")
+	}
+	solved, err := captcha.Verify(ctx, token, ip)
+	if err != nil {
+		loging.Logger.Errorln(err)
+		return http.StatusServiceUnavailable, err
+	}
+	if !solved {
+		return http.StatusBadRequest, newCaptchaError(CaptchaInvalidCode, "the CAPTCHA was not solved, try again")
+	}
+	return 0, nil
+}
+
+// RecordCaptchaFailure counts a failed attempt at the endpoint from the address, for
+// endpoints that ask for a CAPTCHA after failed attempts
+func RecordCaptchaFailure(endpoint, ip string, sessionStore *sessionstore.SessionStore) {
+	if settings.Current.CaptchaEndpoints[endpoint] <= 0 {
+		return
+	}
+	if err := sessionStore.RecordCaptchaFailure(endpoint, ip); err != nil {
+		loging.Logger.Errorf("Error counting a failed %s from %s: %v", endpoint, ip, err)
+	}
+}
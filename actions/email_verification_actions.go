@@ -0,0 +1,188 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/passwordreset"
+	"bigbucks/solution/auth/settings"
+	valids "bigbucks/solution/auth/validations"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// EmailVerificationLifetime is how long a verification link can be used
+	EmailVerificationLifetime = 24 * time.Hour
+	// EmailVerificationResendInterval is how long a user waits before another
+	// verification email is sent
+	EmailVerificationResendInterval = time.Minute
+	// maxEmailVerificationTokenLength bounds the tokens that are verified at all
+	maxEmailVerificationTokenLength = 512
+)
+
+var (
+	// ErrInvalidVerificationToken is returned for verification links that are
+	// malformed, expired, replaced by a newer one or already used
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	// ErrVerificationThrottled is returned when a verification email was sent too recently
+	ErrVerificationThrottled = errors.New("a verification email was sent recently, try again later")
+)
+
+// emailVerificationSecret derives the key verification links are signed with from
+// the application secret, so they cannot be used as tokens of another kind
+func emailVerificationSecret() []byte {
+	return []byte("email-verification:" + settings.Current.SecretKey)
+}
+
+// emailVerificationKey is the value a link is keyed with, it changes whenever a new
+// link is issued
+func emailVerificationKey(verification *models.EmailVerification) []byte {
+	return []byte(verification.Token + ":" + verification.Email)
+}
+
+// SendEmailVerification emails the user a link to verify their email address. Any
+// link sent before stops working. Links are sent at most once per
+// EmailVerificationResendInterval.
+func SendEmailVerification(user *models.User) (int, error) {
+	verification, err := models.GetEmailVerification(user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		verification = &models.EmailVerification{UserID: user.ID}
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	} else if time.Since(verification.UpdatedAt) < EmailVerificationResendInterval {
+		return http.StatusTooManyRequests, ErrVerificationThrottled
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	verification.Token = hex.EncodeToString(b)
+	verification.Email = user.Username
+	verification.ExpiresAt = time.Now().Add(EmailVerificationLifetime)
+	if err := models.Dbcon.Save(verification).Error; err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	token := passwordreset.NewTokenNoPadding(user.ID, EmailVerificationLifetime, emailVerificationKey(verification), emailVerificationSecret())
+	name := user.Profile.FirstName
+	if name == "" {
+		name = user.Username
+	}
+	params := map[string]interface{}{
+		"Subject":          "Verify your email address",
+		"Company":          "BigBucks",
+		"Name":             name,
+		"Email":            verification.Email,
+		"VerificationLink": fmt.Sprintf("%s/verify-email?token=%s", settings.Current.BaseHost, url.QueryEscape(token)),
+		"ExpirationDate":   verification.ExpiresAt.Format("January 2, 2006 at 3:04 PM"),
+	}
+	if err := emailservice.SendEmail(verification.Email, "./templates/email_verification.html", params); err != nil {
+		loging.Logger.Errorf("Failed to send verification email to user %s: %v", user.ID, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to send verification email: %w", err)
+	}
+	return 0, nil
+}
+
+// ResendEmailVerification sends a new verification link to the user signed up with
+// the email address. Unknown and already verified addresses are ignored so the
+// response does not tell which accounts exist.
+func ResendEmailVerification(email string) (int, error) {
+	var user models.User
+	err := models.Dbcon.Where("username = ?", email).Preload("Profile").First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if user.EmailVerified || user.AccountType == constants.AccountTypeService {
+		return 0, nil
+	}
+	return SendEmailVerification(&user)
+}
+
+// ConfirmEmail verifies the email address of the user a verification link was sent
+// to. Pending users become active. The link works once.
+func ConfirmEmail(token string) (int, error) {
+	if len(token) > maxEmailVerificationTokenLength {
+		return http.StatusBadRequest, ErrInvalidVerificationToken
+	}
+	var verification *models.EmailVerification
+	var lookupErr error
+	userID, err := passwordreset.VerifyToken(token, func(userID string) ([]byte, error) {
+		v, err := models.GetEmailVerification(userID)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				lookupErr = err
+			}
+			return nil, err
+		}
+		verification = v
+		return emailVerificationKey(v), nil
+	}, emailVerificationSecret())
+	if lookupErr != nil {
+		return http.StatusInternalServerError, lookupErr
+	}
+	if err != nil || time.Now().After(verification.ExpiresAt) {
+		return http.StatusBadRequest, ErrInvalidVerificationToken
+	}
+
+	err = models.Dbcon.Transaction(func(tx *gorm.DB) error {
+		verified, err := markEmailVerified(tx, userID, verification.Email)
+		if err != nil {
+			return err
+		}
+		if !verified {
+			return ErrInvalidVerificationToken
+		}
+		return tx.Unscoped().Delete(verification).Error
+	})
+	if errors.Is(err, ErrInvalidVerificationToken) {
+		return http.StatusBadRequest, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// markEmailVerified marks the email address of the user verified, if it is still
+// the address a link was sent to, and makes pending users active. It reports
+// whether the address matched.
+func markEmailVerified(tx *gorm.DB, userID, email string) (bool, error) {
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND username = ?", userID, email).
+		Update("email_verified", true)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	err := tx.Model(&models.User{}).
+		Where("id = ? AND status = ?", userID, constants.UserStatusPending).
+		Update("status", constants.UserStatusActive).Error
+	return err == nil, err
+}
+
+// CheckVerifiedEmail refuses users without a verified email address when the
+// RequireVerifiedEmail setting covers what they are doing, either signing in or
+// creating an organization. Requiring it for signin covers both.
+func CheckVerifiedEmail(user *models.User, required, doing string) (int, error) {
+	if user.EmailVerified || user.AccountType == constants.AccountTypeService {
+		return 0, nil
+	}
+	if required != doing && required != settings.RequireVerifiedEmailSignin {
+		return 0, nil
+	}
+	customerr := valids.NewErrorDict()
+	customerr.Errors["email"] = "Email address is not verified"
+	return http.StatusForbidden, customerr
+}
@@ -0,0 +1,134 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	valids "bigbucks/solution/auth/validations"
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAccountLocked is returned for password sign-ins to an account locked after
+// too many wrong passwords
+var ErrAccountLocked = errors.New("account temporarily locked after too many failed sign-ins, try again later")
+
+// CheckLoginLockout refuses password sign-ins to a locked account. retryAfter is
+// how long the lockout still lasts.
+func CheckLoginLockout(userID string, sessionStore *sessionstore.SessionStore) (retryAfter time.Duration, code int, err error) {
+	until, err := sessionStore.LoginLockedUntil(userID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if retryAfter = time.Until(until); retryAfter > 0 {
+		return retryAfter, http.StatusTooManyRequests, ErrAccountLocked
+	}
+	return 0, 0, nil
+}
+
+// RecordLoginFailure counts a wrong password or second factor for the user. When it locks the
+// account, the lockout is written to the audit log, the owner is emailed if the
+// notifyAccountLockout setting is on, and ErrAccountLocked is returned with how
+// long the lockout lasts.
+func RecordLoginFailure(user *models.User, ip string, sessionStore *sessionstore.SessionStore) (retryAfter time.Duration, code int, err error) {
+	// Service accounts have no password to guess
+	if user.AccountType == constants.AccountTypeService {
+		return 0, 0, nil
+	}
+	failures, lockout, err := sessionStore.RecordLoginFailure(user.ID)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if lockout == 0 {
+		return 0, 0, nil
+	}
+	until := time.Now().Add(lockout)
+	loging.Logger.Warnf("Locked user %s for %s after %d failed sign-ins", user.ID, lockout, failures)
+	if err := models.WriteAuditLog(user.ID, nil, models.AuditEventAccountLocked, map[string]interface{}{
+		"ip":       ip,
+		"failures": failures,
+		"until":    until.UTC().Format(time.RFC3339),
+	}); err != nil {
+		loging.Logger.Errorf("Error writing the audit log of user %s: %v", user.ID, err)
+	}
+	if settings.Current.NotifyAccountLockout {
+		sendAccountLockedEmail(user, until)
+	}
+	return lockout, http.StatusTooManyRequests, ErrAccountLocked
+}
+
+// sendAccountLockedEmail tells the owner of the account it was locked. The lockout
+// stands whether or not the email goes out.
+func sendAccountLockedEmail(user *models.User, until time.Time) {
+	params := map[string]interface{}{
+		"Subject":     "Your account was locked",
+		"Company":     "BigBucks",
+		"Name":        displayName(user),
+		"Email":       user.Username,
+		"LockedUntil": until.Format("January 2, 2006 at 3:04 PM"),
+	}
+	if err := emailservice.SendEmail(user.Username, "./templates/account_locked.html", params); err != nil {
+		loging.Logger.Errorf("Failed to send account locked email to user %s: %v", user.ID, err)
+	}
+}
+
+// ResetLoginFailures forgets the wrong passwords of a user who signed in
+func ResetLoginFailures(userID string, sessionStore *sessionstore.SessionStore) {
+	if err := sessionStore.ResetLoginFailures(userID); err != nil {
+		loging.Logger.Errorf("Error resetting the failed sign-ins of user %s: %v", userID, err)
+	}
+}
+
+// UnlockAccountParams defines the parameters for unlocking an account
+type UnlockAccountParams struct {
+	UserID string
+	// OrgID limits the unlock to members of the organization, empty for any user
+	OrgID string
+	// ActorID is the user unlocking the account, nil for the command line
+	ActorID *string
+}
+
+// UnlockAccount ends the lockout of an account and forgets its failed sign-ins.
+// The unlock is written to the audit log.
+func UnlockAccount(params UnlockAccountParams, sessionStore *sessionstore.SessionStore) (int, error) {
+	customerr := valids.NewErrorDict()
+	if params.UserID == "" {
+		customerr.Errors["UserID"] = "User ID is required"
+		return http.StatusBadRequest, customerr
+	}
+
+	query := models.Dbcon.Model(&models.User{}).Where("users.id = ?", params.UserID)
+	if params.OrgID != "" {
+		query = query.Joins("INNER JOIN user_org_roles uor ON uor.user_id = users.id").Where("uor.org_id = ?", params.OrgID)
+	}
+	var user models.User
+	if err := query.First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			customerr.Errors["User"] = "User not found"
+			if params.OrgID != "" {
+				customerr.Errors["User"] = "User not found in the specified organization"
+			}
+			return http.StatusNotFound, customerr
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	until, err := sessionStore.LoginLockedUntil(user.ID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := sessionStore.ResetLoginFailures(user.ID); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	attrs := map[string]interface{}{"wasLocked": !until.IsZero()}
+	if err := models.WriteAuditLog(user.ID, params.ActorID, models.AuditEventAccountUnlocked, attrs); err != nil {
+		loging.Logger.Errorf("Error writing the audit log of user %s: %v", user.ID, err)
+	}
+	return 0, nil
+}
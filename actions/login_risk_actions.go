@@ -0,0 +1,316 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/geoip"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Levels of LoginRisk
+const (
+	LoginRiskLow    = "low"
+	LoginRiskMedium = "medium"
+	LoginRiskHigh   = "high"
+)
+
+const (
+	// LoginRiskHistory is how many past logins a login is compared with
+	LoginRiskHistory = 50
+	// MaxTravelSpeed is the fastest a user is believed to move between two logins,
+	// in km/h, a little faster than an airliner
+	MaxTravelSpeed = 1000.0
+	// MinTravelDistance ignores moves shorter than this, in km, as GeoIP locations
+	// are approximate
+	MinTravelDistance = 300.0
+	earthRadius       = 6371.0
+)
+
+// StepUpMethodEmailLink confirms a sign-in with a link sent by email
+const StepUpMethodEmailLink = "email_link"
+
+// versionNumbers matches the versions in user agents, so browser updates do not
+// look like new devices
+var versionNumbers = regexp.MustCompile(`\d+([._]\d+)*`)
+
+// LoginContext is what a login comes from
+type LoginContext struct {
+	IP        string
+	UserAgent string
+	// Device fingerprints the client
+	Device string
+	// UserAgentDevice tells the device from the user agent, for clients that had no
+	// device ID before this login. Empty for clients that had one.
+	UserAgentDevice string
+	Location        geoip.Location
+	At              time.Time
+}
+
+// NewLoginContext fingerprints and locates a login. ip is the client address,
+// deviceID the ID the service gave the device and known whether the client had it
+// before this login. Without a device ID the device is told from the user agent.
+func NewLoginContext(ip, userAgent, deviceID string, known bool) LoginContext {
+	login := LoginContext{
+		IP:        ip,
+		UserAgent: userAgent,
+		Device:    DeviceFingerprint(userAgent, deviceID),
+		Location:  locate(ip),
+		At:        time.Now().UTC(),
+	}
+	if !known {
+		login.UserAgentDevice = DeviceFingerprint(userAgent, "")
+	}
+	return login
+}
+
+// DeviceFingerprint hashes what identifies the device of a login
+func DeviceFingerprint(userAgent, deviceID string) string {
+	source := "device:" + deviceID
+	if deviceID == "" {
+		source = "ua:" + versionNumbers.ReplaceAllString(userAgent, "")
+	}
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:16])
+}
+
+// deviceIDSecret derives the key device IDs are signed with from the application
+// secret
+func deviceIDSecret() []byte {
+	return []byte("device-id:" + settings.Current.SecretKey)
+}
+
+func signDeviceID(id string) string {
+	mac := hmac.New(sha256.New, deviceIDSecret())
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewDeviceID returns a random device ID and its signed form for the client to
+// keep, so clients cannot choose the device they appear to be
+func NewDeviceID() (signed, id string, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id = base64.RawURLEncoding.EncodeToString(b)
+	return id + "." + signDeviceID(id), id, nil
+}
+
+// VerifyDeviceID returns the device ID of a value from NewDeviceID
+func VerifyDeviceID(signed string) (string, bool) {
+	id, signature, found := strings.Cut(signed, ".")
+	if !found || id == "" || !hmac.Equal([]byte(signature), []byte(signDeviceID(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// locate looks up the client address of a login
+func locate(ip string) geoip.Location {
+	location, _, err := geoip.Lookup(ip)
+	if err != nil {
+		loging.Logger.Errorf("Error looking up %s in the GeoIP database: %v", ip, err)
+	}
+	return location
+}
+
+// LoginRisk is how a login compares with the history of the user
+type LoginRisk struct {
+	Level            string
+	NewDevice        bool
+	NewCountry       bool
+	ImpossibleTravel bool
+	// FirstLogin is set when there is no history to compare with
+	FirstLogin bool
+}
+
+// EvaluateLoginRisk compares the login with the last logins of the user: a device
+// not used before, a country not signed in from before and a move from the last
+// located login faster than MaxTravelSpeed. A new device or country is a medium
+// risk, impossible travel or both a high one. Past logins without a recorded
+// location are looked up again.
+func EvaluateLoginRisk(userID string, login LoginContext) (LoginRisk, error) {
+	risk := LoginRisk{Level: LoginRiskLow}
+	logs, err := models.RecentLogins(userID, LoginRiskHistory)
+	if err != nil {
+		return risk, err
+	}
+	if len(logs) == 0 {
+		risk.FirstLogin = true
+		return risk, nil
+	}
+
+	risk.NewDevice = true
+	countries := map[string]bool{}
+	var last *geoip.Location
+	var lastAt time.Time
+	for _, log := range logs {
+		attrs := log.LoginAttrs()
+		device := attrs.Device
+		if device == "" {
+			device = DeviceFingerprint(attrs.UserAgent, "")
+		}
+		// Clients given a device ID with this login are told from their user agent
+		if device == login.Device || login.UserAgentDevice != "" && DeviceFingerprint(attrs.UserAgent, "") == login.UserAgentDevice {
+			risk.NewDevice = false
+		}
+		location := geoip.Location{Country: attrs.Country}
+		if attrs.Latitude != nil && attrs.Longitude != nil {
+			location.Latitude, location.Longitude, location.HasCoordinates = *attrs.Latitude, *attrs.Longitude, true
+		} else if attrs.Country == "" {
+			location = locate(attrs.IP)
+		}
+		if location.Country != "" {
+			countries[location.Country] = true
+		}
+		if last == nil && location.HasCoordinates {
+			last, lastAt = &location, log.LoginAt
+		}
+	}
+	risk.NewCountry = login.Location.Country != "" && len(countries) > 0 && !countries[login.Location.Country]
+	if last != nil && login.Location.HasCoordinates {
+		distance := haversine(*last, login.Location)
+		hours := login.At.Sub(lastAt).Hours()
+		risk.ImpossibleTravel = distance > MinTravelDistance && (hours <= 0 || distance/hours > MaxTravelSpeed)
+	}
+
+	switch {
+	case risk.ImpossibleTravel, risk.NewDevice && risk.NewCountry:
+		risk.Level = LoginRiskHigh
+	case risk.NewDevice, risk.NewCountry:
+		risk.Level = LoginRiskMedium
+	}
+	return risk, nil
+}
+
+// haversine is the great-circle distance between two locations in km
+func haversine(from, to geoip.Location) float64 {
+	lat1, lat2 := from.Latitude*math.Pi/180, to.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (to.Longitude - from.Longitude) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// RecordLogin records a login in the login history of the user and emails the user
+// about logins from new devices. risk is the risk already evaluated for the login,
+// nil evaluates it. method tells how the user signed in, empty for the password.
+func RecordLogin(user *models.User, login LoginContext, risk *LoginRisk, method string) LoginRisk {
+	if risk == nil {
+		evaluated, err := EvaluateLoginRisk(user.ID, login)
+		if err != nil {
+			loging.Logger.Errorf("Error evaluating the login risk of user %s: %v", user.ID, err)
+		}
+		risk = &evaluated
+	}
+	attrs := models.LoginAttrs{
+		IP:        login.IP,
+		UserAgent: login.UserAgent,
+		Method:    method,
+		Device:    login.Device,
+		Country:   login.Location.Country,
+		Risk:      risk.Level,
+	}
+	if login.Location.HasCoordinates {
+		attrs.Latitude, attrs.Longitude = &login.Location.Latitude, &login.Location.Longitude
+	}
+	if err := user.LogLoginActivity(attrs); err != nil {
+		loging.Logger.Errorf("Error logging login activity of user %s: %v", user.ID, err)
+	}
+	if risk.Level != LoginRiskLow {
+		loging.Logger.Warnf("Login of user %s from %s is %s risk: new device %t, new country %t, impossible travel %t",
+			user.ID, login.IP, risk.Level, risk.NewDevice, risk.NewCountry, risk.ImpossibleTravel)
+	}
+	if risk.NewDevice && user.AccountType != constants.AccountTypeService {
+		sendNewDeviceEmail(user, login)
+	}
+	return *risk
+}
+
+// sendNewDeviceEmail tells the user about a login from a device not used before
+func sendNewDeviceEmail(user *models.User, login LoginContext) {
+	params := map[string]interface{}{
+		"Subject":   "New sign-in to your account",
+		"Company":   "BigBucks",
+		"Name":      displayName(user),
+		"Email":     user.Username,
+		"Device":    login.UserAgent,
+		"IP":        login.IP,
+		"Country":   login.Location.Country,
+		"LoginTime": login.At.Format("January 2, 2006 at 3:04 PM MST"),
+	}
+	if err := emailservice.SendEmail(user.Username, "./templates/new_device_login.html", params); err != nil {
+		loging.Logger.Errorf("Failed to send new device email to user %s: %v", user.ID, err)
+	}
+}
+
+// displayName is the first name of the user, or the username without one
+func displayName(user *models.User) string {
+	var profile models.Profile
+	if err := models.Dbcon.Where("user_id = ?", user.ID).First(&profile).Error; err == nil && profile.FirstName != "" {
+		return profile.FirstName
+	}
+	return user.Username
+}
+
+// LoginNeedsStepUp reports whether a password sign-in is high risk and must be
+// confirmed by email, when the loginRiskStepUp setting is on. Users with a second
+// factor are always asked for it instead. The risk is returned for RecordLogin,
+// nil when the setting is off and it was not evaluated.
+func LoginNeedsStepUp(user *models.User, login LoginContext) (bool, *LoginRisk, error) {
+	if !settings.Current.LoginRiskStepUp || user.AccountType == constants.AccountTypeService {
+		return false, nil, nil
+	}
+	risk, err := EvaluateLoginRisk(user.ID, login)
+	if err != nil {
+		return false, nil, err
+	}
+	return risk.Level == LoginRiskHigh, &risk, nil
+}
+
+// SendStepUpLink emails the user a single use sign-in link that completes a high
+// risk sign-in. Within the resend interval of sign-in links no new one is sent.
+func SendStepUpLink(user *models.User, login LoginContext, sessionStore *sessionstore.SessionStore) (int, error) {
+	allowed, err := sessionStore.AllowMagicLinkEmail(user.Username)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !allowed {
+		return 0, nil
+	}
+	token, err := sessionStore.StoreMagicLink(&sessionstore.MagicLink{UserID: user.ID, Email: user.Username})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	params := map[string]interface{}{
+		"Subject":        "Confirm your sign-in",
+		"Company":        "BigBucks",
+		"Name":           displayName(user),
+		"Email":          user.Username,
+		"Device":         login.UserAgent,
+		"IP":             login.IP,
+		"Country":        login.Location.Country,
+		"SigninLink":     fmt.Sprintf("%s/signin/link?token=%s", settings.Current.BaseHost, url.QueryEscape(token)),
+		"ExpirationDate": time.Now().Add(sessionstore.MagicLinkLifetime).Format("January 2, 2006 at 3:04 PM"),
+	}
+	if err := emailservice.SendEmail(user.Username, "./templates/signin_confirmation.html", params); err != nil {
+		loging.Logger.Errorf("Failed to send sign-in confirmation to user %s: %v", user.ID, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to send sign-in confirmation: %w", err)
+	}
+	return 0, nil
+}
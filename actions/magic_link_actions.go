@@ -0,0 +1,91 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RequestMagicLink emails a single use sign-in link to the user with the email
+// address. Unknown addresses and service accounts are ignored so the response does
+// not tell which accounts exist. An address sent a link within the resend interval
+// is not sent another, with the same response.
+func RequestMagicLink(email string, sessionStore *sessionstore.SessionStore) (int, error) {
+	allowed, err := sessionStore.AllowMagicLinkEmail(email)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !allowed {
+		return 0, nil
+	}
+
+	var user models.User
+	err = models.Dbcon.Where("username = ?", email).Preload("Profile").First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if user.AccountType == constants.AccountTypeService {
+		return 0, nil
+	}
+
+	token, err := sessionStore.StoreMagicLink(&sessionstore.MagicLink{UserID: user.ID, Email: user.Username})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	name := user.Profile.FirstName
+	if name == "" {
+		name = user.Username
+	}
+	params := map[string]interface{}{
+		"Subject":        "Your sign-in link",
+		"Company":        "BigBucks",
+		"Name":           name,
+		"Email":          user.Username,
+		"SigninLink":     fmt.Sprintf("%s/signin/link?token=%s", settings.Current.BaseHost, url.QueryEscape(token)),
+		"ExpirationDate": time.Now().Add(sessionstore.MagicLinkLifetime).Format("January 2, 2006 at 3:04 PM"),
+	}
+	if err := emailservice.SendEmail(user.Username, "./templates/magic_link.html", params); err != nil {
+		loging.Logger.Errorf("Failed to send sign-in link to user %s: %v", user.ID, err)
+		return http.StatusInternalServerError, fmt.Errorf("failed to send sign-in link: %w", err)
+	}
+	return 0, nil
+}
+
+// ConsumeMagicLink uses up a sign-in link and returns the user it was sent to, with
+// roles. Opening the link proves the user owns the address, so it also verifies it.
+// Links sent before the user changed their address do not work.
+func ConsumeMagicLink(token string, sessionStore *sessionstore.SessionStore) (*models.User, int, error) {
+	link, err := sessionStore.ConsumeMagicLink(token)
+	if errors.Is(err, sessionstore.ErrMagicLinkInvalid) {
+		return nil, http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	verified, err := markEmailVerified(models.Dbcon, link.UserID, link.Email)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !verified {
+		return nil, http.StatusUnauthorized, sessionstore.ErrMagicLinkInvalid
+	}
+	var user models.User
+	if err := models.Dbcon.Preload("Roles").First(&user, "id = ?", link.UserID).Error; err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return &user, 0, nil
+}
@@ -0,0 +1,300 @@
+package actions
+
+import (
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/totp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued at a time
+const RecoveryCodeCount = 10
+
+// Second factors a sign-in can be completed with
+const (
+	MFAMethodTOTP         = models.MFAFactorTOTP
+	MFAMethodRecoveryCode = "recovery_code"
+	MFAMethodWebAuthn     = models.MFAFactorWebAuthn
+)
+
+var (
+	// ErrInvalidMFACode is returned when a TOTP or recovery code does not verify
+	ErrInvalidMFACode = errors.New("invalid authentication code")
+	// ErrTOTPAlreadyEnabled is returned when enrolling while an authenticator is confirmed
+	ErrTOTPAlreadyEnabled = errors.New("an authenticator is already enabled, disable it first")
+	// ErrTOTPNotEnrolled is returned when there is no authenticator to confirm or use
+	ErrTOTPNotEnrolled = errors.New("no authenticator is enrolled")
+	// ErrNoWebAuthnCredentials is returned when enabling security key MFA without a key
+	ErrNoWebAuthnCredentials = errors.New("register a security key first")
+	// ErrMFAConfirmationRequired is returned when turning off a second factor without
+	// proving the user still has one
+	ErrMFAConfirmationRequired = errors.New("a security key assertion, authenticator code or recovery code is required")
+)
+
+// TOTPEnrollment is what the user needs to add the account to an authenticator app
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// EnrollTOTP starts authenticator enrollment with a new secret. The authenticator is
+// not used until ConfirmTOTP is called with a code from it; enrolling again before
+// that replaces the secret.
+func EnrollTOTP(user *models.User, issuer string) (*TOTPEnrollment, int, error) {
+	cred, err := models.GetTOTPCredential(user.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, http.StatusInternalServerError, err
+	}
+	if cred != nil && cred.Confirmed() {
+		return nil, http.StatusConflict, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	sealed, err := sealTOTPSecret(user.ID, secret)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if cred == nil {
+		err = models.Dbcon.Create(&models.TOTPCredential{UserID: user.ID, Secret: sealed}).Error
+	} else {
+		err = models.Dbcon.Model(cred).Update("secret", sealed).Error
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: totp.URI(issuer, user.Username, secret)}, 0, nil
+}
+
+// ConfirmTOTP completes enrollment with a code from the authenticator and returns
+// a fresh set of recovery codes, which are shown only this once
+func ConfirmTOTP(userID, code string) ([]string, int, error) {
+	cred, err := models.GetTOTPCredential(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, http.StatusBadRequest, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if cred.Confirmed() {
+		return nil, http.StatusConflict, ErrTOTPAlreadyEnabled
+	}
+	secret, err := openTOTPSecret(cred)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, http.StatusBadRequest, ErrInvalidMFACode
+	}
+
+	var codes []string
+	err = models.Dbcon.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(cred).Updates(map[string]interface{}{"confirmed_at": now, "last_used_step": step}).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return codes, 0, nil
+}
+
+// DisableTOTP removes the authenticator and the recovery codes. A current TOTP
+// code or a recovery code is required, so a stolen session cannot turn MFA off.
+func DisableTOTP(userID, code, recoveryCode string) (int, error) {
+	ok, err := VerifySecondFactor(userID, code, recoveryCode)
+	if errors.Is(err, ErrTOTPNotEnrolled) {
+		return http.StatusBadRequest, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, ErrInvalidMFACode
+	}
+
+	err = models.Dbcon.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user, for example when
+// they run low. A current TOTP code is required.
+func RegenerateRecoveryCodes(userID, code string) ([]string, int, error) {
+	ok, err := VerifySecondFactor(userID, code, "")
+	if errors.Is(err, ErrTOTPNotEnrolled) {
+		return nil, http.StatusBadRequest, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !ok {
+		return nil, http.StatusBadRequest, ErrInvalidMFACode
+	}
+	codes, err := replaceRecoveryCodes(models.Dbcon, userID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return codes, 0, nil
+}
+
+// MFAMethods returns the second factors the user can complete a sign-in with. An
+// empty list means the password is enough.
+func MFAMethods(user *models.User) ([]string, error) {
+	factors, err := models.EnrolledMFAFactors(user)
+	if err != nil {
+		return nil, err
+	}
+	methods := []string{}
+	for _, factor := range factors {
+		methods = append(methods, factor)
+		// Recovery codes stand in for a lost authenticator
+		if factor == models.MFAFactorTOTP {
+			methods = append(methods, MFAMethodRecoveryCode)
+		}
+	}
+	return methods, nil
+}
+
+// SetWebAuthnMFA turns requiring a security key after the password on or off. It
+// can only be turned on once the user has registered a key.
+func SetWebAuthnMFA(user *models.User, enabled bool) (int, error) {
+	if enabled {
+		creds, err := models.GetWebAuthnCredentials(user.ID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if len(creds) == 0 {
+			return http.StatusBadRequest, ErrNoWebAuthnCredentials
+		}
+	}
+	if err := models.Dbcon.Model(user).Update("web_authn_mfa", enabled).Error; err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// VerifySecondFactor checks a TOTP code, or when code is empty a recovery code,
+// for the user. Accepted codes are used up: a TOTP code cannot be replayed and a
+// recovery code works once.
+func VerifySecondFactor(userID, code, recoveryCode string) (bool, error) {
+	cred, err := models.GetTOTPCredential(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !cred.Confirmed()) {
+		return false, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if code != "" {
+		secret, err := openTOTPSecret(cred)
+		if err != nil {
+			return false, err
+		}
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		// Only move forward, a concurrent or replayed use of the step updates nothing
+		result := models.Dbcon.Model(&models.TOTPCredential{}).
+			Where("id = ? AND last_used_step < ?", cred.ID, step).
+			Update("last_used_step", step)
+		return result.RowsAffected == 1, result.Error
+	}
+	if recoveryCode != "" {
+		result := models.Dbcon.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, models.HashRecoveryCode(recoveryCode)).
+			Update("used_at", time.Now())
+		return result.RowsAffected > 0, result.Error
+	}
+	return false, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and issues new ones
+func replaceRecoveryCodes(tx *gorm.DB, userID string) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	rows := make([]models.RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: models.HashRecoveryCode(codes[i])}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// totpSecretLabel binds a sealed authenticator secret to its user, so that it does
+// not open when copied to another account
+func totpSecretLabel(userID string) string {
+	return "totp:" + userID
+}
+
+// sealTOTPSecret seals an authenticator secret for the totp_credentials table
+func sealTOTPSecret(userID, secret string) (string, error) {
+	return jwtops.SealSecret(totpSecretLabel(userID), []byte(secret))
+}
+
+// openTOTPSecret returns the secret of an authenticator. Secrets stored before they
+// were sealed are sealed on the way.
+func openTOTPSecret(cred *models.TOTPCredential) (string, error) {
+	if !strings.HasPrefix(cred.Secret, jwtops.SealedPrefix) {
+		secret := cred.Secret
+		sealed, err := sealTOTPSecret(cred.UserID, secret)
+		if err != nil {
+			return "", err
+		}
+		if err := models.Dbcon.Model(&models.TOTPCredential{}).Where("id = ?", cred.ID).Update("secret", sealed).Error; err != nil {
+			return "", err
+		}
+		return secret, nil
+	}
+	secret, err := jwtops.OpenSecret(totpSecretLabel(cred.UserID), cred.Secret)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// SealTOTPSecrets seals the authenticator secrets stored before they were sealed and
+// returns how many it sealed
+func SealTOTPSecrets() (int, error) {
+	var creds []models.TOTPCredential
+	if err := models.Dbcon.Where("secret NOT LIKE ?", jwtops.SealedPrefix+"%").Find(&creds).Error; err != nil {
+		return 0, err
+	}
+	for i := range creds {
+		if _, err := openTOTPSecret(&creds[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(creds), nil
+}
@@ -0,0 +1,160 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/smsservice"
+	valids "bigbucks/solution/auth/validations"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MobileVerificationLifetime is how long an SMS code can be used
+	MobileVerificationLifetime = 10 * time.Minute
+	// MobileVerificationResendInterval is how long a user waits before another code is sent
+	MobileVerificationResendInterval = time.Minute
+	// MaxMobileVerificationAttempts is how many wrong codes use up a code
+	MaxMobileVerificationAttempts = 5
+)
+
+var (
+	// ErrInvalidMobileCode is returned for wrong, expired, used up or unknown SMS codes
+	ErrInvalidMobileCode = errors.New("invalid or expired verification code")
+	// ErrMobileCodeThrottled is returned when a code was sent too recently
+	ErrMobileCodeThrottled = errors.New("a verification code was sent recently, try again later")
+)
+
+// normalizePhoneNumber drops the separators people type and checks that what is
+// left is an optional + and 7 to 15 digits, the length of E.164 numbers
+func normalizePhoneNumber(number string) (string, bool) {
+	number = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(number)
+	digits := strings.TrimPrefix(number, "+")
+	if len(digits) < 7 || len(digits) > 15 {
+		return "", false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	return number, true
+}
+
+// hashMobileCode hashes an SMS code for storage, bound to the user
+func hashMobileCode(userID, code string) string {
+	sum := sha256.Sum256([]byte(userID + ":" + strings.TrimSpace(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartMobileVerification sends a one-time code by SMS to the number. The number
+// becomes the user's verified number once the code is confirmed; until then the
+// current number is kept. A new code replaces the previous one and is sent at most
+// once per MobileVerificationResendInterval.
+func StartMobileVerification(user *models.User, number string) (int, error) {
+	number, ok := normalizePhoneNumber(number)
+	if !ok {
+		customerr := valids.NewErrorDict()
+		customerr.Errors["phoneNumber"] = "Invalid phone number, expected an international number like +15551234567"
+		return http.StatusBadRequest, customerr
+	}
+	existing, err := models.GetMobileVerification(user.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusInternalServerError, err
+	}
+	if existing != nil && time.Since(existing.CreatedAt) < MobileVerificationResendInterval {
+		return http.StatusTooManyRequests, ErrMobileCodeThrottled
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	verification := &models.MobileVerification{
+		UserID:       user.ID,
+		Token:        hashMobileCode(user.ID, code),
+		MobileNumber: number,
+		ExpiresAt:    time.Now().Add(MobileVerificationLifetime),
+	}
+	err = models.Dbcon.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.MobileVerification{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(verification).Error
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	message := fmt.Sprintf("Your BigBucks verification code is %s. It expires in %d minutes.", code, int(MobileVerificationLifetime.Minutes()))
+	if err := smsservice.SendSMS(number, message); err != nil {
+		loging.Logger.Errorf("Failed to send verification SMS to user %s: %v", user.ID, err)
+		// Let the user try again right away
+		models.Dbcon.Unscoped().Delete(verification)
+		if errors.Is(err, smsservice.ErrNoProvider) {
+			return http.StatusServiceUnavailable, err
+		}
+		return http.StatusInternalServerError, fmt.Errorf("failed to send verification code: %w", err)
+	}
+	return 0, nil
+}
+
+// ConfirmMobileVerification checks the SMS code and makes the number it was sent to
+// the user's verified number. Each code works once and is used up by
+// MaxMobileVerificationAttempts wrong codes.
+func ConfirmMobileVerification(user *models.User, code string) (int, error) {
+	verification, err := models.GetMobileVerification(user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusBadRequest, ErrInvalidMobileCode
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if time.Now().After(verification.ExpiresAt) || verification.Attempts >= MaxMobileVerificationAttempts {
+		models.Dbcon.Unscoped().Delete(verification)
+		return http.StatusBadRequest, ErrInvalidMobileCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashMobileCode(user.ID, code)), []byte(verification.Token)) != 1 {
+		err := models.Dbcon.Model(&models.MobileVerification{}).
+			Where("id = ?", verification.ID).
+			UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusBadRequest, ErrInvalidMobileCode
+	}
+
+	err = models.Dbcon.Transaction(func(tx *gorm.DB) error {
+		// Deleting first makes a concurrent use of the same code fail
+		result := tx.Unscoped().Where("id = ? AND attempts < ?", verification.ID, MaxMobileVerificationAttempts).Delete(&models.MobileVerification{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMobileCode
+		}
+		if err := tx.Model(&models.Profile{}).Where("user_id = ?", user.ID).Update("contact_number", verification.MobileNumber).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", user.ID).Update("mobile_verified", true).Error
+	})
+	if errors.Is(err, ErrInvalidMobileCode) {
+		return http.StatusBadRequest, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,184 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/permission_cache"
+	valids "bigbucks/solution/auth/validations"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// MaxMFAGracePeriodDays is the longest enrollment grace period a policy can give
+const MaxMFAGracePeriodDays = 90
+
+// MFAEnrollmentRequiredCode is the error code of responses refused by an
+// organization's MFA policy
+const MFAEnrollmentRequiredCode = "mfa_enrollment_required"
+
+// MFAEnrollmentRequiredError is returned when an organization requires a second
+// factor the user has not enrolled. It is encoded as the response body so the UI
+// can send the user to enrollment.
+type MFAEnrollmentRequiredError struct {
+	Code           string    `json:"error"`
+	Description    string    `json:"error_description"`
+	OrgID          string    `json:"orgId"`
+	AllowedFactors []string  `json:"allowedFactors"`
+	EnrollBy       time.Time `json:"enrollBy"`
+}
+
+func (e *MFAEnrollmentRequiredError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// OrgSecurityPolicyParams contains the settings of an organization security policy
+type OrgSecurityPolicyParams struct {
+	MFARequired bool
+	// MFARoles limits the requirement to members with one of these roles, empty
+	// requires MFA from every member
+	MFARoles []string
+	// AllowedFactors are the factor types that meet the policy, empty allows any
+	AllowedFactors  []string
+	GracePeriodDays int
+	// PasswordPolicy adds rules for the passwords of members to the default policy
+	PasswordPolicy models.PasswordPolicy
+}
+
+// GetOrgSecurityPolicy returns the security policy of an organization. An
+// organization that never set one gets the empty policy.
+func GetOrgSecurityPolicy(orgID string) (*models.OrgSecurityPolicy, int, error) {
+	policy, err := models.GetOrgSecurityPolicy(orgID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.OrgSecurityPolicy{OrgID: orgID}, 0, nil
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return policy, 0, nil
+}
+
+// UpdateOrgSecurityPolicy sets the security policy of an organization. The grace
+// period starts over whenever the MFA requirement changes; changing only the grace
+// period moves the enrollment deadline of the current requirement. Password rules
+// apply to passwords members set from then on.
+func UpdateOrgSecurityPolicy(orgID string, params OrgSecurityPolicyParams) (*models.OrgSecurityPolicy, int, error) {
+	customerr := valids.NewErrorDict()
+	if params.GracePeriodDays < 0 || params.GracePeriodDays > MaxMFAGracePeriodDays {
+		customerr.Errors["gracePeriodDays"] = fmt.Sprintf("Grace period must be between 0 and %d days", MaxMFAGracePeriodDays)
+	}
+	if minLength := params.PasswordPolicy.MinLength; minLength != 0 && (minLength < models.DefaultPasswordMinLength || minLength > models.MaxPasswordLength) {
+		customerr.Errors["passwordPolicy.minLength"] = fmt.Sprintf("Minimum password length must be between %d and %d", models.DefaultPasswordMinLength, models.MaxPasswordLength)
+	}
+	if historyCount := params.PasswordPolicy.HistoryCount; historyCount < 0 || historyCount > models.MaxPasswordHistory {
+		customerr.Errors["passwordPolicy.historyCount"] = fmt.Sprintf("Password history must be between 0 and %d passwords", models.MaxPasswordHistory)
+	}
+	if maxAgeDays := params.PasswordPolicy.MaxAgeDays; maxAgeDays < 0 || maxAgeDays > models.MaxPasswordAgeDays {
+		customerr.Errors["passwordPolicy.maxAgeDays"] = fmt.Sprintf("Maximum password age must be between 0 and %d days", models.MaxPasswordAgeDays)
+	}
+	for _, factor := range params.AllowedFactors {
+		if !slices.Contains(models.MFAFactors, factor) {
+			customerr.Errors["allowedFactors"] = fmt.Sprintf("Unknown factor %q, expected one of %s", factor, strings.Join(models.MFAFactors, ", "))
+			break
+		}
+	}
+	for _, role := range params.MFARoles {
+		if role == "" || strings.ContainsFunc(role, unicode.IsSpace) {
+			customerr.Errors["mfaRoles"] = fmt.Sprintf("Invalid role name %q", role)
+			break
+		}
+		var count int64
+		if err := models.Dbcon.Model(&models.Role{}).Where("org_id = ? AND name = ?", orgID, role).Count(&count).Error; err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if count == 0 {
+			customerr.Errors["mfaRoles"] = fmt.Sprintf("Role %q does not exist in the organization", role)
+			break
+		}
+	}
+	if len(customerr.Errors) > 0 {
+		return nil, http.StatusBadRequest, customerr
+	}
+
+	policy, code, err := GetOrgSecurityPolicy(orgID)
+	if err != nil {
+		return nil, code, err
+	}
+	roles := strings.Join(params.MFARoles, " ")
+	factors := strings.Join(params.AllowedFactors, " ")
+	changed := policy.MFARequired != params.MFARequired || policy.MFARoles != roles || policy.MFAFactors != factors
+
+	policy.MFARequired = params.MFARequired
+	policy.MFARoles = roles
+	policy.MFAFactors = factors
+	policy.GracePeriodDays = params.GracePeriodDays
+	policy.PasswordMinLength = params.PasswordPolicy.MinLength
+	policy.PasswordRequireUppercase = params.PasswordPolicy.RequireUppercase
+	policy.PasswordRequireLowercase = params.PasswordPolicy.RequireLowercase
+	policy.PasswordRequireDigit = params.PasswordPolicy.RequireDigit
+	policy.PasswordRequireSymbol = params.PasswordPolicy.RequireSymbol
+	policy.PasswordHistoryCount = params.PasswordPolicy.HistoryCount
+	policy.PasswordMaxAgeDays = params.PasswordPolicy.MaxAgeDays
+	if !policy.MFARequired {
+		policy.EnforcedSince = nil
+	} else if changed || policy.EnforcedSince == nil {
+		now := time.Now()
+		policy.EnforcedSince = &now
+	}
+	if err := models.Dbcon.Save(policy).Error; err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return policy, 0, nil
+}
+
+// CheckOrgMFAPolicy checks the MFA policy of an organization for a user. Within the
+// grace period access is allowed and the enrollment deadline is returned; after it
+// a MFAEnrollmentRequiredError is.
+func CheckOrgMFAPolicy(userID, orgID string, perm_cache *permission_cache.PermissionCache, ctx context.Context) (*time.Time, int, error) {
+	// The policy and the user's factors are cached, as every permission-checked
+	// request of the organization reads them
+	policy, err := perm_cache.OrgMFAPolicy(ctx, orgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if policy == nil || !policy.MFARequired {
+		return nil, 0, nil
+	}
+	status, err := perm_cache.UserMFAStatus(ctx, userID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if status.Service || policy.SatisfiedBy(status.Factors) {
+		return nil, 0, nil
+	}
+	if len(policy.Roles()) > 0 {
+		var roles []string
+		err := models.Dbcon.WithContext(ctx).Model(&models.Role{}).
+			Joins("INNER JOIN user_org_roles uor ON uor.role_id = roles.id").
+			Where("uor.user_id = ? AND uor.org_id = ?", userID, orgID).
+			Pluck("roles.name", &roles).Error
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if !policy.AppliesTo(roles) {
+			return nil, 0, nil
+		}
+	}
+	enrollBy := policy.EnrollBy()
+	if time.Now().Before(enrollBy) {
+		return &enrollBy, 0, nil
+	}
+	return nil, http.StatusForbidden, &MFAEnrollmentRequiredError{
+		Code:           MFAEnrollmentRequiredCode,
+		Description:    "the organization requires multi-factor authentication, enroll a second factor and renew the token",
+		OrgID:          orgID,
+		AllowedFactors: policy.AllowedFactors(),
+		EnrollBy:       enrollBy,
+	}
+}
@@ -0,0 +1,45 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/breachedpasswords"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+	valids "bigbucks/solution/auth/validations"
+	"net/http"
+	"strings"
+)
+
+// CheckPassword checks a new password against the password policy of the user's
+// organizations and returns the rules it breaks as a field error on "password".
+// userID is empty for accounts that do not exist yet, which follow the default
+// policy. identities are the username and email addresses of the account.
+//
+// Passwords found in the breached password file are refused, or accepted with
+// breached set when the breachedPasswordsAction setting is "warn".
+func CheckPassword(password, userID string, identities ...string) (breached bool, code int, err error) {
+	policy, err := models.PasswordPolicyFor(userID)
+	if err != nil {
+		return false, http.StatusInternalServerError, err
+	}
+	problems := policy.Check(password, identities...)
+
+	found, err := breachedpasswords.Check(password)
+	if err != nil {
+		// A broken file should not keep users from setting passwords
+		loging.Logger.Errorf("Error checking breached passwords: %v", err)
+	}
+	if found {
+		if settings.Current.BreachedPasswordsAction == settings.BreachedPasswordsWarn {
+			breached = true
+		} else {
+			problems = append(problems, "not be one known from a data breach")
+		}
+	}
+	if len(problems) == 0 {
+		return breached, 0, nil
+	}
+	customerr := valids.NewErrorDict()
+	customerr.Errors["password"] = "Password must " + strings.Join(problems, ", ")
+	return false, http.StatusBadRequest, customerr
+}
@@ -0,0 +1,167 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/passwordreset"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	valids "bigbucks/solution/auth/validations"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPasswordResetLifetime is how long a password reset link can be used
+	// when the passwordResetLifetime setting is not set
+	DefaultPasswordResetLifetime = time.Hour
+	// maxPasswordResetTokenLength bounds the tokens that are verified at all
+	maxPasswordResetTokenLength = 512
+	// PasswordChangeTokenLifetime is how long the token handed out at signin for
+	// an expired password can be used
+	PasswordChangeTokenLifetime = 10 * time.Minute
+)
+
+// ErrInvalidResetToken is returned for reset links that are malformed, expired or
+// already used
+var ErrInvalidResetToken = errors.New("invalid or expired password reset link")
+
+// passwordResetLifetime returns how long reset links are valid
+func passwordResetLifetime() time.Duration {
+	if settings.Current.PasswordResetLifetime > 0 {
+		return settings.Current.PasswordResetLifetime
+	}
+	return DefaultPasswordResetLifetime
+}
+
+// passwordResetSecret derives the key reset links are signed with from the
+// application secret, so they cannot be used as tokens of another kind
+func passwordResetSecret() []byte {
+	return []byte("password-reset:" + settings.Current.SecretKey)
+}
+
+// passwordResetUser loads the user a reset token names. Reset tokens are keyed with
+// the password hash, so they stop working once the password changes.
+func passwordResetUser(userID string) (*models.User, error) {
+	var user models.User
+	if err := models.Dbcon.Select("id", "username", "hashed_password", "account_type").First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if user.AccountType == constants.AccountTypeService {
+		return nil, ErrInvalidResetToken
+	}
+	return &user, nil
+}
+
+// SendPasswordReset emails a password reset link to the user with the email
+// address. Unknown addresses and service accounts are ignored so the response does
+// not tell which accounts exist. For the same reason a link that could not be sent
+// is only logged.
+func SendPasswordReset(email string) (int, error) {
+	var user models.User
+	err := models.Dbcon.Where("username = ?", email).Preload("Profile").First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if user.AccountType == constants.AccountTypeService {
+		return 0, nil
+	}
+
+	lifetime := passwordResetLifetime()
+	token := passwordreset.NewTokenNoPadding(user.ID, lifetime, []byte(user.Password), passwordResetSecret())
+	params := map[string]interface{}{
+		"Subject":        "Reset your password",
+		"Company":        "BigBucks",
+		"Username":       user.Username,
+		"ResetLink":      fmt.Sprintf("%s/auth/changepassword/%s?u=%s", settings.Current.BaseHost, token, url.QueryEscape(user.Username)),
+		"ExpirationDate": time.Now().Add(lifetime).Format("January 2, 2006 at 3:04 PM"),
+	}
+	if err := emailservice.SendEmail(user.Username, "./templates/password_reset.html", params); err != nil {
+		loging.Logger.Errorf("Failed to send password reset email to user %s: %v", user.ID, err)
+	}
+	return 0, nil
+}
+
+// ResetPassword sets the password of the user a reset link was sent to and signs
+// the user out everywhere, ending any lockout of the account. The password follows
+// the policy of the user's organizations; breached is set when it was accepted
+// although it is known from a data breach. The link stops working once the
+// password is changed.
+func ResetPassword(token, password string, sessionStore *sessionstore.SessionStore) (breached bool, code int, err error) {
+	if len(token) > maxPasswordResetTokenLength {
+		return false, http.StatusBadRequest, ErrInvalidResetToken
+	}
+	var lookupErr error
+	var user *models.User
+	_, err = passwordreset.VerifyToken(token, func(userID string) ([]byte, error) {
+		u, err := passwordResetUser(userID)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, ErrInvalidResetToken) {
+				lookupErr = err
+			}
+			return nil, err
+		}
+		user = u
+		return []byte(u.Password), nil
+	}, passwordResetSecret())
+	if lookupErr != nil {
+		return false, http.StatusInternalServerError, lookupErr
+	}
+	if err != nil {
+		return false, http.StatusBadRequest, ErrInvalidResetToken
+	}
+	breached, code, err = CheckPassword(password, user.ID, user.Username)
+	if err != nil {
+		return false, code, err
+	}
+
+	// The hook hashes the password on update and refuses recent passwords.
+	// Matching the old hash makes a concurrent use of the same link fail.
+	result := models.Dbcon.Model(user).
+		Where("hashed_password = ?", user.Password).
+		Update("Password", password)
+	if errors.Is(result.Error, models.ErrPasswordReused) {
+		customerr := valids.NewErrorDict()
+		customerr.Errors["password"] = "Password must not be one you used recently"
+		return false, http.StatusBadRequest, customerr
+	}
+	if result.Error != nil {
+		return false, http.StatusInternalServerError, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, http.StatusBadRequest, ErrInvalidResetToken
+	}
+	if err := sessionStore.RevokeAllUserSessions(user.ID, ""); err != nil {
+		loging.Logger.Error("Error revoking sessions after password reset", err)
+	}
+	// The owner proved control of the account, a lockout from guessing ends here
+	ResetLoginFailures(user.ID, sessionStore)
+	return breached, 0, nil
+}
+
+// PasswordExpired reports whether the password of the user is older than the
+// password policy of the user's organizations allows
+func PasswordExpired(user *models.User) (bool, int, error) {
+	policy, err := models.PasswordPolicyFor(user.ID)
+	if err != nil {
+		return false, http.StatusInternalServerError, err
+	}
+	return policy.Expired(user.PasswordChangedAt), 0, nil
+}
+
+// PasswordChangeToken returns a token for ResetPassword, handed to users signing
+// in with an expired password in place of a session. Like reset links it stops
+// working once the password changes.
+func PasswordChangeToken(user *models.User) string {
+	return passwordreset.NewTokenNoPadding(user.ID, PasswordChangeTokenLifetime, []byte(user.Password), passwordResetSecret())
+}
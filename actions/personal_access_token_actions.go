@@ -0,0 +1,110 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	valids "bigbucks/solution/auth/validations"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// personalAccessTokenHintLength is how much of a token is kept to tell tokens apart
+const personalAccessTokenHintLength = len(jwtops.PersonalAccessTokenPrefix) + 4
+
+// CreatePersonalAccessTokenParams contains parameters for creating a personal access token
+type CreatePersonalAccessTokenParams struct {
+	UserID string
+	Name   string
+	// Permissions restricts the token to these resource:scope:action strings, empty
+	// gives the token all of the user's permissions
+	Permissions []string
+	ExpiresAt   *time.Time
+}
+
+// validPermissionString checks a resource:scope:action string, where each part may be *
+func validPermissionString(perm string) bool {
+	parts := strings.Split(perm, ":")
+	if len(parts) != 3 || strings.TrimSpace(parts[0]) == "" {
+		return false
+	}
+	scope, action := parts[1], parts[2]
+	if scope != "*" && !slices.Contains(constants.Scopes, constants.Scope(scope)) {
+		return false
+	}
+	return action == "*" || slices.Contains(constants.Actions, constants.Action(action))
+}
+
+// CreatePersonalAccessToken creates a token for the user. The token is returned only
+// once, only its hash is stored.
+func CreatePersonalAccessToken(params CreatePersonalAccessTokenParams) (*models.PersonalAccessToken, string, int, error) {
+	customerr := valids.NewErrorDict()
+	if strings.TrimSpace(params.Name) == "" {
+		customerr.Errors["name"] = "Name is required"
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		customerr.Errors["expires_at"] = "Expiry must be in the future"
+	}
+	for _, perm := range params.Permissions {
+		if !validPermissionString(perm) {
+			customerr.Errors["permissions"] = fmt.Sprintf("Invalid permission %q, expected resource:scope:action", perm)
+			break
+		}
+	}
+	if len(customerr.Errors) > 0 {
+		return nil, "", http.StatusBadRequest, customerr
+	}
+
+	secret, err := newClientSecret()
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+	token := jwtops.PersonalAccessTokenPrefix + secret
+
+	pat := &models.PersonalAccessToken{
+		UserID:      params.UserID,
+		Name:        params.Name,
+		TokenHash:   models.HashPersonalAccessToken(token),
+		Hint:        token[:personalAccessTokenHintLength],
+		Permissions: strings.Join(params.Permissions, " "),
+		ExpiresAt:   params.ExpiresAt,
+	}
+	if err := models.Dbcon.Create(pat).Error; err != nil {
+		loging.Logger.Error("Error creating personal access token", err)
+		return nil, "", http.StatusInternalServerError, err
+	}
+	return pat, token, 0, nil
+}
+
+// ListPersonalAccessTokens returns the user's tokens, newest first
+func ListPersonalAccessTokens(userID string) ([]models.PersonalAccessToken, int, error) {
+	var tokens []models.PersonalAccessToken
+	if err := models.Dbcon.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return tokens, 0, nil
+}
+
+// RevokePersonalAccessToken deletes one of the user's tokens, it stops working immediately
+func RevokePersonalAccessToken(userID, tokenID string) (int, error) {
+	customerr := valids.NewErrorDict()
+	var token models.PersonalAccessToken
+	if err := models.Dbcon.Where("id = ? AND user_id = ?", tokenID, userID).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			customerr.Errors["token_id"] = "Token not found"
+			return http.StatusNotFound, customerr
+		}
+		return http.StatusInternalServerError, err
+	}
+	if err := models.Dbcon.Delete(&token).Error; err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
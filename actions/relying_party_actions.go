@@ -0,0 +1,155 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/models"
+	sessionstore "bigbucks/solution/auth/session_store"
+	valids "bigbucks/solution/auth/validations"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// OIDCScopes are the scopes relying parties can be granted
+var OIDCScopes = []string{"openid", "profile", "email", "phone"}
+
+// CodeChallengeMethodS256 is the only PKCE method accepted, plain offers no protection
+// against an intercepted authorization request
+const CodeChallengeMethodS256 = "S256"
+
+// ErrInvalidGrant is returned when an authorization code or refresh token cannot be redeemed
+var ErrInvalidGrant = errors.New("invalid grant")
+
+// CreateRelyingParty registers an OpenID Connect client. Public clients get no
+// secret and must use PKCE; for confidential clients the secret is returned once.
+// Clients without redirect URIs, such as CLIs, can only use the device authorization grant.
+// Users are asked to consent to clients that are not trusted.
+func CreateRelyingParty(name string, redirectURIs, scopes []string, public, trusted bool) (*models.RelyingParty, string, int, error) {
+	customerr := valids.NewErrorDict()
+	if strings.TrimSpace(name) == "" {
+		customerr.Errors["name"] = "Name is required"
+	}
+	for _, uri := range redirectURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.ContainsAny(uri, " \t") {
+			customerr.Errors["redirect_uris"] = fmt.Sprintf("Invalid redirect URI %q, it must be absolute and without fragment", uri)
+			break
+		}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(OIDCScopes, scope) {
+			customerr.Errors["scopes"] = fmt.Sprintf("Unsupported scope %q", scope)
+			break
+		}
+	}
+	if len(customerr.Errors) > 0 {
+		return nil, "", http.StatusBadRequest, customerr
+	}
+
+	clientID, err := newClientID()
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+	rp := &models.RelyingParty{
+		ClientID:     clientID,
+		Name:         name,
+		RedirectURIs: strings.Join(redirectURIs, " "),
+		Scopes:       strings.Join(scopes, " "),
+		Trusted:      trusted,
+	}
+	var secret string
+	if !public {
+		if secret, err = newClientSecret(); err != nil {
+			return nil, "", http.StatusInternalServerError, err
+		}
+		rp.SecretHash = models.HashClientSecret(secret)
+	}
+	if err := models.Dbcon.Create(rp).Error; err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+	return rp, secret, 0, nil
+}
+
+// AuthenticateRelyingParty checks the credentials a relying party presents at the
+// token endpoint. Public clients identify themselves without a secret.
+func AuthenticateRelyingParty(clientID, secret string) (*models.RelyingParty, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+	rp, err := models.GetRelyingParty(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if rp.IsPublic() {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return rp, nil
+	}
+	if !rp.CheckSecret(secret) {
+		return nil, ErrInvalidClient
+	}
+	return rp, nil
+}
+
+// VerifyCodeChallenge checks a PKCE code verifier against the S256 challenge
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	// RFC 7636 verifiers are 43 to 128 characters long
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// RedeemAuthorizationCode exchanges a code for the grant it stands for and the user
+// with roles and profile loaded. The code is consumed even when redemption fails.
+func RedeemAuthorizationCode(rp *models.RelyingParty, code, redirectURI, verifier string, sessionStore *sessionstore.SessionStore) (*sessionstore.AuthorizationCode, *models.User, error) {
+	grant, err := sessionStore.ConsumeAuthorizationCode(code)
+	if errors.Is(err, sessionstore.ErrAuthorizationCodeInvalid) {
+		return nil, nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if grant.ClientID != rp.ClientID || grant.RedirectURI != redirectURI {
+		return nil, nil, ErrInvalidGrant
+	}
+	if grant.CodeChallengeMethod != CodeChallengeMethodS256 || !VerifyCodeChallenge(verifier, grant.CodeChallenge) {
+		return nil, nil, ErrInvalidGrant
+	}
+
+	user, err := LoadTokenUser(grant.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return grant, user, nil
+}
+
+// LoadTokenUser loads a user that is not deactivated with the roles and profile tokens are built from
+func LoadTokenUser(userID string) (*models.User, error) {
+	var user models.User
+	if err := models.Dbcon.Where("id = ?", userID).Preload("Roles").Preload("Profile").First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+	// Like password signin, only deactivated users are refused
+	if user.Status == constants.UserStatusInactive {
+		return nil, ErrInvalidGrant
+	}
+	return &user, nil
+}
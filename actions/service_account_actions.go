@@ -0,0 +1,310 @@
+package actions
+
+import (
+	"bigbucks/solution/auth/constants"
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/permission_cache"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	valids "bigbucks/solution/auth/validations"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// CreateServiceAccountParams contains parameters for creating a service account
+type CreateServiceAccountParams struct {
+	Name   string
+	OrgID  string
+	RoleID string
+	// PublicKey switches the account to private_key_jwt authentication; without it a
+	// client secret is generated
+	PublicKey string
+	// Creator is the user creating the account, who must hold every permission of
+	// the role in the organization, checked with PermCache
+	Creator   *settings.UserInfo
+	PermCache *permission_cache.PermissionCache
+}
+
+// ServiceAccountUsername is the username of the user row backing a service account
+func ServiceAccountUsername(clientID string) string {
+	return fmt.Sprintf("%s@service-accounts", clientID)
+}
+
+// CreateServiceAccount creates a machine identity in the organization. It is a user of
+// type service bound to the role through UserOrgRole, and an API client it
+// authenticates with. The client secret is returned only once.
+func CreateServiceAccount(params CreateServiceAccountParams) (*models.APIClient, string, int, error) {
+	customerr := valids.NewErrorDict()
+	if strings.TrimSpace(params.Name) == "" {
+		customerr.Errors["name"] = "Name is required"
+	}
+	if params.OrgID == "" {
+		customerr.Errors["org_id"] = "Organization ID is required"
+	}
+	if params.RoleID == "" {
+		customerr.Errors["role_id"] = "Role ID is required"
+	}
+	if params.PublicKey != "" {
+		if _, err := jwtops.ParsePublicKeyPEM([]byte(params.PublicKey)); err != nil {
+			customerr.Errors["public_key"] = "Public key must be a PEM encoded EC, RSA or Ed25519 key"
+		}
+	}
+	if len(customerr.Errors) > 0 {
+		return nil, "", http.StatusBadRequest, customerr
+	}
+
+	var role models.Role
+	if err := models.Dbcon.First(&role, "id = ? AND org_id = ?", params.RoleID, params.OrgID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			customerr.Errors["role_id"] = "Role not found"
+			return nil, "", http.StatusBadRequest, customerr
+		}
+		return nil, "", http.StatusInternalServerError, err
+	}
+	if code, err := checkCreatorHoldsRole(&role, params); err != nil {
+		return nil, "", code, err
+	}
+
+	clientID, err := newClientID()
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+
+	var secret string
+	if params.PublicKey == "" {
+		var err error
+		if secret, err = newClientSecret(); err != nil {
+			return nil, "", http.StatusInternalServerError, err
+		}
+	}
+
+	// Never usable, password signin is refused for service accounts
+	password, err := newClientSecret()
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+
+	client := &models.APIClient{
+		ClientID:  clientID,
+		Name:      params.Name,
+		PublicKey: params.PublicKey,
+	}
+	if secret != "" {
+		client.SecretHash = models.HashClientSecret(secret)
+	}
+
+	err = models.Dbcon.Transaction(func(tx *gorm.DB) error {
+		user := models.User{
+			Username:    ServiceAccountUsername(clientID),
+			Password:    password,
+			Status:      constants.UserStatusActive,
+			AccountType: constants.AccountTypeService,
+			Profile:     models.Profile{FirstName: params.Name},
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.UserOrgRole{UserID: user.ID, RoleID: role.ID, OrgID: params.OrgID}).Error; err != nil {
+			return err
+		}
+
+		client.UserID = &user.ID
+		return tx.Create(client).Error
+	})
+	if err != nil {
+		loging.Logger.Error("Error creating service account", err)
+		return nil, "", http.StatusInternalServerError, err
+	}
+	return client, secret, 0, nil
+}
+
+// checkCreatorHoldsRole keeps users from creating service accounts more privileged
+// than themselves, such as one with the Admin role by a user who may only manage
+// users. Each permission of the role, hidden ones included, must be granted to the
+// creator in the organization.
+func checkCreatorHoldsRole(role *models.Role, params CreateServiceAccountParams) (int, error) {
+	customerr := valids.NewErrorDict()
+	customerr.Errors["role_id"] = "The role grants permissions you do not have"
+	if params.Creator == nil || params.PermCache == nil {
+		return http.StatusForbidden, customerr
+	}
+	var permissions []models.Permission
+	err := models.Dbcon.
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Where("role_permissions.role_id = ?", role.ID).
+		Find(&permissions).Error
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	ctx := context.Background()
+	for _, permission := range permissions {
+		allowed, err := params.PermCache.CheckPermission(&ctx, permission.Resource, string(permission.Scope), string(permission.Action), params.OrgID, params.Creator)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !allowed {
+			return http.StatusForbidden, customerr
+		}
+	}
+	return 0, nil
+}
+
+// ListServiceAccounts returns the service account clients with a role in the organization
+func ListServiceAccounts(orgID string) ([]models.APIClient, int, error) {
+	var clients []models.APIClient
+	err := models.Dbcon.
+		Where("user_id IN (?)", models.Dbcon.Model(&models.UserOrgRole{}).Select("user_id").Where("org_id = ?", orgID)).
+		Order("created_at DESC").
+		Find(&clients).Error
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return clients, 0, nil
+}
+
+// getOrgServiceAccount loads a service account client, making sure it belongs to the organization
+func getOrgServiceAccount(tx *gorm.DB, clientID, orgID string) (*models.APIClient, int, error) {
+	customerr := valids.NewErrorDict()
+	var client models.APIClient
+	err := tx.
+		Where("client_id = ? AND user_id IN (?)", clientID, tx.Model(&models.UserOrgRole{}).Select("user_id").Where("org_id = ?", orgID)).
+		First(&client).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			customerr.Errors["client_id"] = "Service account not found"
+			return nil, http.StatusNotFound, customerr
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	return &client, 0, nil
+}
+
+// RotateServiceAccountSecret replaces the client secret of a service account. Accounts
+// using private_key_jwt switch to a client secret.
+func RotateServiceAccountSecret(clientID, orgID string) (string, int, error) {
+	client, code, err := getOrgServiceAccount(models.Dbcon, clientID, orgID)
+	if err != nil {
+		return "", code, err
+	}
+	secret, err := newClientSecret()
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	err = models.Dbcon.Model(client).Updates(map[string]interface{}{
+		"secret_hash": models.HashClientSecret(secret),
+		"public_key":  "",
+	}).Error
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return secret, 0, nil
+}
+
+// DeleteServiceAccount removes a service account and revokes its sessions
+func DeleteServiceAccount(clientID, orgID string, sessionStore *sessionstore.SessionStore) (int, error) {
+	var userID string
+	code := 0
+	err := models.Dbcon.Transaction(func(tx *gorm.DB) error {
+		client, status, err := getOrgServiceAccount(tx, clientID, orgID)
+		if err != nil {
+			code = status
+			return err
+		}
+		userID = *client.UserID
+		if err := tx.Delete(client).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserOrgRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, "id = ?", userID).Error
+	})
+	if err != nil {
+		if code == 0 {
+			code = http.StatusInternalServerError
+		}
+		return code, err
+	}
+	if err := sessionStore.RevokeAllUserSessions(userID, ""); err != nil {
+		loging.Logger.Error("Error revoking service account sessions", err)
+	}
+	return 0, nil
+}
+
+// AuthenticateServiceAccount verifies client credentials for the client_credentials
+// grant and returns the service account user with its roles. Either secret or
+// assertion is set, depending on the client authentication method used.
+func AuthenticateServiceAccount(clientID, secret, assertion string, audiences []string, sessionStore *sessionstore.SessionStore) (*models.User, error) {
+	var client *models.APIClient
+	var err error
+	if assertion != "" {
+		client, err = verifyClientAssertion(assertion, audiences, sessionStore)
+	} else {
+		client, err = AuthenticateAPIClient(clientID, secret)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !client.IsServiceAccount() {
+		return nil, ErrInvalidClient
+	}
+
+	var user models.User
+	if err := models.Dbcon.Where("id = ?", *client.UserID).Preload("Roles").First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if user.AccountType != constants.AccountTypeService || user.Status != constants.UserStatusActive {
+		return nil, ErrInvalidClient
+	}
+	return &user, nil
+}
+
+func verifyClientAssertion(assertion string, audiences []string, sessionStore *sessionstore.SessionStore) (*models.APIClient, error) {
+	clientID, err := jwtops.AssertionClientID(assertion)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	client, err := models.GetAPIClient(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+	if client.PublicKey == "" {
+		return nil, ErrInvalidClient
+	}
+	claims, err := jwtops.VerifyClientAssertion(assertion, client.ClientID, client.PublicKey, audiences)
+	if err != nil {
+		loging.Logger.Debugln("Client assertion rejected", err)
+		return nil, ErrInvalidClient
+	}
+	fresh, err := sessionStore.ClaimAssertionID(client.ClientID, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+func newClientSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,217 @@
+// Package breachedpasswords checks passwords against a local copy of a breached
+// password hash corpus, in the format of the Have I Been Pwned downloads: one
+// "HASH:COUNT" line per password, sorted by hash, where the hash is the uppercase
+// hex SHA-1 or NTLM hash of the password.
+//
+// The file is not loaded into memory. Lookups binary search it by reading a few
+// lines at byte offsets, so a corpus of billions of hashes costs a few dozen reads
+// per check.
+package breachedpasswords
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck // NTLM hashes are MD4
+)
+
+// Hash formats of a corpus
+const (
+	FormatSHA1 = "sha1"
+	FormatNTLM = "ntlm"
+)
+
+// readSize is how much is read at once when looking for a line, more than the
+// longest line of a corpus
+const readSize = 256
+
+var (
+	// ErrInvalidCorpus is returned when a file does not look like a hash corpus
+	ErrInvalidCorpus = errors.New("not a breached password corpus")
+
+	mu      sync.RWMutex
+	current *Corpus
+)
+
+// Corpus is an open breached password hash file
+type Corpus struct {
+	file    *os.File
+	size    int64
+	format  string
+	hashLen int
+}
+
+// Open opens the corpus at path. An empty format is detected from the length of
+// the first hash.
+func Open(path, format string) (*Corpus, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	c := &Corpus{file: file, size: info.Size()}
+	_, first, err := c.lineFrom(0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	hash, _, _ := strings.Cut(first, ":")
+	if _, err := hex.DecodeString(hash); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCorpus, path)
+	}
+	switch {
+	case format == "" && len(hash) == 2*sha1.Size, format == FormatSHA1:
+		c.format, c.hashLen = FormatSHA1, 2*sha1.Size
+	case format == "" && len(hash) == 2*md4.Size, format == FormatNTLM:
+		c.format, c.hashLen = FormatNTLM, 2*md4.Size
+	case format == "":
+		file.Close()
+		return nil, fmt.Errorf("%w: cannot tell the hash format of %s", ErrInvalidCorpus, path)
+	default:
+		file.Close()
+		return nil, fmt.Errorf("%w: unknown hash format %q", ErrInvalidCorpus, format)
+	}
+	if len(hash) != c.hashLen {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s does not hold %s hashes", ErrInvalidCorpus, path, c.format)
+	}
+	return c, nil
+}
+
+// Format returns the hash format of the corpus
+func (c *Corpus) Format() string {
+	return c.format
+}
+
+// Close closes the corpus file
+func (c *Corpus) Close() error {
+	return c.file.Close()
+}
+
+// Contains reports whether the password is in the corpus
+func (c *Corpus) Contains(password string) (bool, error) {
+	return c.containsHash(c.hash(password))
+}
+
+// hash returns the uppercase hex hash of the password in the format of the corpus
+func (c *Corpus) hash(password string) string {
+	var sum []byte
+	if c.format == FormatNTLM {
+		h := md4.New()
+		for _, u := range utf16.Encode([]rune(password)) {
+			h.Write([]byte{byte(u), byte(u >> 8)})
+		}
+		sum = h.Sum(nil)
+	} else {
+		s := sha1.Sum([]byte(password))
+		sum = s[:]
+	}
+	return strings.ToUpper(hex.EncodeToString(sum))
+}
+
+// containsHash binary searches the lines of the file for the hash. lo and hi bound
+// the offsets the line of the hash can start at.
+func (c *Corpus) containsHash(hash string) (bool, error) {
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := c.lineFrom(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= c.size {
+			hi = mid
+			continue
+		}
+		if len(line) < c.hashLen {
+			return false, fmt.Errorf("%w: short line at offset %d", ErrInvalidCorpus, start)
+		}
+		switch strings.Compare(strings.ToUpper(line[:c.hashLen]), hash) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = start + 1
+		default:
+			// No line starts between mid and start
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineFrom returns the first line starting at or after offset, and where it
+// starts. The start is the file size when there is none.
+func (c *Corpus) lineFrom(offset int64) (int64, string, error) {
+	start := offset
+	if offset > 0 {
+		// A line starts at offset when the byte before it ends the previous one
+		pos := offset - 1
+		for {
+			chunk, err := c.read(pos)
+			if err != nil {
+				return 0, "", err
+			}
+			if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+				start = pos + int64(i) + 1
+				break
+			}
+			if pos+int64(len(chunk)) >= c.size {
+				return c.size, "", nil
+			}
+			pos += int64(len(chunk))
+		}
+	}
+	if start >= c.size {
+		return c.size, "", nil
+	}
+	chunk, err := c.read(start)
+	if err != nil {
+		return 0, "", err
+	}
+	if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+		chunk = chunk[:i]
+	}
+	return start, string(bytes.TrimRight(chunk, "\r")), nil
+}
+
+// read reads up to readSize bytes at offset
+func (c *Corpus) read(offset int64) ([]byte, error) {
+	buf := make([]byte, readSize)
+	n, err := c.file.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// SetCorpus makes the corpus the one passwords are checked against, nil turns the
+// check off
+func SetCorpus(corpus *Corpus) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = corpus
+}
+
+// Check reports whether the password is in the corpus set with SetCorpus. It is
+// always false without one.
+func Check(password string) (bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return false, nil
+	}
+	return current.Contains(password)
+}
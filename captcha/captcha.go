@@ -0,0 +1,196 @@
+// Package captcha verifies the tokens CAPTCHA widgets give clients. reCAPTCHA,
+// hCaptcha and Turnstile share the same siteverify protocol: the server posts its
+// secret and the token to the provider and gets back whether a human solved it.
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/settings"
+)
+
+// Values of the captchaProvider setting
+const (
+	ProviderReCaptcha = "recaptcha"
+	ProviderHCaptcha  = "hcaptcha"
+	ProviderTurnstile = "turnstile"
+	// ProviderNoop accepts every token, for development and tests
+	ProviderNoop = "noop"
+)
+
+// Siteverify endpoints of the providers
+const (
+	ReCaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	TurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+// verifyTimeout bounds a call to a provider
+const verifyTimeout = 10 * time.Second
+
+var (
+	// singleton instance
+	instance CaptchaVerifier
+	once     sync.Once
+)
+
+// CaptchaVerifier interface defines the contract for checking CAPTCHA tokens
+type CaptchaVerifier interface {
+	// Verify reports whether the token was solved, remoteIP is the address of the
+	// client when known. The error is set when the provider could not be asked.
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// SiteVerifier checks tokens with a siteverify endpoint
+type SiteVerifier struct {
+	URL    string
+	Secret string
+	// MinScore is the lowest score accepted from providers that score tokens, as
+	// reCAPTCHA v3 does. Zero accepts any.
+	MinScore float64
+	Client   *http.Client
+}
+
+var _ CaptchaVerifier = (*SiteVerifier)(nil)
+
+// NewReCaptcha creates a verifier for Google reCAPTCHA v2 or v3 tokens
+func NewReCaptcha(secret string, minScore float64) *SiteVerifier {
+	return &SiteVerifier{URL: ReCaptchaVerifyURL, Secret: secret, MinScore: minScore, Client: &http.Client{Timeout: verifyTimeout}}
+}
+
+// NewHCaptcha creates a verifier for hCaptcha tokens
+func NewHCaptcha(secret string) *SiteVerifier {
+	return &SiteVerifier{URL: HCaptchaVerifyURL, Secret: secret, Client: &http.Client{Timeout: verifyTimeout}}
+}
+
+// NewTurnstile creates a verifier for Cloudflare Turnstile tokens
+func NewTurnstile(secret string) *SiteVerifier {
+	return &SiteVerifier{URL: TurnstileVerifyURL, Secret: secret, Client: &http.Client{Timeout: verifyTimeout}}
+}
+
+// siteVerifyResponse is the answer of a siteverify endpoint. Score is only sent by
+// providers that score tokens.
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verify posts the token to the provider
+func (v *SiteVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	form := url.Values{"secret": {v.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify CAPTCHA: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to verify CAPTCHA: %s", response.Status)
+	}
+	var result siteVerifyResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode CAPTCHA verification: %w", err)
+	}
+	if !result.Success {
+		loging.Logger.Debugf("CAPTCHA rejected: %v", result.ErrorCodes)
+		return false, nil
+	}
+	if v.MinScore > 0 && result.Score != nil && *result.Score < v.MinScore {
+		loging.Logger.Debugf("CAPTCHA score %.2f below %.2f", *result.Score, v.MinScore)
+		return false, nil
+	}
+	return true, nil
+}
+
+// NoopVerifier accepts every token, for development and tests
+type NoopVerifier struct{}
+
+var _ CaptchaVerifier = NoopVerifier{}
+
+// Verify accepts the token
+func (NoopVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return true, nil
+}
+
+// unavailableVerifier refuses every token, when the configured verifier could not be
+// created
+type unavailableVerifier struct{ err error }
+
+// Verify fails with the error creating the configured verifier
+func (v unavailableVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return false, v.err
+}
+
+// New creates the verifier of the captchaProvider setting. Without a provider no
+// endpoint may ask for a CAPTCHA, accepting every token takes "noop".
+func New(config *settings.Settings) (CaptchaVerifier, error) {
+	switch config.CaptchaProvider {
+	case "":
+		if len(config.CaptchaEndpoints) > 0 {
+			return nil, fmt.Errorf("captchaEndpoints needs a captchaProvider, or %q to accept every token", ProviderNoop)
+		}
+		return NoopVerifier{}, nil
+	case ProviderNoop:
+		return NoopVerifier{}, nil
+	}
+	if config.CaptchaSecret == "" {
+		return nil, fmt.Errorf("CAPTCHA provider %q needs a captchaSecret", config.CaptchaProvider)
+	}
+	switch config.CaptchaProvider {
+	case ProviderReCaptcha:
+		return NewReCaptcha(config.CaptchaSecret, config.CaptchaMinScore), nil
+	case ProviderHCaptcha:
+		return NewHCaptcha(config.CaptchaSecret), nil
+	case ProviderTurnstile:
+		return NewTurnstile(config.CaptchaSecret), nil
+	}
+	return nil, fmt.Errorf("unknown CAPTCHA provider %q", config.CaptchaProvider)
+}
+
+// GetInstance returns the singleton instance of the configured verifier
+func GetInstance() CaptchaVerifier {
+	once.Do(func() {
+		verifier, err := New(settings.Current)
+		if err != nil {
+			loging.Logger.Errorln(err)
+			verifier = unavailableVerifier{err}
+		}
+		instance = verifier
+	})
+	return instance
+}
+
+// SetInstance replaces the verifier used by the package-level functions, for
+// example with a fake in tests
+func SetInstance(verifier CaptchaVerifier) {
+	once.Do(func() {})
+	instance = verifier
+}
+
+// Verify is a package-level function that uses the singleton instance
+func Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return GetInstance().Verify(ctx, token, remoteIP)
+}
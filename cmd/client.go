@@ -0,0 +1,66 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bigbucks/solution/auth/actions"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	clientName   string
+	clientScopes []string
+)
+
+// clientCmd represents the client command
+var clientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "Admin actions for API clients",
+	Long: `Client subcommand actions go here. For example:
+	auth client -h`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+}
+
+// clientCreateCmd represents the client create command
+var clientCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Register an API client",
+	Long: `Registers a confidential client and prints its credentials. The secret is only
+shown once. For example:
+	auth client create --name "billing service" --scope introspect`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, secret, _, err := actions.CreateAPIClient(clientName, clientScopes)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Client ID: %s\nClient secret: %s\n", client.ClientID, secret)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(clientCmd)
+	clientCmd.AddCommand(clientCreateCmd)
+
+	clientCreateCmd.Flags().StringVarP(&clientName, "name", "n", "", "Client name")
+	clientCreateCmd.Flags().StringSliceVarP(&clientScopes, "scope", "s", nil, "Scopes granted to the client [introspect]")
+	_ = clientCreateCmd.MarkFlagRequired("name")
+}
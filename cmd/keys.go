@@ -0,0 +1,161 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	keyAlg   string
+	keyGrace time.Duration
+)
+
+// keysCmd represents the keys command
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the JWT signing keyring",
+	Long: `Keys subcommand actions go here. For example:
+	auth keys -h`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+}
+
+// keysListCmd represents the keys list command
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys in the keyring",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keys, err := models.ListSigningKeys()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KID\tALG\tSTATUS\tCREATED\tEXPIRES")
+		for _, key := range keys {
+			expires := "-"
+			if key.ExpiresAt != nil {
+				expires = key.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", key.Kid, key.Alg, key.Status, key.CreatedAt.Format(time.RFC3339), expires)
+		}
+		return tw.Flush()
+	},
+}
+
+// keysGenerateCmd represents the keys generate command
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a new pending signing key",
+	Long: `Generates a new key and publishes it in the JWKS without signing with it yet.
+Promote it once verifiers had time to fetch the new key set. For example:
+	auth keys generate --alg ES256`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := generateSigningKey()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated key with kid: %s\n", key.Kid)
+		return nil
+	},
+}
+
+// keysPromoteCmd represents the keys promote command
+var keysPromoteCmd = &cobra.Command{
+	Use:   "promote <KID>",
+	Short: "Sign new tokens with the given key",
+	Long: `Makes the key the active signing key. The previously active key is retired and
+keeps verifying tokens for the grace period. For example:
+	auth keys promote <KID> --grace 2h`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := models.PromoteSigningKey(args[0], keyGrace); err != nil {
+			return err
+		}
+		fmt.Printf("Promoted key %s\n", args[0])
+		return nil
+	},
+}
+
+// keysRetireCmd represents the keys retire command
+var keysRetireCmd = &cobra.Command{
+	Use:   "retire <KID>",
+	Short: "Retire a key after the grace period",
+	Long: `Withdraws a pending key; tokens signed with it are accepted until the grace period ends.
+The active key is retired by promoting another key. For example:
+	auth keys retire <KID> --grace 2h`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := models.RetireSigningKey(args[0], keyGrace); err != nil {
+			return err
+		}
+		fmt.Printf("Retired key %s\n", args[0])
+		return nil
+	},
+}
+
+// keysRotateCmd represents the keys rotate command
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Generate a new key and promote it immediately",
+	Long: `Generates a key, makes it active and retires the current key after the grace period.
+For example:
+	auth keys rotate --alg ES256 --grace 2h`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := generateSigningKey()
+		if err != nil {
+			return err
+		}
+		if err := models.PromoteSigningKey(key.Kid, keyGrace); err != nil {
+			return err
+		}
+		fmt.Printf("Rotated to key with kid: %s\n", key.Kid)
+		return nil
+	},
+}
+
+func generateSigningKey() (*models.SigningKey, error) {
+	alg := keyAlg
+	if alg == "" {
+		alg = settings.Current.Alg
+	}
+	key, err := jwtops.GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	return key, models.CreateSigningKey(key)
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysListCmd, keysGenerateCmd, keysPromoteCmd, keysRetireCmd, keysRotateCmd)
+
+	keysGenerateCmd.Flags().StringVarP(&keyAlg, "alg", "a", "", "Signing algorithm, defaults to the configured alg")
+	keysRotateCmd.Flags().StringVarP(&keyAlg, "alg", "a", "", "Signing algorithm, defaults to the configured alg")
+	for _, c := range []*cobra.Command{keysPromoteCmd, keysRetireCmd, keysRotateCmd} {
+		c.Flags().DurationVarP(&keyGrace, "grace", "g", 2*time.Hour, "How long the replaced key keeps verifying tokens")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bigbucks/solution/auth/actions"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	rpName         string
+	rpRedirectURIs []string
+	rpScopes       []string
+	rpPublic       bool
+	rpTrusted      bool
+)
+
+// rpCmd represents the rp command
+var rpCmd = &cobra.Command{
+	Use:   "rp",
+	Short: "Admin actions for OpenID Connect relying parties",
+	Long: `Relying party subcommand actions go here. For example:
+	auth rp -h`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+}
+
+// rpCreateCmd represents the rp create command
+var rpCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Register a relying party",
+	Long: `Registers an application that signs users in with the authorization code flow.
+Public clients such as SPAs get no secret; the secret of confidential clients is
+only shown once. Command line tools that sign in with the device authorization
+grant need no redirect URI. Users are asked to consent to the scopes of clients
+that are not trusted; only mark your own applications trusted. For example:
+	auth rp create --name dashboard --redirect-uri https://app.example.com/callback --public --trusted
+	auth rp create --name cli --public`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rp, secret, _, err := actions.CreateRelyingParty(rpName, rpRedirectURIs, rpScopes, rpPublic, rpTrusted)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Client ID: %s\n", rp.ClientID)
+		if secret != "" {
+			fmt.Printf("Client secret: %s\n", secret)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rpCmd)
+	rpCmd.AddCommand(rpCreateCmd)
+
+	rpCreateCmd.Flags().StringVarP(&rpName, "name", "n", "", "Client name")
+	rpCreateCmd.Flags().StringSliceVarP(&rpRedirectURIs, "redirect-uri", "r", nil, "Allowed redirect URI, repeat for several")
+	rpCreateCmd.Flags().StringSliceVarP(&rpScopes, "scope", "s", nil, "Scopes the client may request [openid profile email phone], defaults to openid profile email")
+	rpCreateCmd.Flags().BoolVar(&rpPublic, "public", false, "Public client without a secret, PKCE only")
+	rpCreateCmd.Flags().BoolVar(&rpTrusted, "trusted", false, "First party client users are not asked to consent to")
+	_ = rpCreateCmd.MarkFlagRequired("name")
+}
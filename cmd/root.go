@@ -16,12 +16,20 @@ limitations under the License.
 package cmd
 
 import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/breachedpasswords"
+	"bigbucks/solution/auth/captcha"
+	"bigbucks/solution/auth/geoip"
 	grpc_auth "bigbucks/solution/auth/grpc-auth"
+	jwtops "bigbucks/solution/auth/jwt-ops"
 	"bigbucks/solution/auth/loging"
 	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/passwordhash"
 	"bigbucks/solution/auth/permission_cache"
+	"bigbucks/solution/auth/ratelimit"
 	router "bigbucks/solution/auth/rest-api"
 	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/smsservice"
 	"context"
 	"os/signal"
 	"syscall"
@@ -76,6 +84,18 @@ var rootCmd = &cobra.Command{
 		loging.Initialize(config)
 		defer loging.Logger.Sync() //nolint:errcheck
 
+		// Every command hashes passwords the same way, setup-superorg included
+		hasher := passwordhash.NewArgon2id(settings.Current.Argon2Time, settings.Current.Argon2MemoryKiB, settings.Current.Argon2Threads)
+		if hasher.Time < settings.Current.Argon2Time || hasher.MemoryKiB < settings.Current.Argon2MemoryKiB {
+			loging.Logger.Warnf("argon2Time and argon2MemoryKiB are limited to %d and %d, hashing with %d passes over %d KiB",
+				passwordhash.MaxArgon2Time, passwordhash.MaxArgon2MemoryKiB, hasher.Time, hasher.MemoryKiB)
+		}
+		passwordhash.SetHasher(hasher)
+		if settings.Current.Argon2MaxConcurrent > passwordhash.MaxArgon2Concurrent {
+			loging.Logger.Warnf("argon2MaxConcurrent is limited to %d", passwordhash.MaxArgon2Concurrent)
+		}
+		passwordhash.SetMaxConcurrent(settings.Current.Argon2MaxConcurrent)
+
 		// dsn := "user=bigbucks password=bigbucks DB.name=bigbucks port=5432 host=localhost sslmode=disable"
 		dsn := fmt.Sprintf("host=%s user=%s password=%s DB.name=%s port=%s sslmode=disable", settings.Current.DBHost, settings.Current.DBUsername, settings.Current.DBPassword, settings.Current.DBName, settings.Current.DBPort)
 		models.Dbcon, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Info), TranslateError: true})
@@ -108,12 +128,67 @@ var rootCmd = &cobra.Command{
 
 		// defer models.Dbcon.Close()
 
+		defer openBreachedPasswords()()
+
+		if settings.Current.GeoIPDatabase != "" {
+			db, err := geoip.Open(settings.Current.GeoIPDatabase)
+			if err != nil {
+				loging.Logger.Fatalln("Error opening GeoIP database:", err)
+			}
+			geoip.SetDatabase(db)
+			loging.Logger.Infoln("Locating logins with the", db.Type(), "database", settings.Current.GeoIPDatabase)
+		}
+
+		// Only the server signs tokens, the other commands may run to fix its keys
+		if err := jwtops.CheckConfiguredKeys(); err != nil {
+			loging.Logger.Fatalln(err)
+		}
+		// Authenticator secrets are sealed with the keys, those of older releases once
+		if sealed, err := actions.SealTOTPSecrets(); err != nil {
+			loging.Logger.Fatalln("Error sealing authenticator secrets:", err)
+		} else if sealed > 0 {
+			loging.Logger.Infof("Sealed %d authenticator secrets", sealed)
+		}
+		if settings.Current.Issuer == "" {
+			loging.Logger.Fatalln("issuer must be set to the external URL of the service")
+		}
+		if _, err := settings.Current.TrustedProxyPrefixes(); err != nil {
+			loging.Logger.Fatalln(err)
+		}
+
+		sender, err := smsservice.New(settings.Current)
+		if err != nil {
+			loging.Logger.Fatalln(err)
+		}
+		smsservice.SetInstance(sender)
+
+		verifier, err := captcha.New(settings.Current)
+		if err != nil {
+			loging.Logger.Fatalln(err)
+		}
+		captcha.SetInstance(verifier)
+
 		g.Go(func() error { return startGrpcServer(settings.Current) })
 		g.Go(func() error { return startHttpServer(settings.Current) })
 		HandleGracefulShutdown(g)
 	},
 }
 
+// openBreachedPasswords opens the breachedPasswordsFile, if set, for the commands that
+// set passwords and returns the function closing it
+func openBreachedPasswords() func() {
+	if settings.Current.BreachedPasswordsFile == "" {
+		return func() {}
+	}
+	corpus, err := breachedpasswords.Open(settings.Current.BreachedPasswordsFile, settings.Current.BreachedPasswordsFormat)
+	if err != nil {
+		loging.Logger.Fatalln("Error opening breached password file:", err)
+	}
+	breachedpasswords.SetCorpus(corpus)
+	loging.Logger.Infoln("Checking new passwords against", corpus.Format(), "hashes in", settings.Current.BreachedPasswordsFile)
+	return func() { corpus.Close() }
+}
+
 func startHttpServer(settings *settings.Settings) (err error) {
 	perm_cache := permission_cache.NewPermissionCache(settings)
 	session_store := sessionstore.NewSessionStore(settings)
@@ -144,9 +219,15 @@ func startGrpcServer(settings *settings.Settings) (err error) {
 	perm_cache := permission_cache.NewPermissionCache(settings)
 	session_store := sessionstore.NewSessionStore(settings)
 	auth_server := grpc_auth.NewGRPCServer(settings, *perm_cache, *session_store)
+	limiter := ratelimit.NewLimiter(settings)
 	grpcServer = grpc.NewServer(grpc.ChainUnaryInterceptor(
 		grpc_zap.UnaryServerInterceptor(loging.InterceptorLogger(loging.Logger.Desugar())),
 		auth_server.JWTInterceptor,
+		grpc_auth.RateLimitInterceptor(limiter, grpc_auth.DefaultRateLimits),
+	), grpc.ChainStreamInterceptor(
+		grpc_zap.StreamServerInterceptor(loging.InterceptorLogger(loging.Logger.Desugar())),
+		auth_server.JWTStreamInterceptor,
+		grpc_auth.RateLimitStreamInterceptor(limiter, grpc_auth.DefaultRateLimits),
 	))
 
 	reflection.Register(grpcServer)
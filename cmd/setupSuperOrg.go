@@ -21,8 +21,10 @@ import (
 	"bigbucks/solution/auth/models"
 	"bigbucks/solution/auth/permission_cache"
 	"bigbucks/solution/auth/settings"
+	valids "bigbucks/solution/auth/validations"
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -39,6 +41,7 @@ var setupSuperOrgCmd = &cobra.Command{
 	Short: "Initializes the super organization and a super admin user",
 	Long:  `This command initializes the super organization and a super admin user.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		defer openBreachedPasswords()()
 
 		var role models.Role
 		err := models.Dbcon.Transaction(func(tx *gorm.DB) error {
@@ -73,6 +76,16 @@ var setupSuperOrgCmd = &cobra.Command{
 			}
 			password := string(passwordBytes)
 			fmt.Println() // Add newline after password input
+			// The account does not exist yet, so the default policy applies
+			breached, _, err := actions.CheckPassword(password, "", username, email)
+			if validationErr, ok := err.(*valids.ValidationErrors); ok {
+				return errors.New(validationErr.Errors["password"])
+			} else if err != nil {
+				return err
+			}
+			if breached {
+				fmt.Println("Warning: this password is known from a data breach")
+			}
 
 			fmt.Print("Enter first name: ")
 			firstName, err := reader.ReadString('\n')
@@ -0,0 +1,64 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/models"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// userCmd represents the user command
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Admin actions for users",
+	Long: `User subcommand actions go here. For example:
+	auth user -h`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+}
+
+// userUnlockCmd represents the user unlock command
+var userUnlockCmd = &cobra.Command{
+	Use:   "unlock <username>",
+	Short: "Unlock an account locked after failed sign-ins",
+	Long: `Ends the lockout of an account locked after too many wrong passwords and
+forgets its failed sign-ins. The unlock is written to the audit log. For example:
+	auth user unlock someone@example.com`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var user models.User
+		if err := models.Dbcon.Select("id").Where("username = ?", args[0]).First(&user).Error; err != nil {
+			return fmt.Errorf("finding user %s: %w", args[0], err)
+		}
+		if _, err := actions.UnlockAccount(actions.UnlockAccountParams{UserID: user.ID}, sessionstore.NewSessionStore(settings.Current)); err != nil {
+			return err
+		}
+		fmt.Printf("Unlocked %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userUnlockCmd)
+}
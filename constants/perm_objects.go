@@ -5,6 +5,7 @@ import "database/sql/driver"
 type Scope string
 type Action string
 type UserStatus string
+type AccountType string
 
 const (
 	ScopeAll        Scope = "all"
@@ -33,6 +34,11 @@ var Actions = []Action{ActionWrite, ActionCreate, ActionUpdate, ActionDelete, Ac
 
 var Resources = []string{"user", "masterdata", "inventory", "role", "permission", "account", "transaction", "session"}
 
+const (
+	AccountTypeHuman   AccountType = "human"
+	AccountTypeService AccountType = "service"
+)
+
 var UserStatuses = []UserStatus{UserStatusActive, UserStatusInactive, UserStatusPending}
 
 func (p *Action) Scan(value interface{}) error {
@@ -60,3 +66,12 @@ func (p *UserStatus) Scan(value interface{}) error {
 func (p UserStatus) Value() (driver.Value, error) {
 	return string(p), nil
 }
+
+func (p *AccountType) Scan(value interface{}) error {
+	*p = AccountType(value.(string))
+	return nil
+}
+
+func (p AccountType) Value() (driver.Value, error) {
+	return string(p), nil
+}
@@ -0,0 +1,322 @@
+package deviceauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"bigbucks/solution/auth/settings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Redis key prefixes for pending device authorizations
+	deviceCodePrefix = "device:code:"
+	userCodePrefix   = "device:user:"
+	// CodeExpiry is how long a device has to get its user code approved
+	CodeExpiry = 10 * time.Minute
+	// PollInterval is the minimum time between token requests of a device
+	PollInterval = 5 * time.Second
+	// userCodeAlphabet has no vowels, so codes never spell words, and no look-alike characters
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Authorization states
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// Polling outcomes, named after the RFC 8628 error codes
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+	// ErrUnknownUserCode is returned when a user code does not match a pending authorization
+	ErrUnknownUserCode = errors.New("unknown or expired user code")
+)
+
+// Authorization is a device authorization request as stored in Redis
+type Authorization struct {
+	ClientID     string    `json:"clientId"`
+	Scope        string    `json:"scope"`
+	UserCode     string    `json:"userCode"`
+	Status       string    `json:"status"`
+	UserID       string    `json:"userId,omitempty"`
+	ApprovedAt   time.Time `json:"approvedAt,omitempty"`
+	LastPolledAt time.Time `json:"lastPolledAt,omitempty"`
+	// Interval grows each time the device polls too fast
+	Interval time.Duration `json:"interval"`
+}
+
+// Service keeps pending device authorizations in Redis.
+type Service struct {
+	redis *redis.Client
+	ctx   context.Context
+}
+
+// NewService creates a new device authorization service from application settings.
+func NewService(s *settings.Settings) *Service {
+	client := redis.NewClient(&redis.Options{
+		Addr:     s.RedisAddress,
+		Username: s.RedisUsername,
+		Password: s.RedisPassword,
+		DB:       0,
+	})
+	return &Service{
+		redis: client,
+		ctx:   context.Background(),
+	}
+}
+
+// Begin starts a device authorization for the client and returns the device code the
+// device polls with, along with the pending authorization holding the user code.
+func (svc *Service) Begin(clientID, scope string) (string, *Authorization, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(b)
+	userCode, err := newUserCode()
+	if err != nil {
+		return "", nil, err
+	}
+
+	auth := &Authorization{
+		ClientID: clientID,
+		Scope:    scope,
+		UserCode: userCode,
+		Status:   StatusPending,
+		Interval: PollInterval,
+	}
+	authJSON, err := json.Marshal(auth)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// A colliding user code must not take over another pending authorization
+	ok, err := svc.redis.SetNX(svc.ctx, userCodePrefix+userCode, hashDeviceCode(deviceCode), CodeExpiry).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, fmt.Errorf("user code collision, retry")
+	}
+	if err := svc.redis.Set(svc.ctx, deviceCodePrefix+hashDeviceCode(deviceCode), authJSON, CodeExpiry).Err(); err != nil {
+		return "", nil, err
+	}
+	return deviceCode, auth, nil
+}
+
+// Lookup returns the pending authorization of a user code, so the user can check
+// which client is asking before approving
+func (svc *Service) Lookup(userCode string) (*Authorization, error) {
+	deviceKey, err := svc.deviceKey(userCode)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := svc.read(svc.redis, deviceKey)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUnknownUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if auth.Status != StatusPending {
+		return nil, ErrUnknownUserCode
+	}
+	return auth, nil
+}
+
+// Approve grants the device authorization to the user. Deny refuses it. Either way
+// the user code cannot be used again.
+func (svc *Service) Approve(userCode, userID string) (*Authorization, error) {
+	return svc.decide(userCode, func(auth *Authorization) {
+		auth.Status = StatusApproved
+		auth.UserID = userID
+		auth.ApprovedAt = time.Now()
+	})
+}
+
+// Deny refuses the device authorization of the user code
+func (svc *Service) Deny(userCode string) (*Authorization, error) {
+	return svc.decide(userCode, func(auth *Authorization) {
+		auth.Status = StatusDenied
+	})
+}
+
+func (svc *Service) decide(userCode string, update func(*Authorization)) (*Authorization, error) {
+	userKey := userCodePrefix + NormalizeUserCode(userCode)
+	deviceKey, err := svc.deviceKey(userCode)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth *Authorization
+	err = svc.redis.Watch(svc.ctx, func(tx *redis.Tx) error {
+		var err error
+		auth, err = svc.read(tx, deviceKey)
+		if errors.Is(err, redis.Nil) {
+			return ErrUnknownUserCode
+		}
+		if err != nil {
+			return err
+		}
+		if auth.Status != StatusPending {
+			return ErrUnknownUserCode
+		}
+		update(auth)
+		authJSON, err := json.Marshal(auth)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(svc.ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(svc.ctx, deviceKey, authJSON, redis.SetArgs{KeepTTL: true})
+			pipe.Del(svc.ctx, userKey)
+			return nil
+		})
+		return err
+	}, deviceKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, ErrUnknownUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// Poll reports the state of a device authorization for the token endpoint. An
+// approved authorization is returned once and then removed; otherwise one of the
+// polling errors is returned.
+func (svc *Service) Poll(deviceCode, clientID string) (*Authorization, error) {
+	deviceKey := deviceCodePrefix + hashDeviceCode(deviceCode)
+	var auth *Authorization
+	err := svc.redis.Watch(svc.ctx, func(tx *redis.Tx) error {
+		var err error
+		auth, err = svc.read(tx, deviceKey)
+		if errors.Is(err, redis.Nil) {
+			return ErrExpiredToken
+		}
+		if err != nil {
+			return err
+		}
+		if auth.ClientID != clientID {
+			return ErrExpiredToken
+		}
+
+		switch auth.Status {
+		case StatusApproved, StatusDenied:
+			_, err = tx.TxPipelined(svc.ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(svc.ctx, deviceKey)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if auth.Status == StatusDenied {
+				return ErrAccessDenied
+			}
+			return nil
+		}
+
+		pollErr := ErrAuthorizationPending
+		now := time.Now()
+		if !auth.LastPolledAt.IsZero() && now.Sub(auth.LastPolledAt) < auth.Interval {
+			// RFC 8628 section 3.5, the device must add 5 seconds to its interval
+			auth.Interval += PollInterval
+			pollErr = ErrSlowDown
+		}
+		auth.LastPolledAt = now
+		authJSON, err := json.Marshal(auth)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(svc.ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(svc.ctx, deviceKey, authJSON, redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return pollErr
+	}, deviceKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		// Concurrent polls of the same device
+		return nil, ErrSlowDown
+	}
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (svc *Service) deviceKey(userCode string) (string, error) {
+	hash, err := svc.redis.Get(svc.ctx, userCodePrefix+NormalizeUserCode(userCode)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrUnknownUserCode
+	}
+	if err != nil {
+		return "", err
+	}
+	return deviceCodePrefix + hash, nil
+}
+
+func (svc *Service) read(cmd redis.Cmdable, key string) (*Authorization, error) {
+	authJSON, err := cmd.Get(svc.ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var auth Authorization
+	if err := json.Unmarshal(authJSON, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+// FormatUserCode splits a user code in two halves for display, e.g. WDJB-MJHT
+func FormatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// NormalizeUserCode accepts user codes typed in lower case or with separators
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			return r
+		}
+		return -1
+	}, code)
+}
+
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// hashDeviceCode hashes a device code so that only digests are kept in Redis
+func hashDeviceCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -121,6 +121,13 @@ func GetInstance() EmailSender {
 	return instance
 }
 
+// SetInstance replaces the sender used by the package-level functions, for example
+// with a fake in tests
+func SetInstance(sender EmailSender) {
+	once.Do(func() {})
+	instance = sender
+}
+
 // SendEmail is a package-level function that uses the singleton instance
 func SendEmail(toEmail string, templatePath string, params any) error {
 	return GetInstance().SendEmail(toEmail, templatePath, params)
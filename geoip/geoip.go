@@ -0,0 +1,122 @@
+// Package geoip finds where addresses are in an offline MaxMind DB file, such as
+// GeoLite2-City.mmdb, GeoLite2-Country.mmdb or the DB-IP lite databases, read
+// with the MaxMind DB reader.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	// ErrInvalidDatabase is returned when a file does not look like a MaxMind DB
+	ErrInvalidDatabase = errors.New("not a MaxMind DB file")
+
+	mu      sync.RWMutex
+	current *Database
+)
+
+// Location is where an address is. Country databases have no coordinates.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code, empty when unknown
+	Country        string
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
+
+// country is the country part of a record
+type country struct {
+	ISOCode string `maxminddb:"iso_code"`
+}
+
+// record is what a lookup reads of the record of a network
+type record struct {
+	Country country `maxminddb:"country"`
+	// RegisteredCountry stands in for networks with no country of their own, like
+	// anycast ones
+	RegisteredCountry country `maxminddb:"registered_country"`
+	Location          struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// Database is an open MaxMind DB
+type Database struct {
+	reader *maxminddb.Reader
+}
+
+// Open maps the database at path into memory
+func Open(path string) (*Database, error) {
+	reader, err := maxminddb.Open(path)
+	var invalid maxminddb.InvalidDatabaseError
+	if errors.As(err, &invalid) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Database{reader: reader}, nil
+}
+
+// Close unmaps the database
+func (db *Database) Close() error {
+	return db.reader.Close()
+}
+
+// Type is the database type of the metadata, such as "GeoLite2-City"
+func (db *Database) Type() string {
+	return db.reader.Metadata.DatabaseType
+}
+
+// Lookup returns where the address is. found is false for addresses the database
+// does not know, such as private ones.
+func (db *Database) Lookup(ip net.IP) (location Location, found bool, err error) {
+	// IPv4 databases know no IPv6 address
+	if ip.To4() == nil && db.reader.Metadata.IPVersion == 4 {
+		return Location{}, false, nil
+	}
+	var r record
+	if _, found, err = db.reader.LookupNetwork(ip, &r); err != nil || !found {
+		return Location{}, false, err
+	}
+	location.Country = r.Country.ISOCode
+	if location.Country == "" {
+		location.Country = r.RegisteredCountry.ISOCode
+	}
+	if r.Location.Latitude != nil && r.Location.Longitude != nil {
+		location.Latitude, location.Longitude, location.HasCoordinates = *r.Location.Latitude, *r.Location.Longitude, true
+	}
+	return location, true, nil
+}
+
+// SetDatabase makes the database the one addresses are looked up in, nil turns
+// lookups off
+func SetDatabase(db *Database) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = db
+}
+
+// Lookup finds the address, with or without a port, in the database set with
+// SetDatabase. found is always false without one.
+func Lookup(address string) (location Location, found bool, err error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return Location{}, false, nil
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return Location{}, false, nil
+	}
+	return current.Lookup(ip)
+}
@@ -2,7 +2,9 @@ package grpc_auth
 
 import (
 	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/settings"
 	context "context"
+	"errors"
 	"log"
 
 	grpc "google.golang.org/grpc"
@@ -19,6 +21,41 @@ func (s *Server) JWTInterceptor(
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler,
 ) (interface{}, error) {
+	newCtx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(newCtx, req)
+
+}
+
+// JWTStreamInterceptor applies the same checks as JWTInterceptor to streaming RPCs
+func (s *Server) JWTStreamInterceptor(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	newCtx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: newCtx})
+}
+
+// authenticatedStream exposes the context carrying the authenticated user to stream handlers
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authenticatedStream) Context() context.Context {
+	return a.ctx
+}
+
+// authenticate verifies the token in the request metadata and that its session is
+// still live, and returns a context carrying the user claims.
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
@@ -31,15 +68,46 @@ func (s *Server) JWTInterceptor(
 
 	accessToken := values[0]
 
+	if jwtops.IsPersonalAccessToken(accessToken) {
+		// Personal access tokens are looked up on every use and have no session
+		AuthClaim, err := jwtops.VerifyPersonalAccessToken(accessToken)
+		if errors.Is(err, jwtops.ErrInvalidPersonalAccessToken) {
+			return nil, status.Errorf(codes.Unauthenticated, "invalid authorization token")
+		}
+		if err != nil {
+			log.Printf("Error verifying personal access token: %v", err)
+			return nil, status.Errorf(codes.Unavailable, "could not verify token")
+		}
+		return authenticatedContext(ctx, AuthClaim), nil
+	}
+
 	AuthClaim, _, err := jwtops.VerifyJWT(accessToken)
 
 	if err != nil {
-		log.Printf("Error verifying JWT: %v, token: %s", err, accessToken)
+		log.Printf("Error verifying JWT: %v", err)
 		return nil, status.Errorf(codes.Unauthenticated, "invalid authorization token")
 	}
+	// Access tokens of relying parties carry no roles to authorize with
+	if AuthClaim.RelyingParty() {
+		return nil, status.Errorf(codes.PermissionDenied, "token was issued to a relying party")
+	}
+
+	// The signature alone does not tell whether the session was revoked
+	live, err := s.sessionstore.CheckSession(AuthClaim.ID)
+	if err != nil {
+		log.Printf("Error checking session: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "could not check session")
+	}
+	if !live {
+		return nil, status.Errorf(codes.Unauthenticated, "session expired")
+	}
+
+	return authenticatedContext(ctx, AuthClaim), nil
+}
 
+// authenticatedContext returns a context carrying the user claims
+func authenticatedContext(ctx context.Context, AuthClaim settings.AuthToken) context.Context {
 	newCtx := context.WithValue(ctx, UserValue("user"), AuthClaim.User)
 	newCtx = context.WithValue(newCtx, UserValue("userID"), AuthClaim.Subject)
-	return handler(newCtx, req)
-
+	return newCtx
 }
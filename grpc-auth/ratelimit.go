@@ -0,0 +1,134 @@
+package grpc_auth
+
+import (
+	"bigbucks/solution/auth/ratelimit"
+	context "context"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	status "google.golang.org/grpc/status"
+)
+
+// RateLimitKey picks the calls a rate limit counts together. Calls with an empty
+// key are not counted.
+type RateLimitKey func(ctx context.Context) string
+
+// ByPeer counts the calls of each client address
+func ByPeer(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "ip:" + addr
+}
+
+// ByUser counts the calls of each user. JWTInterceptor has to run first.
+func ByUser(ctx context.Context) string {
+	if userID, _ := ctx.Value(UserValue("userID")).(string); userID != "" {
+		return "user:" + userID
+	}
+	return ""
+}
+
+// ByOrg counts the calls for each organization in the x-organization-id metadata
+func ByOrg(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-organization-id"); len(values) > 0 && values[0] != "" {
+			return "org:" + values[0]
+		}
+	}
+	return ""
+}
+
+// RateLimit limits the calls to a method that share a key
+type RateLimit struct {
+	Key  RateLimitKey
+	Rule ratelimit.Rule
+}
+
+// DefaultRateLimits are the limits of the methods of the Auth service, by full
+// method name
+var DefaultRateLimits = map[string][]RateLimit{
+	Auth_Authenticate_FullMethodName: {{Key: ByUser, Rule: ratelimit.Rule{Name: "grpc-authenticate-user", Limit: 1200, Window: time.Minute}}},
+	Auth_Authorize_FullMethodName:    {{Key: ByUser, Rule: ratelimit.Rule{Name: "grpc-authorize-user", Limit: 1200, Window: time.Minute}}},
+}
+
+// RateLimitInterceptor limits the calls to the methods in limits, keyed by full
+// method name. Calls over a limit fail with ResourceExhausted and get retry-after
+// and ratelimit-* headers like the REST API.
+func RateLimitInterceptor(limiter *ratelimit.Limiter, limits map[string][]RateLimit) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		header, err := checkRateLimits(ctx, limiter, limits[info.FullMethod])
+		if header != nil {
+			// Fails only outside of a server call, as in tests
+			_ = grpc.SetHeader(ctx, header)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RateLimitStreamInterceptor applies the same limits as RateLimitInterceptor to
+// streaming RPCs, counting each stream once
+func RateLimitStreamInterceptor(limiter *ratelimit.Limiter, limits map[string][]RateLimit) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		header, err := checkRateLimits(stream.Context(), limiter, limits[info.FullMethod])
+		if header != nil {
+			_ = stream.SetHeader(header)
+		}
+		if err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// checkRateLimits counts the call against the limits and returns the headers of
+// the one closest to its limit, with an error once a limit is reached. Calls are
+// let through when Redis cannot be reached.
+func checkRateLimits(ctx context.Context, limiter *ratelimit.Limiter, limits []RateLimit) (metadata.MD, error) {
+	var tightest *ratelimit.Result
+	for _, limit := range limits {
+		key := limit.Key(ctx)
+		if key == "" {
+			continue
+		}
+		result, err := limiter.Allow(ctx, limit.Rule, key)
+		if err != nil {
+			log.Printf("Error checking rate limit %s: %v", limit.Rule.Name, err)
+			continue
+		}
+		if tightest == nil || !result.Allowed || result.Remaining < tightest.Remaining {
+			tightest = &result
+		}
+		if !result.Allowed {
+			break
+		}
+	}
+	if tightest == nil {
+		return nil, nil
+	}
+	header := metadata.Pairs(
+		"ratelimit-limit", strconv.Itoa(tightest.Limit),
+		"ratelimit-remaining", strconv.Itoa(tightest.Remaining),
+		"ratelimit-reset", strconv.Itoa(ratelimit.Seconds(tightest.Reset)),
+	)
+	if !tightest.Allowed {
+		retryAfter := ratelimit.Seconds(tightest.RetryAfter())
+		header.Set("retry-after", strconv.Itoa(retryAfter))
+		return header, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %d seconds", retryAfter)
+	}
+	return header, nil
+}
@@ -2,14 +2,21 @@ package grpc_auth
 
 import (
 	context "context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"bigbucks/solution/auth/actions"
 	"bigbucks/solution/auth/permission_cache"
 	sessionstore "bigbucks/solution/auth/session_store"
 	"bigbucks/solution/auth/settings"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	status "google.golang.org/grpc/status"
 )
 
@@ -73,6 +80,22 @@ func (s *Server) Authorize(ctx context.Context, in *AuthorizeRequest) (*Authoriz
 	log.Printf("Authorize: user=%s resource=%s scope=%s action=%s org=%s",
 		userInfo.Username, in.Resource, scope, in.Action, in.OrgId)
 
+	// Members who have not met the organization's MFA policy are refused like on
+	// the REST API, during the grace period they are reminded
+	userID, _ := ctx.Value(UserValue("userID")).(string)
+	enrollBy, _, err := actions.CheckOrgMFAPolicy(userID, in.OrgId, &s.permcache, ctx)
+	var enrollErr *actions.MFAEnrollmentRequiredError
+	if errors.As(err, &enrollErr) {
+		return nil, mfaEnrollmentRequired(s.settings, enrollErr)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "MFA policy check failed: %v", err)
+	}
+	if enrollBy != nil {
+		// Fails only outside of a server call, as in tests
+		_ = grpc.SetHeader(ctx, metadata.Pairs("x-mfa-enroll-by", enrollBy.UTC().Format(time.RFC3339)))
+	}
+
 	allowed, err := s.permcache.CheckPermission(&ctx, in.Resource, scope, in.Action, in.OrgId, &userInfo)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "permission check failed: %v", err)
@@ -94,3 +117,22 @@ func (s *Server) Authorize(ctx context.Context, in *AuthorizeRequest) (*Authoriz
 
 	return resp, nil
 }
+
+// mfaEnrollmentRequired is the PermissionDenied status of a call refused by an
+// organization's MFA policy. Its ErrorInfo detail carries the reason and the fields
+// of the REST API response, so clients can send the user to enrollment.
+func mfaEnrollmentRequired(s *settings.Settings, e *actions.MFAEnrollmentRequiredError) error {
+	st, err := status.New(codes.PermissionDenied, e.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason: e.Code,
+		Domain: s.Issuer,
+		Metadata: map[string]string{
+			"orgId":          e.OrgID,
+			"allowedFactors": strings.Join(e.AllowedFactors, ","),
+			"enrollBy":       e.EnrollBy.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return status.Error(codes.PermissionDenied, e.Error())
+	}
+	return st.Err()
+}
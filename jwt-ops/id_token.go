@@ -0,0 +1,77 @@
+package jwtops
+
+import (
+	"bigbucks/solution/auth/models"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDTokenParams describes the authentication an ID token is issued for
+type IDTokenParams struct {
+	Issuer    string
+	ClientID  string
+	Nonce     string
+	SessionID string
+	AuthTime  time.Time
+	Scopes    []string
+}
+
+// UserClaims returns the OpenID Connect standard claims of the user released by
+// the scopes. The user's Profile must be loaded.
+func UserClaims(user *models.User, scopes []string, issuer string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user.ID}
+	profile := user.Profile
+	if slices.Contains(scopes, "profile") {
+		claims["preferred_username"] = user.Username
+		if name := strings.TrimSpace(profile.FirstName + " " + profile.LastName); name != "" {
+			claims["name"] = name
+		}
+		if profile.FirstName != "" {
+			claims["given_name"] = profile.FirstName
+		}
+		if profile.LastName != "" {
+			claims["family_name"] = profile.LastName
+		}
+		if profile.Picture != "" {
+			claims["picture"] = issuer + "/avatar/" + profile.Picture
+		}
+		if profile.Timezone != "" {
+			claims["zoneinfo"] = profile.Timezone
+		}
+		if !profile.UpdatedAt.IsZero() {
+			claims["updated_at"] = profile.UpdatedAt.Unix()
+		}
+	}
+	if slices.Contains(scopes, "email") {
+		// The username is the address email_verified refers to, the free form profile
+		// email is never released
+		claims["email"] = user.Username
+		claims["email_verified"] = user.EmailVerified
+	}
+	if slices.Contains(scopes, "phone") && profile.ContactNumber != "" {
+		claims["phone_number"] = profile.ContactNumber
+		claims["phone_number_verified"] = user.MobileVerified
+	}
+	return claims
+}
+
+// SignIDToken issues an OpenID Connect ID token for the user to the client
+func SignIDToken(user *models.User, params IDTokenParams) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims(UserClaims(user, params.Scopes, params.Issuer))
+	claims["iss"] = params.Issuer
+	claims["aud"] = params.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(TokenLifetime).Unix()
+	claims["auth_time"] = params.AuthTime.Unix()
+	if params.Nonce != "" {
+		claims["nonce"] = params.Nonce
+	}
+	if params.SessionID != "" {
+		claims["sid"] = params.SessionID
+	}
+	return signClaims(claims)
+}
@@ -13,22 +13,31 @@ import (
 	"go.uber.org/zap"
 )
 
+// TokenLifetime is how long issued access tokens are valid
+const TokenLifetime = 60 * time.Minute
+
 type Extractor []string
 
+// bearerToken reports whether a header value is a JWT or a personal access token
+func bearerToken(token string) bool {
+	return token != "" && (strings.Count(token, ".") == 2 || IsPersonalAccessToken(token))
+}
+
 func (e Extractor) ExtractToken(r *http.Request) (string, error) {
 	token, _ := request.HeaderExtractor{"X-Auth"}.ExtractToken(r)
-	if token != "" && strings.Count(token, ".") == 2 {
+	if bearerToken(token) {
 		return token, nil
 	}
 
 	// Extract from header Bearer AUthorization
 	token, _ = request.AuthorizationHeaderExtractor.ExtractToken(r)
-	if token != "" && strings.Count(token, ".") == 2 {
-		loging.Logger.Debugln("Token from header", token)
+	if bearerToken(token) {
+		loging.Logger.Debugln("Token from authorization header")
 		return token, nil
 
 	}
 
+	// Personal access tokens are not accepted in the query string, where they end up in logs
 	token = r.URL.Query().Get("auth")
 	if token != "" && strings.Count(token, ".") == 2 {
 		return token, nil
@@ -41,36 +50,97 @@ func (e Extractor) ExtractToken(r *http.Request) (string, error) {
 	return "", request.ErrNoTokenInRequest
 }
 
-func SignJWT(user *models.User, sessionId string) (signed string, err error) {
+// userInfo builds the user claims carried by tokens. Roles in organizations whose
+// MFA policy the user has not met by the end of its grace period are left out, so
+// the token grants nothing there until the user enrolls and renews it.
+func userInfo(user *models.User) (settings.UserInfo, error) {
+	orgRoles := map[string][]string{}
+	for _, role := range user.Roles {
+		orgRoles[role.OrgID] = append(orgRoles[role.OrgID], role.Name)
+	}
+	blocked := map[string]bool{}
+	for orgID, roles := range orgRoles {
+		policy, err := models.UnmetMFAPolicy(user, orgID, roles)
+		if err != nil {
+			return settings.UserInfo{}, err
+		}
+		blocked[orgID] = policy != nil && !time.Now().Before(policy.EnrollBy())
+	}
+
 	var userOrgRole []settings.UserOrgRole
 	for _, role := range user.Roles {
+		if blocked[role.OrgID] {
+			continue
+		}
 		userOrgRole = append(userOrgRole, settings.UserOrgRole{
 			Role:  role.Name,
 			OrgID: role.OrgID,
 		})
 	}
+	return settings.UserInfo{
+		Username: user.Username,
+		Roles:    userOrgRole,
+	}, nil
+}
+
+func SignJWT(user *models.User, sessionId string) (signed string, err error) {
+	info, err := userInfo(user)
+	if err != nil {
+		loging.Logger.Error("Error building token claims", zap.Error(err))
+		return
+	}
 	claims := &settings.AuthToken{
-		User: settings.UserInfo{
-			Username: user.Username,
-			Roles:    userOrgRole,
-		},
+		User: info,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 60)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenLifetime)),
 			Issuer:    "BigBucks Auth",
 			ID:        sessionId,
 			Subject:   user.ID,
 		},
 	}
-	signingMethod := jwt.GetSigningMethod(settings.Current.Alg)
-	token := jwt.NewWithClaims(signingMethod, claims)
-	signingKey, err := jwt.ParseECPrivateKeyFromPEM(settings.SingingKey)
+	return signClaims(claims)
+}
+
+// SignRelyingPartyJWT issues the access token of a relying party session. It is
+// bound to the client by its audience and carries the granted scope instead of the
+// roles of the user, so it is only accepted by the endpoints relying parties call.
+// issuer is the OpenID Connect issuer, like in the ID token, so resource servers can
+// check it against discovery.
+func SignRelyingPartyJWT(user *models.User, sessionId, clientID, scope, issuer string) (signed string, err error) {
+	claims := &settings.AuthToken{
+		User:     settings.UserInfo{Username: user.Username},
+		ClientID: clientID,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenLifetime)),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{clientID},
+			ID:        sessionId,
+			Subject:   user.ID,
+		},
+	}
+	return signClaims(claims)
+}
+
+// signClaims signs the claims with the active key of the keyring
+func signClaims(claims jwt.Claims) (signed string, err error) {
+	signingKey, err := keyring.activeKey()
 	if err != nil {
-		loging.Logger.Error("Error parsing signing key", zap.Error(err))
+		loging.Logger.Error("Error loading signing key", zap.Error(err))
 		return
 	}
+	signingMethod := jwt.GetSigningMethod(signingKey.Alg)
+	if signingMethod == nil {
+		err = ErrUnsupportedAlgo
+		loging.Logger.Error("Error loading signing method", zap.String("alg", signingKey.Alg))
+		return
+	}
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["kid"] = signingKey.Kid
 
-	signed, err = token.SignedString(signingKey)
+	signed, err = token.SignedString(signingKey.Private)
 	if err != nil {
 		loging.Logger.Error("Error signing token", zap.Error(err))
 		return
@@ -78,10 +148,7 @@ func SignJWT(user *models.User, sessionId string) (signed string, err error) {
 	return
 }
 func VerifyJWT(obj interface{}) (claims settings.AuthToken, token *jwt.Token, err error) {
-	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		key, err := jwt.ParseECPublicKeyFromPEM(settings.VerifyingKey)
-		return key, err
-	}
+	keyFunc := verificationKey
 	switch v := obj.(type) {
 	default:
 		loging.Logger.Error("unexpected type %T", v)
@@ -104,3 +171,17 @@ func VerifyJWT(obj interface{}) (claims settings.AuthToken, token *jwt.Token, er
 
 	return
 }
+
+// VerifyRequest authenticates the token carried by the request, either a signed JWT
+// or a personal access token
+func VerifyRequest(r *http.Request) (settings.AuthToken, error) {
+	token, err := Extractor{}.ExtractToken(r)
+	if err != nil {
+		return settings.AuthToken{}, err
+	}
+	if IsPersonalAccessToken(token) {
+		return VerifyPersonalAccessToken(token)
+	}
+	claims, _, err := VerifyJWT(token)
+	return claims, err
+}
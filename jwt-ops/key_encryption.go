@@ -0,0 +1,79 @@
+package jwtops
+
+import (
+	"bigbucks/solution/auth/settings"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// SealedPrefix marks private keys and other secrets sealed with signingKeysSecret
+const SealedPrefix = "sealed:v1:"
+
+var (
+	ErrKeysSecret  = errors.New("signingKeysSecret must be 32 bytes encoded in base64")
+	ErrUnsealedKey = errors.New("stored secret is not sealed")
+)
+
+// keysCipher returns the AES-GCM cipher sealing the private keys of the keyring
+func keysCipher() (cipher.AEAD, error) {
+	secret, err := base64.StdEncoding.DecodeString(settings.Current.SigningKeysSecret)
+	if err != nil || len(secret) != 32 {
+		return nil, ErrKeysSecret
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealPrivateKey encrypts a PEM private key for the signing_keys table. The kid is
+// authenticated along, so a sealed key does not verify under another kid.
+func sealPrivateKey(kid string, privatePEM []byte) (string, error) {
+	return SealSecret(kid, privatePEM)
+}
+
+// openPrivateKey decrypts a private key sealed by sealPrivateKey
+func openPrivateKey(kid, stored string) ([]byte, error) {
+	return OpenSecret(kid, stored)
+}
+
+// SealSecret encrypts a secret for the database with signingKeysSecret, like the
+// private keys of the keyring. The label, such as the ID of the row holding the
+// secret, is authenticated along, so a sealed secret does not open under another.
+func SealSecret(label string, secret []byte) (string, error) {
+	aead, err := keysCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, secret, []byte(label))
+	return SealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenSecret decrypts a secret sealed by SealSecret with the same label
+func OpenSecret(label, stored string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(stored, SealedPrefix)
+	if !ok {
+		return nil, ErrUnsealedKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keysCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrUnsealedKey
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(label))
+}
@@ -0,0 +1,327 @@
+package jwtops
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// keyringRefresh is how often the keyring is reloaded from the database
+	keyringRefresh = time.Minute
+	// keyringMissRefresh limits forced reloads triggered by tokens with an unknown kid
+	keyringMissRefresh = 10 * time.Second
+)
+
+var (
+	ErrUnknownKey      = errors.New("unknown signing key")
+	ErrAlgMismatch     = errors.New("algorithm does not match key")
+	ErrNoSigningKey    = errors.New("no signing key available")
+	ErrUnsupportedAlgo = errors.New("unsupported signing algorithm")
+)
+
+// keyPair is a parsed key from the keyring. The private key is only set for
+// the active key.
+type keyPair struct {
+	Kid     string
+	Alg     string
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// keyRing holds the active signing key and every key tokens may still be verified with.
+// Keys are read from the signing_keys table. The PEM configured in settings is imported
+// into it on the first load and is only used directly without a database.
+type keyRing struct {
+	mu        sync.RWMutex
+	active    *keyPair
+	keys      map[string]*keyPair
+	legacyKid string
+	imported  bool
+	loadedAt  time.Time
+}
+
+var keyring = &keyRing{}
+
+// reload refreshes the keyring when it is older than maxAge
+func (k *keyRing) reload(maxAge time.Duration) error {
+	k.mu.RLock()
+	fresh := !k.loadedAt.IsZero() && time.Since(k.loadedAt) < maxAge
+	k.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.loadedAt.IsZero() && time.Since(k.loadedAt) < maxAge {
+		return nil
+	}
+
+	legacy, err := legacyKeyPair()
+	if err != nil {
+		return err
+	}
+	keys := map[string]*keyPair{}
+	var active *keyPair
+	if models.Dbcon == nil {
+		keys[legacy.Kid] = legacy
+		active = legacy
+	} else {
+		if !k.imported {
+			if err := importConfiguredKey(legacy); err != nil {
+				return err
+			}
+			k.imported = true
+		}
+		stored, err := models.ListVerificationKeys()
+		if err != nil {
+			return err
+		}
+		for _, sk := range stored {
+			pair, err := parseStoredKey(&sk)
+			if err != nil {
+				loging.Logger.Error("Skipping unreadable signing key", zap.String("kid", sk.Kid), zap.Error(err))
+				continue
+			}
+			keys[pair.Kid] = pair
+			if sk.Status == models.SigningKeyStatusActive {
+				active = pair
+			}
+		}
+	}
+
+	k.keys = keys
+	k.active = active
+	k.legacyKid = legacy.Kid
+	k.loadedAt = time.Now()
+	return nil
+}
+
+// invalidate forces the next lookup to reload the keyring
+func (k *keyRing) invalidate() {
+	k.mu.Lock()
+	k.loadedAt = time.Time{}
+	k.mu.Unlock()
+}
+
+// activeKey returns the key new tokens are signed with
+func (k *keyRing) activeKey() (*keyPair, error) {
+	if err := k.reload(keyringRefresh); err != nil {
+		return nil, err
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.active == nil {
+		return nil, ErrNoSigningKey
+	}
+	return k.active, nil
+}
+
+// lookup returns the verification key for a kid. Tokens issued before kid headers
+// were introduced carry no kid and are checked against the configured PEM, for as
+// long as the keyring keeps it.
+func (k *keyRing) lookup(kid string) (*keyPair, error) {
+	if err := k.reload(keyringRefresh); err != nil {
+		return nil, err
+	}
+	if pair := k.find(kid); pair != nil {
+		return pair, nil
+	}
+	// The key may have been added since the last reload
+	if err := k.reload(keyringMissRefresh); err != nil {
+		return nil, err
+	}
+	if pair := k.find(kid); pair != nil {
+		return pair, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (k *keyRing) find(kid string) *keyPair {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if kid == "" {
+		kid = k.legacyKid
+	}
+	return k.keys[kid]
+}
+
+// publicKeys returns the JWKS document with every verification key
+func (k *keyRing) publicKeys() (JWKS, error) {
+	if err := k.reload(keyringRefresh); err != nil {
+		return JWKS{}, err
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	jwks := JWKS{Keys: []JWK{}}
+	for _, pair := range k.keys {
+		jwk, err := NewJWK(pair.Public, pair.Kid, pair.Alg)
+		if err != nil {
+			return JWKS{}, err
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// PublicKeys returns the JWKS document of the default keyring
+func PublicKeys() (JWKS, error) {
+	return keyring.publicKeys()
+}
+
+// InvalidateKeyring drops the cached keys so that changes made to the signing_keys
+// table are picked up on the next sign or verify.
+func InvalidateKeyring() {
+	keyring.invalidate()
+}
+
+// verificationKey is the jwt.Keyfunc backed by the keyring
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	pair, err := keyring.lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != pair.Alg {
+		return nil, ErrAlgMismatch
+	}
+	return pair.Public, nil
+}
+
+// legacyKeyPair parses the PEM keys loaded from settings
+func legacyKeyPair() (*keyPair, error) {
+	private, public, err := parseKeyPair(settings.SingingKey, settings.VerifyingKey, settings.Current.Alg)
+	if err != nil {
+		return nil, fmt.Errorf("configured signing key: %w", err)
+	}
+	kid, err := Thumbprint(public)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{Kid: kid, Alg: settings.Current.Alg, Private: private, Public: public}, nil
+}
+
+// importConfiguredKey stores the PEM configured in settings in the keyring, once. It
+// signs tokens until another key is promoted, or verifies them for a day when a key
+// was promoted before. Private keys stored before they were sealed are sealed too.
+func importConfiguredKey(legacy *keyPair) error {
+	stored, err := models.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+	for _, sk := range stored {
+		if strings.HasPrefix(sk.PrivateKey, SealedPrefix) {
+			continue
+		}
+		sealed, err := sealPrivateKey(sk.Kid, []byte(sk.PrivateKey))
+		if err != nil {
+			return err
+		}
+		if err := models.SetSigningKeyPrivateKey(sk.Kid, sealed); err != nil {
+			return err
+		}
+	}
+
+	if _, err := models.GetSigningKey(legacy.Kid); !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	key, err := newSigningKey(legacy.Private, legacy.Alg)
+	if err != nil {
+		return err
+	}
+	if err := models.ImportSigningKey(key, constants.SESSION_EXPIRY); err != nil {
+		// Another instance may have imported it first
+		if _, lookupErr := models.GetSigningKey(legacy.Kid); lookupErr == nil {
+			return nil
+		}
+		return err
+	}
+	loging.Logger.Info("Imported the configured signing key into the keyring", zap.String("kid", key.Kid), zap.String("status", string(key.Status)))
+	return nil
+}
+
+func parseStoredKey(sk *models.SigningKey) (*keyPair, error) {
+	var privatePEM []byte
+	if sk.Status == models.SigningKeyStatusActive {
+		var err error
+		if privatePEM, err = openPrivateKey(sk.Kid, sk.PrivateKey); err != nil {
+			return nil, err
+		}
+	}
+	private, public, err := parseKeyPair(privatePEM, []byte(sk.PublicKey), sk.Alg)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{Kid: sk.Kid, Alg: sk.Alg, Private: private, Public: public}, nil
+}
+
+// GenerateSigningKey creates a new key pair for alg, ready to be stored in the keyring.
+// The kid is the RFC 7638 thumbprint of the public key and the private key is sealed
+// with signingKeysSecret.
+func GenerateSigningKey(alg string) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		private, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlgo
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(private, alg)
+}
+
+// newSigningKey encodes a key pair for the signing_keys table
+func newSigningKey(private crypto.Signer, alg string) (*models.SigningKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	kid, err := Thumbprint(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealPrivateKey(kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{
+		Kid:        kid,
+		Alg:        alg,
+		PrivateKey: sealed,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer})),
+	}, nil
+}
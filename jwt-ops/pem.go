@@ -0,0 +1,158 @@
+package jwtops
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing keys
+const minRSABits = 2048
+
+var ErrInvalidPEM = errors.New("key must be PEM encoded")
+
+// ParsePrivateKeyPEM parses an EC, RSA or Ed25519 private key in SEC 1, PKCS #1 or PKCS #8 form
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	switch signer.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey, ed25519.PrivateKey:
+		return signer, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// ParsePublicKeyPEM parses an EC, RSA or Ed25519 public key from a PKIX or PKCS #1
+// public key, or from a certificate
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// CheckKeyAlg reports whether the public key can be used with the JWS algorithm
+func CheckKeyAlg(pub crypto.PublicKey, alg string) error {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		var curve elliptic.Curve
+		switch alg {
+		case "ES256":
+			curve = elliptic.P256()
+		case "ES384":
+			curve = elliptic.P384()
+		case "ES512":
+			curve = elliptic.P521()
+		default:
+			return fmt.Errorf("%w: EC key cannot be used with %s", ErrAlgMismatch, alg)
+		}
+		if key.Curve != curve {
+			return fmt.Errorf("%w: %s requires curve %s, key uses %s", ErrAlgMismatch, alg, curve.Params().Name, key.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		default:
+			return fmt.Errorf("%w: RSA key cannot be used with %s", ErrAlgMismatch, alg)
+		}
+		if key.N.BitLen() < minRSABits {
+			return fmt.Errorf("RSA key must be at least %d bits, got %d", minRSABits, key.N.BitLen())
+		}
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("%w: Ed25519 key cannot be used with %s", ErrAlgMismatch, alg)
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+	return nil
+}
+
+// parseKeyPair parses a PEM key pair and checks that both halves belong together and
+// fit alg. The private key is optional for keys that only verify.
+func parseKeyPair(privatePEM, publicPEM []byte, alg string) (crypto.Signer, crypto.PublicKey, error) {
+	public, err := ParsePublicKeyPEM(publicPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	if err := CheckKeyAlg(public, alg); err != nil {
+		return nil, nil, err
+	}
+	if privatePEM == nil {
+		return nil, public, nil
+	}
+
+	private, err := ParsePrivateKeyPEM(privatePEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	type equaler interface {
+		Equal(crypto.PublicKey) bool
+	}
+	if !private.Public().(equaler).Equal(public) {
+		return nil, nil, errors.New("private key does not match public key")
+	}
+	return private, public, nil
+}
+
+// CheckConfiguredKeys validates the PEM keys loaded from settings against the configured
+// alg, and the secret sealing the keyring. It is meant to run at startup so that a
+// misconfigured key fails fast instead of on the first signin.
+func CheckConfiguredKeys() error {
+	if _, err := keysCipher(); err != nil {
+		return err
+	}
+	_, err := legacyKeyPair()
+	return err
+}
@@ -0,0 +1,10 @@
+-- reverse: create index "idx_signing_keys_updated_at" to table: "signing_keys"
+DROP INDEX "idx_signing_keys_updated_at";
+-- reverse: create index "idx_signing_keys_status" to table: "signing_keys"
+DROP INDEX "idx_signing_keys_status";
+-- reverse: create index "idx_signing_keys_deleted_at" to table: "signing_keys"
+DROP INDEX "idx_signing_keys_deleted_at";
+-- reverse: create index "idx_signing_keys_created_at" to table: "signing_keys"
+DROP INDEX "idx_signing_keys_created_at";
+-- reverse: create "signing_keys" table
+DROP TABLE "signing_keys";
@@ -0,0 +1,25 @@
+-- create "signing_keys" table
+CREATE TABLE "signing_keys" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "kid" text NOT NULL,
+  "alg" text NOT NULL,
+  "private_key" text NOT NULL,
+  "public_key" text NOT NULL,
+  "status" text NULL DEFAULT 'pending',
+  "activated_at" timestamptz NULL,
+  "retired_at" timestamptz NULL,
+  "expires_at" timestamptz NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "uni_signing_keys_kid" UNIQUE ("kid")
+);
+-- create index "idx_signing_keys_created_at" to table: "signing_keys"
+CREATE INDEX "idx_signing_keys_created_at" ON "signing_keys" ("created_at");
+-- create index "idx_signing_keys_deleted_at" to table: "signing_keys"
+CREATE INDEX "idx_signing_keys_deleted_at" ON "signing_keys" ("deleted_at");
+-- create index "idx_signing_keys_status" to table: "signing_keys"
+CREATE INDEX "idx_signing_keys_status" ON "signing_keys" ("status");
+-- create index "idx_signing_keys_updated_at" to table: "signing_keys"
+CREATE INDEX "idx_signing_keys_updated_at" ON "signing_keys" ("updated_at");
@@ -0,0 +1,8 @@
+-- reverse: create index "idx_api_clients_updated_at" to table: "api_clients"
+DROP INDEX "idx_api_clients_updated_at";
+-- reverse: create index "idx_api_clients_deleted_at" to table: "api_clients"
+DROP INDEX "idx_api_clients_deleted_at";
+-- reverse: create index "idx_api_clients_created_at" to table: "api_clients"
+DROP INDEX "idx_api_clients_created_at";
+-- reverse: create "api_clients" table
+DROP TABLE "api_clients";
@@ -0,0 +1,19 @@
+-- create "api_clients" table
+CREATE TABLE "api_clients" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "client_id" text NOT NULL,
+  "name" text NOT NULL,
+  "secret_hash" text NOT NULL,
+  "scopes" text NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "uni_api_clients_client_id" UNIQUE ("client_id")
+);
+-- create index "idx_api_clients_created_at" to table: "api_clients"
+CREATE INDEX "idx_api_clients_created_at" ON "api_clients" ("created_at");
+-- create index "idx_api_clients_deleted_at" to table: "api_clients"
+CREATE INDEX "idx_api_clients_deleted_at" ON "api_clients" ("deleted_at");
+-- create index "idx_api_clients_updated_at" to table: "api_clients"
+CREATE INDEX "idx_api_clients_updated_at" ON "api_clients" ("updated_at");
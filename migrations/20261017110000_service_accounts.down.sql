@@ -0,0 +1,6 @@
+-- reverse: create index "idx_api_clients_user_id" to table: "api_clients"
+DROP INDEX "idx_api_clients_user_id";
+-- reverse: modify "api_clients" table
+ALTER TABLE "api_clients" DROP CONSTRAINT "fk_api_clients_user", DROP COLUMN "public_key", DROP COLUMN "user_id", ALTER COLUMN "secret_hash" SET NOT NULL;
+-- reverse: modify "users" table
+ALTER TABLE "users" DROP COLUMN "account_type";
@@ -0,0 +1,6 @@
+-- modify "users" table
+ALTER TABLE "users" ADD COLUMN "account_type" text NULL DEFAULT 'human';
+-- modify "api_clients" table
+ALTER TABLE "api_clients" ALTER COLUMN "secret_hash" DROP NOT NULL, ADD COLUMN "user_id" character(26) NULL, ADD COLUMN "public_key" text NULL, ADD CONSTRAINT "fk_api_clients_user" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION;
+-- create index "idx_api_clients_user_id" to table: "api_clients"
+CREATE INDEX "idx_api_clients_user_id" ON "api_clients" ("user_id");
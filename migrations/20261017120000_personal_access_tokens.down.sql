@@ -0,0 +1,12 @@
+-- reverse: create index "idx_personal_access_tokens_user_id" to table: "personal_access_tokens"
+DROP INDEX "idx_personal_access_tokens_user_id";
+-- reverse: create index "idx_personal_access_tokens_updated_at" to table: "personal_access_tokens"
+DROP INDEX "idx_personal_access_tokens_updated_at";
+-- reverse: create index "idx_personal_access_tokens_expires_at" to table: "personal_access_tokens"
+DROP INDEX "idx_personal_access_tokens_expires_at";
+-- reverse: create index "idx_personal_access_tokens_deleted_at" to table: "personal_access_tokens"
+DROP INDEX "idx_personal_access_tokens_deleted_at";
+-- reverse: create index "idx_personal_access_tokens_created_at" to table: "personal_access_tokens"
+DROP INDEX "idx_personal_access_tokens_created_at";
+-- reverse: create "personal_access_tokens" table
+DROP TABLE "personal_access_tokens";
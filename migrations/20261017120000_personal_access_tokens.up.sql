@@ -0,0 +1,27 @@
+-- create "personal_access_tokens" table
+CREATE TABLE "personal_access_tokens" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "user_id" character(26) NOT NULL,
+  "name" text NOT NULL,
+  "token_hash" text NOT NULL,
+  "hint" text NULL,
+  "permissions" text NULL,
+  "expires_at" timestamptz NULL,
+  "last_used_at" timestamptz NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "uni_personal_access_tokens_token_hash" UNIQUE ("token_hash"),
+  CONSTRAINT "fk_personal_access_tokens_user" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION
+);
+-- create index "idx_personal_access_tokens_created_at" to table: "personal_access_tokens"
+CREATE INDEX "idx_personal_access_tokens_created_at" ON "personal_access_tokens" ("created_at");
+-- create index "idx_personal_access_tokens_deleted_at" to table: "personal_access_tokens"
+CREATE INDEX "idx_personal_access_tokens_deleted_at" ON "personal_access_tokens" ("deleted_at");
+-- create index "idx_personal_access_tokens_expires_at" to table: "personal_access_tokens"
+CREATE INDEX "idx_personal_access_tokens_expires_at" ON "personal_access_tokens" ("expires_at");
+-- create index "idx_personal_access_tokens_updated_at" to table: "personal_access_tokens"
+CREATE INDEX "idx_personal_access_tokens_updated_at" ON "personal_access_tokens" ("updated_at");
+-- create index "idx_personal_access_tokens_user_id" to table: "personal_access_tokens"
+CREATE INDEX "idx_personal_access_tokens_user_id" ON "personal_access_tokens" ("user_id");
@@ -0,0 +1,8 @@
+-- reverse: create index "idx_relying_parties_updated_at" to table: "relying_parties"
+DROP INDEX "idx_relying_parties_updated_at";
+-- reverse: create index "idx_relying_parties_deleted_at" to table: "relying_parties"
+DROP INDEX "idx_relying_parties_deleted_at";
+-- reverse: create index "idx_relying_parties_created_at" to table: "relying_parties"
+DROP INDEX "idx_relying_parties_created_at";
+-- reverse: create "relying_parties" table
+DROP TABLE "relying_parties";
@@ -0,0 +1,20 @@
+-- create "relying_parties" table
+CREATE TABLE "relying_parties" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "client_id" text NOT NULL,
+  "name" text NOT NULL,
+  "secret_hash" text NULL,
+  "redirect_uris" text NOT NULL,
+  "scopes" text NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "uni_relying_parties_client_id" UNIQUE ("client_id")
+);
+-- create index "idx_relying_parties_created_at" to table: "relying_parties"
+CREATE INDEX "idx_relying_parties_created_at" ON "relying_parties" ("created_at");
+-- create index "idx_relying_parties_deleted_at" to table: "relying_parties"
+CREATE INDEX "idx_relying_parties_deleted_at" ON "relying_parties" ("deleted_at");
+-- create index "idx_relying_parties_updated_at" to table: "relying_parties"
+CREATE INDEX "idx_relying_parties_updated_at" ON "relying_parties" ("updated_at");
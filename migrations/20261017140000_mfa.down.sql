@@ -0,0 +1,20 @@
+-- reverse: create index "idx_recovery_codes_user_id" to table: "recovery_codes"
+DROP INDEX "idx_recovery_codes_user_id";
+-- reverse: create index "idx_recovery_codes_updated_at" to table: "recovery_codes"
+DROP INDEX "idx_recovery_codes_updated_at";
+-- reverse: create index "idx_recovery_codes_deleted_at" to table: "recovery_codes"
+DROP INDEX "idx_recovery_codes_deleted_at";
+-- reverse: create index "idx_recovery_codes_created_at" to table: "recovery_codes"
+DROP INDEX "idx_recovery_codes_created_at";
+-- reverse: create "recovery_codes" table
+DROP TABLE "recovery_codes";
+-- reverse: create index "idx_totp_credentials_user_id" to table: "totp_credentials"
+DROP INDEX "idx_totp_credentials_user_id";
+-- reverse: create index "idx_totp_credentials_updated_at" to table: "totp_credentials"
+DROP INDEX "idx_totp_credentials_updated_at";
+-- reverse: create index "idx_totp_credentials_deleted_at" to table: "totp_credentials"
+DROP INDEX "idx_totp_credentials_deleted_at";
+-- reverse: create index "idx_totp_credentials_created_at" to table: "totp_credentials"
+DROP INDEX "idx_totp_credentials_created_at";
+-- reverse: create "totp_credentials" table
+DROP TABLE "totp_credentials";
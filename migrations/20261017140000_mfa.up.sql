@@ -0,0 +1,41 @@
+-- create "totp_credentials" table
+CREATE TABLE "totp_credentials" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "user_id" character(26) NOT NULL,
+  "secret" text NOT NULL,
+  "confirmed_at" timestamptz NULL,
+  "last_used_step" bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_totp_credentials_user" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION
+);
+-- create index "idx_totp_credentials_created_at" to table: "totp_credentials"
+CREATE INDEX "idx_totp_credentials_created_at" ON "totp_credentials" ("created_at");
+-- create index "idx_totp_credentials_deleted_at" to table: "totp_credentials"
+CREATE INDEX "idx_totp_credentials_deleted_at" ON "totp_credentials" ("deleted_at");
+-- create index "idx_totp_credentials_updated_at" to table: "totp_credentials"
+CREATE INDEX "idx_totp_credentials_updated_at" ON "totp_credentials" ("updated_at");
+-- create index "idx_totp_credentials_user_id" to table: "totp_credentials"
+CREATE UNIQUE INDEX "idx_totp_credentials_user_id" ON "totp_credentials" ("user_id");
+-- create "recovery_codes" table
+CREATE TABLE "recovery_codes" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "user_id" character(26) NOT NULL,
+  "code_hash" text NOT NULL,
+  "used_at" timestamptz NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_recovery_codes_user" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION
+);
+-- create index "idx_recovery_codes_created_at" to table: "recovery_codes"
+CREATE INDEX "idx_recovery_codes_created_at" ON "recovery_codes" ("created_at");
+-- create index "idx_recovery_codes_deleted_at" to table: "recovery_codes"
+CREATE INDEX "idx_recovery_codes_deleted_at" ON "recovery_codes" ("deleted_at");
+-- create index "idx_recovery_codes_updated_at" to table: "recovery_codes"
+CREATE INDEX "idx_recovery_codes_updated_at" ON "recovery_codes" ("updated_at");
+-- create index "idx_recovery_codes_user_id" to table: "recovery_codes"
+CREATE INDEX "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
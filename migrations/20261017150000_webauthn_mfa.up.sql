@@ -0,0 +1,2 @@
+-- modify "users" table
+ALTER TABLE "users" ADD COLUMN "web_authn_mfa" boolean NULL DEFAULT false;
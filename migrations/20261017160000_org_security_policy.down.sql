@@ -0,0 +1,10 @@
+-- reverse: create index "idx_org_security_policies_updated_at" to table: "org_security_policies"
+DROP INDEX "idx_org_security_policies_updated_at";
+-- reverse: create index "idx_org_security_policies_org_id" to table: "org_security_policies"
+DROP INDEX "idx_org_security_policies_org_id";
+-- reverse: create index "idx_org_security_policies_deleted_at" to table: "org_security_policies"
+DROP INDEX "idx_org_security_policies_deleted_at";
+-- reverse: create index "idx_org_security_policies_created_at" to table: "org_security_policies"
+DROP INDEX "idx_org_security_policies_created_at";
+-- reverse: create "org_security_policies" table
+DROP TABLE "org_security_policies";
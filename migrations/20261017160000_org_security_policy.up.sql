@@ -0,0 +1,23 @@
+-- create "org_security_policies" table
+CREATE TABLE "org_security_policies" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "org_id" character(26) NOT NULL,
+  "mfa_required" boolean NOT NULL DEFAULT false,
+  "mfa_roles" text NULL,
+  "mfa_factors" text NULL,
+  "grace_period_days" bigint NOT NULL DEFAULT 0,
+  "enforced_since" timestamptz NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_org_security_policies_org" FOREIGN KEY ("org_id") REFERENCES "organizations" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION
+);
+-- create index "idx_org_security_policies_created_at" to table: "org_security_policies"
+CREATE INDEX "idx_org_security_policies_created_at" ON "org_security_policies" ("created_at");
+-- create index "idx_org_security_policies_deleted_at" to table: "org_security_policies"
+CREATE INDEX "idx_org_security_policies_deleted_at" ON "org_security_policies" ("deleted_at");
+-- create index "idx_org_security_policies_org_id" to table: "org_security_policies"
+CREATE UNIQUE INDEX "idx_org_security_policies_org_id" ON "org_security_policies" ("org_id");
+-- create index "idx_org_security_policies_updated_at" to table: "org_security_policies"
+CREATE INDEX "idx_org_security_policies_updated_at" ON "org_security_policies" ("updated_at");
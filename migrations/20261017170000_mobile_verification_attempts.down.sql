@@ -0,0 +1,2 @@
+-- reverse: modify "mobile_verifications" table
+ALTER TABLE "mobile_verifications" DROP COLUMN "attempts";
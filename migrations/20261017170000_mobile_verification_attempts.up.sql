@@ -0,0 +1,2 @@
+-- modify "mobile_verifications" table
+ALTER TABLE "mobile_verifications" ADD COLUMN "attempts" bigint NOT NULL DEFAULT 0;
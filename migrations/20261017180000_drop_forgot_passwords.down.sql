@@ -0,0 +1,13 @@
+-- reverse: drop "forgot_passwords" table
+CREATE TABLE "forgot_passwords" (
+  "id" bigserial NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "user_id" character(26) NULL,
+  "reset_token" text NULL,
+  "expiry" timestamptz NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_users_forgot_password" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE CASCADE ON DELETE SET NULL
+);
+CREATE INDEX "idx_forgot_passwords_deleted_at" ON "forgot_passwords" ("deleted_at");
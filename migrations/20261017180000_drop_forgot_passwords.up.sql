@@ -0,0 +1,2 @@
+-- drop "forgot_passwords" table, reset links are signed tokens now
+DROP TABLE "forgot_passwords";
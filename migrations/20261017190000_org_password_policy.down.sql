@@ -0,0 +1,2 @@
+-- reverse: modify "org_security_policies" table
+ALTER TABLE "org_security_policies" DROP COLUMN "password_require_symbol", DROP COLUMN "password_require_digit", DROP COLUMN "password_require_lowercase", DROP COLUMN "password_require_uppercase", DROP COLUMN "password_min_length";
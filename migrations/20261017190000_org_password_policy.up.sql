@@ -0,0 +1,2 @@
+-- modify "org_security_policies" table
+ALTER TABLE "org_security_policies" ADD COLUMN "password_min_length" bigint NOT NULL DEFAULT 0, ADD COLUMN "password_require_uppercase" boolean NOT NULL DEFAULT false, ADD COLUMN "password_require_lowercase" boolean NOT NULL DEFAULT false, ADD COLUMN "password_require_digit" boolean NOT NULL DEFAULT false, ADD COLUMN "password_require_symbol" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,6 @@
+-- reverse: create "password_histories" table
+DROP TABLE "password_histories";
+-- reverse: modify "org_security_policies" table
+ALTER TABLE "org_security_policies" DROP COLUMN "password_max_age_days", DROP COLUMN "password_history_count";
+-- reverse: modify "users" table
+ALTER TABLE "users" DROP COLUMN "password_changed_at";
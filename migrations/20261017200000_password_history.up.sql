@@ -0,0 +1,25 @@
+-- modify "users" table
+ALTER TABLE "users" ADD COLUMN "password_changed_at" timestamptz NULL;
+-- password expiry of existing accounts counts from the upgrade
+UPDATE "users" SET "password_changed_at" = now();
+-- modify "org_security_policies" table
+ALTER TABLE "org_security_policies" ADD COLUMN "password_history_count" bigint NOT NULL DEFAULT 0, ADD COLUMN "password_max_age_days" bigint NOT NULL DEFAULT 0;
+-- create "password_histories" table
+CREATE TABLE "password_histories" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "user_id" character(26) NOT NULL,
+  "hash" text NOT NULL,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "fk_password_histories_user" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON UPDATE NO ACTION ON DELETE CASCADE
+);
+-- create index "idx_password_histories_created_at" to table: "password_histories"
+CREATE INDEX "idx_password_histories_created_at" ON "password_histories" ("created_at");
+-- create index "idx_password_histories_deleted_at" to table: "password_histories"
+CREATE INDEX "idx_password_histories_deleted_at" ON "password_histories" ("deleted_at");
+-- create index "idx_password_histories_updated_at" to table: "password_histories"
+CREATE INDEX "idx_password_histories_updated_at" ON "password_histories" ("updated_at");
+-- create index "idx_password_histories_user_id" to table: "password_histories"
+CREATE INDEX "idx_password_histories_user_id" ON "password_histories" ("user_id");
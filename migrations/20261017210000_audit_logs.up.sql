@@ -0,0 +1,22 @@
+-- create "audit_logs" table
+CREATE TABLE "audit_logs" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "user_id" character(26) NOT NULL,
+  "actor_id" character(26) NULL,
+  "event" text NOT NULL,
+  "attrs" jsonb NULL,
+  PRIMARY KEY ("id")
+);
+-- create index "idx_audit_logs_created_at" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_created_at" ON "audit_logs" ("created_at");
+-- create index "idx_audit_logs_deleted_at" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_deleted_at" ON "audit_logs" ("deleted_at");
+-- create index "idx_audit_logs_event" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_event" ON "audit_logs" ("event");
+-- create index "idx_audit_logs_updated_at" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_updated_at" ON "audit_logs" ("updated_at");
+-- create index "idx_audit_logs_user_id" to table: "audit_logs"
+CREATE INDEX "idx_audit_logs_user_id" ON "audit_logs" ("user_id");
@@ -0,0 +1,12 @@
+-- reverse: create index "idx_oauth_consents_user_client" to table: "oauth_consents"
+DROP INDEX "idx_oauth_consents_user_client";
+-- reverse: create index "idx_oauth_consents_updated_at" to table: "oauth_consents"
+DROP INDEX "idx_oauth_consents_updated_at";
+-- reverse: create index "idx_oauth_consents_deleted_at" to table: "oauth_consents"
+DROP INDEX "idx_oauth_consents_deleted_at";
+-- reverse: create index "idx_oauth_consents_created_at" to table: "oauth_consents"
+DROP INDEX "idx_oauth_consents_created_at";
+-- reverse: create "oauth_consents" table
+DROP TABLE "oauth_consents";
+-- reverse: modify "relying_parties" table
+ALTER TABLE "relying_parties" DROP COLUMN "trusted";
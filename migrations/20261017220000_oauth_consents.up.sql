@@ -0,0 +1,21 @@
+-- modify "relying_parties" table
+ALTER TABLE "relying_parties" ADD COLUMN "trusted" boolean NOT NULL DEFAULT false;
+-- create "oauth_consents" table
+CREATE TABLE "oauth_consents" (
+  "id" character(26) NOT NULL,
+  "created_at" timestamptz NULL,
+  "updated_at" timestamptz NULL,
+  "deleted_at" timestamptz NULL,
+  "user_id" character(26) NOT NULL,
+  "client_id" text NOT NULL,
+  "scopes" text NOT NULL,
+  PRIMARY KEY ("id")
+);
+-- create index "idx_oauth_consents_created_at" to table: "oauth_consents"
+CREATE INDEX "idx_oauth_consents_created_at" ON "oauth_consents" ("created_at");
+-- create index "idx_oauth_consents_deleted_at" to table: "oauth_consents"
+CREATE INDEX "idx_oauth_consents_deleted_at" ON "oauth_consents" ("deleted_at");
+-- create index "idx_oauth_consents_updated_at" to table: "oauth_consents"
+CREATE INDEX "idx_oauth_consents_updated_at" ON "oauth_consents" ("updated_at");
+-- create index "idx_oauth_consents_user_client" to table: "oauth_consents"
+CREATE UNIQUE INDEX "idx_oauth_consents_user_client" ON "oauth_consents" ("user_id", "client_id");
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// API client scopes
+const (
+	// ClientScopeIntrospect allows a client to call the token introspection endpoint
+	ClientScopeIntrospect = "introspect"
+)
+
+// APIClient : GORM model for confidential clients that call the auth service
+// on their own behalf, such as resource servers introspecting tokens or jobs
+// authenticating as a service account
+type APIClient struct {
+	constants.BaseModel `json:"-"`
+	ClientID            string `gorm:"unique;not null" json:"clientId"`
+	Name                string `gorm:"not null" json:"name"`
+	SecretHash          string `json:"-"`
+	Scopes              string `json:"scopes"` // space separated
+	// UserID links the client to the service account it authenticates as
+	UserID *string `gorm:"type:char(26);index" json:"userId,omitempty"`
+	User   *User   `gorm:"foreignKey:UserID" json:"-"`
+	// PublicKey is the PEM key private_key_jwt assertions are verified with
+	PublicKey string `gorm:"type:text" json:"-"`
+}
+
+// HashClientSecret hashes a client secret for storage. Secrets are random
+// and long, so a fast hash is sufficient.
+func HashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckSecret compares the secret with the stored hash in constant time
+func (c *APIClient) CheckSecret(secret string) bool {
+	if c.SecretHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(HashClientSecret(secret))) == 1
+}
+
+// HasScope reports whether the client was granted the scope
+func (c *APIClient) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scopes), scope)
+}
+
+// GetAPIClient loads a client by its public client id
+func GetAPIClient(clientID string) (*APIClient, error) {
+	var client APIClient
+	if err := Dbcon.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// IsServiceAccount reports whether the client authenticates as a service account
+func (c *APIClient) IsServiceAccount() bool {
+	return c.UserID != nil
+}
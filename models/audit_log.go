@@ -0,0 +1,33 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"encoding/json"
+)
+
+// Events of the audit log
+const (
+	AuditEventAccountLocked   = "account_locked"
+	AuditEventAccountUnlocked = "account_unlocked"
+)
+
+// AuditLog : GORM model for security events of an account. Records are kept when
+// the account is deleted.
+type AuditLog struct {
+	constants.BaseModel `json:"-"`
+	// UserID is the account the event is about
+	UserID string `gorm:"type:char(26);not null;index" json:"userId"`
+	// ActorID is the user who caused the event, nil for the system and the command line
+	ActorID *string         `gorm:"type:char(26)" json:"actorId,omitempty"`
+	Event   string          `gorm:"not null;index" json:"event"`
+	Attrs   json.RawMessage `gorm:"type:jsonb" json:"attrs,omitempty"`
+}
+
+// WriteAuditLog records an event about the account
+func WriteAuditLog(userID string, actorID *string, event string, attrs map[string]interface{}) error {
+	jsonAttrs, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	return Dbcon.Create(&AuditLog{UserID: userID, ActorID: actorID, Event: event, Attrs: jsonAttrs}).Error
+}
@@ -11,3 +11,30 @@ type AuthLog struct {
 	LoginAt time.Time       `gorm:"index:idx_user_login,priority:2,sort:desc"`
 	Attrs   json.RawMessage `gorm:"type:jsonb"`
 }
+
+// LoginAttrs are the Attrs of a login. Logins recorded before the login risk
+// evaluator only have the address and user agent.
+type LoginAttrs struct {
+	IP        string   `json:"ip"`
+	UserAgent string   `json:"user_agent"`
+	Method    string   `json:"method,omitempty"`
+	Device    string   `json:"device,omitempty"`
+	Country   string   `json:"country,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Risk      string   `json:"risk,omitempty"`
+}
+
+// LoginAttrs decodes the Attrs of the login, unreadable ones are empty
+func (l AuthLog) LoginAttrs() LoginAttrs {
+	var attrs LoginAttrs
+	_ = json.Unmarshal(l.Attrs, &attrs)
+	return attrs
+}
+
+// RecentLogins returns the last logins of the user, newest first
+func RecentLogins(userID string, limit int) ([]AuthLog, error) {
+	var logs []AuthLog
+	err := Dbcon.Where("user_id = ?", userID).Order("login_at desc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
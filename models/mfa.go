@@ -0,0 +1,76 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TOTPCredential : GORM model for a user's authenticator app. It only counts as a
+// second factor once the user confirmed it with a valid code.
+type TOTPCredential struct {
+	constants.BaseModel `json:"-"`
+	UserID              string     `gorm:"type:char(26);not null;uniqueIndex" json:"-"`
+	User                *User      `gorm:"foreignKey:UserID" json:"-"`
+	Secret              string     `gorm:"not null" json:"-"` // base32 shared secret, sealed with signingKeysSecret
+	ConfirmedAt         *time.Time `json:"confirmedAt"`
+	// LastUsedStep is the time step of the last accepted code, codes of that step
+	// or earlier are refused so an observed code cannot be replayed
+	LastUsedStep int64 `gorm:"not null;default:0" json:"-"`
+}
+
+// Confirmed reports whether enrollment was completed
+func (c *TOTPCredential) Confirmed() bool {
+	return c.ConfirmedAt != nil
+}
+
+// RecoveryCode : GORM model for one-time codes that stand in for the second
+// factor when the authenticator is lost. Only a hash of each code is stored.
+type RecoveryCode struct {
+	constants.BaseModel `json:"-"`
+	UserID              string     `gorm:"type:char(26);not null;index" json:"-"`
+	User                *User      `gorm:"foreignKey:UserID" json:"-"`
+	CodeHash            string     `gorm:"not null" json:"-"`
+	UsedAt              *time.Time `json:"usedAt"`
+}
+
+// HashRecoveryCode hashes a recovery code for storage. Separators and case are
+// ignored so codes can be typed the way they are shown.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetTOTPCredential loads the authenticator of a user
+func GetTOTPCredential(userID string) (*TOTPCredential, error) {
+	var cred TOTPCredential
+	if err := Dbcon.Where("user_id = ?", userID).First(&cred).Error; err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// HasConfirmedTOTP reports whether the user has a confirmed authenticator
+func HasConfirmedTOTP(userID string) (bool, error) {
+	cred, err := GetTOTPCredential(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return cred.Confirmed(), nil
+}
+
+// CountUnusedRecoveryCodes returns how many recovery codes the user has left
+func CountUnusedRecoveryCodes(userID string) (int64, error) {
+	var count int64
+	err := Dbcon.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
@@ -16,7 +16,7 @@ func Migrate() {
 	_ = Dbcon.AutoMigrate(&UserOrgRole{})
 
 	_ = Dbcon.AutoMigrate(&User{}, &Profile{}, &OAuthClient{}, &Organization{},
-		&Role{}, &Permission{}, &UserOrgRole{}, &RolePermission{}, &ForgotPassword{}, &AuthLog{}, &EmailVerification{}, &MobileVerification{}, &Invitation{}, &WebAuthnCredential{})
+		&Role{}, &Permission{}, &UserOrgRole{}, &RolePermission{}, &AuthLog{}, &EmailVerification{}, &MobileVerification{}, &Invitation{}, &WebAuthnCredential{}, &SigningKey{}, &APIClient{}, &PersonalAccessToken{}, &RelyingParty{}, &OAuthConsent{}, &TOTPCredential{}, &RecoveryCode{}, &OrgSecurityPolicy{}, &PasswordHistory{}, &AuditLog{})
 
 	// Create
 	// results := Dbcon.Create(&User{Username: "L1212", Password: "jamsheed"})
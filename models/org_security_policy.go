@@ -0,0 +1,170 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Second factors an organization policy can require
+const (
+	MFAFactorTOTP     = "totp"
+	MFAFactorWebAuthn = "webauthn"
+)
+
+// MFAFactors are the factor types a policy can allow
+var MFAFactors = []string{MFAFactorTOTP, MFAFactorWebAuthn}
+
+// OrgSecurityPolicy : GORM model for the security rules an organization sets for
+// its members. Without a row the organization has no requirements.
+type OrgSecurityPolicy struct {
+	constants.BaseModel `json:"-"`
+	OrgID               string        `gorm:"type:char(26);not null;uniqueIndex" json:"orgId"`
+	Org                 *Organization `gorm:"foreignKey:OrgID" json:"-"`
+	MFARequired         bool          `gorm:"not null;default:false" json:"mfaRequired"`
+	MFARoles            string        `gorm:"type:text" json:"-"` // space separated role names, empty for every member
+	MFAFactors          string        `gorm:"type:text" json:"-"` // space separated factor types, empty for any
+	GracePeriodDays     int           `gorm:"not null;default:0" json:"gracePeriodDays"`
+	// EnforcedSince is when the current MFA requirement was set, the grace period
+	// for enrollment runs from here
+	EnforcedSince *time.Time `json:"enforcedSince"`
+	// Password rules for members on top of the default policy, zero values add nothing
+	PasswordMinLength        int  `gorm:"not null;default:0" json:"-"`
+	PasswordRequireUppercase bool `gorm:"not null;default:false" json:"-"`
+	PasswordRequireLowercase bool `gorm:"not null;default:false" json:"-"`
+	PasswordRequireDigit     bool `gorm:"not null;default:false" json:"-"`
+	PasswordRequireSymbol    bool `gorm:"not null;default:false" json:"-"`
+	PasswordHistoryCount     int  `gorm:"not null;default:0" json:"-"`
+	PasswordMaxAgeDays       int  `gorm:"not null;default:0" json:"-"`
+}
+
+// PasswordPolicy returns the policy the passwords of members follow
+func (p *OrgSecurityPolicy) PasswordPolicy() PasswordPolicy {
+	return DefaultPasswordPolicy.Merge(PasswordPolicy{
+		MinLength:        p.PasswordMinLength,
+		RequireUppercase: p.PasswordRequireUppercase,
+		RequireLowercase: p.PasswordRequireLowercase,
+		RequireDigit:     p.PasswordRequireDigit,
+		RequireSymbol:    p.PasswordRequireSymbol,
+		HistoryCount:     p.PasswordHistoryCount,
+		MaxAgeDays:       p.PasswordMaxAgeDays,
+	})
+}
+
+// Roles returns the roles MFA is required for, empty for every member
+func (p *OrgSecurityPolicy) Roles() []string {
+	return strings.Fields(p.MFARoles)
+}
+
+// AllowedFactors returns the factor types that meet the policy
+func (p *OrgSecurityPolicy) AllowedFactors() []string {
+	if strings.TrimSpace(p.MFAFactors) == "" {
+		return MFAFactors
+	}
+	return strings.Fields(p.MFAFactors)
+}
+
+// AppliesTo reports whether the policy requires MFA from a member holding roles.
+// Role names are compared case-insensitively, like in permission checks.
+func (p *OrgSecurityPolicy) AppliesTo(roles []string) bool {
+	if !p.MFARequired {
+		return false
+	}
+	required := p.Roles()
+	if len(required) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if slices.ContainsFunc(required, func(r string) bool { return strings.EqualFold(r, role) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// SatisfiedBy reports whether one of the enrolled factors is allowed by the policy
+func (p *OrgSecurityPolicy) SatisfiedBy(factors []string) bool {
+	allowed := p.AllowedFactors()
+	for _, factor := range factors {
+		if slices.Contains(allowed, factor) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnrollBy returns when the grace period for enrollment ends
+func (p *OrgSecurityPolicy) EnrollBy() time.Time {
+	since := p.UpdatedAt
+	if p.EnforcedSince != nil {
+		since = *p.EnforcedSince
+	}
+	return since.AddDate(0, 0, p.GracePeriodDays)
+}
+
+// GetOrgSecurityPolicy loads the security policy of an organization
+func GetOrgSecurityPolicy(orgID string) (*OrgSecurityPolicy, error) {
+	var policy OrgSecurityPolicy
+	if err := Dbcon.Where("org_id = ?", orgID).First(&policy).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// EnrolledMFAFactors returns the second factors the user signs in with. A security
+// key only counts when the user turned on requiring it after the password.
+func EnrolledMFAFactors(user *User) ([]string, error) {
+	factors := []string{}
+	totpEnabled, err := HasConfirmedTOTP(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		factors = append(factors, MFAFactorTOTP)
+	}
+	if user.WebAuthnMFA {
+		creds, err := GetWebAuthnCredentials(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(creds) > 0 {
+			factors = append(factors, MFAFactorWebAuthn)
+		}
+	}
+	return factors, nil
+}
+
+// UnmetBy reports whether the policy requires MFA from the user, who holds roles in
+// the organization, and none of the user's factors is allowed by it. Service
+// accounts cannot enroll a second factor and are not subject to the policy.
+func (p *OrgSecurityPolicy) UnmetBy(user *User, roles []string) (bool, error) {
+	if user.AccountType == constants.AccountTypeService || !p.AppliesTo(roles) {
+		return false, nil
+	}
+	factors, err := EnrolledMFAFactors(user)
+	if err != nil {
+		return false, err
+	}
+	return !p.SatisfiedBy(factors), nil
+}
+
+// UnmetMFAPolicy returns the policy of the organization when the user, who holds
+// roles there, does not meet its MFA requirement, and nil otherwise
+func UnmetMFAPolicy(user *User, orgID string, roles []string) (*OrgSecurityPolicy, error) {
+	policy, err := GetOrgSecurityPolicy(orgID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	unmet, err := policy.UnmetBy(user, roles)
+	if err != nil || !unmet {
+		return nil, err
+	}
+	return policy, nil
+}
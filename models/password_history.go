@@ -0,0 +1,61 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/passwordhash"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// MaxPasswordHistory is how many passwords are kept per user, the most an
+// organization can forbid reusing
+const MaxPasswordHistory = 24
+
+// ErrPasswordReused is returned when a new password is one of the last passwords
+// of the user the password policy forbids reusing
+var ErrPasswordReused = errors.New("password was used recently")
+
+// PasswordHistory : GORM model for a password a user has set, kept as its
+// hash. The current password is the latest entry. Entries go with the user.
+type PasswordHistory struct {
+	constants.BaseModel `json:"-"`
+	UserID              string `gorm:"type:char(26);not null;index" json:"-"`
+	User                *User  `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Hash                string `gorm:"not null" json:"-"`
+}
+
+// PasswordReused reports whether the password is one of the last count passwords
+// of the user
+func PasswordReused(tx *gorm.DB, userID, password string, count int) (bool, error) {
+	if count <= 0 {
+		return false, nil
+	}
+	var hashes []string
+	err := tx.Model(&PasswordHistory{}).Where("user_id = ?", userID).
+		Order("created_at DESC").Limit(min(count, MaxPasswordHistory)).Pluck("hash", &hashes).Error
+	if err != nil {
+		return false, err
+	}
+	for _, hash := range hashes {
+		reused, err := passwordhash.Verify(hash, password)
+		if err != nil {
+			return false, err
+		}
+		if reused {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recordPasswordHistory adds the password hash to the history of the user and
+// drops the entries past MaxPasswordHistory
+func recordPasswordHistory(tx *gorm.DB, userID, hash string) error {
+	if err := tx.Create(&PasswordHistory{UserID: userID, Hash: hash}).Error; err != nil {
+		return err
+	}
+	keep := tx.Model(&PasswordHistory{}).Select("id").Where("user_id = ?", userID).
+		Order("created_at DESC").Limit(MaxPasswordHistory)
+	return tx.Unscoped().Where("user_id = ? AND id NOT IN (?)", userID, keep).Delete(&PasswordHistory{}).Error
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPasswordMinLength is the shortest password accepted without an
+	// organization asking for longer ones
+	DefaultPasswordMinLength = 8
+	// MaxPasswordLength is the longest password accepted, in bytes, the most bcrypt
+	// hashes. Passwords stay within it so the bcrypt hasher remains an option.
+	MaxPasswordLength = 72
+	// MaxPasswordAgeDays is the longest password lifetime an organization can set
+	MaxPasswordAgeDays = 365
+)
+
+// PasswordPolicy : the rules new passwords follow. Passwords containing the
+// username or email of the account are always refused.
+type PasswordPolicy struct {
+	MinLength        int  `json:"minLength"`
+	RequireUppercase bool `json:"requireUppercase"`
+	RequireLowercase bool `json:"requireLowercase"`
+	RequireDigit     bool `json:"requireDigit"`
+	RequireSymbol    bool `json:"requireSymbol"`
+	// HistoryCount is how many of the last passwords cannot be set again, the
+	// current one included
+	HistoryCount int `json:"historyCount"`
+	// MaxAgeDays is how many days a password can be used before it has to be
+	// changed, 0 for no expiry
+	MaxAgeDays int `json:"maxAgeDays"`
+}
+
+// DefaultPasswordPolicy applies to every account
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: DefaultPasswordMinLength}
+
+// Merge returns the policy that holds passwords to the rules of both
+func (p PasswordPolicy) Merge(other PasswordPolicy) PasswordPolicy {
+	p.MinLength = max(p.MinLength, other.MinLength)
+	p.RequireUppercase = p.RequireUppercase || other.RequireUppercase
+	p.RequireLowercase = p.RequireLowercase || other.RequireLowercase
+	p.RequireDigit = p.RequireDigit || other.RequireDigit
+	p.RequireSymbol = p.RequireSymbol || other.RequireSymbol
+	p.HistoryCount = max(p.HistoryCount, other.HistoryCount)
+	if p.MaxAgeDays == 0 || (other.MaxAgeDays > 0 && other.MaxAgeDays < p.MaxAgeDays) {
+		p.MaxAgeDays = other.MaxAgeDays
+	}
+	return p
+}
+
+// Check returns the rules the password breaks, empty when it follows the policy.
+// identities are the username and email addresses of the account.
+func (p PasswordPolicy) Check(password string, identities ...string) []string {
+	var problems []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("be at least %d characters long", p.MinLength))
+	}
+	if len(password) > MaxPasswordLength {
+		problems = append(problems, fmt.Sprintf("be at most %d bytes long", MaxPasswordLength))
+	}
+	if p.RequireUppercase && !strings.ContainsFunc(password, unicode.IsUpper) {
+		problems = append(problems, "contain an uppercase letter")
+	}
+	if p.RequireLowercase && !strings.ContainsFunc(password, unicode.IsLower) {
+		problems = append(problems, "contain a lowercase letter")
+	}
+	if p.RequireDigit && !strings.ContainsFunc(password, unicode.IsDigit) {
+		problems = append(problems, "contain a digit")
+	}
+	if p.RequireSymbol && !strings.ContainsFunc(password, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	}) {
+		problems = append(problems, "contain a symbol")
+	}
+	lower := strings.ToLower(password)
+	for _, identity := range identities {
+		if identity != "" && strings.Contains(lower, strings.ToLower(identity)) {
+			problems = append(problems, "not contain the username or email")
+			break
+		}
+	}
+	return problems
+}
+
+// Expired reports whether a password set at changedAt is past the maximum age. A
+// password with no known change time does not expire.
+func (p PasswordPolicy) Expired(changedAt *time.Time) bool {
+	if p.MaxAgeDays <= 0 || changedAt == nil {
+		return false
+	}
+	return time.Since(*changedAt) > time.Duration(p.MaxAgeDays)*24*time.Hour
+}
+
+// PasswordPolicyFor returns the policy a user's password follows: the default
+// policy held to the rules of every organization the user belongs to
+func PasswordPolicyFor(userID string) (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy
+	if userID == "" {
+		return policy, nil
+	}
+	var orgPolicies []OrgSecurityPolicy
+	err := Dbcon.Where("org_id IN (?)", Dbcon.Model(&UserOrgRole{}).Select("org_id").Where("user_id = ?", userID)).
+		Find(&orgPolicies).Error
+	if err != nil {
+		return policy, err
+	}
+	for _, orgPolicy := range orgPolicies {
+		policy = policy.Merge(orgPolicy.PasswordPolicy())
+	}
+	return policy, nil
+}
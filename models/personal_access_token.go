@@ -0,0 +1,52 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// PersonalAccessToken : GORM model for long lived tokens users create for CLI
+// tooling and CI pipelines. Only a hash of the token is stored.
+type PersonalAccessToken struct {
+	constants.BaseModel `json:"-"`
+	UserID              string `gorm:"type:char(26);not null;index" json:"-"`
+	User                *User  `gorm:"foreignKey:UserID" json:"-"`
+	Name                string `gorm:"not null" json:"name"`
+	TokenHash           string `gorm:"unique;not null" json:"-"`
+	// Hint is the start of the token, shown in listings to tell tokens apart
+	Hint string `json:"hint"`
+	// Permissions restricts the token to these resource:scope:action strings,
+	// space separated. Empty means the token carries all of the user's permissions.
+	Permissions string     `gorm:"type:text" json:"-"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+}
+
+// HashPersonalAccessToken hashes a token for storage and lookup. Tokens are
+// random and long, so a fast hash is sufficient.
+func HashPersonalAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PermissionList returns the permissions the token is restricted to
+func (t *PersonalAccessToken) PermissionList() []string {
+	return strings.Fields(t.Permissions)
+}
+
+// Expired reports whether the token is past its expiry
+func (t *PersonalAccessToken) Expired() bool {
+	return t.ExpiresAt != nil && !time.Now().Before(*t.ExpiresAt)
+}
+
+// GetPersonalAccessTokenByHash loads a token by the hash of its value
+func GetPersonalAccessTokenByHash(hash string) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	if err := Dbcon.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// DefaultRelyingPartyScopes are granted to relying parties registered without scopes
+const DefaultRelyingPartyScopes = "openid profile email"
+
+// RelyingParty : GORM model for OpenID Connect clients that sign users in through
+// the authorization code flow, such as SPAs and third-party tools. Unlike
+// OAuthClient, which records social logins, these are applications this service
+// is the identity provider for.
+type RelyingParty struct {
+	constants.BaseModel `json:"-"`
+	ClientID            string `gorm:"unique;not null" json:"clientId"`
+	Name                string `gorm:"not null" json:"name"`
+	// SecretHash is empty for public clients such as SPAs, which rely on PKCE alone
+	SecretHash   string `json:"-"`
+	RedirectURIs string `gorm:"type:text;not null" json:"redirectUris"` // space separated
+	Scopes       string `gorm:"type:text" json:"scopes"`                // space separated
+	// Trusted clients are first party applications, users are not asked to consent
+	// to them
+	Trusted bool `gorm:"not null;default:false" json:"trusted"`
+}
+
+// OAuthConsent : GORM model for the scopes a user granted a relying party
+type OAuthConsent struct {
+	constants.BaseModel `json:"-"`
+	UserID              string `gorm:"type:char(26);not null;uniqueIndex:idx_oauth_consents_user_client" json:"userId"`
+	ClientID            string `gorm:"not null;uniqueIndex:idx_oauth_consents_user_client" json:"clientId"`
+	Scopes              string `gorm:"type:text;not null" json:"scopes"` // space separated
+}
+
+// IsPublic reports whether the client cannot keep a secret
+func (rp *RelyingParty) IsPublic() bool {
+	return rp.SecretHash == ""
+}
+
+// CheckSecret compares the secret with the stored hash in constant time
+func (rp *RelyingParty) CheckSecret(secret string) bool {
+	if rp.SecretHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(rp.SecretHash), []byte(HashClientSecret(secret))) == 1
+}
+
+// AllowsRedirectURI reports whether the URI exactly matches a registered redirect URI
+func (rp *RelyingParty) AllowsRedirectURI(uri string) bool {
+	return slices.Contains(strings.Fields(rp.RedirectURIs), uri)
+}
+
+// AllowedScopes returns the scopes the client may request
+func (rp *RelyingParty) AllowedScopes() []string {
+	if strings.TrimSpace(rp.Scopes) == "" {
+		return strings.Fields(DefaultRelyingPartyScopes)
+	}
+	return strings.Fields(rp.Scopes)
+}
+
+// GetRelyingParty loads a relying party by its public client id
+func GetRelyingParty(clientID string) (*RelyingParty, error) {
+	var rp RelyingParty
+	if err := Dbcon.Where("client_id = ?", clientID).First(&rp).Error; err != nil {
+		return nil, err
+	}
+	return &rp, nil
+}
+
+// HasConsent reports whether the user granted the client every one of the scopes
+func HasConsent(userID, clientID string, scopes []string) (bool, error) {
+	var consent OAuthConsent
+	err := Dbcon.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	granted := strings.Fields(consent.Scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GrantConsent adds the scopes to those the user granted the client
+func GrantConsent(userID, clientID string, scopes []string) error {
+	return Dbcon.Transaction(func(tx *gorm.DB) error {
+		var consent OAuthConsent
+		err := tx.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(&OAuthConsent{UserID: userID, ClientID: clientID, Scopes: strings.Join(scopes, " ")}).Error
+		}
+		if err != nil {
+			return err
+		}
+		granted := strings.Fields(consent.Scopes)
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				granted = append(granted, scope)
+			}
+		}
+		return tx.Model(&consent).Update("scopes", strings.Join(granted, " ")).Error
+	})
+}
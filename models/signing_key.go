@@ -0,0 +1,152 @@
+package models
+
+import (
+	"bigbucks/solution/auth/constants"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SigningKeyStatus is the lifecycle state of a JWT signing key
+type SigningKeyStatus string
+
+const (
+	// SigningKeyStatusPending keys are published in the JWKS but not used for signing yet
+	SigningKeyStatusPending SigningKeyStatus = "pending"
+	// SigningKeyStatusActive is the single key new tokens are signed with
+	SigningKeyStatusActive SigningKeyStatus = "active"
+	// SigningKeyStatusRetired keys only verify tokens until their grace period ends
+	SigningKeyStatusRetired SigningKeyStatus = "retired"
+)
+
+// ErrRetireActiveKey is returned when retiring the active key would leave nothing to sign with
+var ErrRetireActiveKey = errors.New("the active key can only be retired by promoting another key")
+
+// SigningKey : GORM model for a JWT signing key pair in the keyring
+type SigningKey struct {
+	constants.BaseModel
+	Kid         string           `gorm:"unique;not null"`
+	Alg         string           `gorm:"not null"`
+	PrivateKey  string           `gorm:"type:text;not null"` // PEM encoded, sealed with signingKeysSecret
+	PublicKey   string           `gorm:"type:text;not null"` // PEM encoded
+	Status      SigningKeyStatus `gorm:"default:pending;index"`
+	ActivatedAt *time.Time
+	RetiredAt   *time.Time
+	ExpiresAt   *time.Time // Retired keys stop verifying tokens after this time
+}
+
+// ListSigningKeys returns every key in the keyring, newest first.
+func ListSigningKeys() ([]SigningKey, error) {
+	var keys []SigningKey
+	err := Dbcon.Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// ListVerificationKeys returns the keys that may still verify tokens: pending,
+// active and retired keys whose grace period has not ended.
+func ListVerificationKeys() ([]SigningKey, error) {
+	var keys []SigningKey
+	err := Dbcon.
+		Where("status IN ?", []SigningKeyStatus{SigningKeyStatusPending, SigningKeyStatusActive}).
+		Or("status = ? AND expires_at > ?", SigningKeyStatusRetired, time.Now()).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// GetSigningKey returns the key with the given kid, deleted keys included.
+func GetSigningKey(kid string) (*SigningKey, error) {
+	var key SigningKey
+	err := Dbcon.Unscoped().Where("kid = ?", kid).First(&key).Error
+	return &key, err
+}
+
+// ImportSigningKey stores a key that signed tokens before it was in the keyring. It
+// becomes the active key when no key is active, otherwise it is retired at once and
+// verifies tokens for the grace period.
+func ImportSigningKey(key *SigningKey, grace time.Duration) error {
+	return Dbcon.Transaction(func(tx *gorm.DB) error {
+		var active int64
+		if err := tx.Model(&SigningKey{}).Where("status = ?", SigningKeyStatusActive).Count(&active).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if active == 0 {
+			key.Status = SigningKeyStatusActive
+			key.ActivatedAt = &now
+		} else {
+			expiresAt := now.Add(grace)
+			key.Status = SigningKeyStatusRetired
+			key.RetiredAt = &now
+			key.ExpiresAt = &expiresAt
+		}
+		return tx.Create(key).Error
+	})
+}
+
+// SetSigningKeyPrivateKey replaces the stored private key of a key.
+func SetSigningKeyPrivateKey(kid, privateKey string) error {
+	return Dbcon.Unscoped().Model(&SigningKey{}).Where("kid = ?", kid).Update("private_key", privateKey).Error
+}
+
+// CreateSigningKey stores a new key in the pending state.
+func CreateSigningKey(key *SigningKey) error {
+	key.Status = SigningKeyStatusPending
+	return Dbcon.Create(key).Error
+}
+
+// PromoteSigningKey makes the given key the active signing key. The previously
+// active key is retired and keeps verifying tokens for the grace period.
+func PromoteSigningKey(kid string, grace time.Duration) error {
+	return Dbcon.Transaction(func(tx *gorm.DB) error {
+		var key SigningKey
+		if err := tx.Where("kid = ?", kid).First(&key).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		expiresAt := now.Add(grace)
+		if err := tx.Model(&SigningKey{}).
+			Where("status = ? AND kid <> ?", SigningKeyStatusActive, kid).
+			Updates(map[string]interface{}{
+				"status":     SigningKeyStatusRetired,
+				"retired_at": now,
+				"expires_at": expiresAt,
+			}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&key).Updates(map[string]interface{}{
+			"status":       SigningKeyStatusActive,
+			"activated_at": now,
+			"retired_at":   nil,
+			"expires_at":   nil,
+		}).Error
+	})
+}
+
+// RetireSigningKey retires a pending key, keeping it valid for verification for the
+// grace period. The active key is retired by promoting its replacement.
+func RetireSigningKey(kid string, grace time.Duration) error {
+	var key SigningKey
+	if err := Dbcon.Where("kid = ?", kid).First(&key).Error; err != nil {
+		return err
+	}
+	if key.Status == SigningKeyStatusActive {
+		return ErrRetireActiveKey
+	}
+	now := time.Now()
+	result := Dbcon.Model(&SigningKey{}).
+		Where("kid = ? AND status <> ?", kid, SigningKeyStatusRetired).
+		Updates(map[string]interface{}{
+			"status":     SigningKeyStatusRetired,
+			"retired_at": now,
+			"expires_at": now.Add(grace),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -4,9 +4,7 @@ import (
 	"bigbucks/solution/auth/constants"
 	"bigbucks/solution/auth/loging"
 	"bigbucks/solution/auth/models/types"
-	"bigbucks/solution/auth/passwordreset"
-	valids "bigbucks/solution/auth/validations"
-	"crypto/rand"
+	"bigbucks/solution/auth/passwordhash"
 	"encoding/json"
 	"io"
 	"os"
@@ -20,26 +18,32 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 // User : User credential model
 type User struct {
 	constants.BaseModel `json:"-"`
-	Username            string               `gorm:"unique;not null" validate:"required,email"`
-	Password            string               `gorm:"column:hashed_password" validate:"required,min=8"`
-	Organizations       []*Organization      `gorm:"many2many:UserOrgRole;JoinForeignKey:UserID;JoinReferences:OrgID;"`
-	Roles               []*Role              `gorm:"many2many:UserOrgRole;JoinForeignKey:UserID;JoinReferences:RoleID;"`
-	Profile             Profile              `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-	ForgotPassword      ForgotPassword       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-	OAuthClient         OAuthClient          `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" validate:"structonly,omitempty"`
-	EmailVerified       bool                 `gorm:"default:false"`
-	MobileVerified      bool                 `gorm:"default:false"`
-	Status              constants.UserStatus `gorm:"default:pending"`
+	Username            string                `gorm:"unique;not null" validate:"required,email"`
+	Password            string                `gorm:"column:hashed_password" validate:"required,min=8"`
+	Organizations       []*Organization       `gorm:"many2many:UserOrgRole;JoinForeignKey:UserID;JoinReferences:OrgID;"`
+	Roles               []*Role               `gorm:"many2many:UserOrgRole;JoinForeignKey:UserID;JoinReferences:RoleID;"`
+	Profile             Profile               `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	OAuthClient         OAuthClient           `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" validate:"structonly,omitempty"`
+	EmailVerified       bool                  `gorm:"default:false"`
+	MobileVerified      bool                  `gorm:"default:false"`
+	WebAuthnMFA         bool                  `gorm:"default:false"` // Require a security key after the password
+	Status              constants.UserStatus  `gorm:"default:pending"`
+	AccountType         constants.AccountType `gorm:"default:human"`
 	EmailVerification   EmailVerification
 	MobileVerification  MobileVerification
 	LastLogin           AuthLog
+	// PasswordChangedAt is when the password was last set, password expiry counts
+	// from here
+	PasswordChangedAt *time.Time
+	// newPasswordHash carries the hash of a password changed by an update to the
+	// AfterUpdate hook
+	newPasswordHash string
 }
 
 // MarshalJSON Json Dump override method
@@ -57,53 +61,6 @@ func (usr User) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&tmp)
 }
 
-// GenerateResetToken Generate a reset token to change password
-func (usr User) GenerateResetToken() (string, error) {
-	b := make([]byte, 8)
-	_, err := rand.Read(b)
-	if err != nil {
-		loging.Logger.Error("Error generating reset token", err)
-		return "", err
-	}
-	var fg ForgotPassword
-	err = Dbcon.Model(&usr).Association("ForgotPassword").Find(&fg)
-	if err != nil {
-		loging.Logger.Error("Error finding forgot password", err)
-		return "", err
-	}
-	fg.ResetToken = fmt.Sprintf("%x", b)
-	fg.Expiry = time.Now().Add(5 * time.Hour)
-	if fg.ID == 0 {
-		fg.UserID = usr.ID
-		Dbcon.Model(&fg).Create(&fg)
-	} else {
-		Dbcon.Model(&fg).Save(&fg)
-	}
-	// TODO: make this async
-	passwordreset.SendResetEmail(fg.ResetToken, usr.Profile.Email, usr.Username)
-	return fmt.Sprintf("%x", b), nil
-}
-
-// ChangePassword change user password with reset token
-func (usr User) ChangePassword(token, password string) (int, error) {
-	customerr := valids.NewErrorDict()
-	if usr.ForgotPassword.ResetToken != token || time.Now().After(usr.ForgotPassword.Expiry) {
-		customerr.Errors["Token"] = "Invalid or expired token provided"
-		return http.StatusForbidden, customerr
-	}
-
-	err := Dbcon.Transaction(func(tx *gorm.DB) error {
-		tx.Model(&usr).Update("Password", password)
-		usr.Password = password
-		tx.Model(&usr.ForgotPassword).Update("Expiry", time.Now())
-		return nil
-	})
-	if err != nil {
-		return http.StatusForbidden, err
-	}
-	return 0, nil
-}
-
 // BeforeCreate GORM hook hash the password
 func (usr *User) BeforeCreate(tx *gorm.DB) (err error) {
 	// Call the parent BeforeCreate to generate ULID
@@ -112,43 +69,116 @@ func (usr *User) BeforeCreate(tx *gorm.DB) (err error) {
 	}
 
 	// Hash password
-	if pw, err := bcrypt.GenerateFromPassword([]byte(usr.Password), 0); err == nil {
-		usr.Password = string(pw)
+	hash, err := passwordhash.Hash(usr.Password)
+	if err != nil {
+		return err
 	}
-	return
+	usr.Password = hash
+	now := time.Now()
+	usr.PasswordChangedAt = &now
+	return nil
 }
 
-// BeforeUpdate GORM hook hash the password
+// AfterCreate GORM hook starts the password history of the user
+func (usr *User) AfterCreate(tx *gorm.DB) error {
+	if usr.PasswordChangedAt == nil {
+		return nil
+	}
+	return recordPasswordHistory(tx.Session(&gorm.Session{NewDB: true}), usr.ID, usr.Password)
+}
+
+// BeforeUpdate GORM hook hash the password. When the update names the user, the
+// password must not be one of the last ones the user's password policy forbids
+// reusing.
 func (usr *User) BeforeUpdate(tx *gorm.DB) (err error) {
 	if tx.Statement.Changed("Password") {
 		x := tx.Statement.Dest.(map[string]interface{})["Password"]
-		if pw, err := bcrypt.GenerateFromPassword([]byte(x.(string)), 0); err == nil {
-			tx.Statement.SetColumn("Password", string(pw))
+		if usr.ID != "" {
+			policy, err := PasswordPolicyFor(usr.ID)
+			if err != nil {
+				return err
+			}
+			reused, err := PasswordReused(tx.Session(&gorm.Session{NewDB: true}), usr.ID, x.(string), policy.HistoryCount)
+			if err != nil {
+				return err
+			}
+			if reused {
+				return ErrPasswordReused
+			}
+		}
+		hash, err := passwordhash.Hash(x.(string))
+		if err != nil {
+			return err
 		}
+		tx.Statement.SetColumn("Password", hash)
+		tx.Statement.SetColumn("PasswordChangedAt", time.Now())
+		usr.newPasswordHash = hash
 	}
 	return
 }
 
+// AfterUpdate GORM hook adds a changed password to the history of the user
+func (usr *User) AfterUpdate(tx *gorm.DB) error {
+	hash := usr.newPasswordHash
+	usr.newPasswordHash = ""
+	if hash == "" || usr.ID == "" || tx.Statement.RowsAffected == 0 {
+		return nil
+	}
+	return recordPasswordHistory(tx.Session(&gorm.Session{NewDB: true}), usr.ID, hash)
+}
+
 // Authenticate => check for valid user credentials
 func Authenticate(username, password string) (success bool, user User) {
 	if err := Dbcon.Where("username = ?", username).Preload("Roles").First(&user).Error; err == gorm.ErrRecordNotFound {
 		return
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err == nil {
-		success = true
+	// Service accounts only authenticate with client credentials
+	if user.AccountType == constants.AccountTypeService {
+		return
+	}
+	success, err := passwordhash.Verify(user.Password, password)
+	if err != nil {
+		loging.Logger.Errorf("Error verifying the password of user %s: %v", user.ID, err)
+		return false, user
+	}
+	if success && passwordhash.NeedsRehash(user.Password) {
+		rehashPassword(&user, password)
 	}
-
 	return
 }
 
-func (usr User) LogLoginActivity(attrs map[string]interface{}) error {
+// rehashPassword replaces the stored hash of a password that was just verified
+// with one from the current hasher. The password itself does not change, so the
+// hooks and the password history are left alone.
+func rehashPassword(user *User, password string) {
+	hash, err := passwordhash.Hash(password)
+	if err != nil {
+		loging.Logger.Errorf("Error rehashing the password of user %s: %v", user.ID, err)
+		return
+	}
+	err = Dbcon.Model(&User{}).Where("id = ? AND hashed_password = ?", user.ID, user.Password).
+		UpdateColumn("hashed_password", hash).Error
+	if err != nil {
+		loging.Logger.Errorf("Error rehashing the password of user %s: %v", user.ID, err)
+		return
+	}
+	user.Password = hash
+}
+
+// Deactivated reports whether an admin deactivated the user. Like password signin,
+// tokens of pending users, whose email is not verified yet, keep working.
+func (usr User) Deactivated() bool {
+	return usr.Status == constants.UserStatusInactive
+}
+
+// LogLoginActivity records a login of the user, attrs are usually LoginAttrs
+func (usr User) LogLoginActivity(attrs any) error {
 	jsonAttrs, _ := json.Marshal(attrs)
-	Dbcon.Create(&AuthLog{
+	return Dbcon.Create(&AuthLog{
 		UserID:  usr.ID,
 		LoginAt: time.Now().UTC(),
 		Attrs:   jsonAttrs,
-	})
-	return nil
+	}).Error
 }
 
 // UpdateUserProfile Update user profile data from map<string, dynamic> datastructure
@@ -185,6 +215,10 @@ func (usr User) UpdateUserProfile(data map[string][]string, picture []*multipart
 	}
 	profile.FirstName = data["firstname"][0]
 	profile.LastName = data["lastname"][0]
+	if profile.ContactNumber != data["userphone"][0] {
+		// A number typed into the profile has not been verified by SMS
+		Dbcon.Model(&User{}).Where("id = ?", usr.ID).Update("mobile_verified", false)
+	}
 	profile.ContactNumber = data["userphone"][0]
 	// check if bio or designation or country or timezone is updated, if yes then update it otherwise keep the old value
 	if len(data["bio"][0]) > 0 {
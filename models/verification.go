@@ -6,18 +6,43 @@ import (
 	"gorm.io/gorm"
 )
 
+// EmailVerification : GORM model for the pending verification of a user's email
+// address. Token is a random value the signed verification link is keyed with, so
+// issuing a new link invalidates the previous one.
 type EmailVerification struct {
 	gorm.Model
-	UserID    uint
+	UserID    string
 	Token     string
 	Email     string
 	ExpiresAt time.Time
 }
 
+// MobileVerification : GORM model for a one-time code sent by SMS to confirm a
+// mobile number. Token is a hash of the code, MobileNumber the number it was sent
+// to, which becomes the user's number once confirmed.
 type MobileVerification struct {
 	gorm.Model
-	UserID       uint
+	UserID       string
 	Token        string
 	MobileNumber string
 	ExpiresAt    time.Time
+	Attempts     int `gorm:"not null;default:0"` // wrong codes entered
+}
+
+// GetEmailVerification loads the pending email verification of a user
+func GetEmailVerification(userID string) (*EmailVerification, error) {
+	var verification EmailVerification
+	if err := Dbcon.Where("user_id = ?", userID).First(&verification).Error; err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
+
+// GetMobileVerification loads the pending mobile verification of a user
+func GetMobileVerification(userID string) (*MobileVerification, error) {
+	var verification MobileVerification
+	if err := Dbcon.Where("user_id = ?", userID).First(&verification).Error; err != nil {
+		return nil, err
+	}
+	return &verification, nil
 }
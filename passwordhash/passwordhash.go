@@ -0,0 +1,241 @@
+// Package passwordhash hashes passwords for storage. New hashes are argon2id in
+// the PHC string format, "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>", so the
+// parameters travel with each hash and can be raised without breaking the hashes
+// already stored. bcrypt hashes of older accounts still verify and report that
+// they need a rehash.
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default argon2id parameters, the second recommended option of RFC 9106
+const (
+	DefaultArgon2Time      = 3
+	DefaultArgon2MemoryKiB = 64 * 1024
+	DefaultArgon2Threads   = 4
+	argon2SaltLength       = 16
+	argon2KeyLength        = 32
+)
+
+// MaxArgon2Time and MaxArgon2MemoryKiB bound the configured parameters, as every
+// sign-in attempt pays for them
+const (
+	MaxArgon2Time      = 10
+	MaxArgon2MemoryKiB = 1024 * 1024
+)
+
+// MaxArgon2Concurrent bounds the configured number of argon2id hashes computed at
+// the same time
+const MaxArgon2Concurrent = 1024
+
+var (
+	// ErrUnknownHash is returned for stored hashes of an algorithm this package
+	// does not know
+	ErrUnknownHash = errors.New("unknown password hash format")
+	// ErrInvalidHash is returned for stored hashes that cannot be decoded
+	ErrInvalidHash = errors.New("invalid password hash")
+
+	mu      sync.RWMutex
+	current Hasher = NewArgon2id(0, 0, 0)
+
+	// slots holds a value for each argon2id hash being computed, so that a burst of
+	// sign-ins waits for memory instead of allocating it all at once
+	slotsMu sync.RWMutex
+	slots   = make(chan struct{}, runtime.NumCPU())
+)
+
+// Hasher hashes new passwords and verifies them against stored hashes
+type Hasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches a hash made by the hasher
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether a stored hash should be replaced by a new one
+	// from the hasher, because of its algorithm or parameters
+	NeedsRehash(hash string) bool
+}
+
+// Argon2id hashes passwords with argon2id
+type Argon2id struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+// NewArgon2id returns an argon2id hasher, zero parameters take the defaults and
+// parameters above the maximums are lowered to them
+func NewArgon2id(time, memoryKiB uint32, threads uint8) *Argon2id {
+	h := &Argon2id{Time: min(time, MaxArgon2Time), MemoryKiB: min(memoryKiB, MaxArgon2MemoryKiB), Threads: threads}
+	if h.Time == 0 {
+		h.Time = DefaultArgon2Time
+	}
+	if h.MemoryKiB == 0 {
+		h.MemoryKiB = DefaultArgon2MemoryKiB
+	}
+	if h.Threads == 0 {
+		h.Threads = DefaultArgon2Threads
+	}
+	return h
+}
+
+// Hash returns the PHC string of the argon2id hash of the password with a random salt
+func (h *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := idKey([]byte(password), salt, h.Time, h.MemoryKiB, h.Threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.MemoryKiB, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password with the parameters stored in the hash
+func (h *Argon2id) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := idKey([]byte(password), salt, params.Time, params.MemoryKiB, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// idKey computes an argon2id key once a slot is free
+func idKey(password, salt []byte, time, memoryKiB uint32, threads uint8, keyLen uint32) []byte {
+	slotsMu.RLock()
+	s := slots
+	slotsMu.RUnlock()
+	s <- struct{}{}
+	defer func() { <-s }()
+	return argon2.IDKey(password, salt, time, memoryKiB, threads, keyLen)
+}
+
+// NeedsRehash is true for hashes of another algorithm or other parameters
+func (h *Argon2id) NeedsRehash(hash string) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	return err != nil || *params != *h
+}
+
+// decodeArgon2id splits a PHC string into its parameters, salt and key
+func decodeArgon2id(hash string) (*Argon2id, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("%w: unsupported argon2 version", ErrInvalidHash)
+	}
+	params := &Argon2id{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.MemoryKiB, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+	if params.Time == 0 || params.Threads == 0 || params.MemoryKiB < 8*uint32(params.Threads) {
+		return nil, nil, nil, fmt.Errorf("%w: invalid argon2 parameters", ErrInvalidHash)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: invalid key", ErrInvalidHash)
+	}
+	return params, salt, key, nil
+}
+
+// Bcrypt hashes passwords with bcrypt, which older releases stored
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of the password. bcrypt refuses passwords longer
+// than 72 bytes.
+func (h Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+// Verify checks the password against a bcrypt hash
+func (h Bcrypt) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+	return true, nil
+}
+
+// NeedsRehash is true for hashes of another algorithm or a lower cost
+func (h Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < max(h.Cost, bcrypt.DefaultCost)
+}
+
+// hasherFor returns the hasher that verifies the stored hash
+func hasherFor(hash string) (Hasher, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return &Argon2id{}, nil
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return Bcrypt{}, nil
+	}
+	return nil, ErrUnknownHash
+}
+
+// SetHasher makes the hasher the one new passwords are hashed with
+func SetHasher(hasher Hasher) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = hasher
+}
+
+// SetMaxConcurrent sets how many argon2id hashes are computed at the same time,
+// others wait for one to finish. Zero or less takes the number of CPUs and values
+// above MaxArgon2Concurrent are lowered to it. Hashes already running finish
+// under the previous limit.
+func SetMaxConcurrent(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
+	slots = make(chan struct{}, min(n, MaxArgon2Concurrent))
+}
+
+// Hash hashes a new password with the hasher set with SetHasher, argon2id with
+// the default parameters unless changed
+func Hash(password string) (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current.Hash(password)
+}
+
+// Verify reports whether the password matches a stored hash of any known algorithm
+func Verify(hash, password string) (bool, error) {
+	hasher, err := hasherFor(hash)
+	if err != nil {
+		return false, err
+	}
+	return hasher.Verify(hash, password)
+}
+
+// NeedsRehash reports whether a stored hash should be replaced by a hash of the
+// current hasher once the password is known
+func NeedsRehash(hash string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current.NeedsRehash(hash)
+}
@@ -74,9 +74,6 @@
 package passwordreset
 
 import (
-	"bigbucks/solution/auth/emailservice"
-	"bigbucks/solution/auth/loging"
-	"bigbucks/solution/auth/settings"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/subtle"
@@ -188,21 +185,3 @@ func VerifyToken(token string, pwdvalFn func(string) ([]byte, error), secret []b
 	}
 	return
 }
-
-// SendResetEmail Send Password Reset Email
-func SendResetEmail(token string, to string, username string) {
-	if err := emailservice.SendEmail(to, "./passwordreset/template.html", struct {
-		Hostname string
-		Company  string
-		Token    string
-		Username string
-	}{
-		Hostname: settings.Current.BaseHost,
-		Company:  "BigBucks",
-		Token:    token,
-		Username: username,
-	}); err != nil {
-		loging.Logger.Errorln("Failed to send password reset email:", err)
-	}
-
-}
@@ -0,0 +1,145 @@
+package permission_cache
+
+import (
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// mfaCacheTTL bounds how long a policy or factor status changed without going
+// through the API, which forgets the cached one, is trusted
+const mfaCacheTTL = 10 * time.Minute
+
+// cachedMFAPolicy is the part of an organization's security policy the MFA check
+// reads, Found is false for organizations without a policy
+type cachedMFAPolicy struct {
+	Found           bool       `json:"found"`
+	MFARequired     bool       `json:"mfaRequired"`
+	MFARoles        string     `json:"mfaRoles"`
+	MFAFactors      string     `json:"mfaFactors"`
+	GracePeriodDays int        `json:"gracePeriodDays"`
+	EnforcedSince   *time.Time `json:"enforcedSince"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// MFAStatus is what the MFA policy of an organization looks at of a user
+type MFAStatus struct {
+	// Service accounts are not subject to MFA policies
+	Service bool `json:"service"`
+	// Factors are the second factors the user signs in with
+	Factors []string `json:"factors"`
+}
+
+func mfaPolicyKey(orgID string) string {
+	return fmt.Sprintf("mfa:policy:%s", orgID)
+}
+
+func mfaStatusKey(userID string) string {
+	return fmt.Sprintf("mfa:status:%s", userID)
+}
+
+// OrgMFAPolicy returns the MFA requirement of the organization's security policy,
+// nil without a policy. Only the MFA fields of the policy are set.
+func (pc *PermissionCache) OrgMFAPolicy(ctx context.Context, orgID string) (*models.OrgSecurityPolicy, error) {
+	var cached cachedMFAPolicy
+	if !pc.getJSON(ctx, mfaPolicyKey(orgID), &cached) {
+		policy, err := models.GetOrgSecurityPolicy(orgID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if policy != nil {
+			cached = cachedMFAPolicy{
+				Found:           true,
+				MFARequired:     policy.MFARequired,
+				MFARoles:        policy.MFARoles,
+				MFAFactors:      policy.MFAFactors,
+				GracePeriodDays: policy.GracePeriodDays,
+				EnforcedSince:   policy.EnforcedSince,
+				UpdatedAt:       policy.UpdatedAt,
+			}
+		}
+		pc.setJSON(ctx, mfaPolicyKey(orgID), cached)
+	}
+	if !cached.Found {
+		return nil, nil
+	}
+	policy := &models.OrgSecurityPolicy{
+		OrgID:           orgID,
+		MFARequired:     cached.MFARequired,
+		MFARoles:        cached.MFARoles,
+		MFAFactors:      cached.MFAFactors,
+		GracePeriodDays: cached.GracePeriodDays,
+		EnforcedSince:   cached.EnforcedSince,
+	}
+	policy.UpdatedAt = cached.UpdatedAt
+	return policy, nil
+}
+
+// ForgetOrgMFAPolicy drops the cached policy of the organization once it changed
+func (pc *PermissionCache) ForgetOrgMFAPolicy(ctx context.Context, orgID string) {
+	if err := pc.RedisClient.Del(ctx, mfaPolicyKey(orgID)).Err(); err != nil {
+		loging.Logger.Error("Failed to forget cached MFA policy", zap.String("orgID", orgID), zap.Error(err))
+	}
+}
+
+// UserMFAStatus returns whether the user is a service account and the second
+// factors the user has enrolled
+func (pc *PermissionCache) UserMFAStatus(ctx context.Context, userID string) (MFAStatus, error) {
+	var status MFAStatus
+	if pc.getJSON(ctx, mfaStatusKey(userID), &status) {
+		return status, nil
+	}
+	var user models.User
+	if err := models.Dbcon.WithContext(ctx).Where("id = ?", userID).First(&user).Error; err != nil {
+		return status, err
+	}
+	status.Service = user.AccountType == constants.AccountTypeService
+	factors, err := models.EnrolledMFAFactors(&user)
+	if err != nil {
+		return status, err
+	}
+	status.Factors = factors
+	pc.setJSON(ctx, mfaStatusKey(userID), status)
+	return status, nil
+}
+
+// ForgetUserMFAStatus drops the cached factor status of the user once a second
+// factor was enrolled or removed
+func (pc *PermissionCache) ForgetUserMFAStatus(ctx context.Context, userID string) {
+	if err := pc.RedisClient.Del(ctx, mfaStatusKey(userID)).Err(); err != nil {
+		loging.Logger.Error("Failed to forget cached MFA status", zap.String("userID", userID), zap.Error(err))
+	}
+}
+
+// getJSON decodes the cached value of key into value and reports whether it was
+// found. Redis errors count as a miss, the caller then asks the database.
+func (pc *PermissionCache) getJSON(ctx context.Context, key string, value any) bool {
+	data, err := pc.RedisClient.Get(ctx, key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			loging.Logger.Error("Failed to read cache", zap.String("key", key), zap.Error(err))
+		}
+		return false
+	}
+	return json.Unmarshal(data, value) == nil
+}
+
+// setJSON caches value under key for mfaCacheTTL
+func (pc *PermissionCache) setJSON(ctx context.Context, key string, value any) {
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = pc.RedisClient.Set(ctx, key, data, mfaCacheTTL).Err()
+	}
+	if err != nil {
+		loging.Logger.Error("Failed to write cache", zap.String("key", key), zap.Error(err))
+	}
+}
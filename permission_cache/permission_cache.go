@@ -7,6 +7,7 @@ import (
 	"bigbucks/solution/auth/settings"
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -109,6 +110,61 @@ func (pc *PermissionCache) getTransientActions(action string) []string {
 }
 
 func (pc *PermissionCache) CheckPermission(ctx *context.Context, resource, scope, action, orgID string, userInfo *settings.UserInfo) (bool, error) {
+	if len(userInfo.Permissions) > 0 {
+		return pc.checkRestrictedPermission(ctx, resource, scope, action, orgID, userInfo)
+	}
+	return pc.checkRolePermission(ctx, resource, scope, action, orgID, userInfo)
+}
+
+// checkRestrictedPermission grants a permission only when one of the user's
+// permission restrictions covers it and the roles grant it as well. The scope
+// recorded in the context is narrowed to the restriction's scope.
+func (pc *PermissionCache) checkRestrictedPermission(ctx *context.Context, resource, scope, action, orgID string, userInfo *settings.UserInfo) (bool, error) {
+	resource = strings.ToUpper(strings.TrimSpace(resource))
+	action = strings.ToUpper(strings.TrimSpace(action))
+	scope = strings.ToUpper(strings.TrimSpace(scope))
+	for _, perm := range userInfo.Permissions {
+		parts := strings.Split(strings.ToUpper(perm), ":")
+		if len(parts) != 3 {
+			continue
+		}
+		permResource, permScope, permAction := parts[0], parts[1], parts[2]
+		if permResource != "*" && permResource != resource {
+			continue
+		}
+		if permAction != "*" && !slices.Contains(pc.getTransientActions(action), permAction) {
+			continue
+		}
+		checkScope := scope
+		if permScope != "*" {
+			if scope == "*" {
+				checkScope = permScope
+			} else if !slices.Contains(pc.expandScope(scope), permScope) {
+				continue
+			}
+		}
+
+		allowed, err := pc.checkRolePermission(ctx, resource, checkScope, action, orgID, userInfo)
+		if err != nil {
+			return false, err
+		}
+		if !allowed {
+			continue
+		}
+		if permScope != "*" {
+			if perm, ok := (*ctx).Value(UserPerm).(map[string]interface{}); ok {
+				matched := strings.ToUpper(string(perm["scope"].(constants.Scope)))
+				if matched != permScope && slices.Contains(pc.expandScope(permScope), matched) {
+					perm["scope"] = constants.Scope(strings.ToLower(permScope))
+				}
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (pc *PermissionCache) checkRolePermission(ctx *context.Context, resource, scope, action, orgID string, userInfo *settings.UserInfo) (bool, error) {
 	resource = strings.ToUpper(strings.TrimSpace(resource))
 	scopes := pc.expandScope(scope)
 	actions := pc.getTransientActions(strings.ToUpper(action))
@@ -0,0 +1,138 @@
+// Package ratelimit limits how often clients call an endpoint. Each key of a rule
+// keeps a sliding window log in Redis: a sorted set of the times of the requests
+// it let through within the window, so a burst at the edge of one window is still
+// counted in the next.
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"bigbucks/solution/auth/settings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyPrefix starts the Redis keys of the windows
+const KeyPrefix = "rate-limit:"
+
+// Rule limits the requests that share a key to Limit within Window. Name tells the
+// rules apart in Redis and in the rateLimits setting, which can override Limit and
+// Window.
+type Rule struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// Result is the state of a window after a request
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is how many more requests the window lets through now
+	Remaining int
+	// Reset is how long until the oldest request leaves the window
+	Reset time.Duration
+}
+
+// RetryAfter is how long a refused client waits before a request is let through
+func (r Result) RetryAfter() time.Duration {
+	if r.Allowed {
+		return 0
+	}
+	return r.Reset
+}
+
+// Seconds rounds a duration up to whole seconds, the unit of the Retry-After and
+// RateLimit-Reset headers
+func Seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// slidingWindow drops the requests that left the window, adds the request when
+// the window has room and returns whether it did, the count and the oldest time
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local first = now
+if oldest[2] then
+	first = tonumber(oldest[2])
+end
+return {allowed, count, first}
+`)
+
+// Limiter counts requests in Redis
+type Limiter struct {
+	client   *redis.Client
+	settings *settings.Settings
+}
+
+// NewLimiter creates a limiter on the Redis of the settings. The rateLimits and
+// disableRateLimits settings are read on every request.
+func NewLimiter(settings *settings.Settings) *Limiter {
+	client := redis.NewClient(&redis.Options{
+		Addr:     settings.RedisAddress,
+		Username: settings.RedisUsername,
+		Password: settings.RedisPassword,
+		DB:       0, // use default DB
+	})
+	return &Limiter{client: client, settings: settings}
+}
+
+// rule applies the override of the settings to the rule
+func (l *Limiter) rule(rule Rule) Rule {
+	if override, ok := l.settings.RateLimits[rule.Name]; ok {
+		if override.Limit > 0 {
+			rule.Limit = override.Limit
+		}
+		if override.Window > 0 {
+			rule.Window = override.Window
+		}
+	}
+	return rule
+}
+
+// Allow counts a request with the key against the rule. Requests are always
+// allowed when rate limits are disabled.
+func (l *Limiter) Allow(ctx context.Context, rule Rule, key string) (Result, error) {
+	rule = l.rule(rule)
+	if l.settings.DisableRateLimits || rule.Limit <= 0 || rule.Window <= 0 {
+		return Result{Allowed: true, Limit: rule.Limit, Remaining: rule.Limit}, nil
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return Result{}, err
+	}
+	now := time.Now().UnixMilli()
+	// Requests in the same millisecond need their own members
+	member := strconv.FormatInt(now, 10) + "-" + hex.EncodeToString(b)
+	windowKey := fmt.Sprintf("%s%s:%s", KeyPrefix, rule.Name, key)
+	values, err := slidingWindow.Run(ctx, l.client, []string{windowKey}, now, rule.Window.Milliseconds(), rule.Limit, member).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	allowed, count, oldest := values[0] == 1, int(values[1]), values[2]
+	return Result{
+		Allowed:   allowed,
+		Limit:     rule.Limit,
+		Remaining: max(rule.Limit-count, 0),
+		Reset:     max(time.Duration(oldest+rule.Window.Milliseconds()-now)*time.Millisecond, 0),
+	}, nil
+}
@@ -1,25 +1,48 @@
 package controllers
 
 import (
+	"bigbucks/solution/auth/actions"
 	"bigbucks/solution/auth/constants"
 	jwtops "bigbucks/solution/auth/jwt-ops"
 	"bigbucks/solution/auth/loging"
 	"bigbucks/solution/auth/models"
 	oauth "bigbucks/solution/auth/oauthutils"
 	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	googleAuthIDTokenVerifier "github.com/futurenda/google-auth-id-token-verifier"
+	"go.uber.org/zap"
 )
 
-// Struct for parsing json body login credentials
+// DeviceCookie keeps the device ID the service gives browsers and apps when they
+// sign in, which tells their logins apart in the login risk evaluation
+const DeviceCookie = "device_id"
+
+// deviceCookieLifetime is how long clients keep their device ID
+const deviceCookieLifetime = 2 * 365 * 24 * time.Hour
+
+// Struct for parsing json body login credentials. ReCaptcha is the token of the
+// CAPTCHA widget of any provider, needed when the captchaEndpoints setting asks for one.
 type JsonCred struct {
 	Password  string `json:"password"`
 	Username  string `json:"username"`
 	ReCaptcha string `json:"recaptcha"`
 }
 
+// Struct for parsing refresh token exchange requests
+type RefreshTokenCred struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
 // Struct for parsing Google oauth login credentials
 type GoogleSigninCred struct {
 	IdToken     string `json:"idToken"`
@@ -35,15 +58,21 @@ func init() {
 // Signin godoc
 //
 //	@Summary		Authenticate with username and pssword
-//	@Description	Authenticate user with password and issue jwt token
+//	@Description	Authenticate user with password and issue jwt token. Users with a second factor get an MFA challenge instead, completed at /signin/mfa or /signin/mfa/webauthn. Users whose password expired get a token to set a new one at /user/changepassword/{token} instead. 5 wrong passwords in a row lock the account for a minute, and every further one for twice as long as the last lockout, up to an hour. A CAPTCHA can be required, always or after failed sign-ins from the address, see the captchaEndpoints setting.
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
 //	@Param			request	body		JsonCred	true	"request body"
-//	@Success		200		{string}	string		"JWT token"
-//	@Failure		400		{object}	error		"Bad request"
+//	@Success		202		{string}	string		"JWT token, refresh token in X-Refresh-Token header"
+//	@Success		200		{object}	types.MFAChallenge	"Second factor required"
+//	@Success		200		{object}	types.PasswordExpiredChallenge	"Password expired"
+//	@Success		200		{object}	types.StepUpChallenge	"High risk sign-in to confirm with the link sent by email"
+//	@Failure		400		{object}	actions.CaptchaError	"Bad request or CAPTCHA required"
+//	@Failure		403		{object}	error		"Email address not verified"
 //	@Failure		404		{object}	error		"Not found"
+//	@Failure		429		{object}	error		"Account locked after too many wrong passwords, see Retry-After"
 //	@Failure		500		{object}	error		"Internal server error"
+//	@Failure		503		{object}	error		"CAPTCHA could not be verified"
 //	@Router			/signin [post]
 func Signin(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
 	// time.Sleep(50 * time.Second)
@@ -52,31 +81,159 @@ func Signin(w http.ResponseWriter, r *http.Request, ctx *request_context.Context
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
+	ip := ClientHost(r)
+	if code, err := actions.CheckCaptcha(r.Context(), settings.CaptchaSignin, cred.ReCaptcha, ip, ctx.SessionStore); err != nil {
+		return code, err
+	}
 	success, user := models.Authenticate(cred.Username, cred.Password)
+	if user.ID != "" {
+		// A locked account is refused whether or not the password is right
+		if retryAfter, code, err := actions.CheckLoginLockout(user.ID, ctx.SessionStore); err != nil {
+			setRetryAfter(w, retryAfter)
+			return code, err
+		}
+	}
 	if !success {
+		actions.RecordCaptchaFailure(settings.CaptchaSignin, ip, ctx.SessionStore)
+		if user.ID != "" {
+			if retryAfter, code, err := actions.RecordLoginFailure(&user, ip, ctx.SessionStore); err != nil {
+				setRetryAfter(w, retryAfter)
+				return code, err
+			}
+		}
 		return http.StatusUnauthorized, nil
 	}
-	userAgent := r.UserAgent()
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = r.RemoteAddr
+	if code, err := actions.CheckVerifiedEmail(&user, ctx.Settings.RequireVerifiedEmail, settings.RequireVerifiedEmailSignin); err != nil {
+		return code, err
+	}
+	// Password expiry is checked by completeSignin, once every factor passed, so the
+	// password alone does not get a token to change it
+	methods, err := actions.MFAMethods(&user)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(methods) > 0 {
+		return writeMFAChallenge(w, ctx, &user, methods)
+	}
+	login := newLoginContext(w, r)
+	stepUp, risk, err := actions.LoginNeedsStepUp(&user, login)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if stepUp {
+		return writeStepUpChallenge(w, ctx, &user, login)
+	}
+	return completeEvaluatedSignin(w, r, ctx, &user, "", login, risk)
+}
+
+// setRetryAfter tells a client refused for a while when to try again, in whole seconds
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+}
+
+// writePasswordExpiredChallenge answers a sign-in with an expired password. No
+// session is created, the token only sets a new password.
+func writePasswordExpiredChallenge(w http.ResponseWriter, user *models.User) (int, error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(types.PasswordExpiredChallenge{
+		PasswordExpired:     true,
+		PasswordChangeToken: actions.PasswordChangeToken(user),
+		ExpiresIn:           int64(actions.PasswordChangeTokenLifetime.Seconds()),
+	}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// writeStepUpChallenge answers a high risk sign-in of a user without a second
+// factor. No session is created, the link sent by email completes the sign-in.
+func writeStepUpChallenge(w http.ResponseWriter, ctx *request_context.Context, user *models.User, login actions.LoginContext) (int, error) {
+	if code, err := actions.SendStepUpLink(user, login, ctx.SessionStore); err != nil {
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(types.StepUpChallenge{
+		StepUpRequired: true,
+		Methods:        []string{actions.StepUpMethodEmailLink},
+	}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// newLoginContext describes the client of a sign-in for the login risk evaluator
+func newLoginContext(w http.ResponseWriter, r *http.Request) actions.LoginContext {
+	id, known := deviceID(w, r)
+	return actions.NewLoginContext(ClientHost(r), r.UserAgent(), id, known)
+}
+
+// deviceID returns the device ID of the client and whether the client sent it.
+// Clients without a valid device cookie are given one with the response.
+func deviceID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if cookie, err := r.Cookie(DeviceCookie); err == nil {
+		if id, ok := actions.VerifyDeviceID(cookie.Value); ok {
+			return id, true
+		}
+	}
+	// A sign-in may look at its client more than once
+	for _, line := range w.Header().Values("Set-Cookie") {
+		if cookie, err := http.ParseSetCookie(line); err == nil && cookie.Name == DeviceCookie {
+			id, _ := actions.VerifyDeviceID(cookie.Value)
+			return id, false
+		}
+	}
+	signed, id, err := actions.NewDeviceID()
+	if err != nil {
+		loging.Logger.Error("Error creating device ID", zap.Error(err))
+		return "", false
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookie,
+		Value:    signed,
+		Path:     "/",
+		MaxAge:   int(deviceCookieLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(settings.Current.Issuer, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id, false
+}
+
+// completeSignin creates the session of an authenticated user, records the login
+// and writes the token. Every sign-in ends here, so users whose password expired get
+// a token to set a new one instead of a session, however they signed in. method
+// tells how the user signed in, empty for the password.
+func completeSignin(w http.ResponseWriter, r *http.Request, ctx *request_context.Context, user *models.User, method string) (int, error) {
+	return completeEvaluatedSignin(w, r, ctx, user, method, newLoginContext(w, r), nil)
+}
+
+// completeEvaluatedSignin is completeSignin for a sign-in whose client, and possibly
+// login risk, was already looked at. A nil risk is evaluated when the login is
+// recorded.
+func completeEvaluatedSignin(w http.ResponseWriter, r *http.Request, ctx *request_context.Context, user *models.User, method string, login actions.LoginContext, risk *actions.LoginRisk) (int, error) {
+	// Failures are only forgotten once every factor passed, a right password alone
+	// does not end the lockout count of wrong second factors
+	actions.ResetLoginFailures(user.ID, ctx.SessionStore)
+	expired, code, err := actions.PasswordExpired(user)
+	if err != nil {
+		return code, err
+	}
+	if expired {
+		return writePasswordExpiredChallenge(w, user)
 	}
 	// JWT expiration time (e.g., 24 hours)
 
-	sessionId, err := ctx.SessionStore.CreateSession(user.ID, user.Username, userAgent, ip, constants.SESSION_EXPIRY)
+	sessionId, err := ctx.SessionStore.CreateSession(user.ID, user.Username, login.UserAgent, login.IP, constants.SESSION_EXPIRY)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
-	go func() {
-		if err := user.LogLoginActivity(map[string]interface{}{
-			"ip":         ip,
-			"user_agent": userAgent,
-		}); err != nil {
-			loging.Logger.Error("Error logging login activity", err)
-		}
-	}()
+	go actions.RecordLogin(user, login, risk, method)
 
-	return printToken(w, r, &user, sessionId)
+	return printToken(w, r, ctx, user, sessionId)
 }
 
 func GoogleSignin(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
@@ -92,11 +249,7 @@ func GoogleSignin(w http.ResponseWriter, r *http.Request, ctx *request_context.C
 			w.WriteHeader(http.StatusUnauthorized)
 			return http.StatusUnauthorized, nil
 		}
-		sessionId, err := ctx.SessionStore.CreateSession(user.ID, user.Username, r.UserAgent(), r.RemoteAddr, constants.SESSION_EXPIRY)
-		if err != nil {
-			return http.StatusInternalServerError, err
-		}
-		return printToken(w, r, &user, sessionId)
+		return continueSignin(w, r, ctx, &user, "google")
 	}
 	return http.StatusBadRequest, err
 	// return printToken(w, r, &user, &ctx.Settings)
@@ -113,12 +266,7 @@ func FbSignin(w http.ResponseWriter, r *http.Request, ctx *request_context.Conte
 	if !success {
 		return http.StatusUnauthorized, nil
 	}
-	sessionId, err := ctx.SessionStore.CreateSession(user.ID, user.Username, r.UserAgent(), r.RemoteAddr, constants.SESSION_EXPIRY)
-	if err != nil {
-		loging.Logger.Error("Error creating session", err)
-		return http.StatusInternalServerError, err
-	}
-	return printToken(w, r, &user, sessionId)
+	return continueSignin(w, r, ctx, &user, "facebook")
 	// return printToken(w, r, &user, &ctx.Settings)
 }
 
@@ -140,19 +288,90 @@ func SignOut(w http.ResponseWriter, r *http.Request, ctx *request_context.Contex
 	return 0, nil
 }
 
+// RenewToken godoc
+//
+//	@Summary		Exchange a refresh token for a new JWT
+//	@Description	Rotates the refresh token and issues a new JWT for the same session. The refresh token is read from the X-Refresh-Token header or the request body. Presenting an already used refresh token revokes the session.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Refresh-Token	header		string				false	"Refresh token"
+//	@Param			request			body		RefreshTokenCred	false	"request body"
+//	@Success		202				{string}	string				"JWT token, new refresh token in X-Refresh-Token header"
+//	@Success		200				{object}	types.PasswordExpiredChallenge	"Password expired"
+//	@Failure		400				{object}	error				"Bad request"
+//	@Failure		401				{object}	error				"Invalid or reused refresh token"
+//	@Failure		500				{object}	error				"Internal server error"
+//	@Router			/renew [post]
 func RenewToken(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
-	user, _ := ctx.GetCurrentUserModel()
+	var cred RefreshTokenCred
+	cred.RefreshToken = r.Header.Get("X-Refresh-Token")
+	if cred.RefreshToken == "" && r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&cred); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	if cred.RefreshToken == "" {
+		return http.StatusBadRequest, errors.New("refresh token is required")
+	}
 
-	return printToken(w, r, user, ctx.Auth.ID)
+	sessionID, sessionData, refreshToken, err := ctx.SessionStore.RotateRefreshToken(cred.RefreshToken)
+	if errors.Is(err, sessionstore.ErrRefreshTokenReused) {
+		loging.Logger.Warn("Refresh token reuse detected, session revoked")
+		return http.StatusUnauthorized, err
+	}
+	if errors.Is(err, sessionstore.ErrRefreshTokenInvalid) {
+		return http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if sessionData.ClientID != "" {
+		// Relying parties renew at /oauth/token, treat the token as stolen
+		if err := ctx.SessionStore.RevokeSession(sessionID); err != nil {
+			loging.Logger.Error("Error revoking session", err)
+		}
+		return http.StatusUnauthorized, sessionstore.ErrRefreshTokenInvalid
+	}
+
+	var user models.User
+	if err := models.Dbcon.Where("id = ?", sessionData.UserID).Preload("Roles").First(&user).Error; err != nil {
+		return http.StatusUnauthorized, err
+	}
+	// A session does not outlive the password it was started with
+	expired, code, err := actions.PasswordExpired(&user)
+	if err != nil {
+		return code, err
+	}
+	if expired {
+		if err := ctx.SessionStore.RevokeSession(sessionID); err != nil {
+			loging.Logger.Error("Error revoking session", err)
+		}
+		return writePasswordExpiredChallenge(w, &user)
+	}
+
+	return writeToken(w, &user, sessionID, refreshToken)
+}
+
+// printToken signs a JWT for the session and writes it to the response together
+// with a freshly issued refresh token.
+func printToken(w http.ResponseWriter, _ *http.Request, ctx *request_context.Context, user *models.User, sessionId string) (int, error) {
+	refreshToken, err := ctx.SessionStore.IssueRefreshToken(sessionId)
+	if err != nil {
+		loging.Logger.Error("Error issuing refresh token", err)
+		return http.StatusInternalServerError, err
+	}
+	return writeToken(w, user, sessionId, refreshToken)
 }
 
-func printToken(w http.ResponseWriter, _ *http.Request, user *models.User, sessionId string) (int, error) {
+func writeToken(w http.ResponseWriter, user *models.User, sessionId, refreshToken string) (int, error) {
 	signed, err := jwtops.SignJWT(user, sessionId)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
-	w.WriteHeader(http.StatusAccepted)
 	w.Header().Set("Content-Type", "text")
+	w.Header().Set("X-Refresh-Token", refreshToken)
+	w.WriteHeader(http.StatusAccepted)
 	_, err = w.Write([]byte(signed))
 	if err != nil {
 		loging.Logger.Error("Error writing to response on token print", err)
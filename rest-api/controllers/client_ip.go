@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/settings"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ClientHost returns the address of the caller without the port, for counting
+// requests per client and locating logins. It is the peer address, unless the peer
+// is one of the trustedProxies: then X-Forwarded-For is walked from the right,
+// skipping the hops added by trusted proxies, and the first address not trusted
+// is the client. Anything to the left of it was sent by the client and is ignored.
+func ClientHost(r *http.Request) string {
+	peer := hostOf(r.RemoteAddr)
+	proxies, err := settings.Current.TrustedProxyPrefixes()
+	if err != nil {
+		loging.Logger.Error("Error parsing trusted proxies", zap.Error(err))
+		return peer
+	}
+	if len(proxies) == 0 || !trustedProxy(peer, proxies) {
+		return peer
+	}
+
+	client := peer
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for _, hop := range slices.Backward(forwarded) {
+		hop = hostOf(strings.TrimSpace(hop))
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+		if !trustedProxy(hop, proxies) {
+			break
+		}
+	}
+	return client
+}
+
+// hostOf strips the port of an address, if any
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// trustedProxy reports whether the address is in one of the trusted ranges
+func trustedProxy(address string, proxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
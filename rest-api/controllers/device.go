@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/deviceauth"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"bigbucks/solution/auth/settings"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// DeviceCodeGrantType is the RFC 8628 grant type devices poll the token endpoint with
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceAuthService is the shared device authorization service instance, set during route initialization.
+var deviceAuthService *deviceauth.Service
+
+// SetDeviceAuthService sets the device authorization service (called from route setup).
+func SetDeviceAuthService(svc *deviceauth.Service) {
+	deviceAuthService = svc
+}
+
+// VerifyDeviceRequest represents the request body for approving or denying a device sign-in
+type VerifyDeviceRequest struct {
+	UserCode string `json:"userCode"`
+	Approve  bool   `json:"approve"`
+}
+
+// deviceVerificationURL is the page users enter the user code on
+func deviceVerificationURL(s *settings.Settings) string {
+	if s.DeviceVerificationURL != "" {
+		return s.DeviceVerificationURL
+	}
+	return strings.TrimSuffix(s.BaseHost, "/") + "/device"
+}
+
+// DeviceAuthorization godoc
+//
+//	@Summary		OAuth2 device authorization endpoint
+//	@Description	Starts the RFC 8628 device authorization grant for clients without a browser, such as CLIs. The device shows the user code and verification URI, then polls /oauth/token with the device code until a signed-in user approves it.
+//	@Tags			oauth
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			client_id	formData	string	true	"Client ID"
+//	@Param			scope		formData	string	false	"Space separated scopes"
+//	@Success		200			{object}	types.DeviceAuthorizationResponse
+//	@Failure		400			{object}	OAuthError
+//	@Failure		401			{object}	OAuthError
+//	@Router			/oauth/device_authorization [post]
+func DeviceAuthorization(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	if err := r.ParseForm(); err != nil {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "malformed form body"}
+	}
+	rp, status, err := authenticateRelyingParty(w, r)
+	if err != nil {
+		return status, err
+	}
+	scopes := strings.Fields(r.PostFormValue("scope"))
+	for _, scope := range scopes {
+		if !slices.Contains(rp.AllowedScopes(), scope) {
+			return http.StatusBadRequest, &OAuthError{Code: "invalid_scope", Description: "scope " + scope + " is not allowed for the client"}
+		}
+	}
+
+	deviceCode, auth, err := deviceAuthService.Begin(rp.ClientID, strings.Join(scopes, " "))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	verificationURI := deviceVerificationURL(ctx.Settings)
+	userCode := deviceauth.FormatUserCode(auth.UserCode)
+	response := types.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: withQuery(verificationURI, url.Values{"user_code": {userCode}}),
+		ExpiresIn:               int64(deviceauth.CodeExpiry.Seconds()),
+		Interval:                int64(auth.Interval.Seconds()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// GetDeviceVerification godoc
+//
+//	@Summary		Look up a device sign-in
+//	@Description	Returns the client behind a user code so the user can check it before approving
+//	@Tags			oauth
+//	@Produce		json
+//	@Param			user_code	query		string	true	"User code shown by the device"
+//	@Success		200			{object}	types.DeviceVerification
+//	@Failure		401			""
+//	@Failure		404			{object}	error
+//	@Security		JWTAuth
+//	@Router			/device [get]
+func GetDeviceVerification(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	auth, err := deviceAuthService.Lookup(r.URL.Query().Get("user_code"))
+	if errors.Is(err, deviceauth.ErrUnknownUserCode) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	rp, err := models.GetRelyingParty(auth.ClientID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.DeviceVerification{
+		UserCode:   deviceauth.FormatUserCode(auth.UserCode),
+		ClientID:   rp.ClientID,
+		ClientName: rp.Name,
+		Scope:      auth.Scope,
+	}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// VerifyDevice godoc
+//
+//	@Summary		Approve or deny a device sign-in
+//	@Description	Approves the device showing the user code, which then receives tokens for the signed-in user on its next poll, or denies it. A user code can only be used once.
+//	@Tags			oauth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		VerifyDeviceRequest	true	"User code and decision"
+//	@Success		200		{object}	types.SimpleResponse
+//	@Failure		400		{object}	error
+//	@Failure		401		""
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Security		JWTAuth
+//	@Router			/device [post]
+func VerifyDevice(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req VerifyDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var err error
+	message := "Device sign-in approved"
+	if req.Approve {
+		_, err = deviceAuthService.Approve(req.UserCode, ctx.Auth.Subject)
+	} else {
+		_, err = deviceAuthService.Deny(req.UserCode)
+		message = "Device sign-in denied"
+	}
+	if errors.Is(err, deviceauth.ErrUnknownUserCode) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.SimpleResponse{Message: message}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// deviceCodeGrant issues tokens to a device once its user code was approved
+func deviceCodeGrant(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	rp, status, err := authenticateRelyingParty(w, r)
+	if err != nil {
+		return status, err
+	}
+	deviceCode := r.PostFormValue("device_code")
+	if deviceCode == "" {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "device_code is required"}
+	}
+
+	auth, err := deviceAuthService.Poll(deviceCode, rp.ClientID)
+	switch {
+	case errors.Is(err, deviceauth.ErrAuthorizationPending),
+		errors.Is(err, deviceauth.ErrSlowDown),
+		errors.Is(err, deviceauth.ErrAccessDenied),
+		errors.Is(err, deviceauth.ErrExpiredToken):
+		return http.StatusBadRequest, &OAuthError{Code: err.Error()}
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+
+	user, err := actions.LoadTokenUser(auth.UserID)
+	if errors.Is(err, actions.ErrInvalidGrant) {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_grant"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	sessionID, err := ctx.SessionStore.CreateClientSession(user.ID, user.Username, r.UserAgent(), ClientHost(r), rp.ClientID, auth.Scope, constants.SESSION_EXPIRY)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	refreshToken, err := ctx.SessionStore.IssueRefreshToken(sessionID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return writeRelyingPartyTokens(w, r, ctx, user, rp, sessionID, refreshToken, auth.Scope, "", auth.ApprovedAt)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// VerifyEmailRequest carries the token of a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ResendVerificationRequest asks for a new verification link
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyEmail godoc
+//
+//	@Summary		Verify email address
+//	@Description	Confirms the email address with the token of the link sent at signup. Pending users become active.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		VerifyEmailRequest		true	"Verification token"
+//	@Success		200		{object}	types.SimpleResponse	"Email verified"
+//	@Failure		400		{object}	error					"Invalid or expired link"
+//	@Failure		500		{object}	error					"Internal server error"
+//	@Router			/user/verify-email [post]
+func VerifyEmail(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if code, err := actions.ConfirmEmail(req.Token); err != nil {
+		return code, err
+	}
+	if err := json.NewEncoder(w).Encode(&types.SimpleResponse{Message: "Email address verified"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// ResendVerificationEmail godoc
+//
+//	@Summary		Resend verification email
+//	@Description	Sends a new verification link to an unverified account, which replaces earlier links. A link is sent at most once a minute. The response is the same whether or not the account exists.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ResendVerificationRequest	true	"Email address"
+//	@Success		200		{object}	types.SimpleResponse		"Link sent"
+//	@Failure		400		{object}	error						"Bad request"
+//	@Failure		429		{object}	error						"Sent too recently"
+//	@Failure		500		{object}	error						"Internal server error"
+//	@Router			/user/verify-email/resend [post]
+func ResendVerificationEmail(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if code, err := actions.ResendEmailVerification(req.Email); err != nil {
+		if errors.Is(err, actions.ErrVerificationThrottled) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(actions.EmailVerificationResendInterval.Seconds())))
+		}
+		return code, err
+	}
+	if err := json.NewEncoder(w).Encode(&types.SimpleResponse{Message: "Verification email sent if the account needs one"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/request_context"
+	"encoding/json"
+	"net/http"
+)
+
+// JWKS godoc
+//
+//	@Summary		Public signing keys
+//	@Description	JSON Web Key Set with every key tokens issued by this service can be verified with
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	jwtops.JWKS
+//	@Failure		500	""
+//	@Router			/.well-known/jwks.json [get]
+func JWKS(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	jwks, err := jwtops.PublicKeys()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Verifiers refetch on an unknown kid, so a short cache is enough
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err = json.NewEncoder(w).Encode(jwks)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"encoding/json"
+	"net/http"
+)
+
+// MagicLinkRequest asks for a sign-in link by email
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkSigninRequest carries the token of a sign-in link
+type MagicLinkSigninRequest struct {
+	Token string `json:"token"`
+}
+
+// RequestMagicLink godoc
+//
+//	@Summary		Email a sign-in link
+//	@Description	Sends a single use link that signs in without a password, valid for 15 minutes. The response is the same whether or not the account exists. An address is sent a link at most once a minute. A client can ask for 10 links an hour, and for 5 an hour for the same address.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		MagicLinkRequest		true	"Email address"
+//	@Success		200		{object}	types.SimpleResponse	"Link sent"
+//	@Failure		400		{object}	error					"Bad request"
+//	@Failure		429		{object}	error					"Too many requests"
+//	@Failure		500		{object}	error					"Internal server error"
+//	@Router			/signin/link [post]
+func RequestMagicLink(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if code, err := actions.RequestMagicLink(req.Email, ctx.SessionStore); err != nil {
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&types.SimpleResponse{Message: "Sign-in link sent if the account exists"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// SigninMagicLink godoc
+//
+//	@Summary		Sign in with an emailed link
+//	@Description	Signs in with the token of a link sent by /signin/link and issues the JWT like /signin. Users with a second factor get an MFA challenge instead. Each link works once, and opening it verifies the email address.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		MagicLinkSigninRequest	true	"Link token"
+//	@Success		202		{string}	string					"JWT token, refresh token in X-Refresh-Token header"
+//	@Success		200		{object}	types.PasswordExpiredChallenge	"Password expired"
+//	@Success		200		{object}	types.MFAChallenge		"Second factor required"
+//	@Failure		400		{object}	error					"Bad request"
+//	@Failure		401		{object}	error					"Invalid or expired link"
+//	@Failure		429		{object}	error					"Too many attempts"
+//	@Failure		500		{object}	error					"Internal server error"
+//	@Router			/signin/link/verify [post]
+func SigninMagicLink(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req MagicLinkSigninRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	user, code, err := actions.ConsumeMagicLink(req.Token, ctx.SessionStore)
+	if err != nil {
+		return code, err
+	}
+	return continueSignin(w, r, ctx, user, "")
+}
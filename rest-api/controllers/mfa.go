@@ -0,0 +1,517 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"go.uber.org/zap"
+)
+
+// MFASigninRequest completes a sign-in with a TOTP code or a recovery code
+type MFASigninRequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// WebAuthnMFARequest turns the security key second factor on or off
+type WebAuthnMFARequest struct {
+	Enabled bool `json:"enabled"`
+	// Turning it off takes an assertion started at /me/mfa/webauthn/begin, or a TOTP
+	// code or recovery code
+	Assertion    json.RawMessage `json:"assertion,omitempty" swaggertype:"object"`
+	Code         string          `json:"code,omitempty"`
+	RecoveryCode string          `json:"recoveryCode,omitempty"`
+}
+
+// MFACodeRequest carries a TOTP code, or a recovery code where one is accepted
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// writeMFAChallenge answers a successful password check of a user with a second
+// factor with a challenge token instead of a session
+func writeMFAChallenge(w http.ResponseWriter, ctx *request_context.Context, user *models.User, methods []string) (int, error) {
+	token, err := ctx.SessionStore.StoreMFAChallenge(&sessionstore.MFAChallenge{UserID: user.ID})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(types.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     methods,
+		ExpiresIn:   int64(sessionstore.MFAChallengeLifetime.Seconds()),
+	}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// continueSignin asks users with a second factor for it and signs in the others.
+// method tells how the user proved the first factor, empty for the password.
+func continueSignin(w http.ResponseWriter, r *http.Request, ctx *request_context.Context, user *models.User, method string) (int, error) {
+	methods, err := actions.MFAMethods(user)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(methods) > 0 {
+		return writeMFAChallenge(w, ctx, user, methods)
+	}
+	return completeSignin(w, r, ctx, user, method)
+}
+
+// checkSecondFactorLockout refuses the second step of a sign-in to a locked account
+func checkSecondFactorLockout(w http.ResponseWriter, ctx *request_context.Context, userID string) (int, error) {
+	retryAfter, code, err := actions.CheckLoginLockout(userID, ctx.SessionStore)
+	setRetryAfter(w, retryAfter)
+	return code, err
+}
+
+// failSecondFactor counts a wrong second factor against the challenge and, like a
+// wrong password, against the account, so that signing in again for a new
+// challenge does not give more guesses
+func failSecondFactor(w http.ResponseWriter, r *http.Request, ctx *request_context.Context, mfaToken, userID string) (int, error) {
+	if err := ctx.SessionStore.FailMFAChallenge(mfaToken); err != nil {
+		loging.Logger.Error("Error counting MFA attempt", zap.Error(err))
+	}
+	var user models.User
+	if err := models.Dbcon.Where("id = ?", userID).First(&user).Error; err != nil {
+		return http.StatusUnauthorized, actions.ErrInvalidMFACode
+	}
+	if retryAfter, code, err := actions.RecordLoginFailure(&user, ClientHost(r), ctx.SessionStore); err != nil {
+		setRetryAfter(w, retryAfter)
+		return code, err
+	}
+	return http.StatusUnauthorized, actions.ErrInvalidMFACode
+}
+
+// SigninMFA godoc
+//
+//	@Summary		Complete a sign-in with the second factor
+//	@Description	Second step of signin for users with an authenticator. Takes the MFA token returned by /signin and a TOTP code or a recovery code, and issues the JWT like /signin. The challenge ends after 5 wrong codes, and wrong codes count towards the lockout of the account like wrong passwords.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		MFASigninRequest	true	"request body"
+//	@Success		202		{string}	string				"JWT token, refresh token in X-Refresh-Token header"
+//	@Success		200		{object}	types.PasswordExpiredChallenge	"Password expired"
+//	@Failure		400		{object}	error				"Bad request"
+//	@Failure		401		{object}	error				"Invalid code or challenge"
+//	@Failure		429		{object}	error				"Account locked after too many wrong passwords or codes, see Retry-After"
+//	@Failure		500		{object}	error				"Internal server error"
+//	@Router			/signin/mfa [post]
+func SigninMFA(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req MFASigninRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	challenge, err := ctx.SessionStore.GetMFAChallenge(req.MFAToken)
+	if errors.Is(err, sessionstore.ErrMFAChallengeInvalid) {
+		return http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if code, err := checkSecondFactorLockout(w, ctx, challenge.UserID); err != nil {
+		return code, err
+	}
+
+	ok, err := actions.VerifySecondFactor(challenge.UserID, req.Code, req.RecoveryCode)
+	if err != nil && !errors.Is(err, actions.ErrTOTPNotEnrolled) {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return failSecondFactor(w, r, ctx, req.MFAToken, challenge.UserID)
+	}
+	// Consuming the challenge makes sure it creates a single session
+	if _, err := ctx.SessionStore.ConsumeMFAChallenge(req.MFAToken); err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	user, err := actions.LoadTokenUser(challenge.UserID)
+	if err != nil {
+		return http.StatusUnauthorized, nil
+	}
+	return completeSignin(w, r, ctx, user, "")
+}
+
+// BeginWebAuthnMFA godoc
+//
+//	@Summary		Begin a security key second factor
+//	@Description	Starts a WebAuthn assertion against the security keys of the user behind the MFA token returned by /signin
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		MFASigninRequest				true	"MFA token"
+//	@Success		200		{object}	protocol.CredentialAssertion	"WebAuthn assertion options"
+//	@Failure		400		{object}	error							"Bad request"
+//	@Failure		401		{object}	error							"Invalid challenge"
+//	@Router			/signin/mfa/webauthn/begin [post]
+func BeginWebAuthnMFA(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req MFASigninRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	challenge, err := ctx.SessionStore.GetMFAChallenge(req.MFAToken)
+	if errors.Is(err, sessionstore.ErrMFAChallengeInvalid) {
+		return http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	waUser, err := models.LoadWebAuthnUser(challenge.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	options, err := webAuthnService.BeginSecondFactor(waUser, req.MFAToken)
+	if err != nil {
+		loging.Logger.Error("BeginSecondFactor failed", err)
+		return http.StatusBadRequest, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(options); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// FinishWebAuthnMFA godoc
+//
+//	@Summary		Complete a sign-in with a security key
+//	@Description	Validates the assertion started at /signin/mfa/webauthn/begin and issues the JWT like /signin. Failed assertions count towards the attempts of the MFA challenge and the lockout of the account.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			mfaToken	query		string	true	"MFA token returned by /signin"
+//	@Success		202			{string}	string	"JWT token, refresh token in X-Refresh-Token header"
+//	@Success		200			{object}	types.PasswordExpiredChallenge	"Password expired"
+//	@Failure		400			{object}	error	"Bad request"
+//	@Failure		401			{object}	error	"Unauthorized"
+//	@Failure		429			{object}	error	"Account locked after too many wrong passwords or codes, see Retry-After"
+//	@Router			/signin/mfa/webauthn/finish [post]
+func FinishWebAuthnMFA(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	mfaToken := r.URL.Query().Get("mfaToken")
+	challenge, err := ctx.SessionStore.GetMFAChallenge(mfaToken)
+	if errors.Is(err, sessionstore.ErrMFAChallengeInvalid) {
+		return http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if code, err := checkSecondFactorLockout(w, ctx, challenge.UserID); err != nil {
+		return code, err
+	}
+	parsedResponse, err := protocol.ParseCredentialRequestResponseBody(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	waUser, err := models.LoadWebAuthnUser(challenge.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err := webAuthnService.FinishSecondFactor(waUser, mfaToken, parsedResponse); err != nil {
+		loging.Logger.Error("FinishSecondFactor failed", err)
+		return failSecondFactor(w, r, ctx, mfaToken, challenge.UserID)
+	}
+	if _, err := ctx.SessionStore.ConsumeMFAChallenge(mfaToken); err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	user, err := actions.LoadTokenUser(challenge.UserID)
+	if err != nil {
+		return http.StatusUnauthorized, nil
+	}
+	return completeSignin(w, r, ctx, user, "")
+}
+
+// webAuthnConfirmationToken binds the assertion confirming a change of the security
+// key second factor to the session of the user
+func webAuthnConfirmationToken(ctx *request_context.Context) string {
+	return "confirm:" + ctx.Auth.ID
+}
+
+// BeginWebAuthnConfirmation godoc
+//
+//	@Summary		Begin confirming with a security key
+//	@Description	Starts a WebAuthn assertion against the security keys of the current user, whose result turns off the security key second factor at /me/mfa/webauthn
+//	@Tags			me
+//	@Produce		json
+//	@Param			X-Auth	header		string							true	"Authorization"
+//	@Success		200		{object}	protocol.CredentialAssertion	"WebAuthn assertion options"
+//	@Failure		400		{object}	error							"No security key registered"
+//	@Failure		401		{object}	error							"Unauthorized"
+//	@Failure		403		{object}	error							"Forbidden"
+//	@Router			/me/mfa/webauthn/begin [post]
+func BeginWebAuthnConfirmation(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	user, err := ctx.GetCurrentUserModel()
+	if err != nil || user == nil {
+		return http.StatusUnauthorized, err
+	}
+	waUser, err := models.LoadWebAuthnUserFromModel(user)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	options, err := webAuthnService.BeginSecondFactor(waUser, webAuthnConfirmationToken(ctx))
+	if err != nil {
+		loging.Logger.Error("BeginSecondFactor failed", err)
+		return http.StatusBadRequest, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(options); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// confirmSecondFactor checks the assertion, TOTP code or recovery code of a request
+// turning off the security key second factor
+func confirmSecondFactor(ctx *request_context.Context, user *models.User, req *WebAuthnMFARequest) (int, error) {
+	if len(req.Assertion) > 0 {
+		parsedResponse, err := protocol.ParseCredentialRequestResponseBytes(req.Assertion)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		waUser, err := models.LoadWebAuthnUserFromModel(user)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if err := webAuthnService.FinishSecondFactor(waUser, webAuthnConfirmationToken(ctx), parsedResponse); err != nil {
+			loging.Logger.Error("FinishSecondFactor failed", err)
+			return http.StatusBadRequest, actions.ErrInvalidMFACode
+		}
+		return 0, nil
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		return http.StatusBadRequest, actions.ErrMFAConfirmationRequired
+	}
+	ok, err := actions.VerifySecondFactor(user.ID, req.Code, req.RecoveryCode)
+	if errors.Is(err, actions.ErrTOTPNotEnrolled) {
+		return http.StatusBadRequest, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, actions.ErrInvalidMFACode
+	}
+	return 0, nil
+}
+
+// SetWebAuthnMFA godoc
+//
+//	@Summary		Require a security key after the password
+//	@Description	Turns the WebAuthn second factor on or off for the current user. Turning it on requires a registered credential; signin then asks for an assertion from one of them after the password. Turning it off requires an assertion started at /me/mfa/webauthn/begin, or a TOTP code or recovery code, so a stolen session cannot turn it off.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Auth	header		string				true	"Authorization"
+//	@Param			request	body		WebAuthnMFARequest	true	"Setting"
+//	@Success		200		{object}	types.SimpleResponse
+//	@Failure		400		{object}	error	"No security key registered, or no valid confirmation"
+//	@Failure		401		{object}	error	"Unauthorized"
+//	@Failure		403		{object}	error	"Forbidden"
+//	@Router			/me/mfa/webauthn [post]
+func SetWebAuthnMFA(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req WebAuthnMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	user, err := ctx.GetCurrentUserModel()
+	if err != nil || user == nil {
+		return http.StatusUnauthorized, err
+	}
+	if !req.Enabled && user.WebAuthnMFA {
+		if code, err := confirmSecondFactor(ctx, user, &req); err != nil {
+			return code, err
+		}
+	}
+	if code, err := actions.SetWebAuthnMFA(user, req.Enabled); err != nil {
+		return code, err
+	}
+	ctx.PermCache.ForgetUserMFAStatus(ctx.Context, user.ID)
+
+	message := "Security key second factor disabled"
+	if req.Enabled {
+		message = "Security key second factor enabled"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.SimpleResponse{Message: message}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// GetMFAStatus godoc
+//
+//	@Summary		Multi-factor authentication status
+//	@Description	Whether the current user has an authenticator or security key as second factor, and how many recovery codes are left
+//	@Tags			me
+//	@Produce		json
+//	@Param			X-Auth	header		string	true	"Authorization"
+//	@Success		200		{object}	types.MFAStatus
+//	@Failure		401		{object}	error	"Unauthorized"
+//	@Router			/me/mfa [get]
+func GetMFAStatus(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	user, err := ctx.GetCurrentUserModel()
+	if err != nil || user == nil {
+		return http.StatusUnauthorized, err
+	}
+	methods, err := actions.MFAMethods(user)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	remaining, err := models.CountUnusedRecoveryCodes(user.ID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.MFAStatus{
+		TOTPEnabled:            slices.Contains(methods, actions.MFAMethodTOTP),
+		RecoveryCodesRemaining: remaining,
+		WebAuthnEnabled:        slices.Contains(methods, actions.MFAMethodWebAuthn),
+	}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// EnrollTOTP godoc
+//
+//	@Summary		Start authenticator enrollment
+//	@Description	Creates a TOTP secret for the current user. Show the otpauth URI as a QR code and confirm with a code from the app at /me/mfa/totp/verify; until then sign-in is unchanged.
+//	@Tags			me
+//	@Produce		json
+//	@Param			X-Auth	header		string	true	"Authorization"
+//	@Success		200		{object}	types.TOTPEnrollment
+//	@Failure		401		{object}	error	"Unauthorized"
+//	@Failure		403		{object}	error	"Forbidden"
+//	@Failure		409		{object}	error	"Already enabled"
+//	@Router			/me/mfa/totp [post]
+func EnrollTOTP(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	user, err := ctx.GetCurrentUserModel()
+	if err != nil || user == nil {
+		return http.StatusUnauthorized, err
+	}
+	issuer := ctx.Settings.WebAuthnRPName
+	if issuer == "" {
+		issuer = "BigBucks Auth"
+	}
+
+	enrollment, code, err := actions.EnrollTOTP(user, issuer)
+	if err != nil {
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(types.TOTPEnrollment{Secret: enrollment.Secret, OtpauthURI: enrollment.URI}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// VerifyTOTP godoc
+//
+//	@Summary		Confirm authenticator enrollment
+//	@Description	Enables the authenticator with a code from it and returns recovery codes, which are shown only once. From then on signin asks for a second factor.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Auth	header		string			true	"Authorization"
+//	@Param			request	body		MFACodeRequest	true	"TOTP code"
+//	@Success		200		{object}	types.RecoveryCodes
+//	@Failure		400		{object}	error	"Invalid code"
+//	@Failure		401		{object}	error	"Unauthorized"
+//	@Failure		403		{object}	error	"Forbidden"
+//	@Router			/me/mfa/totp/verify [post]
+func VerifyTOTP(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	codes, code, err := actions.ConfirmTOTP(ctx.Auth.Subject, req.Code)
+	if err != nil {
+		return code, err
+	}
+	ctx.PermCache.ForgetUserMFAStatus(ctx.Context, ctx.Auth.Subject)
+	return writeRecoveryCodes(w, codes)
+}
+
+// DisableTOTP godoc
+//
+//	@Summary		Disable the authenticator
+//	@Description	Removes the authenticator and recovery codes of the current user. Requires a current TOTP code or a recovery code.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Auth	header		string			true	"Authorization"
+//	@Param			request	body		MFACodeRequest	true	"TOTP code or recovery code"
+//	@Success		200		{object}	types.SimpleResponse
+//	@Failure		400		{object}	error	"Invalid code"
+//	@Failure		401		{object}	error	"Unauthorized"
+//	@Failure		403		{object}	error	"Forbidden"
+//	@Router			/me/mfa/totp/disable [post]
+func DisableTOTP(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if code, err := actions.DisableTOTP(ctx.Auth.Subject, req.Code, req.RecoveryCode); err != nil {
+		return code, err
+	}
+	ctx.PermCache.ForgetUserMFAStatus(ctx.Context, ctx.Auth.Subject)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.SimpleResponse{Message: "Authenticator disabled"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// RegenerateRecoveryCodes godoc
+//
+//	@Summary		Regenerate recovery codes
+//	@Description	Replaces the recovery codes of the current user, invalidating the old ones. Requires a current TOTP code.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Auth	header		string			true	"Authorization"
+//	@Param			request	body		MFACodeRequest	true	"TOTP code"
+//	@Success		200		{object}	types.RecoveryCodes
+//	@Failure		400		{object}	error	"Invalid code"
+//	@Failure		401		{object}	error	"Unauthorized"
+//	@Failure		403		{object}	error	"Forbidden"
+//	@Router			/me/mfa/recovery-codes [post]
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	codes, code, err := actions.RegenerateRecoveryCodes(ctx.Auth.Subject, req.Code)
+	if err != nil {
+		return code, err
+	}
+	return writeRecoveryCodes(w, codes)
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) (int, error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(types.RecoveryCodes{RecoveryCodes: codes}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,343 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/constants"
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OAuthError is the RFC 6749 error response used by the /oauth endpoints
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// authenticateClient reads client credentials from HTTP Basic auth or the form
+// body and verifies them. On failure the response status and error are returned.
+func authenticateClient(w http.ResponseWriter, r *http.Request) (*models.APIClient, int, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	client, err := actions.AuthenticateAPIClient(clientID, secret)
+	if err != nil {
+		if !errors.Is(err, actions.ErrInvalidClient) {
+			return nil, http.StatusInternalServerError, err
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return nil, http.StatusUnauthorized, &OAuthError{Code: "invalid_client"}
+	}
+	return client, 0, nil
+}
+
+// Introspect godoc
+//
+//	@Summary		Token introspection
+//	@Description	RFC 7662 introspection for resource servers that cannot verify tokens themselves. Requires client credentials with the introspect scope.
+//	@Tags			oauth
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			token			formData	string	true	"Token to introspect"
+//	@Param			token_type_hint	formData	string	false	"Token type hint"
+//	@Success		200				{object}	types.IntrospectionResponse
+//	@Failure		400				{object}	OAuthError
+//	@Failure		401				{object}	OAuthError
+//	@Failure		403				{object}	OAuthError
+//	@Router			/oauth/introspect [post]
+func Introspect(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	client, status, err := authenticateClient(w, r)
+	if err != nil {
+		return status, err
+	}
+	if !client.HasScope(models.ClientScopeIntrospect) {
+		return http.StatusForbidden, &OAuthError{Code: "unauthorized_client", Description: "client may not introspect tokens"}
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "token is required"}
+	}
+
+	response := types.IntrospectionResponse{Active: false}
+	var claims settings.AuthToken
+	active := false
+	if jwtops.IsPersonalAccessToken(token) {
+		// Personal access tokens are checked against the database, there is no session
+		claims, err = jwtops.VerifyPersonalAccessToken(token)
+		if err != nil && !errors.Is(err, jwtops.ErrInvalidPersonalAccessToken) {
+			loging.Logger.Error("Error verifying personal access token", zap.Error(err))
+		}
+		active = err == nil
+	} else if claims, _, err = jwtops.VerifyJWT(token); err == nil {
+		// A valid signature is not enough, the session must not have been revoked
+		valid, _, err := ctx.SessionStore.ValidateSession(claims.ID)
+		if err != nil && !errors.Is(err, sessionstore.ErrSessionNotFound) {
+			loging.Logger.Error("Error validating session", zap.Error(err))
+		}
+		active = valid
+	}
+	if active {
+		response = types.IntrospectionResponse{
+			Active:      true,
+			TokenType:   "Bearer",
+			Sub:         claims.Subject,
+			Username:    claims.User.Username,
+			Sid:         claims.ID,
+			Iss:         claims.Issuer,
+			Roles:       claims.User.Roles,
+			Permissions: claims.User.Permissions,
+			ClientID:    claims.ClientID,
+			Scope:       claims.Scope,
+			Aud:         claims.Audience,
+		}
+		if claims.ExpiresAt != nil {
+			response.Exp = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			response.Iat = claims.IssuedAt.Unix()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// Token godoc
+//
+//	@Summary		OAuth2 token endpoint
+//	@Description	Issues access tokens. Supports the client_credentials grant for service accounts, authenticated with client_secret_basic, client_secret_post or private_key_jwt, and the authorization_code (with PKCE), refresh_token and device_code grants for OpenID Connect relying parties.
+//	@Tags			oauth
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			grant_type				formData	string	true	"Grant type"
+//	@Param			client_assertion_type	formData	string	false	"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+//	@Param			client_assertion		formData	string	false	"Client assertion JWT"
+//	@Param			code					formData	string	false	"Authorization code"
+//	@Param			redirect_uri			formData	string	false	"Redirect URI the code was sent to"
+//	@Param			code_verifier			formData	string	false	"PKCE code verifier"
+//	@Param			refresh_token			formData	string	false	"Refresh token"
+//	@Param			device_code				formData	string	false	"Device code"
+//	@Success		200						{object}	types.TokenResponse
+//	@Failure		400						{object}	OAuthError
+//	@Failure		401						{object}	OAuthError
+//	@Router			/oauth/token [post]
+func Token(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	if err := r.ParseForm(); err != nil {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "malformed form body"}
+	}
+	switch r.PostFormValue("grant_type") {
+	case "client_credentials":
+		return clientCredentialsGrant(w, r, ctx)
+	case "authorization_code":
+		return authorizationCodeGrant(w, r, ctx)
+	case "refresh_token":
+		return refreshTokenGrant(w, r, ctx)
+	case DeviceCodeGrantType:
+		return deviceCodeGrant(w, r, ctx)
+	case "":
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "grant_type is required"}
+	default:
+		return http.StatusBadRequest, &OAuthError{Code: "unsupported_grant_type"}
+	}
+}
+
+// clientCredentialsGrant issues a token for the service account behind the client
+func clientCredentialsGrant(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var clientID, secret, assertion string
+	if assertionType := r.PostFormValue("client_assertion_type"); assertionType != "" {
+		if assertionType != jwtops.ClientAssertionType {
+			return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "unsupported client_assertion_type"}
+		}
+		assertion = r.PostFormValue("client_assertion")
+	} else {
+		var ok bool
+		clientID, secret, ok = r.BasicAuth()
+		if !ok {
+			clientID = r.PostFormValue("client_id")
+			secret = r.PostFormValue("client_secret")
+		}
+	}
+
+	// Assertions must be addressed to the configured issuer, never to the Host the
+	// client sent
+	issuer := issuerURL(ctx.Settings)
+	user, err := actions.AuthenticateServiceAccount(clientID, secret, assertion, []string{issuer + "/oauth/token", issuer}, ctx.SessionStore)
+	if errors.Is(err, actions.ErrInvalidClient) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return http.StatusUnauthorized, &OAuthError{Code: "invalid_client"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	// Service account tokens are backed by a session like any other token, so they can
+	// be listed and revoked. The session lives as long as the token, there is no refresh.
+	sessionID, err := ctx.SessionStore.CreateSessionWithLimit(user.ID, user.Username, r.UserAgent(), ClientHost(r), jwtops.TokenLifetime, sessionstore.MaxServiceSessions)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	signed, err := jwtops.SignJWT(user, sessionID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return writeTokenResponse(w, types.TokenResponse{
+		AccessToken: signed,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(jwtops.TokenLifetime.Seconds()),
+	})
+}
+
+// authenticateRelyingParty identifies the relying party calling the token endpoint
+// from HTTP Basic auth or the form body
+func authenticateRelyingParty(w http.ResponseWriter, r *http.Request) (*models.RelyingParty, int, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	rp, err := actions.AuthenticateRelyingParty(clientID, secret)
+	if errors.Is(err, actions.ErrInvalidClient) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return nil, http.StatusUnauthorized, &OAuthError{Code: "invalid_client"}
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return rp, 0, nil
+}
+
+// authorizationCodeGrant redeems an authorization code for tokens of a new session
+func authorizationCodeGrant(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	rp, status, err := authenticateRelyingParty(w, r)
+	if err != nil {
+		return status, err
+	}
+	code := r.PostFormValue("code")
+	verifier := r.PostFormValue("code_verifier")
+	if code == "" || verifier == "" {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "code and code_verifier are required"}
+	}
+
+	grant, user, err := actions.RedeemAuthorizationCode(rp, code, r.PostFormValue("redirect_uri"), verifier, ctx.SessionStore)
+	if errors.Is(err, actions.ErrInvalidGrant) {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_grant"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	sessionID, err := ctx.SessionStore.CreateClientSession(user.ID, user.Username, r.UserAgent(), ClientHost(r), rp.ClientID, grant.Scope, constants.SESSION_EXPIRY)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	refreshToken, err := ctx.SessionStore.IssueRefreshToken(sessionID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return writeRelyingPartyTokens(w, r, ctx, user, rp, sessionID, refreshToken, grant.Scope, grant.Nonce, grant.AuthTime)
+}
+
+// refreshTokenGrant rotates a relying party refresh token
+func refreshTokenGrant(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	rp, status, err := authenticateRelyingParty(w, r)
+	if err != nil {
+		return status, err
+	}
+	presented := r.PostFormValue("refresh_token")
+	if presented == "" {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "refresh_token is required"}
+	}
+
+	sessionID, session, refreshToken, err := ctx.SessionStore.RotateRefreshToken(presented)
+	if errors.Is(err, sessionstore.ErrRefreshTokenReused) || errors.Is(err, sessionstore.ErrRefreshTokenInvalid) {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_grant"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if session.ClientID != rp.ClientID {
+		// The token belongs to another client, treat it as stolen
+		if err := ctx.SessionStore.RevokeSession(sessionID); err != nil {
+			loging.Logger.Error("Error revoking session", zap.Error(err))
+		}
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_grant"}
+	}
+
+	user, err := actions.LoadTokenUser(session.UserID)
+	if errors.Is(err, actions.ErrInvalidGrant) {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_grant"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return writeRelyingPartyTokens(w, r, ctx, user, rp, sessionID, refreshToken, session.Scope, "", session.CreatedAt)
+}
+
+// writeRelyingPartyTokens signs the access token, and the ID token when openid was
+// granted, for a relying party session
+func writeRelyingPartyTokens(w http.ResponseWriter, r *http.Request, ctx *request_context.Context, user *models.User, rp *models.RelyingParty, sessionID, refreshToken, scope, nonce string, authTime time.Time) (int, error) {
+	accessToken, err := jwtops.SignRelyingPartyJWT(user, sessionID, rp.ClientID, scope, issuerURL(ctx.Settings))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	response := types.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(jwtops.TokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}
+	scopes := strings.Fields(scope)
+	if slices.Contains(scopes, "openid") {
+		response.IDToken, err = jwtops.SignIDToken(user, jwtops.IDTokenParams{
+			Issuer:    issuerURL(ctx.Settings),
+			ClientID:  rp.ClientID,
+			Nonce:     nonce,
+			SessionID: sessionID,
+			AuthTime:  authTime,
+			Scopes:    scopes,
+		})
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	return writeTokenResponse(w, response)
+}
+
+func writeTokenResponse(w http.ResponseWriter, response types.TokenResponse) (int, error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
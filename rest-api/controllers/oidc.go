@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// issuerURL is the OpenID Connect issuer, used in discovery and ID tokens alike. It
+// is never taken from the request, whose Host the client chooses.
+func issuerURL(s *settings.Settings) string {
+	return strings.TrimSuffix(s.Issuer, "/")
+}
+
+// loginURL is the sign-in page users without a session are sent to
+func loginURL(s *settings.Settings, returnTo string) string {
+	login := s.OIDCLoginURL
+	if login == "" {
+		login = strings.TrimSuffix(s.BaseHost, "/") + "/signin"
+	}
+	separator := "?"
+	if strings.Contains(login, "?") {
+		separator = "&"
+	}
+	return login + separator + url.Values{"return_to": {returnTo}}.Encode()
+}
+
+// withQuery adds parameters to the query of a registered redirect URI, keeping the
+// ones it already has
+func withQuery(uri string, params url.Values) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// OAuthAuthorize godoc
+//
+//	@Summary		OpenID Connect authorization endpoint
+//	@Description	Starts the authorization code flow with PKCE (S256). The user is identified by the auth cookie or the X-Auth header; users without a session are redirected to the sign-in page with the request URL in return_to. With GET the browser is redirected back to the client, with POST the redirect URL is returned as JSON for sign-in pages that hold the token themselves. Clients that are not trusted need the consent of the user to the requested scopes: GET requests are sent to the sign-in page, POST requests answer a ConsentRequest, and the page records the answer by POSTing again with consent=approve or deny and the token in the X-Auth or Authorization header.
+//	@Tags			oauth
+//	@Produce		json
+//	@Param			response_type			query	string	true	"code"
+//	@Param			client_id				query	string	true	"Client ID"
+//	@Param			redirect_uri			query	string	false	"Registered redirect URI"
+//	@Param			scope					query	string	true	"Space separated scopes, e.g. openid profile email"
+//	@Param			state					query	string	false	"Opaque client state"
+//	@Param			nonce					query	string	false	"ID token nonce"
+//	@Param			code_challenge			query	string	true	"PKCE challenge"
+//	@Param			code_challenge_method	query	string	true	"S256"
+//	@Param			prompt					query	string	false	"none to fail instead of asking the user to sign in or consent"
+//	@Param			consent					formData	string	false	"approve or deny, from the sign-in page"
+//	@Success		200						{object}	types.AuthorizeRedirect
+//	@Success		200						{object}	types.ConsentRequest	"Consent required"
+//	@Success		302						""
+//	@Failure		400						{object}	OAuthError
+//	@Failure		401						{object}	OAuthError
+//	@Router			/oauth/authorize [get]
+//	@Router			/oauth/authorize [post]
+func OAuthAuthorize(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	if err := r.ParseForm(); err != nil {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "malformed request"}
+	}
+	form := r.Form
+
+	// Errors about the client or redirect URI must not be sent to the redirect URI
+	rp, err := models.GetRelyingParty(form.Get("client_id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_client", Description: "unknown client_id"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	redirectURI := form.Get("redirect_uri")
+	if redirectURI == "" {
+		if registered := strings.Fields(rp.RedirectURIs); len(registered) == 1 {
+			redirectURI = registered[0]
+		}
+	}
+	if !rp.AllowsRedirectURI(redirectURI) {
+		return http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: "redirect_uri is not registered for the client"}
+	}
+
+	issuer := issuerURL(ctx.Settings)
+	respond := func(target string) (int, error) {
+		if r.Method == http.MethodGet {
+			http.Redirect(w, r, target, http.StatusFound)
+			return 0, nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(types.AuthorizeRedirect{RedirectTo: target}); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return 0, nil
+	}
+	redirectError := func(code, description string) (int, error) {
+		return respond(withQuery(redirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {form.Get("state")},
+			"iss":               {issuer},
+		}))
+	}
+
+	if form.Get("response_type") != "code" {
+		return redirectError("unsupported_response_type", "only the code response type is supported")
+	}
+	scopes := strings.Fields(form.Get("scope"))
+	if len(scopes) == 0 {
+		return redirectError("invalid_scope", "scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(rp.AllowedScopes(), scope) {
+			return redirectError("invalid_scope", "scope "+scope+" is not allowed for the client")
+		}
+	}
+	if form.Get("code_challenge") == "" || form.Get("code_challenge_method") != actions.CodeChallengeMethodS256 {
+		return redirectError("invalid_request", "a PKCE code_challenge with the S256 method is required")
+	}
+
+	// Identify the user from their existing session
+	var session *sessionstore.SessionData
+	claims, err := jwtops.VerifyRequest(r)
+	if err == nil && !claims.PersonalAccessToken && !claims.RelyingParty() {
+		session, err = ctx.SessionStore.GetSession(claims.ID)
+		if err != nil && !errors.Is(err, sessionstore.ErrSessionNotFound) {
+			return http.StatusInternalServerError, err
+		}
+	}
+	// The token may be in the query, it must not travel on to the sign-in page
+	query := r.URL.Query()
+	query.Del("auth")
+	returnTo := issuer + r.URL.EscapedPath() + "?" + query.Encode()
+	if session == nil {
+		if form.Get("prompt") == "none" {
+			return redirectError("login_required", "the user is not signed in")
+		}
+		if r.Method != http.MethodGet {
+			return http.StatusUnauthorized, &OAuthError{Code: "login_required", Description: "the user is not signed in"}
+		}
+		http.Redirect(w, r, loginURL(ctx.Settings, returnTo), http.StatusFound)
+		return 0, nil
+	}
+
+	if !rp.Trusted {
+		granted, err := models.HasConsent(session.UserID, rp.ClientID, scopes)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		// Answers only count from the sign-in page, which sends the token in a header
+		// that other sites cannot make the browser send
+		answer := ""
+		if r.Method == http.MethodPost && (r.Header.Get("X-Auth") != "" || r.Header.Get("Authorization") != "") {
+			answer = r.PostForm.Get("consent")
+		}
+		switch {
+		case granted:
+		case answer == "deny":
+			return redirectError("access_denied", "the user denied the request")
+		case answer == "approve":
+			if err := models.GrantConsent(session.UserID, rp.ClientID, scopes); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		case form.Get("prompt") == "none":
+			return redirectError("consent_required", "the user has not consented to the client")
+		case r.Method == http.MethodGet:
+			http.Redirect(w, r, loginURL(ctx.Settings, returnTo), http.StatusFound)
+			return 0, nil
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			if err := json.NewEncoder(w).Encode(types.ConsentRequest{
+				ConsentRequired: true,
+				ClientID:        rp.ClientID,
+				ClientName:      rp.Name,
+				Scopes:          scopes,
+			}); err != nil {
+				return http.StatusInternalServerError, err
+			}
+			return 0, nil
+		}
+	}
+
+	code, err := ctx.SessionStore.StoreAuthorizationCode(&sessionstore.AuthorizationCode{
+		ClientID:            rp.ClientID,
+		UserID:              session.UserID,
+		RedirectURI:         redirectURI,
+		Scope:               strings.Join(scopes, " "),
+		Nonce:               form.Get("nonce"),
+		CodeChallenge:       form.Get("code_challenge"),
+		CodeChallengeMethod: actions.CodeChallengeMethodS256,
+		AuthTime:            session.CreatedAt,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return respond(withQuery(redirectURI, url.Values{
+		"code":  {code},
+		"state": {form.Get("state")},
+		"iss":   {issuer},
+	}))
+}
+
+// OIDCUserInfo godoc
+//
+//	@Summary		OpenID Connect userinfo endpoint
+//	@Description	Claims about the user the access token was issued for, limited to the scopes granted to the relying party
+//	@Tags			oauth
+//	@Produce		json
+//	@Param			Authorization	header	string	true	"Bearer access token"
+//	@Success		200				{object}	map[string]interface{}
+//	@Failure		401				""
+//	@Failure		403				{object}	OAuthError
+//	@Router			/userinfo [get]
+//	@Router			/userinfo [post]
+func OIDCUserInfo(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	// First party tokens carry no scope and see every claim
+	scopes := actions.OIDCScopes
+	if ctx.Auth.RelyingParty() {
+		scopes = strings.Fields(ctx.Auth.Scope)
+		if !slices.Contains(scopes, "openid") {
+			return http.StatusForbidden, &OAuthError{Code: "insufficient_scope", Description: "the openid scope is required"}
+		}
+	}
+
+	user, err := actions.LoadTokenUser(ctx.Auth.Subject)
+	if errors.Is(err, actions.ErrInvalidGrant) {
+		return http.StatusUnauthorized, &OAuthError{Code: "invalid_token"}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(jwtops.UserClaims(user, scopes, issuerURL(ctx.Settings))); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// OpenIDConfiguration godoc
+//
+//	@Summary		OpenID Connect discovery
+//	@Description	Provider metadata relying parties configure themselves from
+//	@Tags			oauth
+//	@Produce		json
+//	@Success		200	{object}	types.OpenIDConfiguration
+//	@Failure		500	""
+//	@Router			/.well-known/openid-configuration [get]
+func OpenIDConfiguration(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	jwks, err := jwtops.PublicKeys()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	algs := []string{}
+	for _, key := range jwks.Keys {
+		if !slices.Contains(algs, key.Alg) {
+			algs = append(algs, key.Alg)
+		}
+	}
+
+	issuer := issuerURL(ctx.Settings)
+	config := types.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		ScopesSupported:                   actions.OIDCScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", DeviceCodeGrantType},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"},
+		CodeChallengeMethodsSupported:     []string{actions.CodeChallengeMethodS256},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid",
+			"name", "given_name", "family_name", "preferred_username", "picture", "zoneinfo", "updated_at",
+			"email", "email_verified", "phone_number", "phone_number_verified",
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
@@ -5,6 +5,7 @@ import (
 	"bigbucks/solution/auth/loging"
 	"bigbucks/solution/auth/models"
 	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/settings"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -84,9 +85,17 @@ func GetOrg(w http.ResponseWriter, r *http.Request, ctx *request_context.Context
 //	@Success		200		{object}	models.Organization	"Created organization details"
 //	@Failure		400		{object}	error					"Bad request"
 //	@Failure		401		{object}	error					"Unauthorized"
+//	@Failure		403		{object}	error					"Email address not verified"
 //	@Failure		500		{object}	error					"Internal server error"
 //	@Router			/organizations [post]
 func CreateOrg(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	user, err := ctx.GetCurrentUserModel()
+	if err != nil || user == nil {
+		return http.StatusUnauthorized, err
+	}
+	if code, err := actions.CheckVerifiedEmail(user, ctx.Settings.RequireVerifiedEmail, settings.RequireVerifiedEmailOrganization); err != nil {
+		return code, err
+	}
 	org, code, err := actions.OrganizationFromRequest(r)
 	if err != nil {
 		return code, err
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"bigbucks/solution/auth/validations"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CreatePersonalAccessTokenRequest represents the request body for creating a personal access token
+type CreatePersonalAccessTokenRequest struct {
+	Name string `json:"name" validate:"required"`
+	// Optional resource:scope:action strings the token is restricted to
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+func personalAccessTokenResponse(token *models.PersonalAccessToken) types.PersonalAccessToken {
+	return types.PersonalAccessToken{
+		ID:          token.ID,
+		Name:        token.Name,
+		Hint:        token.Hint,
+		Permissions: token.PermissionList(),
+		CreatedAt:   token.CreatedAt,
+		ExpiresAt:   token.ExpiresAt,
+		LastUsedAt:  token.LastUsedAt,
+	}
+}
+
+// @Summary		Create personal access token
+// @Description	Create a long lived token for CLI tooling and CI pipelines, optionally restricted to some permissions. The token is only returned once.
+// @Tags			me
+// @Accept			json
+// @Produce		json
+// @Param			X-Auth	header	string	true	"Authorization"
+// @Param			request	body	CreatePersonalAccessTokenRequest	true	"Token details"
+// @Success		201		{object}	types.PersonalAccessToken
+// @Failure		400		{object}	error	"Bad request"
+// @Failure		401		{object}	error	"Unauthorized"
+// @Failure		403		{object}	error	"Forbidden"
+// @Router			/me/tokens [post]
+func CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req CreatePersonalAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := validations.Validate.Struct(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	token, value, code, err := actions.CreatePersonalAccessToken(actions.CreatePersonalAccessTokenParams{
+		UserID:      ctx.Auth.Subject,
+		Name:        req.Name,
+		Permissions: req.Permissions,
+		ExpiresAt:   req.ExpiresAt,
+	})
+	if err != nil {
+		return code, err
+	}
+
+	response := personalAccessTokenResponse(token)
+	response.Token = value
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// @Summary		List personal access tokens
+// @Description	List the current user's personal access tokens
+// @Tags			me
+// @Produce		json
+// @Param			X-Auth	header	string	true	"Authorization"
+// @Success		200		{array}	types.PersonalAccessToken
+// @Failure		401		{object}	error	"Unauthorized"
+// @Router			/me/tokens [get]
+func ListPersonalAccessTokens(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	tokens, code, err := actions.ListPersonalAccessTokens(ctx.Auth.Subject)
+	if err != nil {
+		return code, err
+	}
+	response := make([]types.PersonalAccessToken, 0, len(tokens))
+	for i := range tokens {
+		response = append(response, personalAccessTokenResponse(&tokens[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// @Summary		Revoke personal access token
+// @Description	Revoke one of the current user's personal access tokens
+// @Tags			me
+// @Produce		json
+// @Param			X-Auth		header	string	true	"Authorization"
+// @Param			token_id	path	string	true	"Token ID"
+// @Success		200			{object}	types.SimpleResponse
+// @Failure		403			{object}	error	"Forbidden"
+// @Failure		404			{object}	error	"Not found"
+// @Router			/me/tokens/{token_id} [delete]
+func RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	code, err := actions.RevokePersonalAccessToken(ctx.Auth.Subject, mux.Vars(r)["token_id"])
+	if err != nil {
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.SimpleResponse{Message: "Token revoked successfully"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
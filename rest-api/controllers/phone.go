@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// PhoneNumberRequest asks for a code to verify a new phone number
+type PhoneNumberRequest struct {
+	PhoneNumber string `json:"phoneNumber"`
+}
+
+// PhoneCodeRequest carries the code sent by SMS
+type PhoneCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// ChangePhoneNumber godoc
+//
+//	@Summary		Change phone number
+//	@Description	Sends a 6 digit code by SMS to the number. The number replaces the current one once the code is confirmed with /me/phone/verify. Codes expire after 10 minutes and are sent at most once a minute, 5 times an hour and 10 times a day per user.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Auth	header		string					true	"Authorization"
+//	@Param			request	body		PhoneNumberRequest		true	"Phone number"
+//	@Success		200		{object}	types.SimpleResponse	"Code sent"
+//	@Failure		400		{object}	error					"Invalid phone number"
+//	@Failure		401		{object}	error					"Unauthorized"
+//	@Failure		403		{object}	error					"Forbidden"
+//	@Failure		429		{object}	error					"Sent too recently or too often"
+//	@Failure		500		{object}	error					"Internal server error"
+//	@Router			/me/phone [post]
+func ChangePhoneNumber(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req PhoneNumberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	user, err := ctx.GetCurrentUserModel()
+	if err != nil || user == nil {
+		return http.StatusUnauthorized, err
+	}
+	if code, err := actions.StartMobileVerification(user, req.PhoneNumber); err != nil {
+		if errors.Is(err, actions.ErrMobileCodeThrottled) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(actions.MobileVerificationResendInterval.Seconds())))
+		}
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.SimpleResponse{Message: "Verification code sent"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// VerifyPhoneNumber godoc
+//
+//	@Summary		Verify phone number
+//	@Description	Confirms the code sent by /me/phone, which makes the number the verified phone number of the current user. A code is used up after 5 wrong attempts.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Auth	header		string					true	"Authorization"
+//	@Param			request	body		PhoneCodeRequest		true	"Code"
+//	@Success		200		{object}	types.SimpleResponse	"Phone number verified"
+//	@Failure		400		{object}	error					"Invalid or expired code"
+//	@Failure		401		{object}	error					"Unauthorized"
+//	@Failure		403		{object}	error					"Forbidden"
+//	@Failure		500		{object}	error					"Internal server error"
+//	@Router			/me/phone/verify [post]
+func VerifyPhoneNumber(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req PhoneCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	user, err := ctx.GetCurrentUserModel()
+	if err != nil || user == nil {
+		return http.StatusUnauthorized, err
+	}
+	if code, err := actions.ConfirmMobileVerification(user, req.Code); err != nil {
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.SimpleResponse{Message: "Phone number verified"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"encoding/json"
+	"net/http"
+)
+
+// SecurityPolicyRequest sets the security policy of the organization
+type SecurityPolicyRequest struct {
+	MFARequired bool `json:"mfaRequired"`
+	// MFARoles limits the requirement to these roles, empty requires MFA from every member
+	MFARoles []string `json:"mfaRoles"`
+	// AllowedFactors are totp and webauthn, empty allows either
+	AllowedFactors  []string `json:"allowedFactors"`
+	GracePeriodDays int      `json:"gracePeriodDays"`
+	// PasswordPolicy adds rules for the passwords of members, a minLength of 0 keeps
+	// the default. historyCount and maxAgeDays of 0 turn reuse checks and expiry off.
+	PasswordPolicy models.PasswordPolicy `json:"passwordPolicy"`
+}
+
+func securityPolicyResponse(policy *models.OrgSecurityPolicy) types.OrgSecurityPolicy {
+	passwordPolicy := policy.PasswordPolicy()
+	response := types.OrgSecurityPolicy{
+		OrgID:           policy.OrgID,
+		MFARequired:     policy.MFARequired,
+		MFARoles:        policy.Roles(),
+		AllowedFactors:  policy.AllowedFactors(),
+		GracePeriodDays: policy.GracePeriodDays,
+		PasswordPolicy: types.PasswordPolicy{
+			MinLength:        passwordPolicy.MinLength,
+			MaxLength:        models.MaxPasswordLength,
+			RequireUppercase: passwordPolicy.RequireUppercase,
+			RequireLowercase: passwordPolicy.RequireLowercase,
+			RequireDigit:     passwordPolicy.RequireDigit,
+			RequireSymbol:    passwordPolicy.RequireSymbol,
+			HistoryCount:     passwordPolicy.HistoryCount,
+			MaxAgeDays:       passwordPolicy.MaxAgeDays,
+		},
+	}
+	if policy.MFARequired {
+		enrollBy := policy.EnrollBy()
+		response.EnrollBy = &enrollBy
+	}
+	return response
+}
+
+func writeSecurityPolicy(w http.ResponseWriter, policy *models.OrgSecurityPolicy) (int, error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(securityPolicyResponse(policy)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// @Summary		Get security policy
+// @Description	Returns the security policy of the organization in X-Organization-Id
+// @Tags			organizations
+// @Produce		json
+// @Param			X-Auth				header		string	true	"Authorization"
+// @Param			X-Organization-Id	header		string	true	"Organization ID"
+// @Success		200					{object}	types.OrgSecurityPolicy
+// @Failure		401					{object}	error	"Unauthorized"
+// @Failure		403					{object}	error	"Forbidden"
+// @Router			/security-policy [get]
+func GetSecurityPolicy(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	policy, code, err := actions.GetOrgSecurityPolicy(ctx.CurrentOrgID)
+	if err != nil {
+		return code, err
+	}
+	return writeSecurityPolicy(w, policy)
+}
+
+// @Summary		Update security policy
+// @Description	Sets the security policy of the organization in X-Organization-Id. When MFA is required, members it applies to lose access to the organization once the grace period ends until they enroll an allowed second factor. Requests they make are refused with a 403 mfa_enrollment_required error. Password rules apply to passwords members set afterwards, on top of the default policy.
+// @Tags			organizations
+// @Accept			json
+// @Produce		json
+// @Param			X-Auth				header		string					true	"Authorization"
+// @Param			X-Organization-Id	header		string					true	"Organization ID"
+// @Param			request				body		SecurityPolicyRequest	true	"Security policy"
+// @Success		200					{object}	types.OrgSecurityPolicy
+// @Failure		400					{object}	error	"Bad request"
+// @Failure		401					{object}	error	"Unauthorized"
+// @Failure		403					{object}	error	"Forbidden"
+// @Router			/security-policy [put]
+func UpdateSecurityPolicy(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req SecurityPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	policy, code, err := actions.UpdateOrgSecurityPolicy(ctx.CurrentOrgID, actions.OrgSecurityPolicyParams{
+		MFARequired:     req.MFARequired,
+		MFARoles:        req.MFARoles,
+		AllowedFactors:  req.AllowedFactors,
+		GracePeriodDays: req.GracePeriodDays,
+		PasswordPolicy:  req.PasswordPolicy,
+	})
+	if err != nil {
+		return code, err
+	}
+	ctx.PermCache.ForgetOrgMFAPolicy(ctx.Context, ctx.CurrentOrgID)
+	return writeSecurityPolicy(w, policy)
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"bigbucks/solution/auth/validations"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateServiceAccountRequest represents the request body for creating a service account
+type CreateServiceAccountRequest struct {
+	Name   string `json:"name" validate:"required"`
+	RoleID string `json:"roleId" validate:"required"`
+	// PEM public key for private_key_jwt authentication, a client secret is issued when empty
+	PublicKey string `json:"publicKey"`
+}
+
+func serviceAccountResponse(client *models.APIClient) types.ServiceAccount {
+	response := types.ServiceAccount{
+		ClientID:   client.ClientID,
+		Name:       client.Name,
+		AuthMethod: "client_secret",
+		CreatedAt:  client.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+	if client.UserID != nil {
+		response.UserID = *client.UserID
+	}
+	if client.PublicKey != "" {
+		response.AuthMethod = "private_key_jwt"
+	}
+	return response
+}
+
+// @Summary		Create service account
+// @Description	Create a machine identity in the organization that gets tokens from /oauth/token with the client_credentials grant. The role may not grant anything the caller does not hold. The client secret is only returned once.
+// @Tags			service-accounts
+// @Accept			json
+// @Produce		json
+// @Param			X-Auth	header	string	true	"Authorization"
+// @Param			request	body	CreateServiceAccountRequest	true	"Service account details"
+// @Success		201		{object}	types.ServiceAccount
+// @Failure		400		{object}	error	"Bad request"
+// @Failure		401		{object}	error	"Unauthorized"
+// @Failure		403		{object}	error	"The role grants permissions the caller does not have"
+// @Failure		500		{object}	error	"Internal server error"
+// @Router			/service-accounts [post]
+func CreateServiceAccount(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	var req CreateServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := validations.Validate.Struct(req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	client, secret, code, err := actions.CreateServiceAccount(actions.CreateServiceAccountParams{
+		Name:      req.Name,
+		OrgID:     ctx.CurrentOrgID,
+		RoleID:    req.RoleID,
+		PublicKey: req.PublicKey,
+		Creator:   &ctx.Auth.User,
+		PermCache: ctx.PermCache,
+	})
+	if err != nil {
+		return code, err
+	}
+
+	response := serviceAccountResponse(client)
+	response.ClientSecret = secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// @Summary		List service accounts
+// @Description	List the service accounts of the organization
+// @Tags			service-accounts
+// @Produce		json
+// @Param			X-Auth	header	string	true	"Authorization"
+// @Success		200		{array}	types.ServiceAccount
+// @Failure		401		{object}	error	"Unauthorized"
+// @Failure		500		{object}	error	"Internal server error"
+// @Router			/service-accounts [get]
+func ListServiceAccounts(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	clients, code, err := actions.ListServiceAccounts(ctx.CurrentOrgID)
+	if err != nil {
+		return code, err
+	}
+	response := make([]types.ServiceAccount, 0, len(clients))
+	for i := range clients {
+		response = append(response, serviceAccountResponse(&clients[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// @Summary		Rotate service account secret
+// @Description	Issue a new client secret; the previous secret stops working immediately
+// @Tags			service-accounts
+// @Produce		json
+// @Param			X-Auth		header	string	true	"Authorization"
+// @Param			client_id	path	string	true	"Client ID"
+// @Success		200			{object}	types.ServiceAccount
+// @Failure		404			{object}	error	"Not found"
+// @Failure		500			{object}	error	"Internal server error"
+// @Router			/service-accounts/{client_id}/secret [post]
+func RotateServiceAccountSecret(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	clientID := mux.Vars(r)["client_id"]
+	secret, code, err := actions.RotateServiceAccountSecret(clientID, ctx.CurrentOrgID)
+	if err != nil {
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.ServiceAccount{ClientID: clientID, AuthMethod: "client_secret", ClientSecret: secret}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// @Summary		Delete service account
+// @Description	Delete a service account and revoke its tokens
+// @Tags			service-accounts
+// @Produce		json
+// @Param			X-Auth		header	string	true	"Authorization"
+// @Param			client_id	path	string	true	"Client ID"
+// @Success		200			{object}	types.SimpleResponse
+// @Failure		404			{object}	error	"Not found"
+// @Failure		500			{object}	error	"Internal server error"
+// @Router			/service-accounts/{client_id} [delete]
+func DeleteServiceAccount(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	clientID := mux.Vars(r)["client_id"]
+	code, err := actions.DeleteServiceAccount(clientID, ctx.CurrentOrgID, ctx.SessionStore)
+	if err != nil {
+		return code, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(types.SimpleResponse{Message: "Service account deleted successfully"}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
@@ -18,6 +18,9 @@ type SignupRequestBody struct {
 	Password  string `json:"password" validate:"required,min=6"`
 	FirstName string `json:"firstName" validate:"required"`
 	LastName  string `json:"lastName" validate:"required"`
+	// ReCaptcha is the token of the CAPTCHA widget, needed when the captchaEndpoints
+	// setting asks for one
+	ReCaptcha string `json:"recaptcha"`
 }
 
 type RolePermissionBindingBody struct {
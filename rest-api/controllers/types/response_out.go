@@ -1,6 +1,10 @@
 package types
 
-import "bigbucks/solution/auth/actions/types"
+import (
+	"bigbucks/solution/auth/actions/types"
+	"bigbucks/solution/auth/settings"
+	"time"
+)
 
 type SimpleResponse struct {
 	Message string `json:"message" example:"message"`
@@ -21,3 +25,181 @@ type ListRolesPagedResponse struct {
 	Page  int                      `json:"page"`
 	Size  int                      `json:"size"`
 }
+
+// IntrospectionResponse is the RFC 7662 token introspection result. Inactive
+// tokens only carry the active flag.
+type IntrospectionResponse struct {
+	Active    bool                   `json:"active"`
+	TokenType string                 `json:"token_type,omitempty"`
+	Sub       string                 `json:"sub,omitempty"`
+	Username  string                 `json:"username,omitempty"`
+	Sid       string                 `json:"sid,omitempty"`
+	Iss       string                 `json:"iss,omitempty"`
+	Exp       int64                  `json:"exp,omitempty"`
+	Iat       int64                  `json:"iat,omitempty"`
+	Roles     []settings.UserOrgRole `json:"roles,omitempty"`
+	// Permissions the token is restricted to, for personal access tokens
+	Permissions []string `json:"permissions,omitempty"`
+	// ClientID, Scope and Aud are set for tokens issued to relying parties
+	ClientID string   `json:"client_id,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+	Aud      []string `json:"aud,omitempty"`
+}
+
+// TokenResponse is the RFC 6749 access token response of /oauth/token
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// ServiceAccount describes a service account API client
+type ServiceAccount struct {
+	ClientID   string `json:"clientId"`
+	Name       string `json:"name"`
+	UserID     string `json:"userId"`
+	AuthMethod string `json:"authMethod"` // client_secret or private_key_jwt
+	CreatedAt  string `json:"createdAt"`
+	// ClientSecret is only returned when the account is created or its secret rotated
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// PersonalAccessToken describes a personal access token without its value
+type PersonalAccessToken struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Hint        string     `json:"hint"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+	// Token is only returned when the token is created
+	Token string `json:"token,omitempty"`
+}
+
+// OpenIDConfiguration is the OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// AuthorizeRedirect tells a sign-in page where to send the browser after an
+// authorization request made with POST
+type AuthorizeRedirect struct {
+	RedirectTo string `json:"redirectTo"`
+}
+
+// ConsentRequest asks a sign-in page to have the user consent to a relying party.
+// The page POSTs the authorization request again with consent set to approve or deny.
+type ConsentRequest struct {
+	ConsentRequired bool     `json:"consentRequired"`
+	ClientID        string   `json:"clientId"`
+	ClientName      string   `json:"clientName"`
+	Scopes          []string `json:"scopes"`
+}
+
+// DeviceAuthorizationResponse is the RFC 8628 device authorization response
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceVerification describes a pending device sign-in for the user to confirm
+type DeviceVerification struct {
+	UserCode   string `json:"userCode"`
+	ClientID   string `json:"clientId"`
+	ClientName string `json:"clientName"`
+	Scope      string `json:"scope"`
+}
+
+// MFAChallenge is returned by signin instead of a token when the user has a second
+// factor. The client completes the sign-in at /signin/mfa with the MFA token.
+type MFAChallenge struct {
+	MFARequired bool     `json:"mfaRequired"`
+	MFAToken    string   `json:"mfaToken"`
+	Methods     []string `json:"methods"`
+	ExpiresIn   int64    `json:"expiresIn"`
+}
+
+// PasswordExpiredChallenge is returned by signin instead of a token when the
+// password is older than the password policy allows. The token only works at
+// /user/changepassword/{token}, the user signs in again with the new password.
+type PasswordExpiredChallenge struct {
+	PasswordExpired     bool   `json:"passwordExpired"`
+	PasswordChangeToken string `json:"passwordChangeToken"`
+	ExpiresIn           int64  `json:"expiresIn"`
+}
+
+// StepUpChallenge is returned by signin instead of a token for high risk sign-ins
+// of users without a second factor. The user completes the sign-in with the link
+// emailed to them, verified at /signin/link/verify.
+type StepUpChallenge struct {
+	StepUpRequired bool     `json:"stepUpRequired"`
+	Methods        []string `json:"methods"`
+}
+
+// TOTPEnrollment is the shared secret of a new authenticator
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	// OtpauthURI is the payload of the QR code authenticator apps scan
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+// RecoveryCodes are shown once, when they are issued
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFAStatus describes the second factors of the current user
+type MFAStatus struct {
+	TOTPEnabled            bool  `json:"totpEnabled"`
+	RecoveryCodesRemaining int64 `json:"recoveryCodesRemaining"`
+	WebAuthnEnabled        bool  `json:"webAuthnEnabled"`
+}
+
+// OrgSecurityPolicy describes the security requirements of an organization
+type OrgSecurityPolicy struct {
+	OrgID           string   `json:"orgId"`
+	MFARequired     bool     `json:"mfaRequired"`
+	MFARoles        []string `json:"mfaRoles"`
+	AllowedFactors  []string `json:"allowedFactors"`
+	GracePeriodDays int      `json:"gracePeriodDays"`
+	// EnrollBy is when members without an allowed factor lose access, unset while
+	// MFA is not required
+	EnrollBy *time.Time `json:"enrollBy,omitempty"`
+	// PasswordPolicy is what the passwords of members follow, the default policy
+	// included
+	PasswordPolicy PasswordPolicy `json:"passwordPolicy"`
+}
+
+// PasswordPolicy describes the rules passwords follow
+type PasswordPolicy struct {
+	MinLength        int  `json:"minLength"`
+	MaxLength        int  `json:"maxLength"`
+	RequireUppercase bool `json:"requireUppercase"`
+	RequireLowercase bool `json:"requireLowercase"`
+	RequireDigit     bool `json:"requireDigit"`
+	RequireSymbol    bool `json:"requireSymbol"`
+	HistoryCount     int  `json:"historyCount"`
+	MaxAgeDays       int  `json:"maxAgeDays"`
+}
@@ -7,6 +7,8 @@ import (
 	"bigbucks/solution/auth/models"
 	"bigbucks/solution/auth/request_context"
 	"bigbucks/solution/auth/rest-api/controllers/types"
+	"bigbucks/solution/auth/settings"
+	valids "bigbucks/solution/auth/validations"
 	"encoding/json"
 	"errors"
 	"mime/multipart"
@@ -18,13 +20,19 @@ import (
 	"gorm.io/gorm"
 )
 
+// PasswordBreachedHeader is set on responses that accepted a password known from a
+// data breach, when breached passwords only warn
+const PasswordBreachedHeader = "X-Password-Breached"
+
 type RequestPasswordResetToken struct {
-	Email string `json:"email" example:"example@example.com"`
+	Email     string `json:"email" example:"example@example.com"`
+	ReCaptcha string `json:"recaptcha"`
 }
+
+// ResetPassword carries the new password, the token is in the path
 type ResetPassword struct {
 	Token    string `json:"-"`
-	Password string
-	Email    string
+	Password string `json:"password"`
 }
 
 type UpdateProfileBody struct {
@@ -34,15 +42,15 @@ type UpdateProfileBody struct {
 // SendResetToken godoc
 //
 //	@Summary		Send the password reset token
-//	@Description	Get password reset token to email
+//	@Description	Emails a password reset link to the account. The response is the same whether or not the account exists. A CAPTCHA can be required, see the captchaEndpoints setting; every request counts as a failed attempt.
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
 //	@Param			request	body		RequestPasswordResetToken	true	"request body"
 //	@Success		200		{object}	types.SimpleResponse		"return message"
-//	@Failure		400		""
-//	@Failure		404		""
-//	@Failure		500		""
+//	@Failure		400		{object}	actions.CaptchaError		"Bad request or CAPTCHA required"
+//	@Failure		500		{object}	error						"Internal server error"
+//	@Failure		503		{object}	error						"CAPTCHA could not be verified"
 //	@Router			/user/reset [post]
 func SendResetToken(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
 	var requestBody RequestPasswordResetToken
@@ -50,11 +58,15 @@ func SendResetToken(w http.ResponseWriter, r *http.Request, ctx *request_context
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
-	var usr models.User
-	models.Dbcon.Preload("Profile").Find(&usr, &models.User{Username: requestBody.Email})
-	_, err = usr.GenerateResetToken()
-	if err != nil {
-		return http.StatusInternalServerError, err
+	ip := ClientHost(r)
+	if code, err := actions.CheckCaptcha(r.Context(), settings.CaptchaReset, requestBody.ReCaptcha, ip, ctx.SessionStore); err != nil {
+		return code, err
+	}
+	// The response does not tell whether the address has an account, so every
+	// request counts
+	actions.RecordCaptchaFailure(settings.CaptchaReset, ip, ctx.SessionStore)
+	if code, err := actions.SendPasswordReset(requestBody.Email); err != nil {
+		return code, err
 	}
 	loging.Logger.Debugln("Sending Reset Token..")
 	err = json.NewEncoder(w).Encode(&types.SimpleResponse{Message: "Password reset token sent to registered email"})
@@ -67,16 +79,15 @@ func SendResetToken(w http.ResponseWriter, r *http.Request, ctx *request_context
 // ChangePassword godoc
 //
 //	@Summary		Reset the password with the password reset token sent
-//	@Description	Reset the password with the password reset token sent to email
+//	@Description	Sets a new password with the token of the reset link sent by email, or the one signin returns for an expired password. The token works once and all sessions of the user are signed out.
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
-//	@Param			token	path	string			true	"token"
-//	@Param			request	body	ResetPassword	true	"request body"
-//	@Success		200		""
-//	@Failure		400		""
-//	@Failure		404		""
-//	@Failure		500		""
+//	@Param			token	path		string					true	"token"
+//	@Param			request	body		ResetPassword			true	"request body"
+//	@Success		200		{object}	types.SimpleResponse	"Password changed"
+//	@Failure		400		{object}	error					"Invalid or expired token, password against the password policy or used recently"
+//	@Failure		500		{object}	error					"Internal server error"
 //	@Router			/user/changepassword/{token} [post]
 func ChangePassword(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
 	var body ResetPassword
@@ -86,14 +97,15 @@ func ChangePassword(w http.ResponseWriter, r *http.Request, ctx *request_context
 	}
 	vars := mux.Vars(r)
 	body.Token = vars["token"]
-	var usr models.User
-	err = models.Dbcon.Preload("ForgotPassword").First(&usr, "username = ?", body.Email).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return http.StatusBadRequest, err
-	}
-	num, err := usr.ChangePassword(body.Token, body.Password)
+	breached, code, err := actions.ResetPassword(body.Token, body.Password, ctx.SessionStore)
 	if err != nil {
-		return num, err
+		return code, err
+	}
+	if breached {
+		w.Header().Set(PasswordBreachedHeader, "true")
+	}
+	if err := json.NewEncoder(w).Encode(&types.SimpleResponse{Message: "Password changed"}); err != nil {
+		return http.StatusInternalServerError, err
 	}
 	return 0, nil
 }
@@ -155,16 +167,18 @@ func GetMeDetails(w http.ResponseWriter, r *http.Request, ctx *request_context.C
 // Signup godoc
 //
 //	@Summary		Register a new user
-//	@Description	Create a new user account
+//	@Description	Create a new user account. A CAPTCHA can be required, always or after rejected signups from the address, see the captchaEndpoints setting.
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
 //	@Param			request	body		types.SignupRequestBody	true	"User signup details"
 //
 //	@Success		200		{object}	types.SimpleResponse	"Success message"
-//	@Failure		400		{object}	error					"Bad request"
+//	@Failure		400		{object}	error					"Bad request, password against the password policy or CAPTCHA required"
 //	@Failure		404		{object}	error					"Not found"
+//	@Failure		409		{object}	error					"User with this email already exists"
 //	@Failure		500		{object}	error					"Internal server error"
+//	@Failure		503		{object}	error					"CAPTCHA could not be verified"
 //
 //	@Router			/signup [post]
 func Signup(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
@@ -172,6 +186,19 @@ func Signup(w http.ResponseWriter, r *http.Request, ctx *request_context.Context
 	if err := json.NewDecoder(r.Body).Decode(&signupRequest); err != nil {
 		return http.StatusBadRequest, err
 	}
+	ip := ClientHost(r)
+	if code, err := actions.CheckCaptcha(r.Context(), settings.CaptchaSignup, signupRequest.ReCaptcha, ip, ctx.SessionStore); err != nil {
+		return code, err
+	}
+
+	breached, code, err := actions.CheckPassword(signupRequest.Password, "", signupRequest.Email)
+	if err != nil {
+		actions.RecordCaptchaFailure(settings.CaptchaSignup, ip, ctx.SessionStore)
+		return code, err
+	}
+	if breached {
+		w.Header().Set(PasswordBreachedHeader, "true")
+	}
 
 	user := models.User{
 		Username: signupRequest.Email,
@@ -186,13 +213,17 @@ func Signup(w http.ResponseWriter, r *http.Request, ctx *request_context.Context
 	if err := models.Dbcon.Create(&user).Error; err != nil {
 		loging.Logger.Warnln("Attempt to register with existing email:")
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
-
+			actions.RecordCaptchaFailure(settings.CaptchaSignup, ip, ctx.SessionStore)
 			return http.StatusConflict, errors.New("user with this email already exists")
 		}
 		return http.StatusInternalServerError, err
 	}
+	// Signup succeeds without the email, the user can ask for the link again
+	if _, err := actions.SendEmailVerification(&user); err != nil {
+		loging.Logger.Warnln("Could not send verification email:", err)
+	}
 
-	err := json.NewEncoder(w).Encode(&types.SimpleResponse{
+	err = json.NewEncoder(w).Encode(&types.SimpleResponse{
 		Message: "User registered successfully",
 	})
 	if err != nil {
@@ -324,3 +355,46 @@ func DeactivateUser(w http.ResponseWriter, r *http.Request, ctx *request_context
 	}
 	return 0, nil
 }
+
+// UnlockUser godoc
+//
+//	@Summary		Unlock a user
+//	@Description	Ends the lockout of a member locked after too many wrong passwords and forgets the failed sign-ins
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			X-Auth	header	string	true	"Authorization"
+//	@Security		JWTAuth
+//	@Param			user_id	path	string	true	"User ID"
+//	@Success		200		{object}	types.SimpleResponse	"Success message"
+//	@Failure		400		{object}	error					"Bad request"
+//	@Failure		404		{object}	error					"User not found"
+//	@Failure		500		{object}	error					"Internal server error"
+//	@Router			/users/{user_id}/unlock [put]
+func UnlockUser(w http.ResponseWriter, r *http.Request, ctx *request_context.Context) (int, error) {
+	// Without an organization the action would unlock any user
+	if ctx.CurrentOrgID == "" {
+		customerr := valids.NewErrorDict()
+		customerr.Errors["OrgID"] = "Organization ID is required"
+		return http.StatusBadRequest, customerr
+	}
+	actorID := ctx.Auth.Subject
+	code, err := actions.UnlockAccount(actions.UnlockAccountParams{
+		UserID:  mux.Vars(r)["user_id"],
+		OrgID:   ctx.CurrentOrgID,
+		ActorID: &actorID,
+	}, ctx.SessionStore)
+	if err != nil {
+		return code, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&types.SimpleResponse{
+		Message: "User unlocked successfully",
+	})
+	if err != nil {
+		loging.Logger.Error("Error encoding unlock user response", err)
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
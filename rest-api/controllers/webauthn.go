@@ -1,10 +1,11 @@
 package controllers
 
 import (
-	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/actions"
 	"bigbucks/solution/auth/loging"
 	"bigbucks/solution/auth/models"
 	"bigbucks/solution/auth/request_context"
+	"bigbucks/solution/auth/settings"
 	webauthnservice "bigbucks/solution/auth/webauthn"
 	"encoding/json"
 	"net/http"
@@ -105,6 +106,7 @@ func FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request, ctx *req
 		loging.Logger.Error("FinishRegistration failed", err)
 		return http.StatusInternalServerError, err
 	}
+	ctx.PermCache.ForgetUserMFAStatus(ctx.Context, user.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
@@ -165,7 +167,8 @@ func BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request, ctx *request_con
 //	@Accept			json
 //	@Produce		json
 //	@Param			username	query		string	false	"Username (must match begin request)"
-//	@Success		202			{string}	string	"JWT token"
+//	@Success		202			{string}	string	"JWT token, refresh token in X-Refresh-Token header"
+//	@Success		200			{object}	types.PasswordExpiredChallenge	"Password expired"
 //	@Failure		400			{object}	error	"Bad request"
 //	@Failure		401			{object}	error	"Unauthorized"
 //	@Failure		500			{object}	error	"Internal server error"
@@ -184,30 +187,12 @@ func FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request, ctx *request_co
 		loging.Logger.Error("FinishLogin failed", err)
 		return http.StatusUnauthorized, err
 	}
-
-	// Create session and issue JWT — same flow as password signin
-	userAgent := r.UserAgent()
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = r.RemoteAddr
+	if code, err := actions.CheckVerifiedEmail(user, ctx.Settings.RequireVerifiedEmail, settings.RequireVerifiedEmailSignin); err != nil {
+		return code, err
 	}
 
-	sessionID, err := ctx.SessionStore.CreateSession(user.ID, user.Username, userAgent, ip, constants.SESSION_EXPIRY)
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
-
-	go func() {
-		if err := user.LogLoginActivity(map[string]interface{}{
-			"ip":         ip,
-			"user_agent": userAgent,
-			"method":     "webauthn",
-		}); err != nil {
-			loging.Logger.Error("Error logging login activity", err)
-		}
-	}()
-
-	return printToken(w, r, user, sessionID)
+	// Create session and issue JWT — same flow as password signin
+	return completeSignin(w, r, ctx, user, "webauthn")
 }
 
 // ---- Credential Management ----
@@ -288,6 +273,7 @@ func DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request, ctx *reque
 	if err := models.DeleteWebAuthnCredential(uint(credID), user.ID); err != nil {
 		return http.StatusNotFound, err
 	}
+	ctx.PermCache.ForgetUserMFAStatus(ctx.Context, user.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]string{
@@ -1,9 +1,11 @@
 package rest
 
 import (
+	"bigbucks/solution/auth/actions"
 	jwtops "bigbucks/solution/auth/jwt-ops"
 	"bigbucks/solution/auth/loging"
 	"bigbucks/solution/auth/permission_cache"
+	"bigbucks/solution/auth/ratelimit"
 	"bigbucks/solution/auth/request_context"
 	sessionstore "bigbucks/solution/auth/session_store"
 	"bigbucks/solution/auth/settings"
@@ -51,10 +53,14 @@ func (l *responseLogger) Size() int {
 
 func Authenticate(w *responseLogger, r *http.Request, settings *settings.Settings) (bool, settings.AuthToken, error) {
 
-	authToken, _, err := jwtops.VerifyJWT(r)
+	authToken, err := jwtops.VerifyRequest(r)
 	if err != nil {
 		return false, authToken, err
 	}
+	if authToken.PersonalAccessToken {
+		// Personal access tokens are not renewed
+		return true, authToken, nil
+	}
 
 	expTime, expErr := authToken.GetExpirationTime()
 	if expErr != nil {
@@ -78,7 +84,7 @@ func JSONError(responselogger *responseLogger, err interface{}, code int) {
 	}
 }
 
-func handle(fn handleFunc, config *handlerConfig, setting *settings.Settings, perm_cache *permission_cache.PermissionCache, session_store *sessionstore.SessionStore) http.Handler {
+func handle(fn handleFunc, config *handlerConfig, setting *settings.Settings, perm_cache *permission_cache.PermissionCache, session_store *sessionstore.SessionStore, limiter *ratelimit.Limiter) http.Handler {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		_responseLogger := &responseLogger{w: w, status: http.StatusOK}
@@ -107,7 +113,34 @@ func handle(fn handleFunc, config *handlerConfig, setting *settings.Settings, pe
 				http.Error(_responseLogger, strconv.Itoa(http.StatusUnauthorized)+" "+http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 				return
 			}
+			// Access tokens of relying parties carry no roles and only open the routes
+			// meant for them
+			if authToken.RelyingParty() && !config.relyingPartyTokens {
+				http.Error(_responseLogger, "Forbidden", http.StatusForbidden)
+				return
+			}
+			// The permissions a personal access token is limited to can only be enforced
+			// on routes that check one, it may not do anything else
+			if authToken.PersonalAccessToken && config.resource == "" && !config.personalAccessTokens {
+				http.Error(_responseLogger, "Personal access tokens cannot be used here, sign in instead", http.StatusForbidden)
+				return
+			}
+			// Reject tokens whose session was revoked or expired. Personal access tokens
+			// have no session, their revocation was checked when they were resolved.
+			if !authToken.PersonalAccessToken {
+				live, err := session_store.CheckSession(authToken.ID)
+				if err != nil {
+					loging.Logger.Error("Error checking session", zap.Error(err))
+					http.Error(_responseLogger, "Session store unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				if !live {
+					http.Error(_responseLogger, "Session expired", http.StatusUnauthorized)
+					return
+				}
+			}
 			ctx.Auth = &authToken
+			r = r.WithContext(context.WithValue(r.Context(), authUserKey{}, authToken.Subject))
 			orgID := r.Header.Get("X-Organization-Id")
 			if orgID == "" {
 				orgID = r.PathValue("org_id")
@@ -115,16 +148,26 @@ func handle(fn handleFunc, config *handlerConfig, setting *settings.Settings, pe
 			ctx.CurrentOrgID = orgID
 
 			if config.resource != "" && config.scope != "" && config.action != "" {
-				valid, _, err := session_store.ValidateSession(ctx.Auth.ID)
-				if err != nil || !valid {
-					http.Error(_responseLogger, "Session expired", http.StatusUnauthorized)
-					return
-				}
 				if ctx.CurrentOrgID == "" {
 					loging.Logger.Warn("No org_id found in request")
 					http.Error(_responseLogger, "Forbidden", http.StatusForbidden)
 					return
 				}
+				// Members who have not met the organization's MFA policy are refused with
+				// a body the UI can act on, during the grace period they are reminded
+				enrollBy, status, err := actions.CheckOrgMFAPolicy(authToken.Subject, orgID, perm_cache, ctx.Context)
+				if status == http.StatusForbidden {
+					JSONError(_responseLogger, err, status)
+					return
+				}
+				if err != nil {
+					loging.Logger.Error("Error checking organization MFA policy", zap.Error(err))
+					http.Error(_responseLogger, "Forbidden", http.StatusForbidden)
+					return
+				}
+				if enrollBy != nil {
+					_responseLogger.Header().Set("X-MFA-Enroll-By", enrollBy.UTC().Format(time.RFC3339))
+				}
 				hasPermission, err := perm_cache.CheckPermission(&ctx.Context, config.resource, config.scope, config.action, orgID, &ctx.Auth.User)
 				if err != nil || !hasPermission {
 					http.Error(_responseLogger, "Forbidden", http.StatusForbidden)
@@ -133,6 +176,12 @@ func handle(fn handleFunc, config *handlerConfig, setting *settings.Settings, pe
 			}
 		}
 
+		// Only requests allowed on the route count, so others cannot use up the
+		// limits of an organization
+		if len(config.rateLimits) > 0 && !checkRateLimits(_responseLogger, r, limiter, config.rateLimits) {
+			http.Error(_responseLogger, strconv.Itoa(http.StatusTooManyRequests)+" "+http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
 		status, err := fn(_responseLogger, r, ctx)
 
 		if status != 0 {
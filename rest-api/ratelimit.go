@@ -0,0 +1,145 @@
+package rest
+
+import (
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/ratelimit"
+	ctr "bigbucks/solution/auth/rest-api/controllers"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// maxRateLimitBody bounds how much of a body is read to find the key
+const maxRateLimitBody = 1 << 20
+
+// RateLimitKey picks the requests a rate limit counts together. Requests with an
+// empty key are not counted.
+type RateLimitKey func(r *http.Request) string
+
+// ByIP counts the requests of each client address
+func ByIP(r *http.Request) string {
+	return "ip:" + ctr.ClientHost(r)
+}
+
+// ByUsername counts the requests for each account named in the "username" query
+// parameter, or the "username" or "email" field of a JSON body
+func ByUsername(r *http.Request) string {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		var fields struct {
+			Username string `json:"username"`
+			Email    string `json:"email"`
+		}
+		readBody(r, &fields)
+		username = cmp.Or(fields.Username, fields.Email)
+	}
+	if username = strings.ToLower(strings.TrimSpace(username)); username == "" {
+		return ""
+	}
+	return "user:" + username
+}
+
+// ByMFAToken counts the attempts at each MFA challenge, named in the "mfaToken"
+// query parameter or field of a JSON body. The token is hashed so that it is not
+// kept in Redis.
+func ByMFAToken(r *http.Request) string {
+	token := r.URL.Query().Get("mfaToken")
+	if token == "" {
+		var fields struct {
+			MFAToken string `json:"mfaToken"`
+		}
+		readBody(r, &fields)
+		token = fields.MFAToken
+	}
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "mfa:" + hex.EncodeToString(sum[:16])
+}
+
+// readBody decodes a JSON body into fields and puts the body back for the handler
+func readBody(r *http.Request, fields any) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRateLimitBody))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return
+	}
+	_ = json.Unmarshal(body, fields)
+}
+
+// authUserKey holds the ID of the signed in user in the request context
+type authUserKey struct{}
+
+// ByUser counts the requests of each signed in user. The route needs WithAuth.
+func ByUser(r *http.Request) string {
+	if userID, _ := r.Context().Value(authUserKey{}).(string); userID != "" {
+		return "user:" + userID
+	}
+	return ""
+}
+
+// ByOrg counts the requests for each organization in X-Organization-Id
+func ByOrg(r *http.Request) string {
+	if orgID := r.Header.Get("X-Organization-Id"); orgID != "" {
+		return "org:" + orgID
+	}
+	return ""
+}
+
+type rateLimit struct {
+	key  RateLimitKey
+	rule ratelimit.Rule
+}
+
+// WithRateLimit limits the requests to the route that share a key to the rule.
+// Several limits can be set on a route, a request has to be within all of them.
+func WithRateLimit(key RateLimitKey, rule ratelimit.Rule) HandlerOption {
+	return func(c *handlerConfig) {
+		c.rateLimits = append(c.rateLimits, rateLimit{key: key, rule: rule})
+	}
+}
+
+// checkRateLimits counts the request against the limits of the route and sets the
+// RateLimit headers of the one closest to its limit. It returns false, with
+// Retry-After set, once a limit is reached. Requests are let through when Redis
+// cannot be reached.
+func checkRateLimits(w http.ResponseWriter, r *http.Request, limiter *ratelimit.Limiter, limits []rateLimit) bool {
+	var tightest *ratelimit.Result
+	for _, limit := range limits {
+		key := limit.key(r)
+		if key == "" {
+			continue
+		}
+		result, err := limiter.Allow(r.Context(), limit.rule, key)
+		if err != nil {
+			loging.Logger.Error("Error checking rate limit", zap.String("rule", limit.rule.Name), zap.Error(err))
+			continue
+		}
+		if tightest == nil || !result.Allowed || result.Remaining < tightest.Remaining {
+			tightest = &result
+		}
+		if !result.Allowed {
+			break
+		}
+	}
+	if tightest == nil {
+		return true
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ratelimit.Seconds(tightest.Reset)))
+	if !tightest.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ratelimit.Seconds(tightest.RetryAfter())))
+		return false
+	}
+	return true
+}
@@ -1,13 +1,16 @@
 package rest
 
 import (
+	"bigbucks/solution/auth/deviceauth"
 	"bigbucks/solution/auth/permission_cache"
+	"bigbucks/solution/auth/ratelimit"
 	"bigbucks/solution/auth/request_context"
 	ctr "bigbucks/solution/auth/rest-api/controllers" //Load all controllers methods by deafult
 	sessionstore "bigbucks/solution/auth/session_store"
 	"bigbucks/solution/auth/settings"
 	webauthnservice "bigbucks/solution/auth/webauthn"
 	"net/http"
+	"time"
 
 	_ "bigbucks/solution/auth/docs"
 
@@ -42,6 +45,7 @@ func NewHandler(settings *settings.Settings, perm_cache *permission_cache.Permis
 	settings.Clean()
 
 	r := mux.NewRouter()
+	limiter := ratelimit.NewLimiter(settings)
 
 	makeHandler := func(fn handleFunc, opts ...HandlerOption) http.Handler {
 		config := &handlerConfig{
@@ -53,19 +57,47 @@ func NewHandler(settings *settings.Settings, perm_cache *permission_cache.Permis
 			opt(config)
 		}
 
-		return handle(fn, config, settings, perm_cache, session_store)
+		return handle(fn, config, settings, perm_cache, session_store, limiter)
 	}
 	//cors
 
 	api := r.PathPrefix("/api/v1").Subrouter()
-	api.Handle("/signin", makeHandler(ctr.Signin)).Methods("POST")
-	api.Handle("/signup", makeHandler(ctr.Signup)).Methods("POST")
-	api.Handle("/signin/google", makeHandler(ctr.GoogleSignin)).Methods("POST")
-	api.Handle("/signin/facebook", makeHandler(ctr.FbSignin)).Methods("POST")
-	api.Handle("/renew", makeHandler(ctr.RenewToken, WithAuth(true))).Methods("POST")
+	api.Handle("/signin", makeHandler(ctr.Signin,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-ip", Limit: 30, Window: time.Minute}),
+		WithRateLimit(ByUsername, ratelimit.Rule{Name: "signin-username", Limit: 10, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/signup", makeHandler(ctr.Signup,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signup-ip", Limit: 10, Window: time.Hour}),
+	)).Methods("POST")
+	api.Handle("/signin/mfa", makeHandler(ctr.SigninMFA,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-mfa-ip", Limit: 30, Window: time.Minute}),
+		WithRateLimit(ByMFAToken, ratelimit.Rule{Name: "signin-mfa-challenge", Limit: 5, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/signin/mfa/webauthn/begin", makeHandler(ctr.BeginWebAuthnMFA,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-mfa-webauthn-ip", Limit: 30, Window: time.Minute}),
+		WithRateLimit(ByMFAToken, ratelimit.Rule{Name: "signin-mfa-webauthn-challenge", Limit: 10, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/signin/mfa/webauthn/finish", makeHandler(ctr.FinishWebAuthnMFA,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-mfa-webauthn-ip", Limit: 30, Window: time.Minute}),
+		WithRateLimit(ByMFAToken, ratelimit.Rule{Name: "signin-mfa-webauthn-challenge", Limit: 10, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/signin/link", makeHandler(ctr.RequestMagicLink,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-link-ip", Limit: 10, Window: time.Hour}),
+		WithRateLimit(ByUsername, ratelimit.Rule{Name: "signin-link-email", Limit: 5, Window: time.Hour}),
+	)).Methods("POST")
+	api.Handle("/signin/link/verify", makeHandler(ctr.SigninMagicLink,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-link-verify-ip", Limit: 30, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/signin/google", makeHandler(ctr.GoogleSignin,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-google-ip", Limit: 30, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/signin/facebook", makeHandler(ctr.FbSignin,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "signin-facebook-ip", Limit: 30, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/renew", makeHandler(ctr.RenewToken)).Methods("POST")
 	api.Handle("/signout", makeHandler(ctr.SignOut, WithAuth(true))).Methods("POST")
 	api.Handle("/organizations", makeHandler(ctr.CreateOrg, WithAuth(true))).Methods("POST")
-	api.Handle("/organizations/{org_id}", makeHandler(ctr.GetOrg, WithAuth(true))).Methods("GET")
+	api.Handle("/organizations/{org_id}", makeHandler(ctr.GetOrg, WithAuth(true), WithPersonalAccessTokens())).Methods("GET")
 
 	// sessions
 	api.Handle("/sessions/users/{user_id}", makeHandler(ctr.Sessions, WithAuth(true), WithPermission("session:all:read"))).Methods("GET")
@@ -84,11 +116,42 @@ func NewHandler(settings *settings.Settings, perm_cache *permission_cache.Permis
 		makeHandler(ctr.DeactivateUser, WithAuth(true), WithPermission("user:*:update")),
 	).Methods("PUT")
 
-	api.Handle("/me", makeHandler(ctr.GetMeDetails, WithAuth(true))).Methods("GET")
-	api.Handle("/user/reset", makeHandler(ctr.SendResetToken)).Methods("POST")
+	api.Handle("/users/{user_id}/unlock",
+		makeHandler(ctr.UnlockUser, WithAuth(true), WithPermission("user:*:update")),
+	).Methods("PUT")
+
+	api.Handle("/me", makeHandler(ctr.GetMeDetails, WithAuth(true), WithPersonalAccessTokens())).Methods("GET")
+	api.Handle("/me/tokens", makeHandler(ctr.CreatePersonalAccessToken, WithAuth(true))).Methods("POST")
+	api.Handle("/me/tokens", makeHandler(ctr.ListPersonalAccessTokens, WithAuth(true))).Methods("GET")
+	api.Handle("/me/tokens/{token_id}", makeHandler(ctr.RevokePersonalAccessToken, WithAuth(true))).Methods("DELETE")
+	api.Handle("/me/mfa", makeHandler(ctr.GetMFAStatus, WithAuth(true), WithPersonalAccessTokens())).Methods("GET")
+	api.Handle("/me/mfa/totp", makeHandler(ctr.EnrollTOTP, WithAuth(true))).Methods("POST")
+	api.Handle("/me/mfa/totp/verify", makeHandler(ctr.VerifyTOTP, WithAuth(true))).Methods("POST")
+	api.Handle("/me/mfa/totp/disable", makeHandler(ctr.DisableTOTP, WithAuth(true))).Methods("POST")
+	api.Handle("/me/mfa/webauthn", makeHandler(ctr.SetWebAuthnMFA, WithAuth(true))).Methods("POST")
+	api.Handle("/me/mfa/webauthn/begin", makeHandler(ctr.BeginWebAuthnConfirmation, WithAuth(true))).Methods("POST")
+	api.Handle("/me/mfa/recovery-codes", makeHandler(ctr.RegenerateRecoveryCodes, WithAuth(true))).Methods("POST")
+	// Every code is a paid text message, so numbers cannot be pumped through a session
+	api.Handle("/me/phone", makeHandler(ctr.ChangePhoneNumber, WithAuth(true),
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "phone-ip", Limit: 10, Window: time.Hour}),
+		WithRateLimit(ByUser, ratelimit.Rule{Name: "phone-user", Limit: 5, Window: time.Hour}),
+		WithRateLimit(ByUser, ratelimit.Rule{Name: "phone-user-daily", Limit: 10, Window: 24 * time.Hour}),
+	)).Methods("POST")
+	api.Handle("/me/phone/verify", makeHandler(ctr.VerifyPhoneNumber, WithAuth(true))).Methods("POST")
+	api.Handle("/user/reset", makeHandler(ctr.SendResetToken,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "password-reset-ip", Limit: 10, Window: time.Hour}),
+		WithRateLimit(ByUsername, ratelimit.Rule{Name: "password-reset-email", Limit: 3, Window: time.Hour}),
+	)).Methods("POST")
+	api.Handle("/user/verify-email", makeHandler(ctr.VerifyEmail)).Methods("POST")
+	api.Handle("/user/verify-email/resend", makeHandler(ctr.ResendVerificationEmail,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "verify-email-resend-ip", Limit: 10, Window: time.Hour}),
+		WithRateLimit(ByUsername, ratelimit.Rule{Name: "verify-email-resend-email", Limit: 3, Window: time.Hour}),
+	)).Methods("POST")
 	api.Handle("/user/updateprofile", makeHandler(ctr.UpdateProfile, WithAuth(true))).Methods("POST")
-	api.Handle("/user/changepassword/{token:[a-z0-9]+}", makeHandler(ctr.ChangePassword)).Methods("POST")
-	api.Handle("/user/authorize", makeHandler(ctr.Authorize, WithAuth(true))).Methods("POST")
+	api.Handle("/user/changepassword/{token}", makeHandler(ctr.ChangePassword,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "change-password-ip", Limit: 30, Window: time.Hour}),
+	)).Methods("POST")
+	api.Handle("/user/authorize", makeHandler(ctr.Authorize, WithAuth(true), WithPersonalAccessTokens())).Methods("POST")
 
 	api.Handle("/roles",
 		makeHandler(ctr.ListRoles, WithAuth(true), WithPermission("role:*:read")),
@@ -124,7 +187,8 @@ func NewHandler(settings *settings.Settings, perm_cache *permission_cache.Permis
 
 	// Invitations
 	api.Handle("/invitations",
-		makeHandler(ctr.InviteUser, WithAuth(true), WithPermission("user:*:write")),
+		makeHandler(ctr.InviteUser, WithAuth(true), WithPermission("user:*:write"),
+			WithRateLimit(ByOrg, ratelimit.Rule{Name: "invitations-org", Limit: 100, Window: time.Hour})),
 	).Methods("POST")
 	api.Handle("/invitations",
 		makeHandler(ctr.ListInvitations, WithAuth(true), WithPermission("user:*:read")),
@@ -139,6 +203,28 @@ func NewHandler(settings *settings.Settings, perm_cache *permission_cache.Permis
 		makeHandler(ctr.ResendInvitation, WithAuth(true), WithPermission("user:*:write")),
 	).Methods("POST")
 
+	// Service accounts
+	api.Handle("/service-accounts",
+		makeHandler(ctr.CreateServiceAccount, WithAuth(true), WithPermission("user:*:write")),
+	).Methods("POST")
+	api.Handle("/service-accounts",
+		makeHandler(ctr.ListServiceAccounts, WithAuth(true), WithPermission("user:*:read")),
+	).Methods("GET")
+	api.Handle("/service-accounts/{client_id}/secret",
+		makeHandler(ctr.RotateServiceAccountSecret, WithAuth(true), WithPermission("user:*:write")),
+	).Methods("POST")
+	api.Handle("/service-accounts/{client_id}",
+		makeHandler(ctr.DeleteServiceAccount, WithAuth(true), WithPermission("user:*:write")),
+	).Methods("DELETE")
+
+	// Organization security policy
+	api.Handle("/security-policy",
+		makeHandler(ctr.GetSecurityPolicy, WithAuth(true), WithPermission("user:all:read")),
+	).Methods("GET")
+	api.Handle("/security-policy",
+		makeHandler(ctr.UpdateSecurityPolicy, WithAuth(true), WithPermission("user:all:update")),
+	).Methods("PUT")
+
 	// Master data
 	api.Handle("/master-data/resources",
 		makeHandler(ctr.GetResources, WithAuth(true), WithPermission("masterdata:*:read")),
@@ -159,11 +245,39 @@ func NewHandler(settings *settings.Settings, perm_cache *permission_cache.Permis
 
 	api.Handle("/webauthn/register/begin", makeHandler(ctr.BeginWebAuthnRegistration, WithAuth(true))).Methods("POST")
 	api.Handle("/webauthn/register/finish", makeHandler(ctr.FinishWebAuthnRegistration, WithAuth(true))).Methods("POST")
-	api.Handle("/webauthn/login/begin", makeHandler(ctr.BeginWebAuthnLogin)).Methods("POST")
-	api.Handle("/webauthn/login/finish", makeHandler(ctr.FinishWebAuthnLogin)).Methods("POST")
-	api.Handle("/webauthn/credentials", makeHandler(ctr.ListWebAuthnCredentialsCtrl, WithAuth(true))).Methods("GET")
+	api.Handle("/webauthn/login/begin", makeHandler(ctr.BeginWebAuthnLogin,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "webauthn-login-ip", Limit: 30, Window: time.Minute}),
+		WithRateLimit(ByUsername, ratelimit.Rule{Name: "webauthn-login-username", Limit: 10, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/webauthn/login/finish", makeHandler(ctr.FinishWebAuthnLogin,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "webauthn-login-finish-ip", Limit: 30, Window: time.Minute}),
+	)).Methods("POST")
+	api.Handle("/webauthn/credentials", makeHandler(ctr.ListWebAuthnCredentialsCtrl, WithAuth(true), WithPersonalAccessTokens())).Methods("GET")
 	api.Handle("/webauthn/credentials/{credential_id:[0-9]+}", makeHandler(ctr.DeleteWebAuthnCredential, WithAuth(true))).Methods("DELETE")
-	api.Handle("/webauthn/check", makeHandler(ctr.HasWebAuthnCredentials)).Methods("GET")
+	api.Handle("/webauthn/check", makeHandler(ctr.HasWebAuthnCredentials,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "webauthn-check-ip", Limit: 30, Window: time.Minute}),
+	)).Methods("GET")
+
+	// OAuth device authorization grant
+	ctr.SetDeviceAuthService(deviceauth.NewService(settings))
+
+	r.Handle("/oauth/device_authorization", makeHandler(ctr.DeviceAuthorization,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "device-authorization-ip", Limit: 30, Window: time.Hour}),
+	)).Methods("POST")
+	api.Handle("/device", makeHandler(ctr.GetDeviceVerification, WithAuth(true))).Methods("GET")
+	api.Handle("/device", makeHandler(ctr.VerifyDevice, WithAuth(true))).Methods("POST")
+
+	// OAuth and OpenID Connect
+	r.Handle("/oauth/authorize", makeHandler(ctr.OAuthAuthorize)).Methods("GET", "POST")
+	r.Handle("/oauth/token", makeHandler(ctr.Token,
+		WithRateLimit(ByIP, ratelimit.Rule{Name: "oauth-token-ip", Limit: 120, Window: time.Minute}),
+	)).Methods("POST")
+	r.Handle("/userinfo", makeHandler(ctr.OIDCUserInfo, WithAuth(true), WithRelyingPartyTokens(), WithPersonalAccessTokens())).Methods("GET", "POST")
+	r.Handle("/.well-known/openid-configuration", makeHandler(ctr.OpenIDConfiguration)).Methods("GET")
+	r.Handle("/oauth/introspect", makeHandler(ctr.Introspect)).Methods("POST")
+
+	// Public keys for verifying issued tokens
+	r.Handle("/.well-known/jwks.json", makeHandler(ctr.JWKS)).Methods("GET")
 
 	// Static file server
 	fileServer := http.FileServer(http.Dir("./profile_pics/"))
@@ -172,7 +286,22 @@ func NewHandler(settings *settings.Settings, perm_cache *permission_cache.Permis
 	r.PathPrefix("/org-logo/").Handler(http.StripPrefix("/org-logo/", logoServer))
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 	// r.Handle("/avatar/", http.StripPrefix("/avatar", fileServer))
-	handler := cors.AllowAll().Handler(r)
+	handler := cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{
+			http.MethodHead,
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		},
+		AllowedHeaders: []string{"*"},
+		// Let browser clients read the token headers set by signin and renew, the
+		// enrollment deadline of organization MFA policies and the rate limits
+		ExposedHeaders: []string{"X-Refresh-Token", "X-Renew-Token", "X-MFA-Enroll-By", "X-Password-Breached",
+			"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
+	}).Handler(r)
 
 	return http.StripPrefix(settings.BaseURL, handler), nil
 
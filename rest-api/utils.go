@@ -13,6 +13,14 @@ type handlerConfig struct {
 	action   string
 	resource string
 	scope    string
+	// personalAccessTokens accepts personal access tokens on a route that checks no
+	// permission. Without it they are only accepted where a permission limits them.
+	personalAccessTokens bool
+	// relyingPartyTokens accepts access tokens issued to relying parties, which are
+	// refused on every other route
+	relyingPartyTokens bool
+	// rateLimits are checked after authentication and permissions
+	rateLimits []rateLimit
 }
 type HandlerOption func(*handlerConfig)
 
@@ -28,6 +36,22 @@ func WithAuth(auth bool) HandlerOption {
 	}
 }
 
+// WithPersonalAccessTokens lets personal access tokens call a route that checks no
+// permission, for routes that only read the account of the token's owner
+func WithPersonalAccessTokens() HandlerOption {
+	return func(c *handlerConfig) {
+		c.personalAccessTokens = true
+	}
+}
+
+// WithRelyingPartyTokens lets relying parties call the route with the access
+// tokens issued to them
+func WithRelyingPartyTokens() HandlerOption {
+	return func(c *handlerConfig) {
+		c.relyingPartyTokens = true
+	}
+}
+
 func WithPermission(permStr string) HandlerOption {
 	return func(c *handlerConfig) {
 		resource, scope, action, err := parsePermissionString(permStr)
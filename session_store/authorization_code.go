@@ -0,0 +1,70 @@
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	AuthorizationCodePrefix = "authorization-code:"
+	// AuthorizationCodeLifetime is how long an authorization code can be exchanged
+	AuthorizationCodeLifetime = time.Minute
+)
+
+// ErrAuthorizationCodeInvalid is returned for unknown, expired or already used codes
+var ErrAuthorizationCodeInvalid = errors.New("invalid authorization code")
+
+// AuthorizationCode is what an OpenID Connect authorization code stands for
+type AuthorizationCode struct {
+	ClientID            string    `json:"clientId"`
+	UserID              string    `json:"userId"`
+	RedirectURI         string    `json:"redirectUri"`
+	Scope               string    `json:"scope"`
+	Nonce               string    `json:"nonce,omitempty"`
+	CodeChallenge       string    `json:"codeChallenge"`
+	CodeChallengeMethod string    `json:"codeChallengeMethod"`
+	AuthTime            time.Time `json:"authTime"`
+}
+
+// StoreAuthorizationCode saves the grant and returns the single use code for it
+func (s *SessionStore) StoreAuthorizationCode(grant *AuthorizationCode) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	grantJSON, err := json.Marshal(grant)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s%s", AuthorizationCodePrefix, hashRefreshToken(code))
+	if err := s.client.Set(s.ctx, key, grantJSON, AuthorizationCodeLifetime).Err(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ConsumeAuthorizationCode returns the grant of a code and deletes it, so that each
+// code can be exchanged only once
+func (s *SessionStore) ConsumeAuthorizationCode(code string) (*AuthorizationCode, error) {
+	key := fmt.Sprintf("%s%s", AuthorizationCodePrefix, hashRefreshToken(code))
+	grantJSON, err := s.client.GetDel(s.ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrAuthorizationCodeInvalid
+		}
+		return nil, err
+	}
+	var grant AuthorizationCode
+	if err := json.Unmarshal([]byte(grantJSON), &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
@@ -0,0 +1,46 @@
+package sessionstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	CaptchaFailuresPrefix = "captcha-failures:"
+	// CaptchaFailureWindow is how long the failed attempts of an address count
+	// towards asking it for a CAPTCHA
+	CaptchaFailureWindow = time.Hour
+)
+
+// CaptchaFailures returns how many failed attempts at the endpoint the address made
+// within CaptchaFailureWindow
+func (s *SessionStore) CaptchaFailures(endpoint, ip string) (int64, error) {
+	failures, err := s.client.Get(s.ctx, fmt.Sprintf("%s%s:%s", CaptchaFailuresPrefix, endpoint, ip)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return failures, err
+}
+
+// RecordCaptchaFailure counts a failed attempt at the endpoint from the address
+func (s *SessionStore) RecordCaptchaFailure(endpoint, ip string) error {
+	_, err := s.countInWindow(fmt.Sprintf("%s%s:%s", CaptchaFailuresPrefix, endpoint, ip), CaptchaFailureWindow)
+	return err
+}
+
+// countInWindow increments the counter at key, which starts over window after its
+// first increment, and returns the new count
+func (s *SessionStore) countInWindow(key string, window time.Duration) (int64, error) {
+	count, err := s.client.Incr(s.ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := s.client.Expire(s.ctx, key, window).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
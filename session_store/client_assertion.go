@@ -0,0 +1,20 @@
+package sessionstore
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ClientAssertionPrefix = "client-assertion:"
+)
+
+// ClaimAssertionID records the jti of a client assertion until it expires. It returns
+// false when the assertion was already used, so that captured assertions cannot be replayed.
+func (s *SessionStore) ClaimAssertionID(clientID, jti string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return s.client.SetNX(s.ctx, fmt.Sprintf("%s%s:%s", ClientAssertionPrefix, clientID, jti), 1, ttl).Result()
+}
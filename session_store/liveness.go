@@ -0,0 +1,83 @@
+package sessionstore
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionStaleness is how long a session confirmed live in Redis is trusted
+	// without asking Redis again
+	DefaultSessionStaleness = 5 * time.Second
+	// livenessSweepSize is the cache size above which expired entries are swept on insert
+	livenessSweepSize = 10000
+)
+
+// livenessCache remembers sessions recently confirmed live so that authenticated
+// requests do not all hit Redis. Revocations made through this store evict the
+// entry immediately; revocations made elsewhere are seen after the staleness window.
+type livenessCache struct {
+	mu        sync.Mutex
+	staleness time.Duration
+	entries   map[string]time.Time
+}
+
+func newLivenessCache(staleness time.Duration) *livenessCache {
+	if staleness == 0 {
+		staleness = DefaultSessionStaleness
+	}
+	return &livenessCache{staleness: staleness, entries: map[string]time.Time{}}
+}
+
+func (c *livenessCache) fresh(sessionID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.entries[sessionID]
+	return ok && time.Now().Before(expires)
+}
+
+func (c *livenessCache) add(sessionID string) {
+	if c.staleness < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= livenessSweepSize {
+		for id, expires := range c.entries {
+			if !now.Before(expires) {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[sessionID] = now.Add(c.staleness)
+}
+
+func (c *livenessCache) evict(sessionIDs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range sessionIDs {
+		delete(c.entries, id)
+	}
+}
+
+// CheckSession reports whether the session is still live. Results are cached locally
+// for the staleness window, use ValidateSession to always consult Redis.
+func (s *SessionStore) CheckSession(sessionID string) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+	if s.liveness.fresh(sessionID) {
+		return true, nil
+	}
+	valid, _, err := s.ValidateSession(sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return false, nil
+	}
+	if err != nil || !valid {
+		return false, err
+	}
+	s.liveness.add(sessionID)
+	return true, nil
+}
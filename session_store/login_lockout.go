@@ -0,0 +1,68 @@
+package sessionstore
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	LoginFailuresPrefix = "login-failures:"
+	LoginLockoutPrefix  = "login-lockout:"
+	// MaxLoginFailures is how many wrong passwords in a row lock an account
+	MaxLoginFailures = 5
+	// LoginLockoutDuration is how long the first lockout lasts. Each failure after
+	// a lockout locks the account again for twice as long.
+	LoginLockoutDuration = time.Minute
+	// MaxLoginLockoutDuration caps the lockouts
+	MaxLoginLockoutDuration = time.Hour
+	// LoginFailureWindow is how long failures are remembered after the last one
+	LoginFailureWindow = 24 * time.Hour
+)
+
+// LoginLockedUntil returns when the lockout of the account ends, the zero time when
+// it is not locked
+func (s *SessionStore) LoginLockedUntil(userID string) (time.Time, error) {
+	until, err := s.client.Get(s.ctx, fmt.Sprintf("%s%s", LoginLockoutPrefix, userID)).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return time.Unix(until, 0), nil
+}
+
+// RecordLoginFailure counts a wrong password for the account and locks it once
+// there were MaxLoginFailures in a row. It returns the failures so far and how
+// long the account is now locked, zero when this failure did not lock it.
+func (s *SessionStore) RecordLoginFailure(userID string) (int64, time.Duration, error) {
+	key := fmt.Sprintf("%s%s", LoginFailuresPrefix, userID)
+	failures, err := s.client.Incr(s.ctx, key).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := s.client.Expire(s.ctx, key, LoginFailureWindow).Err(); err != nil {
+		return 0, 0, err
+	}
+	if failures < MaxLoginFailures {
+		return failures, 0, nil
+	}
+	lockout := MaxLoginLockoutDuration
+	if doublings := failures - MaxLoginFailures; doublings < 16 {
+		lockout = min(LoginLockoutDuration<<doublings, MaxLoginLockoutDuration)
+	}
+	until := time.Now().Add(lockout)
+	err = s.client.Set(s.ctx, fmt.Sprintf("%s%s", LoginLockoutPrefix, userID), strconv.FormatInt(until.Unix(), 10), lockout).Err()
+	if err != nil {
+		return 0, 0, err
+	}
+	return failures, lockout, nil
+}
+
+// ResetLoginFailures forgets the failures of the account and ends its lockout
+func (s *SessionStore) ResetLoginFailures(userID string) error {
+	return s.client.Del(s.ctx, fmt.Sprintf("%s%s", LoginFailuresPrefix, userID), fmt.Sprintf("%s%s", LoginLockoutPrefix, userID)).Err()
+}
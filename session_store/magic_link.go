@@ -0,0 +1,78 @@
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	MagicLinkPrefix      = "magic-link:"
+	MagicLinkEmailPrefix = "magic-link-email:"
+	// MagicLinkLifetime is how long a sign-in link can be used
+	MagicLinkLifetime = 15 * time.Minute
+	// MagicLinkResendInterval is how long an address waits before another link is sent
+	MagicLinkResendInterval = time.Minute
+)
+
+// ErrMagicLinkInvalid is returned for unknown, expired or already used sign-in links
+var ErrMagicLinkInvalid = errors.New("invalid or expired sign-in link")
+
+// MagicLink is a sign-in by email waiting for its link to be opened
+type MagicLink struct {
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// StoreMagicLink saves the sign-in and returns the single use token of its link
+func (s *SessionStore) StoreMagicLink(link *MagicLink) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	link.CreatedAt = time.Now()
+	linkJSON, err := json.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s%s", MagicLinkPrefix, hashRefreshToken(token))
+	if err := s.client.Set(s.ctx, key, linkJSON, MagicLinkLifetime).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeMagicLink returns the sign-in of a token and deletes it, so that each link
+// creates at most one session
+func (s *SessionStore) ConsumeMagicLink(token string) (*MagicLink, error) {
+	key := fmt.Sprintf("%s%s", MagicLinkPrefix, hashRefreshToken(token))
+	linkJSON, err := s.client.GetDel(s.ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, err
+	}
+	var link MagicLink
+	if err := json.Unmarshal([]byte(linkJSON), &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// AllowMagicLinkEmail returns false when the address was sent a sign-in link within
+// MagicLinkResendInterval. Addresses are counted whether or not they have an account,
+// so that the emails sent do not tell which accounts exist.
+func (s *SessionStore) AllowMagicLinkEmail(email string) (bool, error) {
+	emailKey := fmt.Sprintf("%s%s", MagicLinkEmailPrefix, strings.ToLower(strings.TrimSpace(email)))
+	return s.client.SetNX(s.ctx, emailKey, 1, MagicLinkResendInterval).Result()
+}
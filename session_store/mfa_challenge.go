@@ -0,0 +1,107 @@
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	MFAChallengePrefix         = "mfa-challenge:"
+	MFAChallengeAttemptsPrefix = "mfa-challenge-attempts:"
+	// MFAChallengeLifetime is how long the user has to enter the second factor
+	MFAChallengeLifetime = 5 * time.Minute
+	// MaxMFAAttempts is how many wrong codes end a challenge
+	MaxMFAAttempts = 5
+)
+
+// ErrMFAChallengeInvalid is returned for unknown, expired or already used challenges
+var ErrMFAChallengeInvalid = errors.New("invalid or expired MFA challenge")
+
+// MFAChallenge is a sign-in that passed the password check and waits for the
+// second factor
+type MFAChallenge struct {
+	UserID    string    `json:"userId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// StoreMFAChallenge saves the challenge and returns the token the client completes it with
+func (s *SessionStore) StoreMFAChallenge(challenge *MFAChallenge) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	challenge.CreatedAt = time.Now()
+	challengeJSON, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s%s", MFAChallengePrefix, hashRefreshToken(token))
+	if err := s.client.Set(s.ctx, key, challengeJSON, MFAChallengeLifetime).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetMFAChallenge returns the challenge of a token without using it up
+func (s *SessionStore) GetMFAChallenge(token string) (*MFAChallenge, error) {
+	key := fmt.Sprintf("%s%s", MFAChallengePrefix, hashRefreshToken(token))
+	challengeJSON, err := s.client.Get(s.ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrMFAChallengeInvalid
+		}
+		return nil, err
+	}
+	var challenge MFAChallenge
+	if err := json.Unmarshal([]byte(challengeJSON), &challenge); err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// FailMFAChallenge counts a wrong code and drops the challenge once MaxMFAAttempts
+// is reached, so that codes cannot be guessed within its lifetime
+func (s *SessionStore) FailMFAChallenge(token string) error {
+	hash := hashRefreshToken(token)
+	attemptsKey := fmt.Sprintf("%s%s", MFAChallengeAttemptsPrefix, hash)
+	attempts, err := s.client.Incr(s.ctx, attemptsKey).Result()
+	if err != nil {
+		return err
+	}
+	if attempts == 1 {
+		if err := s.client.Expire(s.ctx, attemptsKey, MFAChallengeLifetime).Err(); err != nil {
+			return err
+		}
+	}
+	if attempts >= MaxMFAAttempts {
+		return s.client.Del(s.ctx, fmt.Sprintf("%s%s", MFAChallengePrefix, hash), attemptsKey).Err()
+	}
+	return nil
+}
+
+// ConsumeMFAChallenge deletes the challenge of a completed sign-in, so that each
+// challenge creates at most one session
+func (s *SessionStore) ConsumeMFAChallenge(token string) (*MFAChallenge, error) {
+	hash := hashRefreshToken(token)
+	challengeJSON, err := s.client.GetDel(s.ctx, fmt.Sprintf("%s%s", MFAChallengePrefix, hash)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrMFAChallengeInvalid
+		}
+		return nil, err
+	}
+	s.client.Del(s.ctx, fmt.Sprintf("%s%s", MFAChallengeAttemptsPrefix, hash))
+	var challenge MFAChallenge
+	if err := json.Unmarshal([]byte(challengeJSON), &challenge); err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
@@ -0,0 +1,159 @@
+package sessionstore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RefreshTokenPrefix = "refresh-token:"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or orphaned refresh tokens
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The owning session is revoked before this error is returned.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// IssueRefreshToken generates a new refresh token for the session. The new token
+// replaces any previously issued one, which from then on counts as reused.
+func (s *SessionStore) IssueRefreshToken(sessionID string) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	sessionKey := fmt.Sprintf("%s%s", SessionKeyPrefix, sessionID)
+	err = s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+		sessionData, ttl, err := s.readSession(tx, sessionKey)
+		if err != nil {
+			return err
+		}
+		sessionData.RefreshTokenHash = hash
+		return s.storeRefreshToken(tx, sessionID, sessionData, hash, ttl)
+	}, sessionKey)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one. The session the token
+// belongs to is returned along with the replacement token. Presenting a token that
+// has already been rotated revokes the whole session.
+func (s *SessionStore) RotateRefreshToken(refreshToken string) (string, *SessionData, string, error) {
+	hash := hashRefreshToken(refreshToken)
+
+	// Resolve the session the token was issued for
+	sessionID, err := s.client.Get(s.ctx, fmt.Sprintf("%s%s", RefreshTokenPrefix, hash)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil, "", ErrRefreshTokenInvalid
+		}
+		return "", nil, "", err
+	}
+
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	var sessionData *SessionData
+	sessionKey := fmt.Sprintf("%s%s", SessionKeyPrefix, sessionID)
+	err = s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+		var ttl time.Duration
+		var err error
+		sessionData, ttl, err = s.readSession(tx, sessionKey)
+		if err != nil {
+			return err
+		}
+		if sessionData.RefreshTokenHash != hash {
+			return ErrRefreshTokenReused
+		}
+		sessionData.RefreshTokenHash = newHash
+		sessionData.LastSeen = time.Now()
+		return s.storeRefreshToken(tx, sessionID, sessionData, newHash, ttl)
+	}, sessionKey)
+
+	switch {
+	case errors.Is(err, ErrRefreshTokenReused):
+		// An old token came back, assume it was stolen and kill the session
+		if revokeErr := s.RevokeSession(sessionID); revokeErr != nil {
+			return "", nil, "", revokeErr
+		}
+		return "", nil, "", ErrRefreshTokenReused
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, redis.TxFailedErr):
+		return "", nil, "", ErrRefreshTokenInvalid
+	case err != nil:
+		return "", nil, "", err
+	}
+
+	return sessionID, sessionData, newToken, nil
+}
+
+// readSession loads session data and its remaining TTL inside a watched transaction
+func (s *SessionStore) readSession(tx *redis.Tx, sessionKey string) (*SessionData, time.Duration, error) {
+	sessionJSON, err := tx.Get(s.ctx, sessionKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, 0, ErrSessionNotFound
+		}
+		return nil, 0, err
+	}
+	ttl, err := tx.TTL(s.ctx, sessionKey).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if ttl <= 0 {
+		return nil, 0, ErrSessionNotFound
+	}
+
+	var sessionData SessionData
+	if err := json.Unmarshal([]byte(sessionJSON), &sessionData); err != nil {
+		return nil, 0, err
+	}
+	return &sessionData, ttl, nil
+}
+
+// storeRefreshToken saves the session with its new refresh token hash. The token
+// lookup key lives as long as the session so that reuse of rotated tokens can be
+// detected until the session expires.
+func (s *SessionStore) storeRefreshToken(tx *redis.Tx, sessionID string, sessionData *SessionData, hash string, ttl time.Duration) error {
+	sessionJSON, err := json.Marshal(sessionData)
+	if err != nil {
+		return err
+	}
+	_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(s.ctx, fmt.Sprintf("%s%s", SessionKeyPrefix, sessionID), sessionJSON, ttl)
+		pipe.Set(s.ctx, fmt.Sprintf("%s%s", RefreshTokenPrefix, hash), sessionID, ttl)
+		return nil
+	})
+	return err
+}
+
+// newRefreshToken returns a random opaque refresh token and its storage hash
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken hashes a refresh token so that only digests are kept in Redis
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -15,10 +15,16 @@ import (
 
 const (
 	MaxSessionsPerUser = 5 // Maximum number of active sessions per user
+	// MaxServiceSessions is the session limit for service accounts, whose jobs often run
+	// many replicas that each request their own token
+	MaxServiceSessions = 100
 	SessionKeyPrefix   = "session:"
 	UserSessionsPrefix = "user-sessions:"
 )
 
+// ErrSessionNotFound is returned when a session does not exist or has expired
+var ErrSessionNotFound = errors.New("session not found")
+
 // SessionData represents the data stored in Redis for each session
 type SessionData struct {
 	UserID    string    `json:"userId"`
@@ -27,12 +33,18 @@ type SessionData struct {
 	IP        string    `json:"ip"`
 	CreatedAt time.Time `json:"createdAt"`
 	LastSeen  time.Time `json:"lastSeen"`
+	// RefreshTokenHash is the digest of the only refresh token currently accepted for this session
+	RefreshTokenHash string `json:"refreshTokenHash,omitempty"`
+	// ClientID and Scope are set for sessions created for an OpenID Connect relying party
+	ClientID string `json:"clientId,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 }
 
 // SessionStore manages user sessions using Redis
 type SessionStore struct {
-	client *redis.Client
-	ctx    context.Context
+	client   *redis.Client
+	ctx      context.Context
+	liveness *livenessCache
 }
 
 // NewSessionStore creates a new session store with the provided settings
@@ -45,25 +57,47 @@ func NewSessionStore(settings *settings.Settings) *SessionStore {
 	})
 
 	return &SessionStore{
-		client: client,
-		ctx:    context.Background(),
+		client:   client,
+		ctx:      context.Background(),
+		liveness: newLivenessCache(settings.SessionStaleness),
 	}
 }
 
 // CreateSession creates a new session for a user and stores it in Redis
 func (s *SessionStore) CreateSession(userID, username, userAgent, ip string, expiresIn time.Duration) (string, error) {
-	// Generate a new session ID
-	sessionID := uuid.New().String()
+	return s.CreateSessionWithLimit(userID, username, userAgent, ip, expiresIn, MaxSessionsPerUser)
+}
 
-	// Create session data
-	sessionData := SessionData{
+// CreateSessionWithLimit creates a new session, evicting the oldest one when the user
+// has more than maxSessions
+func (s *SessionStore) CreateSessionWithLimit(userID, username, userAgent, ip string, expiresIn time.Duration, maxSessions int64) (string, error) {
+	return s.createSession(SessionData{
 		UserID:    userID,
 		Username:  username,
 		UserAgent: userAgent,
 		IP:        ip,
-		CreatedAt: time.Now(),
-		LastSeen:  time.Now(),
-	}
+	}, expiresIn, maxSessions)
+}
+
+// CreateClientSession creates a session for tokens issued to an OpenID Connect
+// relying party, remembering the client and the granted scope
+func (s *SessionStore) CreateClientSession(userID, username, userAgent, ip, clientID, scope string, expiresIn time.Duration) (string, error) {
+	return s.createSession(SessionData{
+		UserID:    userID,
+		Username:  username,
+		UserAgent: userAgent,
+		IP:        ip,
+		ClientID:  clientID,
+		Scope:     scope,
+	}, expiresIn, MaxSessionsPerUser)
+}
+
+func (s *SessionStore) createSession(sessionData SessionData, expiresIn time.Duration, maxSessions int64) (string, error) {
+	// Generate a new session ID
+	sessionID := uuid.New().String()
+	userID := sessionData.UserID
+	sessionData.CreatedAt = time.Now()
+	sessionData.LastSeen = time.Now()
 
 	// Serialize session data
 	sessionJSON, err := json.Marshal(sessionData)
@@ -94,7 +128,7 @@ func (s *SessionStore) CreateSession(userID, username, userAgent, ip string, exp
 
 	// If user has too many sessions, remove the oldest one
 	count := countCmd.Val()
-	if count > MaxSessionsPerUser {
+	if count > maxSessions {
 		// Get the oldest session
 		oldestSessions, err := s.client.ZRangeWithScores(s.ctx, userSessionsKey, 0, 0).Result()
 		if err != nil {
@@ -112,6 +146,7 @@ func (s *SessionStore) CreateSession(userID, username, userAgent, ip string, exp
 			if err != nil {
 				return "", err
 			}
+			s.liveness.evict(oldestSessionID)
 		}
 	}
 
@@ -139,7 +174,7 @@ func (s *SessionStore) GetSession(sessionID string) (*SessionData, error) {
 	sessionJSON, err := s.client.Get(s.ctx, sessionKey).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, errors.New("session not found")
+			return nil, ErrSessionNotFound
 		}
 		return nil, err
 	}
@@ -156,27 +191,40 @@ func (s *SessionStore) GetSession(sessionID string) (*SessionData, error) {
 
 // ValidateSession checks if a session is valid and updates last seen time
 func (s *SessionStore) ValidateSession(sessionID string) (bool, *SessionData, error) {
-	sessionData, err := s.GetSession(sessionID)
-	if err != nil {
-		return false, nil, err
-	}
+	var sessionData *SessionData
+	sessionKey := fmt.Sprintf("%s%s", SessionKeyPrefix, sessionID)
+	// Watch the key so that a concurrent refresh token rotation is not overwritten
+	err := s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+		var ttl time.Duration
+		var err error
+		sessionData, ttl, err = s.readSession(tx, sessionKey)
+		if err != nil {
+			return err
+		}
 
-	// Update last seen time
-	sessionData.LastSeen = time.Now()
-	sessionJSON, err := json.Marshal(sessionData)
-	if err != nil {
-		return false, nil, err
-	}
+		// Update last seen time
+		sessionData.LastSeen = time.Now()
+		sessionJSON, err := json.Marshal(sessionData)
+		if err != nil {
+			return err
+		}
 
-	// Get the TTL of the existing session
-	sessionKey := fmt.Sprintf("%s%s", SessionKeyPrefix, sessionID)
-	ttl, err := s.client.TTL(s.ctx, sessionKey).Result()
-	if err != nil {
-		return false, nil, err
+		// Update the session with the new last seen time but keep the same TTL
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(s.ctx, sessionKey, sessionJSON, ttl)
+			return nil
+		})
+		return err
+	}, sessionKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		// The session was written concurrently; skip the last seen update but make sure
+		// it was not revoked in the meantime
+		sessionData, err = s.GetSession(sessionID)
+		if err != nil {
+			return false, nil, err
+		}
+		return true, sessionData, nil
 	}
-
-	// Update the session with the new last seen time but keep the same TTL
-	err = s.client.Set(s.ctx, sessionKey, sessionJSON, ttl).Err()
 	if err != nil {
 		return false, nil, err
 	}
@@ -204,6 +252,7 @@ func (s *SessionStore) RevokeSession(sessionID string) error {
 	pipe.ZRem(s.ctx, userSessionsKey, sessionID)
 
 	_, err = pipe.Exec(s.ctx)
+	s.liveness.evict(sessionID)
 	return err
 }
 
@@ -226,6 +275,7 @@ func (s *SessionStore) RevokeAllUserSessions(userID string, exceptSessionID stri
 			sessionKey := fmt.Sprintf("%s%s", SessionKeyPrefix, sessionID)
 			pipe.Del(s.ctx, sessionKey)
 			pipe.ZRem(s.ctx, userSessionsKey, sessionID)
+			s.liveness.evict(sessionID)
 		}
 	}
 
@@ -2,9 +2,12 @@ package settings
 
 import (
 	"crypto/rand"
+	"fmt"
+	"net/netip"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -46,13 +49,29 @@ type UserInfo struct {
 	Username string `json:"username"`
 
 	Roles []UserOrgRole `json:"roles"`
+	// Permissions restricts the user to these resource:scope:action strings on top of
+	// the roles, as set by personal access tokens. Empty means no restriction.
+	Permissions []string `json:"permissions,omitempty"`
 }
 
 type AuthToken struct {
 	User UserInfo `json:"user"`
+	// PersonalAccessToken is set when the claims were resolved from a personal access
+	// token rather than a signed JWT; the ID is then the token id, not a session
+	PersonalAccessToken bool `json:"-"`
+	// ClientID is the relying party an access token was issued to, also its audience,
+	// and Scope what the user granted it. Both are empty for first party tokens.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// RelyingParty reports whether the token was issued to a relying party rather than
+// to the first party apps
+func (t *AuthToken) RelyingParty() bool {
+	return t.ClientID != "" || len(t.Audience) > 0
+}
+
 // Server specific settings.
 type Settings struct {
 	SecretKey       string   `json:"key" mapstructure:"key"`
@@ -82,6 +101,135 @@ type Settings struct {
 	WebAuthnRPID    string   `json:"webAuthnRPID" mapstructure:"webAuthnRPID"`
 	WebAuthnRPName  string   `json:"webAuthnRPName" mapstructure:"webAuthnRPName"`
 	WebAuthnOrigins []string `json:"webAuthnOrigins" mapstructure:"webAuthnOrigins"`
+	// SessionStaleness is how long a session checked against Redis is trusted locally.
+	// Zero uses the default, a negative value checks Redis on every request.
+	SessionStaleness time.Duration `json:"sessionStaleness"`
+	// SigningKeysSecret encrypts the private keys of the signing_keys table, 32 random
+	// bytes in base64. Required.
+	SigningKeysSecret string `json:"signingKeysSecret"`
+	// Issuer is the external URL of the service and its OpenID Connect issuer, which
+	// ID tokens carry. Required.
+	Issuer string `json:"issuer"`
+	// OIDCLoginURL is where /oauth/authorize sends users who are not signed in, with
+	// the authorization request URL in the return_to query parameter. Empty uses
+	// baseHost + "/signin".
+	OIDCLoginURL string `json:"oidcLoginURL" mapstructure:"oidcLoginURL"`
+	// DeviceVerificationURL is the page where users enter the code shown by a device
+	// signing in with the device authorization grant. Empty uses baseHost + "/device".
+	DeviceVerificationURL string `json:"deviceVerificationURL" mapstructure:"deviceVerificationURL"`
+	// RequireVerifiedEmail keeps users who have not verified their email address from
+	// signing in ("signin") or from creating organizations ("organization"). Empty
+	// requires nothing.
+	RequireVerifiedEmail string `json:"requireVerifiedEmail" mapstructure:"requireVerifiedEmail"`
+	// Development allows providers that record messages instead of sending them
+	Development bool `json:"development" mapstructure:"development"`
+	// SMSProvider sends text messages: "log" records them, in development only. Empty
+	// sends none, phone verification then fails until a provider is installed.
+	SMSProvider string `json:"smsProvider" mapstructure:"smsProvider"`
+	// SMSLogFile is where the development SMS provider appends the messages it would
+	// send. Empty only logs that a message was sent, not its text.
+	SMSLogFile string `json:"smsLogFile" mapstructure:"smsLogFile"`
+	// PasswordResetLifetime is how long a password reset link can be used. Zero uses
+	// the default of one hour.
+	PasswordResetLifetime time.Duration `json:"passwordResetLifetime" mapstructure:"passwordResetLifetime"`
+	// BreachedPasswordsFile is a sorted SHA-1 or NTLM hash file in the Have I Been
+	// Pwned download format that new passwords are checked against. Empty turns the
+	// check off.
+	BreachedPasswordsFile string `json:"breachedPasswordsFile" mapstructure:"breachedPasswordsFile"`
+	// BreachedPasswordsFormat is "sha1" or "ntlm". Empty detects it from the file.
+	BreachedPasswordsFormat string `json:"breachedPasswordsFormat" mapstructure:"breachedPasswordsFormat"`
+	// BreachedPasswordsAction is what happens to passwords found in the file, "reject"
+	// or "warn". Empty rejects them.
+	BreachedPasswordsAction string `json:"breachedPasswordsAction" mapstructure:"breachedPasswordsAction"`
+	// Argon2Time, Argon2MemoryKiB and Argon2Threads are the argon2id parameters new
+	// password hashes are made with. Zero values use the defaults of 3 passes over
+	// 64 MiB with 4 threads. Stored hashes with other parameters are replaced at the
+	// next sign-in.
+	Argon2Time      uint32 `json:"argon2Time" mapstructure:"argon2Time"`
+	Argon2MemoryKiB uint32 `json:"argon2MemoryKiB" mapstructure:"argon2MemoryKiB"`
+	Argon2Threads   uint8  `json:"argon2Threads" mapstructure:"argon2Threads"`
+	// Argon2MaxConcurrent is how many argon2id hashes are computed at the same time,
+	// each taking Argon2MemoryKiB. Zero uses the number of CPUs.
+	Argon2MaxConcurrent int `json:"argon2MaxConcurrent" mapstructure:"argon2MaxConcurrent"`
+	// NotifyAccountLockout emails account owners when too many wrong passwords lock
+	// their account
+	NotifyAccountLockout bool `json:"notifyAccountLockout" mapstructure:"notifyAccountLockout"`
+	// RateLimits overrides the limits of rate limited endpoints by rule name
+	RateLimits map[string]RateLimit `json:"rateLimits" mapstructure:"rateLimits"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies in
+	// front of the service. Client addresses are only taken from X-Forwarded-For
+	// when the request comes from one of them; empty uses the peer address.
+	TrustedProxies []string `json:"trustedProxies" mapstructure:"trustedProxies"`
+	// DisableRateLimits lets every request through, for development and tests
+	DisableRateLimits bool `json:"disableRateLimits" mapstructure:"disableRateLimits"`
+	// CaptchaProvider verifies CAPTCHA tokens: "recaptcha", "hcaptcha" or
+	// "turnstile". "noop" accepts every token, for development. Empty is only
+	// allowed without CaptchaEndpoints.
+	CaptchaProvider string `json:"captchaProvider" mapstructure:"captchaProvider"`
+	// CaptchaSiteKey is the public key of the widget, returned to clients asked for
+	// a CAPTCHA
+	CaptchaSiteKey string `json:"captchaSiteKey" mapstructure:"captchaSiteKey"`
+	CaptchaSecret  string `json:"captchaSecret" mapstructure:"captchaSecret"`
+	// CaptchaMinScore is the lowest reCAPTCHA v3 score accepted. Zero accepts any.
+	CaptchaMinScore float64 `json:"captchaMinScore" mapstructure:"captchaMinScore"`
+	// CaptchaEndpoints asks for a CAPTCHA on "signin", "signup" and "reset". The value
+	// is how many failed attempts from an address within an hour go through without
+	// one, zero asks for one every time.
+	CaptchaEndpoints map[string]int `json:"captchaEndpoints" mapstructure:"captchaEndpoints"`
+	// GeoIPDatabase is a MaxMind DB file, such as GeoLite2-City.mmdb, used to tell
+	// logins from new countries and impossible travel. Empty only compares devices.
+	GeoIPDatabase string `json:"geoIPDatabase" mapstructure:"geoIPDatabase"`
+	// LoginRiskStepUp asks users without a second factor to confirm high risk
+	// password sign-ins with a link sent by email
+	LoginRiskStepUp bool `json:"loginRiskStepUp" mapstructure:"loginRiskStepUp"`
+}
+
+// RateLimit overrides a rate limit rule, zero values keep the default of the rule
+type RateLimit struct {
+	Limit  int           `json:"limit" mapstructure:"limit"`
+	Window time.Duration `json:"window" mapstructure:"window"`
+}
+
+// Values of RequireVerifiedEmail
+const (
+	RequireVerifiedEmailSignin       = "signin"
+	RequireVerifiedEmailOrganization = "organization"
+)
+
+// Keys of CaptchaEndpoints
+const (
+	CaptchaSignin = "signin"
+	CaptchaSignup = "signup"
+	CaptchaReset  = "reset"
+)
+
+// Values of BreachedPasswordsAction
+const (
+	BreachedPasswordsReject = "reject"
+	BreachedPasswordsWarn   = "warn"
+)
+
+// TrustedProxyPrefixes parses TrustedProxies, single addresses are taken as
+// ranges of one address
+func (s *Settings) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
 }
 
 // Clean cleans any variables that might need cleaning.
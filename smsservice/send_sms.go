@@ -0,0 +1,117 @@
+package smsservice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"bigbucks/solution/auth/loging"
+	"bigbucks/solution/auth/settings"
+)
+
+// Values of the smsProvider setting
+const (
+	// ProviderLog records messages instead of sending them, for development
+	ProviderLog = "log"
+)
+
+var (
+	// singleton instance
+	instance SMSSender
+	once     sync.Once
+
+	// ErrNoProvider is returned when no SMS provider is configured
+	ErrNoProvider = errors.New("no SMS provider is configured")
+)
+
+// SMSSender interface defines the contract for sending text messages
+type SMSSender interface {
+	SendSMS(toNumber string, message string) error
+}
+
+// LogSMSService is the development provider, it writes messages to the log and,
+// when a file is configured, appends them to it instead of sending them
+type LogSMSService struct {
+	config *settings.Settings
+	mu     sync.Mutex
+}
+
+var _ SMSSender = (*LogSMSService)(nil)
+
+func NewLogSMSService(config *settings.Settings) SMSSender {
+	return &LogSMSService{
+		config: config,
+	}
+}
+
+// SendSMS records the message for the number. The message may carry a code, so it
+// only goes to the file, never to the log.
+func (s *LogSMSService) SendSMS(toNumber string, message string) error {
+	loging.Logger.Infof("SMS to %s recorded by the development provider", toNumber)
+	if s.config == nil || s.config.SMSLogFile == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.config.SMSLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open SMS log file: %w", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), toNumber, message); err != nil {
+		return fmt.Errorf("failed to write SMS log file: %w", err)
+	}
+	return nil
+}
+
+// unavailableSender fails to send every message, when no provider is configured
+type unavailableSender struct{ err error }
+
+// SendSMS fails with the reason no provider is available
+func (u unavailableSender) SendSMS(toNumber string, message string) error {
+	return u.err
+}
+
+// New creates the sender of the smsProvider setting. Empty sends nothing until a
+// provider is installed with SetInstance, and the log provider is refused outside
+// development.
+func New(config *settings.Settings) (SMSSender, error) {
+	switch config.SMSProvider {
+	case "":
+		return unavailableSender{ErrNoProvider}, nil
+	case ProviderLog:
+		if !config.Development {
+			return nil, fmt.Errorf("SMS provider %q only records messages and needs development", ProviderLog)
+		}
+		return NewLogSMSService(config), nil
+	}
+	return nil, fmt.Errorf("unknown SMS provider %q", config.SMSProvider)
+}
+
+// GetInstance returns the singleton instance of the configured SMS provider
+func GetInstance() SMSSender {
+	once.Do(func() {
+		sender, err := New(settings.Current)
+		if err != nil {
+			loging.Logger.Errorln(err)
+			sender = unavailableSender{err}
+		}
+		instance = sender
+	})
+	return instance
+}
+
+// SetInstance replaces the sender used by the package-level functions, for example
+// with a real provider or a fake in tests
+func SetInstance(sender SMSSender) {
+	once.Do(func() {})
+	instance = sender
+}
+
+// SendSMS is a package-level function that uses the singleton instance
+func SendSMS(toNumber string, message string) error {
+	return GetInstance().SendSMS(toNumber, message)
+}
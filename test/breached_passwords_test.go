@@ -0,0 +1,120 @@
+package auth_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"bigbucks/solution/auth/breachedpasswords"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// writeCorpus writes a SHA-1 corpus of the passwords in the HIBP download format
+func writeCorpus(dir string, passwords ...string) string {
+	lines := make([]string, 0, len(passwords))
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(dir, "pwned-passwords-sha1.txt")
+	Ω(os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600)).Should(Succeed())
+	return path
+}
+
+var _ = Describe("Breached Password Corpus", func() {
+	It("Finds every password of a SHA-1 corpus and nothing else", func() {
+		var passwords []string
+		for i := 0; i < 1000; i++ {
+			passwords = append(passwords, fmt.Sprintf("breached-%d", i))
+		}
+		corpus, err := breachedpasswords.Open(writeCorpus(GinkgoT().TempDir(), passwords...), "")
+		Ω(err).Should(BeNil())
+		defer corpus.Close()
+		Ω(corpus.Format()).Should(Equal(breachedpasswords.FormatSHA1))
+
+		for _, password := range passwords {
+			Ω(corpus.Contains(password)).Should(BeTrue(), password)
+		}
+		for _, password := range []string{"", "breached-1000", "not-breached", "BREACHED-1"} {
+			Ω(corpus.Contains(password)).Should(BeFalse(), password)
+		}
+	})
+
+	It("Reads NTLM corpora", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "pwned-passwords-ntlm.txt")
+		// 8846F7EA... is the NTLM hash of "password"
+		content := "0000000000000000000000000000000A:1\r\n8846F7EAEE8FB117AD06BDD830B7586C:3\r\nFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:2\r\n"
+		Ω(os.WriteFile(path, []byte(content), 0o600)).Should(Succeed())
+
+		corpus, err := breachedpasswords.Open(path, "")
+		Ω(err).Should(BeNil())
+		defer corpus.Close()
+		Ω(corpus.Format()).Should(Equal(breachedpasswords.FormatNTLM))
+		Ω(corpus.Contains("password")).Should(BeTrue())
+		Ω(corpus.Contains("Password")).Should(BeFalse())
+
+		_, err = breachedpasswords.Open(path, breachedpasswords.FormatSHA1)
+		Ω(err).Should(MatchError(breachedpasswords.ErrInvalidCorpus))
+	})
+})
+
+var _ = Describe("Breached Password API Tests", Ordered, func() {
+	const breachedPassword = "breached-password-1"
+
+	signup := func(email, password string) *http.Response {
+		jsonData, _ := json.Marshal(map[string]string{
+			"email":     email,
+			"password":  password,
+			"firstName": "Breached",
+			"lastName":  "User",
+		})
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signup", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	BeforeAll(func() {
+		corpus, err := breachedpasswords.Open(writeCorpus(GinkgoT().TempDir(), breachedPassword, "another-one-2"), "")
+		Ω(err).Should(BeNil())
+		breachedpasswords.SetCorpus(corpus)
+		DeferCleanup(func() {
+			breachedpasswords.SetCorpus(nil)
+			corpus.Close()
+		})
+	})
+
+	It("Rejects breached passwords", func() {
+		response := signup("breached@x.com", breachedPassword)
+		Ω(response.StatusCode).Should(Equal(400))
+		var body map[string]map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		Ω(body["errors"]["password"]).Should(ContainSubstring("data breach"))
+	})
+
+	It("Only warns when configured to", func() {
+		previous := settings.Current.BreachedPasswordsAction
+		settings.Current.BreachedPasswordsAction = settings.BreachedPasswordsWarn
+		DeferCleanup(func() { settings.Current.BreachedPasswordsAction = previous })
+
+		response := signup("breached@x.com", breachedPassword)
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(response.Header.Get("X-Password-Breached")).Should(Equal("true"))
+
+		response = signup("clean@x.com", "clean-password-1")
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(response.Header.Get("X-Password-Breached")).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,155 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/captcha"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCaptchaVerifier accepts one token and records the addresses it was asked for
+type fakeCaptchaVerifier struct {
+	token string
+	ips   []string
+}
+
+func (f *fakeCaptchaVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	f.ips = append(f.ips, remoteIP)
+	return token == f.token, nil
+}
+
+var _ = Describe("CAPTCHA Tests", Ordered, func() {
+	var verifier *fakeCaptchaVerifier
+
+	post := func(route string, body interface{}) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1%s", s.URL, route), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	captchaError := func(response *http.Response) actions.CaptchaError {
+		Ω(response.StatusCode).Should(Equal(400))
+		var body actions.CaptchaError
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		return body
+	}
+
+	BeforeAll(func() {
+		verifier = &fakeCaptchaVerifier{token: "solved-token"}
+		captcha.SetInstance(verifier)
+		previousEndpoints, previousSiteKey := settings.Current.CaptchaEndpoints, settings.Current.CaptchaSiteKey
+		settings.Current.CaptchaSiteKey = "site-key"
+		DeferCleanup(func() {
+			settings.Current.CaptchaEndpoints = previousEndpoints
+			settings.Current.CaptchaSiteKey = previousSiteKey
+			captcha.SetInstance(captcha.NoopVerifier{})
+		})
+	})
+
+	It("does not ask for a CAPTCHA on endpoints that are not configured", func() {
+		settings.Current.CaptchaEndpoints = nil
+		response := post("/signin", map[string]string{"username": "captcha-nobody@x.com", "password": "wrong-password"})
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("asks for a CAPTCHA on every sign-in when configured with zero", func() {
+		settings.Current.CaptchaEndpoints = map[string]int{settings.CaptchaSignin: 0}
+
+		body := captchaError(post("/signin", map[string]string{"username": "captcha-nobody@x.com", "password": "wrong-password"}))
+		Ω(body.Code).Should(Equal(actions.CaptchaRequiredCode))
+		Ω(body.SiteKey).Should(Equal("site-key"))
+
+		body = captchaError(post("/signin", map[string]string{"username": "captcha-nobody@x.com", "password": "wrong-password", "recaptcha": "wrong-token"}))
+		Ω(body.Code).Should(Equal(actions.CaptchaInvalidCode))
+		Ω(verifier.ips).Should(ContainElement("127.0.0.1"))
+
+		response := post("/signin", map[string]string{"username": "captcha-nobody@x.com", "password": "wrong-password", "recaptcha": "solved-token"})
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("asks for a CAPTCHA on signups only after failed attempts from the address", func() {
+		settings.Current.CaptchaEndpoints = map[string]int{settings.CaptchaSignup: 2}
+		signup := map[string]string{"email": "captcha@x.com", "password": "1", "firstName": "Captcha", "lastName": "User"}
+
+		Ω(post("/signup", signup).StatusCode).Should(Equal(400))
+		Ω(post("/signup", signup).StatusCode).Should(Equal(400))
+		body := captchaError(post("/signup", signup))
+		Ω(body.Code).Should(Equal(actions.CaptchaRequiredCode))
+
+		signup["password"] = "captcha-user-123"
+		signup["recaptcha"] = "solved-token"
+		response := post("/signup", signup)
+		bodyBytes, _ := io.ReadAll(response.Body)
+		Ω(response.StatusCode).Should(Equal(200), string(bodyBytes))
+	})
+
+	It("counts every password reset request", func() {
+		settings.Current.CaptchaEndpoints = map[string]int{settings.CaptchaReset: 1}
+
+		Ω(post("/user/reset", map[string]string{"email": "nobody@x.com"}).StatusCode).Should(Equal(200))
+		body := captchaError(post("/user/reset", map[string]string{"email": "nobody@x.com"}))
+		Ω(body.Code).Should(Equal(actions.CaptchaRequiredCode))
+		response := post("/user/reset", map[string]string{"email": "nobody@x.com", "recaptcha": "solved-token"})
+		Ω(response.StatusCode).Should(Equal(200))
+	})
+
+	It("needs a provider when endpoints ask for a CAPTCHA", func() {
+		config := settings.Settings{CaptchaEndpoints: map[string]int{settings.CaptchaSignin: 0}}
+		_, err := captcha.New(&config)
+		Ω(err).Should(HaveOccurred())
+
+		config.CaptchaProvider = captcha.ProviderTurnstile
+		_, err = captcha.New(&config)
+		Ω(err).Should(HaveOccurred())
+
+		config.CaptchaSecret = "secret"
+		Ω(captcha.New(&config)).Should(BeAssignableToTypeOf(&captcha.SiteVerifier{}))
+
+		config.CaptchaProvider = captcha.ProviderNoop
+		Ω(captcha.New(&config)).Should(Equal(captcha.NoopVerifier{}))
+	})
+
+	It("verifies tokens with a siteverify endpoint", func() {
+		var form map[string]string
+		score := 0.9
+		provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Ω(r.ParseForm()).Should(Succeed())
+			form = map[string]string{"secret": r.PostForm.Get("secret"), "response": r.PostForm.Get("response"), "remoteip": r.PostForm.Get("remoteip")}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": r.PostForm.Get("response") == "solved-token", "score": score})
+		}))
+		defer provider.Close()
+
+		siteVerifier := captcha.NewReCaptcha("secret", 0.5)
+		siteVerifier.URL = provider.URL
+		solved, err := siteVerifier.Verify(context.Background(), "solved-token", "10.0.0.1")
+		Ω(err).Should(Succeed())
+		Ω(solved).Should(BeTrue())
+		Ω(form).Should(Equal(map[string]string{"secret": "secret", "response": "solved-token", "remoteip": "10.0.0.1"}))
+
+		solved, err = siteVerifier.Verify(context.Background(), "wrong-token", "10.0.0.1")
+		Ω(err).Should(Succeed())
+		Ω(solved).Should(BeFalse())
+
+		score = 0.3
+		solved, err = siteVerifier.Verify(context.Background(), "solved-token", "10.0.0.1")
+		Ω(err).Should(Succeed())
+		Ω(solved).Should(BeFalse())
+
+		provider.Close()
+		_, err = siteVerifier.Verify(context.Background(), "solved-token", "10.0.0.1")
+		Ω(err).Should(HaveOccurred())
+	})
+})
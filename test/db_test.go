@@ -11,6 +11,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"net/http"
@@ -78,12 +79,16 @@ var _ = BeforeSuite(func() {
 	err = models.Dbcon.Create(sampleData).Error
 	Ω(err).To(Succeed())
 	TestUserID = sampleData.ID
-	settings.Current = &settings.Settings{Alg: "ES256", PrivateKey: "ec_private.pem", PublicKey: "ec_public.pem", LogLevel: "info", WebAuthnRPID: "localhost", WebAuthnRPName: "BigBucks Auth"}
+	settings.Current = &settings.Settings{Alg: "ES256", PrivateKey: "ec_private.pem", PublicKey: "ec_public.pem", LogLevel: "info", WebAuthnRPID: "localhost", WebAuthnRPName: "BigBucks Auth",
+		SigningKeysSecret: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")),
+		// Every spec signs in from the same address, the rate limit specs turn them on
+		DisableRateLimits: true}
 	// settings.Current.LoadKeys()
 	handler, err := router.NewHandler(settings.Current, permission_cache.NewPermissionCache(settings.Current), sessionstore.NewSessionStore(settings.Current))
 
 	Ω(err).Should(Succeed())
 	s = httptest.NewServer(handler)
+	settings.Current.Issuer = s.URL
 	c = s.Client()
 	valids.InitializeValidations()
 })
@@ -0,0 +1,144 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bigbucks/solution/auth/actions"
+
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Device Authorization Grant Tests", Ordered, func() {
+	var jwtToken, clientID string
+
+	begin := func() map[string]interface{} {
+		response, err := c.PostForm(fmt.Sprintf("%s/oauth/device_authorization", s.URL), url.Values{
+			"client_id": {clientID},
+			"scope":     {"openid profile"},
+		})
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var body map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		return body
+	}
+
+	poll := func(deviceCode string) (*http.Response, map[string]interface{}) {
+		response, err := c.PostForm(fmt.Sprintf("%s/oauth/token", s.URL), url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"client_id":   {clientID},
+			"device_code": {deviceCode},
+		})
+		Ω(err).Should(BeNil())
+		var body map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&body)
+		return response, body
+	}
+
+	verify := func(userCode string, approve bool) *http.Response {
+		jsonData, _ := json.Marshal(map[string]interface{}{"userCode": userCode, "approve": approve})
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/device", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	BeforeAll(func() {
+		rp, _, code, err := actions.CreateRelyingParty("cli", nil, nil, true, false)
+		Ω(code).Should(Equal(0))
+		Ω(err).Should(BeNil())
+		clientID = rp.ClientID
+
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, _ := c.Do(request)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken = string(bodyBytes)
+	})
+
+	It("Signs in a device once the user approves its code", func() {
+		body := begin()
+		deviceCode := body["device_code"].(string)
+		userCode := body["user_code"].(string)
+		Ω(userCode).Should(MatchRegexp(`^[A-Z]{4}-[A-Z]{4}$`))
+		Ω(body["verification_uri_complete"]).Should(ContainSubstring("user_code=" + userCode))
+		Ω(body["interval"]).Should(BeNumerically("==", 5))
+
+		response, body := poll(deviceCode)
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("authorization_pending"))
+		response, body = poll(deviceCode)
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("slow_down"))
+
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/device?user_code=%s", s.URL, strings.ToLower(userCode)), nil)
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var verification map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&verification)).Should(Succeed())
+		Ω(verification["clientName"]).Should(Equal("cli"))
+
+		Ω(verify(userCode, true).StatusCode).Should(Equal(200))
+		Ω(verify(userCode, true).StatusCode).Should(Equal(404))
+
+		response, body = poll(deviceCode)
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(body["access_token"]).ShouldNot(BeEmpty())
+		Ω(body["refresh_token"]).ShouldNot(BeEmpty())
+		Ω(body["id_token"]).ShouldNot(BeEmpty())
+		claims := jwt.MapClaims{}
+		_, _, err = jwt.NewParser().ParseUnverified(body["access_token"].(string), claims)
+		Ω(err).Should(BeNil())
+		Ω(claims["scope"]).Should(Equal("openid profile"))
+		Ω(claims["client_id"]).Should(Equal(clientID))
+
+		// The device code is single use
+		response, body = poll(deviceCode)
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("expired_token"))
+	})
+
+	It("Tells the device when the user denies it", func() {
+		body := begin()
+		Ω(verify(body["user_code"].(string), false).StatusCode).Should(Equal(200))
+		response, body := poll(body["device_code"].(string))
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("access_denied"))
+	})
+
+	It("Requires a signed-in user to approve", func() {
+		body := begin()
+		jsonData, _ := json.Marshal(map[string]interface{}{"userCode": body["user_code"], "approve": true})
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/device", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Rejects scopes the client may not request", func() {
+		response, err := c.PostForm(fmt.Sprintf("%s/oauth/device_authorization", s.URL), url.Values{
+			"client_id": {clientID},
+			"scope":     {"openid admin"},
+		})
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(400))
+	})
+})
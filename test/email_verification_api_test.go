@@ -0,0 +1,166 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"bigbucks/solution/auth/constants"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeEmailSender records emails instead of sending them. Emails sent in the
+// background are read with sent.
+type fakeEmailSender struct {
+	mu     sync.Mutex
+	to     []string
+	params []any
+}
+
+func (f *fakeEmailSender) SendEmail(toEmail string, templatePath string, params any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.to = append(f.to, toEmail)
+	f.params = append(f.params, params)
+	return nil
+}
+
+// sent returns the parameters of the emails so far
+func (f *fakeEmailSender) sent() []any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]any(nil), f.params...)
+}
+
+var _ = Describe("Email Verification Tests", Ordered, func() {
+	const username = "verify@x.com"
+	const password = "verify123"
+	var sender *fakeEmailSender
+	var firstToken string
+
+	post := func(path string, body interface{}, token string) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1%s", s.URL, path), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if token != "" {
+			request.Header.Set("X-Auth", token)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func(user, pass string) *http.Response {
+		return post("/signin", map[string]string{"username": user, "password": pass}, "")
+	}
+
+	// lastToken returns the token of the last verification link sent
+	lastToken := func() string {
+		Ω(sender.params).ShouldNot(BeEmpty())
+		params := sender.params[len(sender.params)-1].(map[string]interface{})
+		link, err := url.Parse(params["VerificationLink"].(string))
+		Ω(err).Should(BeNil())
+		token := link.Query().Get("token")
+		Ω(token).ShouldNot(BeEmpty())
+		return token
+	}
+
+	requireVerifiedEmail := func(value string) {
+		previous := settings.Current.RequireVerifiedEmail
+		settings.Current.RequireVerifiedEmail = value
+		DeferCleanup(func() { settings.Current.RequireVerifiedEmail = previous })
+	}
+
+	BeforeAll(func() {
+		sender = &fakeEmailSender{}
+		emailservice.SetInstance(sender)
+		DeferCleanup(func() { emailservice.SetInstance(emailservice.NewEmailService(settings.Current)) })
+
+		response := post("/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Verify",
+			"lastName":  "User",
+		}, "")
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(Equal([]string{username}))
+		firstToken = lastToken()
+	})
+
+	It("Blocks signin until the email is verified when required", func() {
+		requireVerifiedEmail(settings.RequireVerifiedEmailSignin)
+		response := signin(username, password)
+		Ω(response.StatusCode).Should(Equal(403))
+		var body map[string]map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		Ω(body["errors"]).Should(HaveKey("email"))
+	})
+
+	It("Throttles resending the link", func() {
+		response := post("/user/verify-email/resend", map[string]string{"email": username}, "")
+		Ω(response.StatusCode).Should(Equal(429))
+		Ω(response.Header.Get("Retry-After")).Should(Equal("60"))
+
+		// Unknown addresses get the same answer as known ones
+		Ω(post("/user/verify-email/resend", map[string]string{"email": "nobody@x.com"}, "").StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(HaveLen(1))
+	})
+
+	It("Replaces the link when it is resent", func() {
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		Ω(models.Dbcon.Model(&models.EmailVerification{}).Where("user_id = ?", user.ID).
+			UpdateColumn("updated_at", time.Now().Add(-2*time.Minute)).Error).Should(Succeed())
+
+		Ω(post("/user/verify-email/resend", map[string]string{"email": username}, "").StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(HaveLen(2))
+		Ω(lastToken()).ShouldNot(Equal(firstToken))
+		Ω(post("/user/verify-email", map[string]string{"token": firstToken}, "").StatusCode).Should(Equal(400))
+	})
+
+	It("Verifies the email with the link", func() {
+		Ω(post("/user/verify-email", map[string]string{"token": "not-a-token"}, "").StatusCode).Should(Equal(400))
+
+		token := lastToken()
+		Ω(post("/user/verify-email", map[string]string{"token": token}, "").StatusCode).Should(Equal(200))
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		Ω(user.EmailVerified).Should(BeTrue())
+		Ω(user.Status).Should(Equal(constants.UserStatusActive))
+
+		// Links work once
+		Ω(post("/user/verify-email", map[string]string{"token": token}, "").StatusCode).Should(Equal(400))
+
+		requireVerifiedEmail(settings.RequireVerifiedEmailSignin)
+		Ω(signin(username, password).StatusCode).Should(Equal(202))
+	})
+
+	It("Blocks creating organizations until the email is verified when required", func() {
+		response := post("/signup", map[string]string{
+			"email":     "unverified@x.com",
+			"password":  "unverified123",
+			"firstName": "Unverified",
+			"lastName":  "User",
+		}, "")
+		Ω(response.StatusCode).Should(Equal(200))
+
+		requireVerifiedEmail(settings.RequireVerifiedEmailOrganization)
+		response = signin("unverified@x.com", "unverified123")
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken := string(bodyBytes)
+
+		response = post("/organizations", map[string]string{"name": "Unverified Corp", "email": "admin@unverified.com"}, jwtToken)
+		Ω(response.StatusCode).Should(Equal(403))
+	})
+})
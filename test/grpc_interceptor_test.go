@@ -0,0 +1,102 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	grpc_auth "bigbucks/solution/auth/grpc-auth"
+	"bigbucks/solution/auth/permission_cache"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeServerStream) Context() context.Context {
+	return f.ctx
+}
+
+var _ = Describe("gRPC Interceptor Tests", Ordered, func() {
+	var server *grpc_auth.Server
+	var jwt string
+
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token))
+	}
+
+	unary := func(ctx context.Context) (interface{}, error) {
+		return server.JWTInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return ctx.Value(grpc_auth.UserValue("userID")), nil
+		})
+	}
+
+	stream := func(ctx context.Context) (interface{}, error) {
+		var userID interface{}
+		err := server.JWTStreamInterceptor(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+			userID = stream.Context().Value(grpc_auth.UserValue("userID"))
+			return nil
+		})
+		return userID, err
+	}
+
+	BeforeAll(func() {
+		// The REST handler revokes sessions through its own store, so skip the local
+		// liveness cache to see revocations immediately
+		cfg := *settings.Current
+		cfg.SessionStaleness = -1
+		store := sessionstore.NewSessionStore(&cfg)
+		server = grpc_auth.NewGRPCServer(&cfg, *permission_cache.NewPermissionCache(settings.Current), *store)
+
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, _ := c.Do(request)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwt = string(bodyBytes)
+	})
+
+	It("Accepts tokens of live sessions", func() {
+		for _, call := range []func(context.Context) (interface{}, error){unary, stream} {
+			userID, err := call(withToken(jwt))
+			Ω(err).Should(BeNil())
+			Ω(userID).Should(Equal(TestUserID))
+		}
+	})
+
+	It("Rejects requests without a token", func() {
+		for _, call := range []func(context.Context) (interface{}, error){unary, stream} {
+			_, err := call(context.Background())
+			Ω(status.Code(err)).Should(Equal(codes.Unauthenticated))
+		}
+	})
+
+	It("Rejects tokens of revoked sessions", func() {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signout", s.URL), nil)
+		request.Header.Set("X-Auth", jwt)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+
+		for _, call := range []func(context.Context) (interface{}, error){unary, stream} {
+			_, err := call(withToken(jwt))
+			Ω(status.Code(err)).Should(Equal(codes.Unauthenticated))
+		}
+	})
+})
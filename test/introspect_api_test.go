@@ -0,0 +1,97 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/models"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Introspection API Tests", Ordered, func() {
+	var jwt string
+	var clientID, clientSecret string
+
+	introspect := func(id, secret, token string) *http.Response {
+		form := url.Values{"token": {token}}
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/oauth/introspect", s.URL), strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth(id, secret)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	decode := func(response *http.Response) map[string]interface{} {
+		var body map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		return body
+	}
+
+	BeforeAll(func() {
+		client, secret, _, err := actions.CreateAPIClient("introspection test", []string{models.ClientScopeIntrospect})
+		Ω(err).Should(BeNil())
+		clientID, clientSecret = client.ClientID, secret
+
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, _ := c.Do(request)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwt = string(bodyBytes)
+	})
+
+	It("Returns the claims of an active token", func() {
+		response := introspect(clientID, clientSecret, jwt)
+		Ω(response.StatusCode).Should(Equal(200))
+		body := decode(response)
+		Ω(body["active"]).Should(BeTrue())
+		Ω(body["sub"]).Should(Equal(TestUserID))
+		Ω(body["username"]).Should(Equal("john@x.com"))
+		Ω(body).Should(HaveKey("sid"))
+		Ω(body).Should(HaveKey("exp"))
+	})
+
+	It("Reports garbage tokens as inactive", func() {
+		response := introspect(clientID, clientSecret, "not.a.token")
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(decode(response)).Should(Equal(map[string]interface{}{"active": false}))
+	})
+
+	It("Rejects bad client credentials", func() {
+		response := introspect(clientID, "wrong-secret", jwt)
+		Ω(response.StatusCode).Should(Equal(401))
+		Ω(decode(response)["error"]).Should(Equal("invalid_client"))
+	})
+
+	It("Rejects clients without the introspect scope", func() {
+		client, secret, _, err := actions.CreateAPIClient("no scope", nil)
+		Ω(err).Should(BeNil())
+		response := introspect(client.ClientID, secret, jwt)
+		Ω(response.StatusCode).Should(Equal(403))
+	})
+
+	It("Reports revoked sessions as inactive", func() {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signout", s.URL), nil)
+		request.Header.Set("X-Auth", jwt)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(BeNumerically("<", 300))
+
+		response = introspect(clientID, clientSecret, jwt)
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(decode(response)["active"]).Should(BeFalse())
+	})
+})
@@ -0,0 +1,138 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/models"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JWKS API Tests", Ordered, func() {
+	signin := func() string {
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return string(bodyBytes)
+	}
+
+	kids := func() []string {
+		response, err := c.Get(fmt.Sprintf("%s/.well-known/jwks.json", s.URL))
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var jwks jwtops.JWKS
+		Ω(json.NewDecoder(response.Body).Decode(&jwks)).Should(Succeed())
+		var ids []string
+		for _, key := range jwks.Keys {
+			ids = append(ids, key.Kid)
+		}
+		return ids
+	}
+
+	tokenKid := func(jwt string) string {
+		_, token, err := jwtops.VerifyJWT(jwt)
+		Ω(err).Should(BeNil())
+		kid, _ := token.Header["kid"].(string)
+		return kid
+	}
+
+	It("Publishes the kid of issued tokens", func() {
+		kid := tokenKid(signin())
+		Ω(kid).ShouldNot(BeEmpty())
+		Ω(kids()).Should(ContainElement(kid))
+	})
+
+	It("Rotates to a promoted key and keeps verifying old tokens", func() {
+		oldToken := signin()
+		oldKid := tokenKid(oldToken)
+
+		key, err := jwtops.GenerateSigningKey("ES256")
+		Ω(err).Should(BeNil())
+		Ω(models.CreateSigningKey(key)).Should(Succeed())
+		jwtops.InvalidateKeyring()
+		Ω(kids()).Should(ContainElement(key.Kid))
+
+		Ω(models.PromoteSigningKey(key.Kid, time.Hour)).Should(Succeed())
+		jwtops.InvalidateKeyring()
+		Ω(tokenKid(signin())).Should(Equal(key.Kid))
+		Ω(tokenKid(oldToken)).Should(Equal(oldKid))
+
+		// The active key is only retired by promoting another one
+		Ω(models.RetireSigningKey(key.Kid, time.Hour)).Should(MatchError(models.ErrRetireActiveKey))
+		Ω(models.PromoteSigningKey(oldKid, time.Hour)).Should(Succeed())
+		jwtops.InvalidateKeyring()
+		Ω(tokenKid(signin())).Should(Equal(oldKid))
+		Ω(kids()).Should(ContainElement(key.Kid))
+	})
+
+	It("Signs with RSA and Ed25519 keys", func() {
+		configured := tokenKid(signin())
+		for _, alg := range []string{"RS256", "PS256", "EdDSA"} {
+			key, err := jwtops.GenerateSigningKey(alg)
+			Ω(err).Should(BeNil())
+			Ω(models.CreateSigningKey(key)).Should(Succeed())
+			Ω(models.PromoteSigningKey(key.Kid, time.Hour)).Should(Succeed())
+			jwtops.InvalidateKeyring()
+
+			_, token, err := jwtops.VerifyJWT(signin())
+			Ω(err).Should(BeNil())
+			Ω(token.Method.Alg()).Should(Equal(alg))
+			Ω(token.Header["kid"]).Should(Equal(key.Kid))
+			Ω(kids()).Should(ContainElement(key.Kid))
+		}
+		Ω(models.PromoteSigningKey(configured, time.Hour)).Should(Succeed())
+		jwtops.InvalidateKeyring()
+	})
+
+	It("Keeps the configured key in the keyring with sealed private keys", func() {
+		configured, err := models.GetSigningKey(tokenKid(signin()))
+		Ω(err).Should(BeNil())
+		Ω(configured.Status).Should(Equal(models.SigningKeyStatusActive))
+
+		keys, err := models.ListSigningKeys()
+		Ω(err).Should(BeNil())
+		for _, key := range keys {
+			Ω(key.PrivateKey).ShouldNot(ContainSubstring("PRIVATE KEY"))
+		}
+	})
+
+	It("Rejects keys that do not match the algorithm", func() {
+		key, err := jwtops.GenerateSigningKey("EdDSA")
+		Ω(err).Should(BeNil())
+		public, err := jwtops.ParsePublicKeyPEM([]byte(key.PublicKey))
+		Ω(err).Should(BeNil())
+		Ω(jwtops.CheckKeyAlg(public, "EdDSA")).Should(Succeed())
+		Ω(jwtops.CheckKeyAlg(public, "ES256")).ShouldNot(Succeed())
+
+		key, err = jwtops.GenerateSigningKey("ES384")
+		Ω(err).Should(BeNil())
+		public, err = jwtops.ParsePublicKeyPEM([]byte(key.PublicKey))
+		Ω(err).Should(BeNil())
+		Ω(jwtops.CheckKeyAlg(public, "ES256")).ShouldNot(Succeed())
+		Ω(jwtops.CheckKeyAlg(public, "RS256")).ShouldNot(Succeed())
+		Ω(jwtops.CheckConfiguredKeys()).Should(Succeed())
+	})
+
+	It("Drops retired keys after the grace period", func() {
+		key, err := jwtops.GenerateSigningKey("ES256")
+		Ω(err).Should(BeNil())
+		Ω(models.CreateSigningKey(key)).Should(Succeed())
+		Ω(models.RetireSigningKey(key.Kid, 0)).Should(Succeed())
+		jwtops.InvalidateKeyring()
+		Ω(kids()).ShouldNot(ContainElement(key.Kid))
+	})
+})
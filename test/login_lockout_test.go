@@ -0,0 +1,154 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/models"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Login Lockout API Tests", Ordered, func() {
+	const username = "lockout@x.com"
+	const password = "lockout-123"
+	var sender *fakeEmailSender
+	var store *sessionstore.SessionStore
+	var jwtToken, orgID, userID string
+
+	send := func(method, route string, body interface{}) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest(method, fmt.Sprintf("%s/api/v1%s", s.URL, route), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if jwtToken != "" {
+			request.Header.Set("X-Auth", jwtToken)
+		}
+		if orgID != "" {
+			request.Header.Set("X-Organization-Id", orgID)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func(password string) *http.Response {
+		return send("POST", "/signin", map[string]string{"username": username, "password": password})
+	}
+
+	token := func() string {
+		response := signin(password)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return string(bodyBytes)
+	}
+
+	lockout := func() *http.Response {
+		for i := 1; i < sessionstore.MaxLoginFailures; i++ {
+			Ω(signin("wrong-password").StatusCode).Should(Equal(401))
+		}
+		return signin("wrong-password")
+	}
+
+	BeforeAll(func() {
+		sender = &fakeEmailSender{}
+		emailservice.SetInstance(sender)
+		store = sessionstore.NewSessionStore(settings.Current)
+		previous := settings.Current.NotifyAccountLockout
+		settings.Current.NotifyAccountLockout = true
+		DeferCleanup(func() {
+			settings.Current.NotifyAccountLockout = previous
+			emailservice.SetInstance(emailservice.NewEmailService(settings.Current))
+		})
+
+		response := send("POST", "/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Lockout",
+			"lastName":  "User",
+		})
+		Ω(response.StatusCode).Should(Equal(200))
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		userID = user.ID
+
+		jwtToken = token()
+		Ω(send("POST", "/organizations", map[string]string{"name": "Lockout Corp", "email": "admin@lockout.com"}).StatusCode).Should(Equal(200))
+		var org models.Organization
+		Ω(models.Dbcon.Where("name = ?", "Lockout Corp").First(&org).Error).Should(Succeed())
+		orgID = org.ID
+		jwtToken = token()
+	})
+
+	AfterAll(func() {
+		Ω(store.ResetLoginFailures(userID)).Should(Succeed())
+		models.Dbcon.Unscoped().Where("user_id = ?", userID).Delete(&models.AuditLog{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.UserOrgRole{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.Role{})
+		models.Dbcon.Unscoped().Where("id = ?", orgID).Delete(&models.Organization{})
+	})
+
+	It("Forgets wrong passwords after a sign-in", func() {
+		for i := 1; i < sessionstore.MaxLoginFailures; i++ {
+			Ω(signin("wrong-password").StatusCode).Should(Equal(401))
+		}
+		Ω(signin(password).StatusCode).Should(Equal(202))
+		Ω(signin("wrong-password").StatusCode).Should(Equal(401))
+		Ω(signin(password).StatusCode).Should(Equal(202))
+	})
+
+	It("Locks the account after too many wrong passwords", func() {
+		sent := len(sender.to)
+		response := lockout()
+		Ω(response.StatusCode).Should(Equal(429))
+		Ω(response.Header.Get("Retry-After")).Should(Equal("60"))
+
+		// The right password does not get through either
+		response = signin(password)
+		Ω(response.StatusCode).Should(Equal(429))
+		Ω(response.Header.Get("Retry-After")).ShouldNot(BeEmpty())
+
+		Ω(sender.to[sent:]).Should(Equal([]string{username}))
+		var audit models.AuditLog
+		Ω(models.Dbcon.Where("user_id = ? AND event = ?", userID, models.AuditEventAccountLocked).First(&audit).Error).Should(Succeed())
+		Ω(audit.ActorID).Should(BeNil())
+	})
+
+	It("Doubles the lockout for every further failure", func() {
+		failures, locked, err := store.RecordLoginFailure(userID)
+		Ω(err).Should(BeNil())
+		Ω(failures).Should(BeNumerically("==", sessionstore.MaxLoginFailures+1))
+		Ω(locked).Should(Equal(2 * sessionstore.LoginLockoutDuration))
+
+		until, err := store.LoginLockedUntil(userID)
+		Ω(err).Should(BeNil())
+		Ω(until).Should(BeTemporally("~", time.Now().Add(2*sessionstore.LoginLockoutDuration), 2*time.Second))
+	})
+
+	It("Lets organization admins unlock members", func() {
+		// The token issued before the lockout still works
+		Ω(send("PUT", "/users/"+userID+"/unlock", nil).StatusCode).Should(Equal(200))
+		Ω(signin(password).StatusCode).Should(Equal(202))
+
+		var audit models.AuditLog
+		Ω(models.Dbcon.Where("user_id = ? AND event = ?", userID, models.AuditEventAccountUnlocked).First(&audit).Error).Should(Succeed())
+		Ω(audit.ActorID).ShouldNot(BeNil())
+		Ω(*audit.ActorID).Should(Equal(userID))
+
+		Ω(send("PUT", "/users/01ARZ3NDEKTSV4RRFFQ69G5FAV/unlock", nil).StatusCode).Should(Equal(404))
+	})
+
+	It("Unlocks any account from the command line action", func() {
+		Ω(lockout().StatusCode).Should(Equal(429))
+		Ω(actions.UnlockAccount(actions.UnlockAccountParams{UserID: userID}, store)).Should(Equal(0))
+		Ω(signin(password).StatusCode).Should(Equal(202))
+	})
+})
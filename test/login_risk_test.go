@@ -0,0 +1,348 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/geoip"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/rest-api/controllers"
+	"bigbucks/solution/auth/rest-api/controllers/types"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func encodeMMDBString(s string) []byte {
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+
+func encodeMMDBDouble(f float64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{0x68}, math.Float64bits(f))
+}
+
+// writeGeoIPDatabase writes a MaxMind DB of IPv4 networks laid out like GeoLite2
+// City: under ::/96 of an IPv6 tree with 28-bit records. Networks must not overlap.
+func writeGeoIPDatabase(dir string, networks map[string]geoip.Location) string {
+	// Records are a node index above zero, zero when empty and -(offset+1) for data
+	type node struct{ records [2]int }
+	nodes := []node{{}}
+	var data []byte
+	for cidr, location := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		Ω(err).Should(BeNil())
+		ones, _ := network.Mask.Size()
+		offset := len(data)
+		data = append(data, 0xE2)
+		data = append(data, encodeMMDBString("country")...)
+		data = append(data, 0xE1)
+		data = append(data, encodeMMDBString("iso_code")...)
+		data = append(data, encodeMMDBString(location.Country)...)
+		data = append(data, encodeMMDBString("location")...)
+		data = append(data, 0xE2)
+		data = append(data, encodeMMDBString("latitude")...)
+		data = append(data, encodeMMDBDouble(location.Latitude)...)
+		data = append(data, encodeMMDBString("longitude")...)
+		data = append(data, encodeMMDBDouble(location.Longitude)...)
+
+		bits := append(make([]byte, 12), network.IP.To4()...)
+		n := 0
+		for i := 0; i < 96+ones; i++ {
+			bit := bits[i/8] >> (7 - i%8) & 1
+			if i == 96+ones-1 {
+				nodes[n].records[bit] = -(offset + 1)
+				break
+			}
+			if nodes[n].records[bit] <= 0 {
+				nodes = append(nodes, node{})
+				nodes[n].records[bit] = len(nodes) - 1
+			}
+			n = nodes[n].records[bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	value := func(record int) uint32 {
+		switch {
+		case record > 0:
+			return uint32(record)
+		case record == 0:
+			return uint32(nodeCount)
+		}
+		return uint32(nodeCount + 16 - record - 1)
+	}
+	var file []byte
+	for _, n := range nodes {
+		left, right := value(n.records[0]), value(n.records[1])
+		file = append(file, byte(left>>16), byte(left>>8), byte(left), byte(left>>24)<<4|byte(right>>24), byte(right>>16), byte(right>>8), byte(right))
+	}
+	file = append(file, make([]byte, 16)...)
+	file = append(file, data...)
+	file = append(file, "\xAB\xCD\xEFMaxMind.com"...)
+	file = append(file, 0xE4)
+	file = append(file, encodeMMDBString("node_count")...)
+	file = binary.BigEndian.AppendUint32(append(file, 0xC4), uint32(nodeCount))
+	file = append(file, encodeMMDBString("record_size")...)
+	file = append(file, 0xA2, 0, 28)
+	file = append(file, encodeMMDBString("ip_version")...)
+	file = append(file, 0xA2, 0, 6)
+	file = append(file, encodeMMDBString("database_type")...)
+	file = append(file, encodeMMDBString("Test-City")...)
+
+	path := filepath.Join(dir, "test-city.mmdb")
+	Ω(os.WriteFile(path, file, 0o600)).Should(Succeed())
+	return path
+}
+
+var testGeoIPNetworks = map[string]geoip.Location{
+	"81.2.69.0/24":     {Country: "GB", Latitude: 51.5, Longitude: -0.1},
+	"81.2.70.0/24":     {Country: "GB", Latitude: 53.5, Longitude: -2.2},
+	"175.16.199.0/24":  {Country: "CN", Latitude: 43.9, Longitude: 125.3},
+	"89.160.20.112/28": {Country: "SE", Latitude: 58.4, Longitude: 15.6},
+}
+
+var _ = Describe("GeoIP Database", func() {
+	It("Finds the networks of a MaxMind DB and nothing else", func() {
+		db, err := geoip.Open(writeGeoIPDatabase(GinkgoT().TempDir(), testGeoIPNetworks))
+		Ω(err).Should(BeNil())
+		Ω(db.Type()).Should(Equal("Test-City"))
+
+		location, found, err := db.Lookup(net.ParseIP("81.2.69.160"))
+		Ω(err).Should(BeNil())
+		Ω(found).Should(BeTrue())
+		Ω(location).Should(Equal(geoip.Location{Country: "GB", Latitude: 51.5, Longitude: -0.1, HasCoordinates: true}))
+
+		location, found, err = db.Lookup(net.ParseIP("89.160.20.127"))
+		Ω(err).Should(BeNil())
+		Ω(found).Should(BeTrue())
+		Ω(location.Country).Should(Equal("SE"))
+
+		for _, address := range []string{"89.160.20.128", "10.0.0.1", "2001:db8::1"} {
+			_, found, err = db.Lookup(net.ParseIP(address))
+			Ω(err).Should(BeNil())
+			Ω(found).Should(BeFalse(), address)
+		}
+	})
+
+	It("Refuses files that are not MaxMind DBs", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "not.mmdb")
+		Ω(os.WriteFile(path, []byte("not a database"), 0o600)).Should(Succeed())
+		_, err := geoip.Open(path)
+		Ω(err).Should(MatchError(geoip.ErrInvalidDatabase))
+	})
+})
+
+var _ = Describe("Login Risk Tests", Ordered, func() {
+	const username = "risk@x.com"
+	const password = "risk-user-123"
+	const laptop = "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0"
+	const phone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) Safari/604.1"
+	var sender *fakeEmailSender
+	var userID string
+
+	signin := func(userAgent, ip string, cookies ...*http.Cookie) *http.Response {
+		jsonData, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		request.Header.Set("User-Agent", userAgent)
+		request.Header.Set("X-Forwarded-For", ip)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	logins := func() int64 {
+		var count int64
+		Ω(models.Dbcon.Model(&models.AuthLog{}).Where("user_id = ?", userID).Count(&count).Error).Should(Succeed())
+		return count
+	}
+
+	// signinRecorded signs in and waits for the login to be recorded in the background
+	signinRecorded := func(userAgent, ip string) {
+		before := logins()
+		Ω(signin(userAgent, ip).StatusCode).Should(Equal(202))
+		Eventually(logins).Should(Equal(before + 1))
+	}
+
+	emailsWithSubject := func(subject string) []map[string]interface{} {
+		var emails []map[string]interface{}
+		for _, params := range sender.sent() {
+			if fields, ok := params.(map[string]interface{}); ok && fields["Subject"] == subject {
+				emails = append(emails, fields)
+			}
+		}
+		return emails
+	}
+
+	BeforeAll(func() {
+		sender = &fakeEmailSender{}
+		emailservice.SetInstance(sender)
+		db, err := geoip.Open(writeGeoIPDatabase(GinkgoT().TempDir(), testGeoIPNetworks))
+		Ω(err).Should(BeNil())
+		geoip.SetDatabase(db)
+		// The test client plays the proxy that sets X-Forwarded-For
+		settings.Current.TrustedProxies = []string{"127.0.0.1"}
+		DeferCleanup(func() {
+			geoip.SetDatabase(nil)
+			settings.Current.LoginRiskStepUp = false
+			settings.Current.TrustedProxies = nil
+			emailservice.SetInstance(emailservice.NewEmailService(settings.Current))
+		})
+
+		jsonData, _ := json.Marshal(map[string]string{"email": username, "password": password, "firstName": "Risk", "lastName": "User"})
+		response, err := c.Post(fmt.Sprintf("%s/api/v1/signup", s.URL), "application/json", bytes.NewBuffer(jsonData))
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		userID = user.ID
+	})
+
+	It("Records the device and location of logins without emailing about the first one", func() {
+		signinRecorded(laptop, "81.2.69.160")
+
+		logs, err := models.RecentLogins(userID, 1)
+		Ω(err).Should(BeNil())
+		attrs := logs[0].LoginAttrs()
+		Ω(attrs.Device).ShouldNot(BeEmpty())
+		Ω(attrs.Country).Should(Equal("GB"))
+		Ω(*attrs.Latitude).Should(Equal(51.5))
+		Ω(attrs.Risk).Should(Equal(actions.LoginRiskLow))
+		Consistently(func() []map[string]interface{} { return emailsWithSubject("New sign-in to your account") }, "200ms").Should(BeEmpty())
+	})
+
+	It("Does not take browser updates for new devices", func() {
+		signinRecorded("Mozilla/5.0 (X11; Linux x86_64) Firefox/121.0", "81.2.70.10")
+		Consistently(func() []map[string]interface{} { return emailsWithSubject("New sign-in to your account") }, "200ms").Should(BeEmpty())
+	})
+
+	It("Emails the user about logins from new devices", func() {
+		signinRecorded(phone, "81.2.69.161")
+		Eventually(func() []map[string]interface{} { return emailsWithSubject("New sign-in to your account") }).Should(HaveLen(1))
+		email := emailsWithSubject("New sign-in to your account")[0]
+		Ω(email["Device"]).Should(Equal(phone))
+		Ω(email["IP"]).Should(Equal("81.2.69.161"))
+		Ω(email["Country"]).Should(Equal("GB"))
+
+		logs, err := models.RecentLogins(userID, 1)
+		Ω(err).Should(BeNil())
+		Ω(logs[0].LoginAttrs().Risk).Should(Equal(actions.LoginRiskMedium))
+	})
+
+	It("Tells devices apart by the device cookie the service gave them", func() {
+		deviceCookie := func(response *http.Response) *http.Cookie {
+			for _, cookie := range response.Cookies() {
+				if cookie.Name == controllers.DeviceCookie {
+					return cookie
+				}
+			}
+			return nil
+		}
+
+		before := logins()
+		response := signin(laptop, "81.2.69.163")
+		Ω(response.StatusCode).Should(Equal(202))
+		Eventually(logins).Should(Equal(before + 1))
+		device := deviceCookie(response)
+		Ω(device).ShouldNot(BeNil())
+		Ω(device.HttpOnly).Should(BeTrue())
+		id, ok := actions.VerifyDeviceID(device.Value)
+		Ω(ok).Should(BeTrue())
+		logs, err := models.RecentLogins(userID, 1)
+		Ω(err).Should(BeNil())
+		Ω(logs[0].LoginAttrs().Device).Should(Equal(actions.DeviceFingerprint(laptop, id)))
+
+		// The cookie is kept, and tells the device whatever its user agent
+		response = signin("Unknown/2.0", "81.2.69.163", device)
+		Ω(response.StatusCode).Should(Equal(202))
+		Ω(deviceCookie(response)).Should(BeNil())
+		risk, err := actions.EvaluateLoginRisk(userID, actions.NewLoginContext("81.2.69.163", "Unknown/2.0", id, true))
+		Ω(err).Should(BeNil())
+		Ω(risk.NewDevice).Should(BeFalse())
+
+		// Clients cannot pick their device ID
+		_, ok = actions.VerifyDeviceID(id + ".forged")
+		Ω(ok).Should(BeFalse())
+		response = signin(laptop, "81.2.69.163", &http.Cookie{Name: controllers.DeviceCookie, Value: id + ".forged"})
+		Ω(response.StatusCode).Should(Equal(202))
+		Ω(deviceCookie(response)).ShouldNot(BeNil())
+		Eventually(logins).Should(Equal(before + 3))
+	})
+
+	It("Rates logins from new countries and impossible travel", func() {
+		risk, err := actions.EvaluateLoginRisk(userID, actions.NewLoginContext("175.16.199.10", laptop, "", false))
+		Ω(err).Should(BeNil())
+		Ω(risk.NewDevice).Should(BeFalse())
+		Ω(risk.NewCountry).Should(BeTrue())
+		Ω(risk.ImpossibleTravel).Should(BeTrue())
+		Ω(risk.Level).Should(Equal(actions.LoginRiskHigh))
+
+		// The same trip a day later is possible
+		login := actions.NewLoginContext("175.16.199.10", laptop, "", false)
+		login.At = login.At.Add(24 * time.Hour)
+		risk, err = actions.EvaluateLoginRisk(userID, login)
+		Ω(err).Should(BeNil())
+		Ω(risk.ImpossibleTravel).Should(BeFalse())
+		Ω(risk.Level).Should(Equal(actions.LoginRiskMedium))
+
+		risk, err = actions.EvaluateLoginRisk(userID, actions.NewLoginContext("81.2.69.162", laptop, "app-install-1", true))
+		Ω(err).Should(BeNil())
+		Ω(risk.NewDevice).Should(BeTrue())
+		Ω(risk.NewCountry).Should(BeFalse())
+		Ω(risk.Level).Should(Equal(actions.LoginRiskMedium))
+	})
+
+	It("Asks for a link sent by email for high risk sign-ins when step-up is on", func() {
+		// Without step-up the sign-in goes through
+		var user models.User
+		user.ID = userID
+		stepUp, _, err := actions.LoginNeedsStepUp(&user, actions.NewLoginContext("175.16.199.10", "Unknown/1.0", "", false))
+		Ω(err).Should(BeNil())
+		Ω(stepUp).Should(BeFalse())
+
+		settings.Current.LoginRiskStepUp = true
+		response := signin("Unknown/1.0", "175.16.199.10")
+		Ω(response.StatusCode).Should(Equal(200))
+		var challenge types.StepUpChallenge
+		Ω(json.NewDecoder(response.Body).Decode(&challenge)).Should(Succeed())
+		Ω(challenge.StepUpRequired).Should(BeTrue())
+		Ω(challenge.Methods).Should(Equal([]string{actions.StepUpMethodEmailLink}))
+
+		emails := emailsWithSubject("Confirm your sign-in")
+		Ω(emails).Should(HaveLen(1))
+		Ω(emails[0]["Country"]).Should(Equal("CN"))
+		link, err := url.Parse(emails[0]["SigninLink"].(string))
+		Ω(err).Should(BeNil())
+
+		before := logins()
+		jsonData, _ := json.Marshal(map[string]string{"token": link.Query().Get("token")})
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin/link/verify", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		request.Header.Set("User-Agent", "Unknown/1.0")
+		request.Header.Set("X-Forwarded-For", "175.16.199.10")
+		response, err = c.Do(request)
+		Ω(err).Should(BeNil())
+		bodyBytes, _ := io.ReadAll(response.Body)
+		Ω(response.StatusCode).Should(Equal(202), string(bodyBytes))
+		Eventually(logins).Should(Equal(before + 1))
+
+		// The confirmed device and country are known from now on
+		Ω(signin("Unknown/1.0", "175.16.199.10").StatusCode).Should(Equal(202))
+	})
+})
@@ -0,0 +1,86 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Magic Link Signin Tests", Ordered, func() {
+	const username = "magic@x.com"
+	var sender *fakeEmailSender
+
+	post := func(path string, body interface{}) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1%s", s.URL, path), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	// lastToken returns the token of the last sign-in link sent
+	lastToken := func() string {
+		Ω(sender.params).ShouldNot(BeEmpty())
+		params := sender.params[len(sender.params)-1].(map[string]interface{})
+		link, err := url.Parse(params["SigninLink"].(string))
+		Ω(err).Should(BeNil())
+		token := link.Query().Get("token")
+		Ω(token).ShouldNot(BeEmpty())
+		return token
+	}
+
+	BeforeAll(func() {
+		sender = &fakeEmailSender{}
+		emailservice.SetInstance(sender)
+		DeferCleanup(func() { emailservice.SetInstance(emailservice.NewEmailService(settings.Current)) })
+
+		response := post("/signup", map[string]string{
+			"email":     username,
+			"password":  "magic123",
+			"firstName": "Magic",
+			"lastName":  "User",
+		})
+		Ω(response.StatusCode).Should(Equal(200))
+		sender.to, sender.params = nil, nil
+	})
+
+	It("Answers the same for unknown accounts", func() {
+		response := post("/signin/link", map[string]string{"email": "nobody-magic@x.com"})
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(BeEmpty())
+	})
+
+	It("Throttles links to the same address", func() {
+		Ω(post("/signin/link", map[string]string{"email": username}).StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(Equal([]string{username}))
+
+		// Within a minute the answer is the same but no link is sent
+		Ω(post("/signin/link", map[string]string{"email": username}).StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(HaveLen(1))
+	})
+
+	It("Signs in once with the link", func() {
+		Ω(post("/signin/link/verify", map[string]string{"token": "not-a-token"}).StatusCode).Should(Equal(401))
+
+		token := lastToken()
+		response := post("/signin/link/verify", map[string]string{"token": token})
+		Ω(response.StatusCode).Should(Equal(202))
+		Ω(response.Header.Get("X-Refresh-Token")).ShouldNot(BeEmpty())
+
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		Ω(user.EmailVerified).Should(BeTrue())
+
+		Ω(post("/signin/link/verify", map[string]string{"token": token}).StatusCode).Should(Equal(401))
+	})
+})
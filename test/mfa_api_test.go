@@ -0,0 +1,185 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"bigbucks/solution/auth/actions"
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/models"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"bigbucks/solution/auth/totp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Multi-factor Authentication Tests", Ordered, func() {
+	const username = "mfa@x.com"
+	const password = "mfa12345"
+	var jwtToken, secret, enrollmentCode, userID string
+	var store *sessionstore.SessionStore
+	var recoveryCodes []string
+
+	post := func(path string, body interface{}, token string) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1%s", s.URL, path), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if token != "" {
+			request.Header.Set("X-Auth", token)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func() *http.Response {
+		return post("/signin", map[string]string{"username": username, "password": password}, "")
+	}
+
+	mfaToken := func() string {
+		response := signin()
+		Ω(response.StatusCode).Should(Equal(200))
+		var challenge map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&challenge)).Should(Succeed())
+		Ω(challenge["mfaRequired"]).Should(BeTrue())
+		return challenge["mfaToken"].(string)
+	}
+
+	codeAt := func(offset int64) string {
+		code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+		Ω(err).Should(BeNil())
+		return code
+	}
+
+	BeforeAll(func() {
+		response := post("/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Mfa",
+			"lastName":  "User",
+		}, "")
+		Ω(response.StatusCode).Should(Equal(200))
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		userID = user.ID
+		store = sessionstore.NewSessionStore(settings.Current)
+
+		response = signin()
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken = string(bodyBytes)
+	})
+
+	AfterAll(func() {
+		Ω(store.ResetLoginFailures(userID)).Should(Succeed())
+	})
+
+	It("Enrolls an authenticator", func() {
+		response := post("/me/mfa/totp", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		var enrollment map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&enrollment)).Should(Succeed())
+		secret = enrollment["secret"]
+		Ω(enrollment["otpauthUri"]).Should(HavePrefix("otpauth://totp/"))
+		Ω(enrollment["otpauthUri"]).Should(ContainSubstring("secret=" + secret))
+
+		// Enrollment alone does not change signin
+		Ω(signin().StatusCode).Should(Equal(202))
+
+		Ω(post("/me/mfa/totp/verify", map[string]string{"code": "000000"}, jwtToken).StatusCode).Should(Equal(400))
+		enrollmentCode = codeAt(0)
+		response = post("/me/mfa/totp/verify", map[string]string{"code": enrollmentCode}, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		var body map[string][]string
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		recoveryCodes = body["recoveryCodes"]
+		Ω(recoveryCodes).Should(HaveLen(10))
+
+		Ω(post("/me/mfa/totp", nil, jwtToken).StatusCode).Should(Equal(409))
+	})
+
+	It("Stores the secret sealed", func() {
+		cred, err := models.GetTOTPCredential(userID)
+		Ω(err).Should(BeNil())
+		Ω(cred.Secret).Should(HavePrefix(jwtops.SealedPrefix))
+		Ω(cred.Secret).ShouldNot(ContainSubstring(secret))
+
+		// Secrets of older releases are sealed at startup
+		Ω(models.Dbcon.Model(cred).Update("secret", secret).Error).Should(Succeed())
+		Ω(actions.SealTOTPSecrets()).Should(BeNumerically(">=", 1))
+		cred, err = models.GetTOTPCredential(userID)
+		Ω(err).Should(BeNil())
+		Ω(cred.Secret).Should(HavePrefix(jwtops.SealedPrefix))
+	})
+
+	It("Requires the second factor at signin", func() {
+		token := mfaToken()
+		Ω(post("/signin/mfa", map[string]string{"mfaToken": token, "code": "000000"}, "").StatusCode).Should(Equal(401))
+
+		// The code used for enrollment cannot be replayed
+		Ω(post("/signin/mfa", map[string]string{"mfaToken": token, "code": enrollmentCode}, "").StatusCode).Should(Equal(401))
+
+		response := post("/signin/mfa", map[string]string{"mfaToken": token, "code": codeAt(1)}, "")
+		Ω(response.StatusCode).Should(Equal(202))
+		Ω(response.Header.Get("X-Refresh-Token")).ShouldNot(BeEmpty())
+
+		// The challenge is single use
+		Ω(post("/signin/mfa", map[string]string{"mfaToken": token, "code": codeAt(1)}, "").StatusCode).Should(Equal(401))
+	})
+
+	It("Ends the challenge after too many wrong codes", func() {
+		token := mfaToken()
+		for range 5 {
+			// Keep the account from locking, which would answer 429 instead
+			Ω(store.ResetLoginFailures(userID)).Should(Succeed())
+			Ω(post("/signin/mfa", map[string]string{"mfaToken": token, "code": "000000"}, "").StatusCode).Should(Equal(401))
+		}
+		response := post("/signin/mfa", map[string]string{"mfaToken": token, "recoveryCode": recoveryCodes[0]}, "")
+		Ω(response.StatusCode).Should(Equal(401))
+		Ω(store.ResetLoginFailures(userID)).Should(Succeed())
+	})
+
+	It("Locks the account after wrong codes across challenges", func() {
+		for i := 1; i < sessionstore.MaxLoginFailures; i++ {
+			Ω(post("/signin/mfa", map[string]string{"mfaToken": mfaToken(), "code": "000000"}, "").StatusCode).Should(Equal(401))
+		}
+		token := mfaToken()
+		response := post("/signin/mfa", map[string]string{"mfaToken": token, "code": "000000"}, "")
+		Ω(response.StatusCode).Should(Equal(429))
+		Ω(response.Header.Get("Retry-After")).Should(Equal("60"))
+
+		// Neither the right code nor the right password get through
+		Ω(post("/signin/mfa", map[string]string{"mfaToken": token, "code": codeAt(0)}, "").StatusCode).Should(Equal(429))
+		Ω(signin().StatusCode).Should(Equal(429))
+
+		Ω(store.ResetLoginFailures(userID)).Should(Succeed())
+	})
+
+	It("Accepts each recovery code once", func() {
+		response := post("/signin/mfa", map[string]string{"mfaToken": mfaToken(), "recoveryCode": recoveryCodes[0]}, "")
+		Ω(response.StatusCode).Should(Equal(202))
+		response = post("/signin/mfa", map[string]string{"mfaToken": mfaToken(), "recoveryCode": recoveryCodes[0]}, "")
+		Ω(response.StatusCode).Should(Equal(401))
+
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/me/mfa", s.URL), nil)
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		var status map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&status)).Should(Succeed())
+		Ω(status["totpEnabled"]).Should(BeTrue())
+		Ω(status["recoveryCodesRemaining"]).Should(BeNumerically("==", 9))
+	})
+
+	It("Disables the authenticator with a recovery code", func() {
+		Ω(post("/me/mfa/totp/disable", map[string]string{"code": "000000"}, jwtToken).StatusCode).Should(Equal(400))
+		Ω(post("/me/mfa/totp/disable", map[string]string{"recoveryCode": recoveryCodes[1]}, jwtToken).StatusCode).Should(Equal(200))
+		Ω(signin().StatusCode).Should(Equal(202))
+	})
+})
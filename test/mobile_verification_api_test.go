@@ -0,0 +1,153 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+	"bigbucks/solution/auth/smsservice"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSMSSender records text messages instead of sending them
+type fakeSMSSender struct {
+	to       []string
+	messages []string
+}
+
+func (f *fakeSMSSender) SendSMS(toNumber string, message string) error {
+	f.to = append(f.to, toNumber)
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+var _ = Describe("Mobile Verification Tests", Ordered, func() {
+	const username = "phone@x.com"
+	const password = "phone123"
+	var sender *fakeSMSSender
+	var jwtToken string
+	var user models.User
+
+	post := func(path string, body interface{}) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1%s", s.URL, path), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if jwtToken != "" {
+			request.Header.Set("X-Auth", jwtToken)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	// lastCode returns the code of the last text message sent
+	lastCode := func() string {
+		Ω(sender.messages).ShouldNot(BeEmpty())
+		code := regexp.MustCompile(`\d{6}`).FindString(sender.messages[len(sender.messages)-1])
+		Ω(code).ShouldNot(BeEmpty())
+		return code
+	}
+
+	// allowResend moves the last code back in time past the resend interval
+	allowResend := func() {
+		Ω(models.Dbcon.Model(&models.MobileVerification{}).Where("user_id = ?", user.ID).
+			UpdateColumn("created_at", time.Now().Add(-2*time.Minute)).Error).Should(Succeed())
+	}
+
+	reloadUser := func() {
+		Ω(models.Dbcon.Where("username = ?", username).Preload("Profile").First(&user).Error).Should(Succeed())
+	}
+
+	BeforeAll(func() {
+		previous := smsservice.GetInstance()
+		sender = &fakeSMSSender{}
+		smsservice.SetInstance(sender)
+		DeferCleanup(func() { smsservice.SetInstance(previous) })
+
+		response := post("/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Phone",
+			"lastName":  "User",
+		})
+		Ω(response.StatusCode).Should(Equal(200))
+		response = post("/signin", map[string]string{"username": username, "password": password})
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken = string(bodyBytes)
+		reloadUser()
+	})
+
+	It("Rejects invalid phone numbers", func() {
+		Ω(post("/me/phone", map[string]string{"phoneNumber": "call me"}).StatusCode).Should(Equal(400))
+		Ω(post("/me/phone", map[string]string{"phoneNumber": "+1 555"}).StatusCode).Should(Equal(400))
+		Ω(sender.to).Should(BeEmpty())
+	})
+
+	It("Sends a code and throttles resending it", func() {
+		Ω(post("/me/phone", map[string]string{"phoneNumber": "+1 (555) 123-4567"}).StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(Equal([]string{"+15551234567"}))
+
+		response := post("/me/phone", map[string]string{"phoneNumber": "+15551234567"})
+		Ω(response.StatusCode).Should(Equal(429))
+		Ω(response.Header.Get("Retry-After")).Should(Equal("60"))
+		Ω(sender.to).Should(HaveLen(1))
+	})
+
+	It("Uses up a code after too many wrong attempts", func() {
+		code := lastCode()
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		for i := 0; i < 5; i++ {
+			Ω(post("/me/phone/verify", map[string]string{"code": wrong}).StatusCode).Should(Equal(400))
+		}
+		Ω(post("/me/phone/verify", map[string]string{"code": code}).StatusCode).Should(Equal(400))
+		reloadUser()
+		Ω(user.MobileVerified).Should(BeFalse())
+	})
+
+	It("Rejects expired codes", func() {
+		allowResend()
+		Ω(post("/me/phone", map[string]string{"phoneNumber": "+15551234567"}).StatusCode).Should(Equal(200))
+		Ω(models.Dbcon.Model(&models.MobileVerification{}).Where("user_id = ?", user.ID).
+			UpdateColumn("expires_at", time.Now().Add(-time.Minute)).Error).Should(Succeed())
+		Ω(post("/me/phone/verify", map[string]string{"code": lastCode()}).StatusCode).Should(Equal(400))
+	})
+
+	It("Needs a provider to send codes", func() {
+		_, err := smsservice.New(&settings.Settings{SMSProvider: smsservice.ProviderLog})
+		Ω(err).Should(HaveOccurred())
+		_, err = smsservice.New(&settings.Settings{SMSProvider: smsservice.ProviderLog, Development: true})
+		Ω(err).Should(Succeed())
+
+		unconfigured, err := smsservice.New(&settings.Settings{})
+		Ω(err).Should(Succeed())
+		smsservice.SetInstance(unconfigured)
+		defer smsservice.SetInstance(sender)
+		allowResend()
+		Ω(post("/me/phone", map[string]string{"phoneNumber": "+15551234567"}).StatusCode).Should(Equal(503))
+	})
+
+	It("Verifies the phone number with the code", func() {
+		allowResend()
+		Ω(post("/me/phone", map[string]string{"phoneNumber": "+15557654321"}).StatusCode).Should(Equal(200))
+		code := lastCode()
+		Ω(post("/me/phone/verify", map[string]string{"code": code}).StatusCode).Should(Equal(200))
+		reloadUser()
+		Ω(user.MobileVerified).Should(BeTrue())
+		Ω(user.Profile.ContactNumber).Should(Equal("+15557654321"))
+
+		// Codes work once
+		Ω(post("/me/phone/verify", map[string]string{"code": code}).StatusCode).Should(Equal(400))
+	})
+})
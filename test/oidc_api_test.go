@@ -0,0 +1,339 @@
+package auth_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bigbucks/solution/auth/actions"
+
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenID Connect Provider Tests", Ordered, func() {
+	const redirectURI = "https://app.example.com/callback"
+	const verifier = "dBjftJeZ4CVP-mJ92K9qzJ6vUzrZ5TWBqR0Hc3tXYcpk4w8xLgN1f"
+	var jwtToken, clientID string
+	var noRedirect *http.Client
+
+	challenge := func(v string) string {
+		sum := sha256.Sum256([]byte(v))
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	authorize := func(params url.Values) *url.URL {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/oauth/authorize", s.URL), strings.NewReader(params.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var body map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		target, err := url.Parse(body["redirectTo"])
+		Ω(err).Should(BeNil())
+		return target
+	}
+
+	authorizeParams := func() url.Values {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {clientID},
+			"redirect_uri":          {redirectURI},
+			"scope":                 {"openid profile email"},
+			"state":                 {"xyz"},
+			"nonce":                 {"n-0S6_WzA2Mj"},
+			"code_challenge":        {challenge(verifier)},
+			"code_challenge_method": {"S256"},
+		}
+	}
+
+	token := func(form url.Values) (*http.Response, map[string]interface{}) {
+		response, err := c.PostForm(fmt.Sprintf("%s/oauth/token", s.URL), form)
+		Ω(err).Should(BeNil())
+		var body map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&body)
+		return response, body
+	}
+
+	exchange := func(code string) (*http.Response, map[string]interface{}) {
+		return token(url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientID},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		})
+	}
+
+	BeforeAll(func() {
+		rp, _, code, err := actions.CreateRelyingParty("dashboard", []string{redirectURI}, nil, true, true)
+		Ω(code).Should(Equal(0))
+		Ω(err).Should(BeNil())
+		clientID = rp.ClientID
+		noRedirect = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, _ := c.Do(request)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken = string(bodyBytes)
+	})
+
+	It("Publishes the discovery document", func() {
+		response, err := c.Get(fmt.Sprintf("%s/.well-known/openid-configuration", s.URL))
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var config map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&config)).Should(Succeed())
+		Ω(config["issuer"]).Should(Equal(s.URL))
+		Ω(config["token_endpoint"]).Should(Equal(s.URL + "/oauth/token"))
+		Ω(config["jwks_uri"]).Should(Equal(s.URL + "/.well-known/jwks.json"))
+		Ω(config["code_challenge_methods_supported"]).Should(ConsistOf("S256"))
+	})
+
+	It("Sends users without a session to sign in", func() {
+		response, err := noRedirect.Get(fmt.Sprintf("%s/oauth/authorize?%s", s.URL, authorizeParams().Encode()))
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(302))
+		location, _ := url.Parse(response.Header.Get("Location"))
+		Ω(location.Path).Should(Equal("/signin"))
+		Ω(location.Query().Get("return_to")).Should(HavePrefix(s.URL + "/oauth/authorize?"))
+
+		// A token in the query does not travel on to the sign-in page
+		params := authorizeParams()
+		params.Set("auth", "expired.jwt.token")
+		response, err = noRedirect.Get(fmt.Sprintf("%s/oauth/authorize?%s", s.URL, params.Encode()))
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(302))
+		location, _ = url.Parse(response.Header.Get("Location"))
+		Ω(location.Query().Get("return_to")).ShouldNot(ContainSubstring("auth="))
+
+		params = authorizeParams()
+		params.Set("prompt", "none")
+		response, err = noRedirect.Get(fmt.Sprintf("%s/oauth/authorize?%s", s.URL, params.Encode()))
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(302))
+		location, _ = url.Parse(response.Header.Get("Location"))
+		Ω(location.Query().Get("error")).Should(Equal("login_required"))
+		Ω(location.Query().Get("state")).Should(Equal("xyz"))
+	})
+
+	It("Asks the user to consent to clients that are not trusted", func() {
+		rp, _, _, err := actions.CreateRelyingParty("third party tool", []string{redirectURI}, nil, true, false)
+		Ω(err).Should(BeNil())
+		params := authorizeParams()
+		params.Set("client_id", rp.ClientID)
+
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/oauth/authorize", s.URL), strings.NewReader(params.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var consent map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&consent)).Should(Succeed())
+		Ω(consent["consentRequired"]).Should(BeTrue())
+		Ω(consent["clientName"]).Should(Equal("third party tool"))
+
+		// The session cookie alone does not get a code
+		cookieRequest, _ := http.NewRequest("GET", fmt.Sprintf("%s/oauth/authorize?%s", s.URL, params.Encode()), nil)
+		cookieRequest.AddCookie(&http.Cookie{Name: "auth", Value: jwtToken})
+		response, err = noRedirect.Do(cookieRequest)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(302))
+		location, _ := url.Parse(response.Header.Get("Location"))
+		Ω(location.Path).Should(Equal("/signin"))
+
+		params.Set("prompt", "none")
+		Ω(authorize(params).Query().Get("error")).Should(Equal("consent_required"))
+		params.Del("prompt")
+
+		params.Set("consent", "deny")
+		Ω(authorize(params).Query().Get("error")).Should(Equal("access_denied"))
+
+		params.Set("consent", "approve")
+		Ω(authorize(params).Query().Get("code")).ShouldNot(BeEmpty())
+
+		// Consent is remembered for the scopes granted
+		params.Del("consent")
+		params.Set("prompt", "none")
+		Ω(authorize(params).Query().Get("code")).ShouldNot(BeEmpty())
+		params.Set("scope", "openid phone")
+		Ω(authorize(params).Query().Get("error")).ShouldNot(BeEmpty())
+	})
+
+	It("Rejects unregistered redirect URIs without redirecting", func() {
+		params := authorizeParams()
+		params.Set("redirect_uri", "https://evil.example.com/callback")
+		response, err := noRedirect.Get(fmt.Sprintf("%s/oauth/authorize?%s", s.URL, params.Encode()))
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(400))
+	})
+
+	It("Requires PKCE", func() {
+		params := authorizeParams()
+		params.Del("code_challenge")
+		target := authorize(params)
+		Ω(target.Query().Get("error")).Should(Equal("invalid_request"))
+	})
+
+	It("Exchanges an authorization code for tokens", func() {
+		target := authorize(authorizeParams())
+		Ω(target.Host).Should(Equal("app.example.com"))
+		Ω(target.Query().Get("state")).Should(Equal("xyz"))
+		Ω(target.Query().Get("iss")).Should(Equal(s.URL))
+		code := target.Query().Get("code")
+		Ω(code).ShouldNot(BeEmpty())
+
+		response, body := exchange(code)
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(body["token_type"]).Should(Equal("Bearer"))
+		Ω(body["refresh_token"]).ShouldNot(BeEmpty())
+
+		claims := jwt.MapClaims{}
+		_, _, err := jwt.NewParser().ParseUnverified(body["id_token"].(string), claims)
+		Ω(err).Should(BeNil())
+		Ω(claims["iss"]).Should(Equal(s.URL))
+		Ω(claims["aud"]).Should(Equal(clientID))
+		Ω(claims["sub"]).Should(Equal(TestUserID))
+		Ω(claims["nonce"]).Should(Equal("n-0S6_WzA2Mj"))
+		Ω(claims["email"]).Should(Equal("john@x.com"))
+		Ω(claims["given_name"]).Should(Equal("John"))
+		Ω(claims).ShouldNot(HaveKey("phone_number"))
+
+		// The access token carries the same issuer as the ID token
+		accessClaims := jwt.MapClaims{}
+		_, _, err = jwt.NewParser().ParseUnverified(body["access_token"].(string), accessClaims)
+		Ω(err).Should(BeNil())
+		Ω(accessClaims["iss"]).Should(Equal(s.URL))
+
+		// Codes are single use
+		response, body = exchange(code)
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("invalid_grant"))
+	})
+
+	It("Serves userinfo for the granted scopes", func() {
+		params := authorizeParams()
+		params.Set("scope", "openid email")
+		_, body := exchange(authorize(params).Query().Get("code"))
+
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/userinfo", s.URL), nil)
+		request.Header.Set("Authorization", "Bearer "+body["access_token"].(string))
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		var info map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&info)).Should(Succeed())
+		Ω(info["sub"]).Should(Equal(TestUserID))
+		Ω(info["email"]).Should(Equal("john@x.com"))
+		Ω(info).ShouldNot(HaveKey("given_name"))
+	})
+
+	It("Binds access tokens to the client and the granted scope", func() {
+		params := authorizeParams()
+		params.Set("scope", "openid email")
+		_, body := exchange(authorize(params).Query().Get("code"))
+		accessToken := body["access_token"].(string)
+
+		claims := jwt.MapClaims{}
+		_, _, err := jwt.NewParser().ParseUnverified(accessToken, claims)
+		Ω(err).Should(BeNil())
+		Ω(claims["aud"]).Should(ConsistOf(clientID))
+		Ω(claims["client_id"]).Should(Equal(clientID))
+		Ω(claims["scope"]).Should(Equal("openid email"))
+		Ω(claims["user"]).ShouldNot(HaveKey("roles"))
+
+		// First party routes refuse it
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/me", s.URL), nil)
+		request.Header.Set("X-Auth", accessToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(403))
+
+		// Its refresh token is only renewed at the token endpoint
+		request, _ = http.NewRequest("POST", fmt.Sprintf("%s/api/v1/renew", s.URL), nil)
+		request.Header.Set("X-Refresh-Token", body["refresh_token"].(string))
+		response, err = c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(401))
+		response, body = token(url.Values{"grant_type": {"refresh_token"}, "client_id": {clientID}, "refresh_token": {body["refresh_token"].(string)}})
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("invalid_grant"))
+	})
+
+	It("Rejects a wrong code verifier", func() {
+		code := authorize(authorizeParams()).Query().Get("code")
+		response, body := token(url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {clientID},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {strings.Repeat("a", 43)},
+		})
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("invalid_grant"))
+	})
+
+	It("Rotates refresh tokens", func() {
+		_, body := exchange(authorize(authorizeParams()).Query().Get("code"))
+		first := body["refresh_token"].(string)
+
+		response, body := token(url.Values{"grant_type": {"refresh_token"}, "client_id": {clientID}, "refresh_token": {first}})
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(body["refresh_token"]).ShouldNot(Equal(first))
+		Ω(body["id_token"]).ShouldNot(BeEmpty())
+
+		response, body = token(url.Values{"grant_type": {"refresh_token"}, "client_id": {clientID}, "refresh_token": {first}})
+		Ω(response.StatusCode).Should(Equal(400))
+		Ω(body["error"]).Should(Equal("invalid_grant"))
+	})
+
+	It("Authenticates confidential clients", func() {
+		rp, secret, _, err := actions.CreateRelyingParty("reporting tool", []string{redirectURI}, []string{"openid"}, false, false)
+		Ω(err).Should(BeNil())
+		params := authorizeParams()
+		params.Set("client_id", rp.ClientID)
+		params.Set("scope", "openid")
+		params.Set("consent", "approve")
+		code := authorize(params).Query().Get("code")
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		}
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/oauth/token", s.URL), strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth(rp.ClientID, "wrong")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(401))
+
+		code = authorize(params).Query().Get("code")
+		form.Set("code", code)
+		request, _ = http.NewRequest("POST", fmt.Sprintf("%s/oauth/token", s.URL), strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.SetBasicAuth(rp.ClientID, secret)
+		response, err = c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+	})
+})
@@ -0,0 +1,186 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	grpc_auth "bigbucks/solution/auth/grpc-auth"
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/permission_cache"
+	sessionstore "bigbucks/solution/auth/session_store"
+	"bigbucks/solution/auth/settings"
+	"bigbucks/solution/auth/totp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Organization Security Policy Tests", Ordered, func() {
+	const username = "policy@x.com"
+	const password = "policy123"
+	var jwtToken, orgID string
+
+	send := func(method, path string, body interface{}, token string) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest(method, fmt.Sprintf("%s/api/v1%s", s.URL, path), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if token != "" {
+			request.Header.Set("X-Auth", token)
+		}
+		if orgID != "" {
+			request.Header.Set("X-Organization-Id", orgID)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func() string {
+		response := send("POST", "/signin", map[string]string{"username": username, "password": password}, "")
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return string(bodyBytes)
+	}
+
+	orgRoles := func(token string) []string {
+		claims, _, err := jwtops.VerifyJWT(token)
+		Ω(err).Should(BeNil())
+		roles := []string{}
+		for _, role := range claims.User.Roles {
+			if role.OrgID == orgID {
+				roles = append(roles, role.Role)
+			}
+		}
+		return roles
+	}
+
+	BeforeAll(func() {
+		response := send("POST", "/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Policy",
+			"lastName":  "User",
+		}, "")
+		Ω(response.StatusCode).Should(Equal(200))
+
+		response = send("POST", "/organizations", map[string]string{"name": "Policy Corp", "email": "admin@policy.com"}, signin())
+		Ω(response.StatusCode).Should(Equal(200))
+		var org models.Organization
+		Ω(models.Dbcon.Where("name = ?", "Policy Corp").First(&org).Error).Should(Succeed())
+		orgID = org.ID
+
+		// Sign in again for a token with the Admin role of the new organization
+		jwtToken = signin()
+		Ω(orgRoles(jwtToken)).Should(ConsistOf("Admin"))
+	})
+
+	AfterAll(func() {
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.OrgSecurityPolicy{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.UserOrgRole{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.Role{})
+		models.Dbcon.Unscoped().Where("id = ?", orgID).Delete(&models.Organization{})
+	})
+
+	It("Starts without requirements", func() {
+		response := send("GET", "/security-policy", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		var policy map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&policy)).Should(Succeed())
+		Ω(policy["mfaRequired"]).Should(BeFalse())
+		Ω(policy).ShouldNot(HaveKey("enrollBy"))
+	})
+
+	It("Validates the policy", func() {
+		Ω(send("PUT", "/security-policy", map[string]interface{}{"mfaRequired": true, "allowedFactors": []string{"sms"}}, jwtToken).StatusCode).Should(Equal(400))
+		Ω(send("PUT", "/security-policy", map[string]interface{}{"mfaRequired": true, "mfaRoles": []string{"Auditor"}}, jwtToken).StatusCode).Should(Equal(400))
+		Ω(send("PUT", "/security-policy", map[string]interface{}{"mfaRequired": true, "gracePeriodDays": -1}, jwtToken).StatusCode).Should(Equal(400))
+	})
+
+	It("Allows access during the grace period", func() {
+		response := send("PUT", "/security-policy", map[string]interface{}{
+			"mfaRequired":     true,
+			"mfaRoles":        []string{"Admin"},
+			"allowedFactors":  []string{"totp"},
+			"gracePeriodDays": 7,
+		}, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		var policy map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&policy)).Should(Succeed())
+		Ω(policy["mfaRoles"]).Should(ConsistOf("Admin"))
+		Ω(policy["allowedFactors"]).Should(ConsistOf("totp"))
+		Ω(policy["enrollBy"]).ShouldNot(BeEmpty())
+
+		response = send("GET", "/roles", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(response.Header.Get("X-MFA-Enroll-By")).ShouldNot(BeEmpty())
+		Ω(orgRoles(signin())).Should(ConsistOf("Admin"))
+	})
+
+	It("Denies access to the organization after the grace period", func() {
+		response := send("PUT", "/security-policy", map[string]interface{}{
+			"mfaRequired":    true,
+			"mfaRoles":       []string{"Admin"},
+			"allowedFactors": []string{"totp"},
+		}, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+
+		response = send("GET", "/roles", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(403))
+		var body map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		Ω(body["error"]).Should(Equal("mfa_enrollment_required"))
+		Ω(body["orgId"]).Should(Equal(orgID))
+		Ω(body["allowedFactors"]).Should(ConsistOf("totp"))
+
+		// gRPC Authorize refuses the same way
+		claims, _, err := jwtops.VerifyJWT(jwtToken)
+		Ω(err).Should(BeNil())
+		server := grpc_auth.NewGRPCServer(settings.Current, *permission_cache.NewPermissionCache(settings.Current), *sessionstore.NewSessionStore(settings.Current))
+		ctx := context.WithValue(context.Background(), grpc_auth.UserValue("user"), claims.User)
+		ctx = context.WithValue(ctx, grpc_auth.UserValue("userID"), claims.Subject)
+		_, err = server.Authorize(ctx, &grpc_auth.AuthorizeRequest{Resource: "role", Action: "read", OrgId: orgID})
+		Ω(status.Code(err)).Should(Equal(codes.PermissionDenied))
+		details := status.Convert(err).Details()
+		Ω(details).Should(HaveLen(1))
+		Ω(details[0].(*errdetails.ErrorInfo).Reason).Should(Equal("mfa_enrollment_required"))
+		Ω(details[0].(*errdetails.ErrorInfo).Metadata["orgId"]).Should(Equal(orgID))
+
+		// New tokens carry no roles in the organization
+		Ω(orgRoles(signin())).Should(BeEmpty())
+	})
+
+	It("Restores access once an allowed factor is enrolled", func() {
+		response := send("POST", "/me/mfa/totp", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		var enrollment map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&enrollment)).Should(Succeed())
+		secret := enrollment["secret"]
+		code, err := totp.Code(secret, totp.Step(time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(send("POST", "/me/mfa/totp/verify", map[string]string{"code": code}, jwtToken).StatusCode).Should(Equal(200))
+
+		response = send("GET", "/roles", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(response.Header.Get("X-MFA-Enroll-By")).Should(BeEmpty())
+
+		response = send("POST", "/signin", map[string]string{"username": username, "password": password}, "")
+		Ω(response.StatusCode).Should(Equal(200))
+		var challenge map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&challenge)).Should(Succeed())
+		code, err = totp.Code(secret, totp.Step(time.Now())+1)
+		Ω(err).Should(BeNil())
+		response = send("POST", "/signin/mfa", map[string]string{"mfaToken": challenge["mfaToken"].(string), "code": code}, "")
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		Ω(orgRoles(string(bodyBytes))).Should(ConsistOf("Admin"))
+	})
+})
@@ -0,0 +1,118 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/passwordhash"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ = Describe("Password Hashing", func() {
+	It("Hashes with argon2id and records the parameters", func() {
+		hash, err := passwordhash.Hash("secret-123")
+		Ω(err).Should(BeNil())
+		Ω(hash).Should(HavePrefix(fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$",
+			passwordhash.DefaultArgon2MemoryKiB, passwordhash.DefaultArgon2Time, passwordhash.DefaultArgon2Threads)))
+
+		other, err := passwordhash.Hash("secret-123")
+		Ω(err).Should(BeNil())
+		Ω(other).ShouldNot(Equal(hash))
+
+		Ω(passwordhash.Verify(hash, "secret-123")).Should(BeTrue())
+		Ω(passwordhash.Verify(hash, "secret-124")).Should(BeFalse())
+		Ω(passwordhash.NeedsRehash(hash)).Should(BeFalse())
+		Ω(passwordhash.NewArgon2id(1, 0, 0).NeedsRehash(hash)).Should(BeTrue())
+	})
+
+	It("Limits the configured parameters", func() {
+		hasher := passwordhash.NewArgon2id(1000, 64*1024*1024, 0)
+		Ω(hasher.Time).Should(BeEquivalentTo(passwordhash.MaxArgon2Time))
+		Ω(hasher.MemoryKiB).Should(BeEquivalentTo(passwordhash.MaxArgon2MemoryKiB))
+	})
+
+	It("Hashes concurrently within the limit", func() {
+		passwordhash.SetMaxConcurrent(1)
+		defer passwordhash.SetMaxConcurrent(0)
+
+		hashes := make(chan string, 4)
+		for range 4 {
+			go func() {
+				defer GinkgoRecover()
+				hash, err := passwordhash.Hash("secret-123")
+				Ω(err).Should(BeNil())
+				hashes <- hash
+			}()
+		}
+		for range 4 {
+			Ω(passwordhash.Verify(<-hashes, "secret-123")).Should(BeTrue())
+		}
+	})
+
+	It("Verifies bcrypt hashes and asks for a rehash", func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("secret-123"), bcrypt.MinCost)
+		Ω(err).Should(BeNil())
+		Ω(passwordhash.Verify(string(hash), "secret-123")).Should(BeTrue())
+		Ω(passwordhash.Verify(string(hash), "secret-124")).Should(BeFalse())
+		Ω(passwordhash.NeedsRehash(string(hash))).Should(BeTrue())
+	})
+
+	It("Refuses hashes it cannot read", func() {
+		_, err := passwordhash.Verify("secret-123", "secret-123")
+		Ω(err).Should(MatchError(passwordhash.ErrUnknownHash))
+		_, err = passwordhash.Verify("$argon2id$v=19$m=x$salt$key", "secret-123")
+		Ω(err).Should(MatchError(passwordhash.ErrInvalidHash))
+	})
+})
+
+var _ = Describe("Password Hash Upgrade API Tests", Ordered, func() {
+	const username = "legacy@x.com"
+	const password = "legacy-123"
+
+	signin := func(password string) int {
+		jsonData, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response.StatusCode
+	}
+
+	storedHash := func() string {
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		return user.Password
+	}
+
+	BeforeAll(func() {
+		user := models.User{Username: username, Password: password, Profile: models.Profile{Email: username}}
+		Ω(models.Dbcon.Create(&user).Error).Should(Succeed())
+		Ω(storedHash()).Should(HavePrefix("$argon2id$"))
+
+		legacy, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		Ω(err).Should(BeNil())
+		Ω(models.Dbcon.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumn("hashed_password", string(legacy)).Error).Should(Succeed())
+	})
+
+	It("Keeps the bcrypt hash after a wrong password", func() {
+		Ω(signin("wrong-password")).Should(Equal(401))
+		Ω(storedHash()).Should(HavePrefix("$2a$"))
+	})
+
+	It("Rehashes bcrypt hashes with argon2id at sign-in", func() {
+		Ω(signin(password)).Should(Equal(202))
+		hash := storedHash()
+		Ω(strings.HasPrefix(hash, "$argon2id$")).Should(BeTrue(), hash)
+		Ω(passwordhash.Verify(hash, password)).Should(BeTrue())
+
+		Ω(signin(password)).Should(Equal(202))
+		Ω(storedHash()).Should(Equal(hash))
+	})
+})
@@ -0,0 +1,170 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Password Rotation Policy", func() {
+	It("Keeps the strictest history and the shortest lifetime", func() {
+		policy := models.DefaultPasswordPolicy.Merge(models.PasswordPolicy{HistoryCount: 3, MaxAgeDays: 90})
+		policy = policy.Merge(models.PasswordPolicy{HistoryCount: 5, MaxAgeDays: 30})
+		policy = policy.Merge(models.PasswordPolicy{HistoryCount: 1})
+		Ω(policy.HistoryCount).Should(Equal(5))
+		Ω(policy.MaxAgeDays).Should(Equal(30))
+
+		recent := time.Now().Add(-29 * 24 * time.Hour)
+		old := time.Now().Add(-31 * 24 * time.Hour)
+		Ω(policy.Expired(&recent)).Should(BeFalse())
+		Ω(policy.Expired(&old)).Should(BeTrue())
+		Ω(policy.Expired(nil)).Should(BeFalse())
+		Ω(models.DefaultPasswordPolicy.Expired(&old)).Should(BeFalse())
+	})
+})
+
+var _ = Describe("Password History API Tests", Ordered, func() {
+	const username = "rotate@x.com"
+	const password = "rotate-123"
+	var sender *fakeEmailSender
+	var jwtToken, orgID, userID string
+
+	send := func(method, route string, body interface{}) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest(method, fmt.Sprintf("%s/api/v1%s", s.URL, route), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if jwtToken != "" {
+			request.Header.Set("X-Auth", jwtToken)
+		}
+		if orgID != "" {
+			request.Header.Set("X-Organization-Id", orgID)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func(password string) string {
+		response := send("POST", "/signin", map[string]string{"username": username, "password": password})
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return string(bodyBytes)
+	}
+
+	resetToken := func() string {
+		Ω(send("POST", "/user/reset", map[string]string{"email": username}).StatusCode).Should(Equal(200))
+		params := sender.params[len(sender.params)-1].(map[string]interface{})
+		link, err := url.Parse(params["ResetLink"].(string))
+		Ω(err).Should(BeNil())
+		return path.Base(link.Path)
+	}
+
+	BeforeAll(func() {
+		sender = &fakeEmailSender{}
+		emailservice.SetInstance(sender)
+		DeferCleanup(func() { emailservice.SetInstance(emailservice.NewEmailService(settings.Current)) })
+
+		response := send("POST", "/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Rotate",
+			"lastName":  "User",
+		})
+		Ω(response.StatusCode).Should(Equal(200))
+		var user models.User
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+		userID = user.ID
+		Ω(user.PasswordChangedAt).ShouldNot(BeNil())
+
+		jwtToken = signin(password)
+		Ω(send("POST", "/organizations", map[string]string{"name": "Rotate Corp", "email": "admin@rotate.com"}).StatusCode).Should(Equal(200))
+		var org models.Organization
+		Ω(models.Dbcon.Where("name = ?", "Rotate Corp").First(&org).Error).Should(Succeed())
+		orgID = org.ID
+		jwtToken = signin(password)
+	})
+
+	AfterAll(func() {
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.OrgSecurityPolicy{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.UserOrgRole{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.Role{})
+		models.Dbcon.Unscoped().Where("id = ?", orgID).Delete(&models.Organization{})
+	})
+
+	It("Validates the rotation settings", func() {
+		Ω(send("PUT", "/security-policy", map[string]interface{}{"passwordPolicy": map[string]interface{}{"historyCount": models.MaxPasswordHistory + 1}}).StatusCode).Should(Equal(400))
+		Ω(send("PUT", "/security-policy", map[string]interface{}{"passwordPolicy": map[string]interface{}{"maxAgeDays": -1}}).StatusCode).Should(Equal(400))
+
+		response := send("PUT", "/security-policy", map[string]interface{}{
+			"passwordPolicy": map[string]interface{}{"historyCount": 2, "maxAgeDays": 30},
+		})
+		Ω(response.StatusCode).Should(Equal(200))
+		var policy map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&policy)).Should(Succeed())
+		Ω(policy["passwordPolicy"]).Should(HaveKeyWithValue("historyCount", BeNumerically("==", 2)))
+		Ω(policy["passwordPolicy"]).Should(HaveKeyWithValue("maxAgeDays", BeNumerically("==", 30)))
+	})
+
+	It("Refuses the last passwords of the user", func() {
+		response := send("POST", "/user/changepassword/"+resetToken(), map[string]string{"password": password})
+		Ω(response.StatusCode).Should(Equal(400))
+		var body map[string]map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		Ω(body["errors"]["password"]).Should(ContainSubstring("used recently"))
+
+		Ω(send("POST", "/user/changepassword/"+resetToken(), map[string]string{"password": "rotate-456"}).StatusCode).Should(Equal(200))
+		Ω(send("POST", "/user/changepassword/"+resetToken(), map[string]string{"password": password}).StatusCode).Should(Equal(400))
+		Ω(send("POST", "/user/changepassword/"+resetToken(), map[string]string{"password": "rotate-789"}).StatusCode).Should(Equal(200))
+		// Only the last two passwords count
+		Ω(send("POST", "/user/changepassword/"+resetToken(), map[string]string{"password": password}).StatusCode).Should(Equal(200))
+
+		var count int64
+		Ω(models.Dbcon.Model(&models.PasswordHistory{}).Where("user_id = ?", userID).Count(&count).Error).Should(Succeed())
+		Ω(count).Should(BeNumerically("==", 4))
+	})
+
+	It("Only allows a password change once the password expired", func() {
+		response := send("POST", "/signin", map[string]string{"username": username, "password": password})
+		Ω(response.StatusCode).Should(Equal(202))
+		refreshToken := response.Header.Get("X-Refresh-Token")
+
+		Ω(models.Dbcon.Model(&models.User{}).Where("id = ?", userID).
+			Update("password_changed_at", time.Now().Add(-31*24*time.Hour)).Error).Should(Succeed())
+
+		// Sessions started before cannot be renewed either
+		response = send("POST", "/renew", map[string]string{"refreshToken": refreshToken})
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(response.Header.Get("X-Refresh-Token")).Should(BeEmpty())
+		var renewal map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&renewal)).Should(Succeed())
+		Ω(renewal["passwordExpired"]).Should(BeTrue())
+
+		response = send("POST", "/signin", map[string]string{"username": username, "password": password})
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(response.Header.Get("X-Refresh-Token")).Should(BeEmpty())
+		var challenge map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&challenge)).Should(Succeed())
+		Ω(challenge["passwordExpired"]).Should(BeTrue())
+		token, _ := challenge["passwordChangeToken"].(string)
+		Ω(token).ShouldNot(BeEmpty())
+
+		Ω(send("POST", "/user/changepassword/"+token, map[string]string{"password": password}).StatusCode).Should(Equal(400))
+		Ω(send("POST", "/user/changepassword/"+token, map[string]string{"password": "rotate-abc"}).StatusCode).Should(Equal(200))
+		// The token goes with the old password
+		Ω(send("POST", "/user/changepassword/"+token, map[string]string{"password": "rotate-def"}).StatusCode).Should(Equal(400))
+
+		Ω(signin("rotate-abc")).ShouldNot(BeEmpty())
+	})
+})
@@ -0,0 +1,151 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Password Policy", func() {
+	It("Checks length, character classes and account identities", func() {
+		policy := models.DefaultPasswordPolicy
+		Ω(policy.Check("secret12", "someone@x.com")).Should(BeEmpty())
+		Ω(policy.Check("short", "someone@x.com")).Should(ConsistOf("be at least 8 characters long"))
+		Ω(policy.Check(string(bytes.Repeat([]byte("a"), models.MaxPasswordLength+1)))).Should(ConsistOf("be at most 72 bytes long"))
+		Ω(policy.Check("xSomeone@X.comx", "someone@x.com")).Should(ConsistOf("not contain the username or email"))
+
+		strict := policy.Merge(models.PasswordPolicy{MinLength: 12, RequireUppercase: true, RequireDigit: true, RequireSymbol: true})
+		Ω(strict.MinLength).Should(Equal(12))
+		Ω(strict.Check("lowercaseonly")).Should(ConsistOf("contain an uppercase letter", "contain a digit", "contain a symbol"))
+		Ω(strict.Check("Upper-case-123")).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("Password Policy API Tests", Ordered, func() {
+	const username = "strict@x.com"
+	const password = "strict123"
+	var sender *fakeEmailSender
+	var jwtToken, orgID string
+
+	send := func(method, route string, body interface{}) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest(method, fmt.Sprintf("%s/api/v1%s", s.URL, route), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if jwtToken != "" {
+			request.Header.Set("X-Auth", jwtToken)
+		}
+		if orgID != "" {
+			request.Header.Set("X-Organization-Id", orgID)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func() string {
+		response := send("POST", "/signin", map[string]string{"username": username, "password": password})
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return string(bodyBytes)
+	}
+
+	passwordError := func(response *http.Response) string {
+		Ω(response.StatusCode).Should(Equal(400))
+		var body map[string]map[string]string
+		Ω(json.NewDecoder(response.Body).Decode(&body)).Should(Succeed())
+		Ω(body["errors"]).Should(HaveKey("password"))
+		return body["errors"]["password"]
+	}
+
+	resetToken := func() string {
+		Ω(send("POST", "/user/reset", map[string]string{"email": username}).StatusCode).Should(Equal(200))
+		params := sender.params[len(sender.params)-1].(map[string]interface{})
+		link, err := url.Parse(params["ResetLink"].(string))
+		Ω(err).Should(BeNil())
+		return path.Base(link.Path)
+	}
+
+	BeforeAll(func() {
+		sender = &fakeEmailSender{}
+		emailservice.SetInstance(sender)
+		DeferCleanup(func() { emailservice.SetInstance(emailservice.NewEmailService(settings.Current)) })
+
+		response := send("POST", "/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Strict",
+			"lastName":  "User",
+		})
+		Ω(response.StatusCode).Should(Equal(200))
+
+		jwtToken = signin()
+		Ω(send("POST", "/organizations", map[string]string{"name": "Strict Corp", "email": "admin@strict.com"}).StatusCode).Should(Equal(200))
+		var org models.Organization
+		Ω(models.Dbcon.Where("name = ?", "Strict Corp").First(&org).Error).Should(Succeed())
+		orgID = org.ID
+		jwtToken = signin()
+	})
+
+	AfterAll(func() {
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.OrgSecurityPolicy{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.UserOrgRole{})
+		models.Dbcon.Unscoped().Where("org_id = ?", orgID).Delete(&models.Role{})
+		models.Dbcon.Unscoped().Where("id = ?", orgID).Delete(&models.Organization{})
+	})
+
+	It("Refuses weak passwords at signup", func() {
+		response := send("POST", "/signup", map[string]string{
+			"email":     "weak@x.com",
+			"password":  "weak",
+			"firstName": "Weak",
+			"lastName":  "User",
+		})
+		Ω(passwordError(response)).Should(ContainSubstring("at least 8 characters"))
+
+		response = send("POST", "/signup", map[string]string{
+			"email":     "weak@x.com",
+			"password":  "weak@x.com1",
+			"firstName": "Weak",
+			"lastName":  "User",
+		})
+		Ω(passwordError(response)).Should(ContainSubstring("username or email"))
+	})
+
+	It("Lets organization admins set a stricter policy", func() {
+		response := send("GET", "/security-policy", nil)
+		Ω(response.StatusCode).Should(Equal(200))
+		var policy map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&policy)).Should(Succeed())
+		Ω(policy["passwordPolicy"]).Should(HaveKeyWithValue("minLength", BeNumerically("==", 8)))
+
+		Ω(send("PUT", "/security-policy", map[string]interface{}{"passwordPolicy": map[string]interface{}{"minLength": 4}}).StatusCode).Should(Equal(400))
+
+		response = send("PUT", "/security-policy", map[string]interface{}{
+			"passwordPolicy": map[string]interface{}{"minLength": 12, "requireUppercase": true, "requireDigit": true},
+		})
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(json.NewDecoder(response.Body).Decode(&policy)).Should(Succeed())
+		Ω(policy["passwordPolicy"]).Should(HaveKeyWithValue("minLength", BeNumerically("==", 12)))
+		Ω(policy["passwordPolicy"]).Should(HaveKeyWithValue("requireUppercase", true))
+	})
+
+	It("Applies the organization policy to members changing their password", func() {
+		token := resetToken()
+		message := passwordError(send("POST", "/user/changepassword/"+token, map[string]string{"password": "lowercase123"}))
+		Ω(message).Should(ContainSubstring("at least 12 characters"))
+		Ω(message).Should(ContainSubstring("uppercase"))
+
+		Ω(send("POST", "/user/changepassword/"+token, map[string]string{"password": "Uppercase12345"}).StatusCode).Should(Equal(200))
+	})
+})
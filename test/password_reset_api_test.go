@@ -0,0 +1,95 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+
+	"bigbucks/solution/auth/emailservice"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Password Reset Tests", Ordered, func() {
+	const username = "reset@x.com"
+	const password = "reset123"
+	const newPassword = "reset456"
+	var sender *fakeEmailSender
+
+	send := func(method, route string, body interface{}, token string) *http.Response {
+		jsonData, _ := json.Marshal(body)
+		request, _ := http.NewRequest(method, fmt.Sprintf("%s/api/v1%s", s.URL, route), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if token != "" {
+			request.Header.Set("X-Auth", token)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func(pass string) *http.Response {
+		return send("POST", "/signin", map[string]string{"username": username, "password": pass}, "")
+	}
+
+	// lastToken returns the token of the last reset link sent
+	lastToken := func() string {
+		Ω(sender.params).ShouldNot(BeEmpty())
+		params := sender.params[len(sender.params)-1].(map[string]interface{})
+		link, err := url.Parse(params["ResetLink"].(string))
+		Ω(err).Should(BeNil())
+		return path.Base(link.Path)
+	}
+
+	BeforeAll(func() {
+		sender = &fakeEmailSender{}
+		emailservice.SetInstance(sender)
+		DeferCleanup(func() { emailservice.SetInstance(emailservice.NewEmailService(settings.Current)) })
+
+		response := send("POST", "/signup", map[string]string{
+			"email":     username,
+			"password":  password,
+			"firstName": "Reset",
+			"lastName":  "User",
+		}, "")
+		Ω(response.StatusCode).Should(Equal(200))
+		sender.to, sender.params = nil, nil
+	})
+
+	It("Answers the same for unknown accounts", func() {
+		Ω(send("POST", "/user/reset", map[string]string{"email": "nobody@x.com"}, "").StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(BeEmpty())
+	})
+
+	It("Rejects invalid tokens and short passwords", func() {
+		Ω(send("POST", "/user/reset", map[string]string{"email": username}, "").StatusCode).Should(Equal(200))
+		Ω(sender.to).Should(Equal([]string{username}))
+
+		Ω(send("POST", "/user/changepassword/not-a-token", map[string]string{"password": newPassword}, "").StatusCode).Should(Equal(400))
+		Ω(send("POST", "/user/changepassword/"+lastToken(), map[string]string{"password": "abc"}, "").StatusCode).Should(Equal(400))
+		Ω(signin(password).StatusCode).Should(Equal(202))
+	})
+
+	It("Changes the password once and signs the user out", func() {
+		response := signin(password)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken := string(bodyBytes)
+		Ω(send("GET", "/me", nil, jwtToken).StatusCode).Should(Equal(200))
+
+		token := lastToken()
+		Ω(send("POST", "/user/changepassword/"+token, map[string]string{"password": newPassword}, "").StatusCode).Should(Equal(200))
+		Ω(send("GET", "/me", nil, jwtToken).StatusCode).Should(Equal(401))
+		Ω(signin(password).StatusCode).Should(Equal(401))
+		Ω(signin(newPassword).StatusCode).Should(Equal(202))
+
+		// The link stops working once the password changed
+		Ω(send("POST", "/user/changepassword/"+token, map[string]string{"password": "reset789"}, "").StatusCode).Should(Equal(400))
+	})
+})
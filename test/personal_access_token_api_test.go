@@ -0,0 +1,159 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"bigbucks/solution/auth/actions"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/permission_cache"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Personal Access Token API Tests", Ordered, func() {
+	var jwtToken string
+
+	createToken := func(auth, body string) *http.Response {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/me/tokens", s.URL), strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		request.Header.Set("X-Auth", auth)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	newToken := func(body string) map[string]interface{} {
+		response := createToken(jwtToken, body)
+		Ω(response.StatusCode).Should(Equal(201))
+		var token map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&token)).Should(Succeed())
+		return token
+	}
+
+	get := func(path, header, value string) int {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1%s", s.URL, path), nil)
+		request.Header.Set(header, value)
+		request.Header.Set("X-Organization-Id", models.SuperOrganization)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response.StatusCode
+	}
+
+	BeforeAll(func() {
+		roleID, status, _ := actions.CreateRole(&models.Role{Name: "token-user-reader", Description: "personal access token role", OrgID: models.SuperOrganization})
+		Ω(status).Should(Equal(0))
+		code, err := actions.BindPermission("user", "all", "read", roleID, models.SuperOrganization, permission_cache.NewPermissionCache(settings.Current), context.Background())
+		Ω(code).Should(Equal(0))
+		Ω(err).Should(BeNil())
+		_, _ = actions.BindUserRole(TestUserID, roleID, models.SuperOrganization)
+
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, _ := c.Do(request)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken = string(bodyBytes)
+	})
+
+	It("Authenticates with a personal access token", func() {
+		token := newToken(`{"name": "laptop cli"}`)
+		value := token["token"].(string)
+		Ω(value).Should(HavePrefix("bbpat_"))
+		Ω(value).Should(HavePrefix(token["hint"].(string)))
+
+		Ω(get("/me", "X-Auth", value)).Should(Equal(200))
+		Ω(get("/me", "Authorization", "Bearer "+value)).Should(Equal(200))
+		Ω(get("/users", "X-Auth", value)).Should(Equal(200))
+		Ω(get("/me", "X-Auth", value+"x")).Should(Equal(401))
+
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/me/tokens", s.URL), nil)
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		var tokens []map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&tokens)).Should(Succeed())
+		var listed map[string]interface{}
+		for _, t := range tokens {
+			Ω(t).ShouldNot(HaveKey("token"))
+			if t["id"] == token["id"] {
+				listed = t
+			}
+		}
+		Ω(listed).ShouldNot(BeNil())
+		Ω(listed["lastUsedAt"]).ShouldNot(BeNil())
+	})
+
+	It("Restricts tokens to their permissions", func() {
+		restricted := newToken(`{"name": "roles only", "permissions": ["role:all:read"]}`)["token"].(string)
+		Ω(get("/users", "X-Auth", restricted)).Should(Equal(403))
+		Ω(get("/me", "X-Auth", restricted)).Should(Equal(200))
+
+		allowed := newToken(`{"name": "users only", "permissions": ["user:all:read"]}`)["token"].(string)
+		Ω(get("/users", "X-Auth", allowed)).Should(Equal(200))
+
+		response := createToken(jwtToken, `{"name": "bad", "permissions": ["user:everything"]}`)
+		Ω(response.StatusCode).Should(Equal(400))
+	})
+
+	It("Does not let tokens create tokens", func() {
+		value := newToken(`{"name": "ci"}`)["token"].(string)
+		response := createToken(value, `{"name": "escalated"}`)
+		Ω(response.StatusCode).Should(Equal(403))
+	})
+
+	It("Refuses tokens on routes that check no permission", func() {
+		value := newToken(`{"name": "account"}`)["token"].(string)
+		for _, route := range []struct{ method, path, body string }{
+			{"POST", "/organizations", `{"name": "token org"}`},
+			{"POST", "/user/updateprofile", `{"firstName": "Token"}`},
+			{"POST", "/webauthn/register/begin", `{}`},
+			{"DELETE", "/webauthn/credentials/1", ``},
+			{"POST", "/me/mfa/totp", `{}`},
+			{"POST", "/device", `{"userCode": "ABCD-EFGH", "approve": true}`},
+		} {
+			request, _ := http.NewRequest(route.method, fmt.Sprintf("%s/api/v1%s", s.URL, route.path), strings.NewReader(route.body))
+			request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+			request.Header.Set("X-Auth", value)
+			response, err := c.Do(request)
+			Ω(err).Should(BeNil())
+			Ω(response.StatusCode).Should(Equal(403), route.path)
+		}
+		Ω(get("/me/mfa", "X-Auth", value)).Should(Equal(200))
+		Ω(get("/webauthn/credentials", "X-Auth", value)).Should(Equal(200))
+	})
+
+	It("Rejects expired and revoked tokens", func() {
+		response := createToken(jwtToken, fmt.Sprintf(`{"name": "old", "expiresAt": "%s"}`, time.Now().Add(-time.Hour).Format(time.RFC3339)))
+		Ω(response.StatusCode).Should(Equal(400))
+
+		token := newToken(fmt.Sprintf(`{"name": "pipeline", "expiresAt": "%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339)))
+		value := token["token"].(string)
+		Ω(get("/me", "X-Auth", value)).Should(Equal(200))
+
+		// Expire the token in place
+		Ω(models.Dbcon.Model(&models.PersonalAccessToken{}).Where("id = ?", token["id"]).Update("expires_at", time.Now().Add(-time.Minute)).Error).Should(Succeed())
+		Ω(get("/me", "X-Auth", value)).Should(Equal(401))
+
+		token = newToken(`{"name": "revoked"}`)
+		value = token["token"].(string)
+		request, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/api/v1/me/tokens/%s", s.URL, token["id"]), nil)
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(get("/me", "X-Auth", value)).Should(Equal(401))
+	})
+})
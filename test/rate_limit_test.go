@@ -0,0 +1,129 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	grpc_auth "bigbucks/solution/auth/grpc-auth"
+	"bigbucks/solution/auth/ratelimit"
+	ctr "bigbucks/solution/auth/rest-api/controllers"
+	"bigbucks/solution/auth/settings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Rate Limit Tests", Ordered, func() {
+	BeforeAll(func() {
+		previous, previousLimits := settings.Current.DisableRateLimits, settings.Current.RateLimits
+		settings.Current.DisableRateLimits = false
+		settings.Current.RateLimits = map[string]settings.RateLimit{
+			"signin-username":      {Limit: 2, Window: time.Minute},
+			"signin-mfa-challenge": {Limit: 1},
+			"grpc-test-user":       {Limit: 2},
+		}
+		DeferCleanup(func() {
+			settings.Current.DisableRateLimits = previous
+			settings.Current.RateLimits = previousLimits
+		})
+	})
+
+	signin := func(username string) *http.Response {
+		jsonData, _ := json.Marshal(map[string]string{"username": username, "password": "wrong-password"})
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	It("refuses sign-ins over the limit for a username with 429", func() {
+		response := signin("ratelimited@x.com")
+		Ω(response.StatusCode).Should(Equal(401))
+		Ω(response.Header.Get("RateLimit-Limit")).Should(Equal("2"))
+		Ω(response.Header.Get("RateLimit-Remaining")).Should(Equal("1"))
+		Ω(signin("ratelimited@x.com").StatusCode).Should(Equal(401))
+
+		response = signin("RateLimited@x.com")
+		Ω(response.StatusCode).Should(Equal(http.StatusTooManyRequests))
+		Ω(response.Header.Get("RateLimit-Remaining")).Should(Equal("0"))
+		retryAfter, err := strconv.Atoi(response.Header.Get("Retry-After"))
+		Ω(err).Should(Succeed())
+		Ω(retryAfter).Should(BeNumerically(">", 0))
+		Ω(retryAfter).Should(BeNumerically("<=", 60))
+		Ω(response.Header.Get("RateLimit-Reset")).Should(Equal(response.Header.Get("Retry-After")))
+	})
+
+	It("counts other usernames separately", func() {
+		Ω(signin("not-ratelimited@x.com").StatusCode).Should(Equal(401))
+	})
+
+	It("counts MFA attempts per challenge", func() {
+		mfa := func(token string) int {
+			jsonData, _ := json.Marshal(map[string]string{"mfaToken": token, "code": "000000"})
+			response, err := c.Post(fmt.Sprintf("%s/api/v1/signin/mfa", s.URL), "application/json", bytes.NewBuffer(jsonData))
+			Ω(err).Should(BeNil())
+			return response.StatusCode
+		}
+		Ω(mfa("ratelimited-challenge")).Should(Equal(401))
+		Ω(mfa("ratelimited-challenge")).Should(Equal(http.StatusTooManyRequests))
+		Ω(mfa("another-challenge")).Should(Equal(401))
+	})
+
+	It("only takes client addresses from X-Forwarded-For behind trusted proxies", func() {
+		DeferCleanup(func() { settings.Current.TrustedProxies = nil })
+		request := httptest.NewRequest("POST", "/api/v1/signin", nil)
+		request.RemoteAddr = "10.0.0.2:41000"
+		request.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7, 10.0.0.1")
+
+		settings.Current.TrustedProxies = nil
+		Ω(ctr.ClientHost(request)).Should(Equal("10.0.0.2"))
+
+		settings.Current.TrustedProxies = []string{"10.0.0.0/8"}
+		Ω(ctr.ClientHost(request)).Should(Equal("203.0.113.7"))
+
+		request.RemoteAddr = "198.51.100.1:41000"
+		Ω(ctr.ClientHost(request)).Should(Equal("198.51.100.1"))
+
+		request.RemoteAddr = "10.0.0.2:41000"
+		request.Header.Set("X-Forwarded-For", "10.0.0.1")
+		Ω(ctr.ClientHost(request)).Should(Equal("10.0.0.1"))
+	})
+
+	It("lets requests through when rate limits are disabled", func() {
+		settings.Current.DisableRateLimits = true
+		defer func() { settings.Current.DisableRateLimits = false }()
+		Ω(signin("ratelimited@x.com").StatusCode).Should(Equal(401))
+	})
+
+	It("refuses gRPC calls over the limit with ResourceExhausted", func() {
+		limiter := ratelimit.NewLimiter(settings.Current)
+		interceptor := grpc_auth.RateLimitInterceptor(limiter, map[string][]grpc_auth.RateLimit{
+			"/Test/Call": {{Key: grpc_auth.ByUser, Rule: ratelimit.Rule{Name: "grpc-test-user", Limit: 100, Window: time.Minute}}},
+		})
+		call := func(userID, method string) error {
+			ctx := context.WithValue(context.Background(), grpc_auth.UserValue("userID"), userID)
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			return err
+		}
+
+		Ω(call(TestUserID, "/Test/Call")).Should(Succeed())
+		Ω(call(TestUserID, "/Test/Call")).Should(Succeed())
+		err := call(TestUserID, "/Test/Call")
+		Ω(status.Code(err)).Should(Equal(codes.ResourceExhausted))
+
+		Ω(call("another-user", "/Test/Call")).Should(Succeed())
+		Ω(call(TestUserID, "/Test/Other")).Should(Succeed())
+	})
+})
@@ -0,0 +1,78 @@
+package auth_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	jwtops "bigbucks/solution/auth/jwt-ops"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Refresh Token API Tests", Ordered, func() {
+	var jwt string
+	var refreshToken string
+
+	renew := func(token string) *http.Response {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/renew", s.URL), nil)
+		request.Header.Set("X-Refresh-Token", token)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	BeforeAll(func() {
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, _ := c.Do(request)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwt = string(bodyBytes)
+		refreshToken = response.Header.Get("X-Refresh-Token")
+	})
+
+	It("Returns a refresh token on signin", func() {
+		Ω(refreshToken).ShouldNot(BeEmpty())
+	})
+
+	It("Rotates the refresh token and keeps the session", func() {
+		response := renew(refreshToken)
+		Ω(response.StatusCode).Should(Equal(202))
+
+		bodyBytes, _ := io.ReadAll(response.Body)
+		newClaims, _, err := jwtops.VerifyJWT(string(bodyBytes))
+		Ω(err).Should(BeNil())
+		oldClaims, _, _ := jwtops.VerifyJWT(jwt)
+		Ω(newClaims.ID).Should(Equal(oldClaims.ID))
+
+		rotated := response.Header.Get("X-Refresh-Token")
+		Ω(rotated).ShouldNot(BeEmpty())
+		Ω(rotated).ShouldNot(Equal(refreshToken))
+
+		// Reusing the old token revokes the session, including the rotated token
+		response = renew(refreshToken)
+		Ω(response.StatusCode).Should(Equal(401))
+		response = renew(rotated)
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Rejects an unknown refresh token", func() {
+		response := renew("not-a-refresh-token")
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Requires a refresh token", func() {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/renew", s.URL), nil)
+		request.Header.Set("X-Auth", jwt)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(400))
+	})
+})
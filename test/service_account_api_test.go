@@ -0,0 +1,203 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bigbucks/solution/auth/actions"
+	jwtops "bigbucks/solution/auth/jwt-ops"
+	"bigbucks/solution/auth/models"
+	"bigbucks/solution/auth/permission_cache"
+	"bigbucks/solution/auth/settings"
+
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Service Account API Tests", Ordered, func() {
+	var jwtToken string
+	var roleID string
+
+	adminRequest := func(method, path string, body []byte) *http.Response {
+		request, _ := http.NewRequest(method, fmt.Sprintf("%s/api/v1%s", s.URL, path), bytes.NewBuffer(body))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		request.Header.Set("X-Auth", jwtToken)
+		request.Header.Set("X-Organization-Id", models.SuperOrganization)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	createAccount := func(body string) map[string]interface{} {
+		response := adminRequest("POST", "/service-accounts", []byte(body))
+		Ω(response.StatusCode).Should(Equal(201))
+		var account map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&account)).Should(Succeed())
+		return account
+	}
+
+	tokenRequest := func(form url.Values, clientID, secret string) *http.Response {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/oauth/token", s.URL), strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if clientID != "" {
+			request.SetBasicAuth(clientID, secret)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	BeforeAll(func() {
+		id, status, _ := actions.CreateRole(&models.Role{Name: "service-account-admin", Description: "service account role", OrgID: models.SuperOrganization})
+		Ω(status).Should(Equal(0))
+		roleID = id
+		for _, action := range []string{"read", "write"} {
+			code, err := actions.BindPermission("user", "all", action, roleID, models.SuperOrganization, permission_cache.NewPermissionCache(settings.Current), context.Background())
+			Ω(code).Should(Equal(0))
+			Ω(err).Should(BeNil())
+		}
+		_, _ = actions.BindUserRole(TestUserID, roleID, models.SuperOrganization)
+
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, _ := c.Do(request)
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken = string(bodyBytes)
+	})
+
+	It("Refuses roles granting more than the caller holds", func() {
+		id, status, _ := actions.CreateRole(&models.Role{Name: "service-account-vault", Description: "more than the caller holds", OrgID: models.SuperOrganization})
+		Ω(status).Should(Equal(0))
+		code, err := actions.BindPermission("vault", "all", "read", id, models.SuperOrganization, permission_cache.NewPermissionCache(settings.Current), context.Background())
+		Ω(code).Should(Equal(0))
+		Ω(err).Should(BeNil())
+
+		response := adminRequest("POST", "/service-accounts", []byte(fmt.Sprintf(`{"name": "escalation", "roleId": "%s"}`, id)))
+		Ω(response.StatusCode).Should(Equal(403))
+	})
+
+	It("Issues permission checked tokens for a client secret", func() {
+		account := createAccount(fmt.Sprintf(`{"name": "nightly job", "roleId": "%s"}`, roleID))
+		clientID := account["clientId"].(string)
+		secret := account["clientSecret"].(string)
+		Ω(secret).ShouldNot(BeEmpty())
+
+		response := tokenRequest(url.Values{"grant_type": {"client_credentials"}}, clientID, secret)
+		Ω(response.StatusCode).Should(Equal(200))
+		var token map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&token)).Should(Succeed())
+		Ω(token["token_type"]).Should(Equal("Bearer"))
+
+		claims, _, err := jwtops.VerifyJWT(token["access_token"].(string))
+		Ω(err).Should(BeNil())
+		Ω(claims.Subject).Should(Equal(account["userId"]))
+
+		ctx := context.Background()
+		allowed, err := permission_cache.NewPermissionCache(settings.Current).CheckPermission(&ctx, "user", "all", "read", models.SuperOrganization, &claims.User)
+		Ω(err).Should(BeNil())
+		Ω(allowed).Should(BeTrue())
+
+		// The token works on authenticated routes
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/service-accounts", s.URL), nil)
+		request.Header.Set("X-Auth", token["access_token"].(string))
+		request.Header.Set("X-Organization-Id", models.SuperOrganization)
+		response, err = c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+
+		response = tokenRequest(url.Values{"grant_type": {"client_credentials"}}, clientID, "wrong")
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Refuses password signin for service accounts", func() {
+		account := createAccount(fmt.Sprintf(`{"name": "signin check", "roleId": "%s"}`, roleID))
+		jsonData := []byte(fmt.Sprintf(`{"username": "%s", "password": ""}`, actions.ServiceAccountUsername(account["clientId"].(string))))
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Accepts private_key_jwt assertions once", func() {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Ω(err).Should(BeNil())
+		der, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+		publicPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		body, _ := json.Marshal(map[string]string{"name": "keyed job", "roleId": roleID, "publicKey": publicPEM})
+		account := createAccount(string(body))
+		clientID := account["clientId"].(string)
+		Ω(account["authMethod"]).Should(Equal("private_key_jwt"))
+
+		assertion, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    clientID,
+			Subject:   clientID,
+			Audience:  jwt.ClaimStrings{s.URL + "/oauth/token"},
+			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}).SignedString(priv)
+		Ω(err).Should(BeNil())
+
+		form := url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_assertion_type": {jwtops.ClientAssertionType},
+			"client_assertion":      {assertion},
+		}
+		response := tokenRequest(form, "", "")
+		Ω(response.StatusCode).Should(Equal(200))
+
+		response = tokenRequest(form, "", "")
+		Ω(response.StatusCode).Should(Equal(401))
+
+		// The audience is the configured issuer, whatever Host the request names
+		assertion, err = jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    clientID,
+			Subject:   clientID,
+			Audience:  jwt.ClaimStrings{"http://evil.example.com/oauth/token"},
+			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}).SignedString(priv)
+		Ω(err).Should(BeNil())
+		form.Set("client_assertion", assertion)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/oauth/token", s.URL), strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Host = "evil.example.com"
+		response, err = c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Revokes access when the service account is deleted", func() {
+		account := createAccount(fmt.Sprintf(`{"name": "short lived", "roleId": "%s"}`, roleID))
+		clientID := account["clientId"].(string)
+
+		response := adminRequest("DELETE", "/service-accounts/"+clientID, nil)
+		Ω(response.StatusCode).Should(Equal(200))
+
+		response = tokenRequest(url.Values{"grant_type": {"client_credentials"}}, clientID, account["clientSecret"].(string))
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Rejects unsupported grant types", func() {
+		response := tokenRequest(url.Values{"grant_type": {"password"}}, "", "")
+		Ω(response.StatusCode).Should(Equal(400))
+	})
+})
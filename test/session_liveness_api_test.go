@@ -0,0 +1,50 @@
+package auth_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session Liveness Tests", Ordered, func() {
+	signin := func() string {
+		jsonData := []byte(`{
+			"username": "john@x.com",
+			"password": "john123"
+		}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signin", s.URL), bytes.NewBuffer(jsonData))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return string(bodyBytes)
+	}
+
+	me := func(jwt string) int {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/me", s.URL), nil)
+		request.Header.Set("X-Auth", jwt)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response.StatusCode
+	}
+
+	It("Rejects tokens of revoked sessions on routes without permissions", func() {
+		revoked := signin()
+		other := signin()
+		Ω(me(revoked)).Should(Equal(200))
+
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signout", s.URL), nil)
+		request.Header.Set("X-Auth", revoked)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(response.StatusCode).Should(Equal(200))
+
+		Ω(me(revoked)).Should(Equal(401))
+		Ω(me(other)).Should(Equal(200))
+	})
+})
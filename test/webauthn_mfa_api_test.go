@@ -0,0 +1,151 @@
+package auth_test
+
+import (
+	"bigbucks/solution/auth/models"
+	ctr "bigbucks/solution/auth/rest-api/controllers"
+	"bigbucks/solution/auth/settings"
+	webauthnservice "bigbucks/solution/auth/webauthn"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebAuthn Second Factor Tests", Ordered, func() {
+	const username = "key@x.com"
+	const password = "key12345"
+	var jwtToken string
+	var user models.User
+	var credentialID []byte
+	var privateKey *ecdsa.PrivateKey
+
+	post := func(path string, body []byte, token string) *http.Response {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1%s", s.URL, path), bytes.NewBuffer(body))
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		if token != "" {
+			request.Header.Set("X-Auth", token)
+		}
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		return response
+	}
+
+	signin := func() *http.Response {
+		return post("/signin", []byte(fmt.Sprintf(`{"username": %q, "password": %q}`, username, password)), "")
+	}
+
+	// beginAssertion signs in with the password and starts the security key step
+	beginAssertion := func() (string, string) {
+		response := signin()
+		Ω(response.StatusCode).Should(Equal(200))
+		var challenge map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&challenge)).Should(Succeed())
+		Ω(challenge["methods"]).Should(ConsistOf("webauthn"))
+		mfaToken := challenge["mfaToken"].(string)
+
+		response = post("/signin/mfa/webauthn/begin", []byte(fmt.Sprintf(`{"mfaToken": %q}`, mfaToken)), "")
+		Ω(response.StatusCode).Should(Equal(200))
+		var options map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&options)).Should(Succeed())
+		publicKey := options["publicKey"].(map[string]interface{})
+		Ω(publicKey["allowCredentials"]).Should(HaveLen(1))
+		return mfaToken, publicKey["challenge"].(string)
+	}
+
+	BeforeAll(func() {
+		svc, err := webauthnservice.NewService(&settings.Settings{
+			WebAuthnRPID:    "localhost",
+			WebAuthnRPName:  "Test Auth",
+			WebAuthnOrigins: []string{s.URL},
+			RedisAddress:    "localhost:6379",
+		})
+		Ω(err).Should(BeNil())
+		ctr.SetWebAuthnService(svc)
+
+		response := post("/signup", []byte(fmt.Sprintf(`{"email": %q, "password": %q, "firstName": "Key", "lastName": "User"}`, username, password)), "")
+		Ω(response.StatusCode).Should(Equal(200))
+		Ω(models.Dbcon.Where("username = ?", username).First(&user).Error).Should(Succeed())
+
+		response = signin()
+		Ω(response.StatusCode).Should(Equal(202))
+		bodyBytes, _ := io.ReadAll(response.Body)
+		jwtToken = string(bodyBytes)
+	})
+
+	AfterAll(func() {
+		models.Dbcon.Unscoped().Where("user_id = ?", user.ID).Delete(&models.WebAuthnCredential{})
+		svc, err := webauthnservice.NewService(settings.Current)
+		if err == nil {
+			ctr.SetWebAuthnService(svc)
+		}
+	})
+
+	It("Needs a registered key before it can be enabled", func() {
+		Ω(post("/me/mfa/webauthn", []byte(`{"enabled": true}`), jwtToken).StatusCode).Should(Equal(400))
+	})
+
+	It("Asks for the security key after the password", func() {
+		response := post("/webauthn/register/begin", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		var beginResult map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&beginResult)).Should(Succeed())
+		challenge := beginResult["publicKey"].(map[string]interface{})["challenge"].(string)
+		var attestation []byte
+		attestation, privateKey, credentialID = buildWebAuthnAttestationResponseWithKey(challenge, s.URL, "localhost")
+		Ω(post("/webauthn/register/finish?name=YubiKey", attestation, jwtToken).StatusCode).Should(Equal(200))
+
+		// Registering a key alone keeps password signin as it was
+		Ω(signin().StatusCode).Should(Equal(202))
+		Ω(post("/me/mfa/webauthn", []byte(`{"enabled": true}`), jwtToken).StatusCode).Should(Equal(200))
+
+		mfaToken, challenge := beginAssertion()
+		assertion := buildWebAuthnAssertionResponse(challenge, s.URL, "localhost", credentialID, privateKey, []byte(user.ID), 1)
+		response = post("/signin/mfa/webauthn/finish?mfaToken="+url.QueryEscape(mfaToken), assertion, "")
+		Ω(response.StatusCode).Should(Equal(202))
+		Ω(response.Header.Get("X-Refresh-Token")).ShouldNot(BeEmpty())
+
+		// The challenge cannot be used again
+		Ω(post("/signin/mfa/webauthn/begin", []byte(fmt.Sprintf(`{"mfaToken": %q}`, mfaToken)), "").StatusCode).Should(Equal(401))
+	})
+
+	It("Rejects an assertion from another key", func() {
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Ω(err).Should(BeNil())
+		mfaToken, challenge := beginAssertion()
+		assertion := buildWebAuthnAssertionResponse(challenge, s.URL, "localhost", credentialID, otherKey, []byte(user.ID), 2)
+		response := post("/signin/mfa/webauthn/finish?mfaToken="+url.QueryEscape(mfaToken), assertion, "")
+		Ω(response.StatusCode).Should(Equal(401))
+	})
+
+	It("Reports and turns off the security key second factor", func() {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/me/mfa", s.URL), nil)
+		request.Header.Set("X-Auth", jwtToken)
+		response, err := c.Do(request)
+		Ω(err).Should(BeNil())
+		var status map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&status)).Should(Succeed())
+		Ω(status["webAuthnEnabled"]).Should(BeTrue())
+		Ω(status["totpEnabled"]).Should(BeFalse())
+
+		// Turning it off needs the key, or a code the user does not have
+		Ω(post("/me/mfa/webauthn", []byte(`{"enabled": false}`), jwtToken).StatusCode).Should(Equal(400))
+		Ω(post("/me/mfa/webauthn", []byte(`{"enabled": false, "code": "123456"}`), jwtToken).StatusCode).Should(Equal(400))
+
+		response = post("/me/mfa/webauthn/begin", nil, jwtToken)
+		Ω(response.StatusCode).Should(Equal(200))
+		var options map[string]interface{}
+		Ω(json.NewDecoder(response.Body).Decode(&options)).Should(Succeed())
+		challenge := options["publicKey"].(map[string]interface{})["challenge"].(string)
+		assertion := buildWebAuthnAssertionResponse(challenge, s.URL, "localhost", credentialID, privateKey, []byte(user.ID), 3)
+		Ω(post("/me/mfa/webauthn", []byte(fmt.Sprintf(`{"enabled": false, "assertion": %s}`, assertion)), jwtToken).StatusCode).Should(Equal(200))
+		Ω(signin().StatusCode).Should(Equal(202))
+	})
+})
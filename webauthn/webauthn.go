@@ -2,6 +2,8 @@ package webauthnservice
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -20,6 +22,7 @@ const (
 	// Redis key prefixes for WebAuthn challenge sessions
 	registrationSessionPrefix = "webauthn:reg:"
 	loginSessionPrefix        = "webauthn:login:"
+	mfaSessionPrefix          = "webauthn:mfa:"
 	challengeExpiry           = 5 * time.Minute
 )
 
@@ -321,3 +324,60 @@ func (svc *Service) FinishLogin(username string, response *protocol.ParsedCreden
 
 	return &user, nil
 }
+
+// ---- Second Factor Flow ----
+
+// BeginSecondFactor starts an assertion against the user's registered credentials
+// for a sign-in that already passed the password check. The ceremony is bound to
+// the MFA challenge token of that sign-in.
+func (svc *Service) BeginSecondFactor(user *models.WebAuthnUser, challengeToken string) (*protocol.CredentialAssertion, error) {
+	if len(user.WebAuthnCredentials()) == 0 {
+		return nil, fmt.Errorf("no webauthn credentials registered for this user")
+	}
+	// The password was the knowledge factor, possession of the key is enough here
+	options, session, err := svc.webAuthn.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationDiscouraged))
+	if err != nil {
+		return nil, fmt.Errorf("begin second factor failed: %w", err)
+	}
+
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal second factor session: %w", err)
+	}
+	if err := svc.redis.Set(svc.ctx, mfaSessionKey(challengeToken), sessionJSON, challengeExpiry).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store second factor session: %w", err)
+	}
+	return options, nil
+}
+
+// FinishSecondFactor validates the assertion of a second factor ceremony
+func (svc *Service) FinishSecondFactor(user *models.WebAuthnUser, challengeToken string, response *protocol.ParsedCredentialAssertionData) error {
+	key := mfaSessionKey(challengeToken)
+	sessionJSON, err := svc.redis.GetDel(svc.ctx, key).Bytes()
+	if err != nil {
+		return fmt.Errorf("second factor session not found or expired: %w", err)
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(sessionJSON, &session); err != nil {
+		return fmt.Errorf("failed to unmarshal second factor session: %w", err)
+	}
+
+	credential, err := svc.webAuthn.ValidateLogin(user, session, response)
+	if err != nil {
+		return fmt.Errorf("second factor validation failed: %w", err)
+	}
+	if err := models.UpdateSignCount(credential.ID, credential.Authenticator.SignCount); err != nil {
+		loging.Logger.Error("Failed to update sign count", err)
+	}
+	if err := models.UpdateCredentialFlags(credential.ID, credential.Flags.BackupEligible, credential.Flags.BackupState); err != nil {
+		loging.Logger.Error("Failed to update credential flags", err)
+	}
+	return nil
+}
+
+// mfaSessionKey keys second factor sessions by a digest of the MFA challenge token
+func mfaSessionKey(challengeToken string) string {
+	sum := sha256.Sum256([]byte(challengeToken))
+	return mfaSessionPrefix + hex.EncodeToString(sum[:])
+}